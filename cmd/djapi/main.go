@@ -1,25 +1,69 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/joho/godotenv"
 
+	"coco-life.de/wapi/internal/auth"
+	"coco-life.de/wapi/internal/db"
 	"coco-life.de/wapi/internal/handlers"
+	"coco-life.de/wapi/internal/idempotency"
+	"coco-life.de/wapi/internal/server"
+	"coco-life.de/wapi/internal/store"
+	"coco-life.de/wapi/internal/utils"
 	"github.com/gin-gonic/gin"
 )
 
+// authn authenticates all requests except the probes, see authFromEnv.
+var authn auth.Authenticator = auth.TokenAuth{}
+
+// idempotencyKeys stores the Idempotency-Key of POST /articles for idempotencyTTL,
+// see idempotencyFromEnv.
+var idempotencyKeys idempotency.Store = idempotency.NewMemStore()
+var idempotencyTTL = 24 * time.Hour
+
 // https://github.com/gin-gonic/gin#testing
 func setupRouter() *gin.Engine {
 	r := gin.Default()
 	r.GET("/ping", func(c *gin.Context) {
 		c.String(http.StatusOK, "pong")
 	})
+	r.GET("/healthz", handlers.Liveness)
+	r.GET("/readyz", handlers.Readiness)
 	r.GET("/db/health", handlers.DbHealthCheck)
-	r.POST("/articles", handlers.InsertArticle)
-	r.GET("/articles/root", handlers.RetrieveRootArticle)
-    r.GET("/articles/:id", handlers.RetrieveArticleByID)
+
+	read := r.Group("/", auth.Authenticate(authn), auth.Require(auth.ScopeRead))
+	read.GET("/articles", handlers.ListArticles)
+	read.GET("/articles/root", handlers.RetrieveRootArticle)
+	read.GET("/articles/by-path/*path", handlers.RetrieveArticleByPath)
+	read.GET("/articles/:id", handlers.RetrieveArticleByID)
+	read.GET("/articles/:id/html", handlers.RetrieveArticleHTML)
+	read.GET("/articles/:id/toc", handlers.RetrieveArticleTOC)
+	read.GET("/articles/:id/links", handlers.ListArticleLinks)
+	read.GET("/articles/:id/backlinks", handlers.ListBacklinks)
+	read.GET("/search", handlers.SearchArticles)
+
+	write := r.Group("/", auth.Authenticate(authn), auth.Require(auth.ScopeWrite))
+	write.POST("/articles", idempotency.Middleware(idempotencyKeys, idempotencyTTL), handlers.InsertArticle)
+	write.POST("/articles/batch", idempotency.Middleware(idempotencyKeys, idempotencyTTL), handlers.BatchArticles)
+	write.POST("/articles/import", idempotency.Middleware(idempotencyKeys, idempotencyTTL), handlers.ImportArticles)
+	write.PUT("/articles/:id", handlers.UpdateArticle)
+	write.DELETE("/articles/:id", handlers.DeleteArticle)
+	write.POST("/articles/:id/move", handlers.MoveArticle)
+	write.PATCH("/articles/:id/slug", handlers.RenameArticle)
+	write.POST("/articles/:id/copy", idempotency.Middleware(idempotencyKeys, idempotencyTTL), handlers.CopyArticle)
+	write.POST("/articles/:id/lock", handlers.LockArticle)
+	write.DELETE("/articles/:id/lock", handlers.UnlockArticle)
+
+	admin := r.Group("/admin", auth.Authenticate(authn), auth.Require(auth.ScopeAdmin))
+	admin.GET("/links/broken", handlers.ListBrokenLinks)
 	return r
 }
 
@@ -27,10 +71,148 @@ func readEnv() {
 	// Load the .env file in the current directory
 	godotenv.Load()
 	handlers.SetBaseURL("https://" + os.Getenv("host") + "/")
+	if v := os.Getenv("WAPI_SEARCH_CONFIG"); v != "" {
+		handlers.SetSearchConfig(v)
+	}
+	if v := os.Getenv("WAPI_WIKI_URL"); v != "" {
+		handlers.SetWikiURL(strings.TrimSuffix(v, "/") + "/")
+	}
+}
+
+// proxiesFromEnv configures the trusted proxies according to WAPI_TRUSTED_PROXIES, a
+// comma separated list of IP addresses and CIDRs. By default, no proxy is trusted and
+// the client IP is the address of the peer.
+func proxiesFromEnv() error {
+	nets, err := utils.ParseTrustedProxies(os.Getenv("WAPI_TRUSTED_PROXIES"))
+	if err != nil {
+		return fmt.Errorf("WAPI_TRUSTED_PROXIES: %v", err)
+	}
+	handlers.SetTrustedProxies(nets)
+	return nil
+}
+
+// authFromEnv configures authn according to WAPI_AUTH, a comma separated list of
+// - 'token' (default): API tokens from WAPI_TOKEN_FILE, if set, and wapi_apitoken,
+// - 'django': HTTP Basic credentials of the Django users in auth_user. Superusers get
+//   the admin scope, all other active users the scope WAPI_DJANGO_SCOPE (default
+//   'write'),
+// or 'none': No authentication, every caller has admin scope. For development only.
+func authFromEnv(tokens auth.TokenStore, users store.UserStore) error {
+	modes := os.Getenv("WAPI_AUTH")
+	if modes == "" {
+		modes = "token"
+	}
+	if modes == "none" {
+		fmt.Fprintln(os.Stderr, "WARNING: WAPI_AUTH=none, the API is not protected.")
+		authn = auth.Disabled{}
+		return nil
+	}
+
+	var any auth.Any
+	for _, mode := range strings.Split(modes, ",") {
+		switch strings.TrimSpace(mode) {
+		case "token":
+			stores := []auth.TokenStore{}
+			if path := os.Getenv("WAPI_TOKEN_FILE"); path != "" {
+				fileTokens, err := auth.LoadTokenFile(path)
+				if err != nil {
+					return err
+				}
+				stores = append(stores, fileTokens)
+			}
+			any = append(any, auth.TokenAuth{Stores: append(stores, tokens), Users: users})
+		case "django":
+			scope := auth.ScopeWrite
+			if v := os.Getenv("WAPI_DJANGO_SCOPE"); v != "" {
+				var err error
+				if scope, err = auth.ParseScope(v); err != nil {
+					return fmt.Errorf("WAPI_DJANGO_SCOPE: %v", err)
+				}
+			}
+			any = append(any, auth.NewDjangoAuth(users, scope))
+		default:
+			return fmt.Errorf("Invalid WAPI_AUTH '%v', expected 'none' or a list of 'token' and 'django'", modes)
+		}
+	}
+	authn = any
+	if len(any) == 1 {
+		authn = any[0]
+	}
+	return nil
+}
+
+// idempotencyFromEnv stores idempotency keys in keys for WAPI_IDEMPOTENCY_TTL
+// (default 24h).
+func idempotencyFromEnv(keys idempotency.Store) error {
+	if v := os.Getenv("WAPI_IDEMPOTENCY_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("Invalid duration in WAPI_IDEMPOTENCY_TTL: %v", err)
+		}
+		idempotencyTTL = ttl
+	}
+	idempotencyKeys = keys
+	return nil
 }
 
 func main() {
-	r := setupRouter()
-	r.Run(":8080")
 	readEnv()
+
+	if len(os.Args) > 1 && os.Args[1] == "token" {
+		if err := runTokenCmd(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	cfg, err := server.ConfigFromEnv()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if err := proxiesFromEnv(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	/* The database connection parameters will be loaded from environment variables.
+	 * user=<PGUSER> host=<PGHOST> password=<PGPASSWORD> port=<PGPORT>
+	 * dbname=<PGDATABASE>
+	 * The mapping of environment variables to keyboard is as follows:
+	 * hostaddr -> PGHOST
+	 * port -> PGPORT
+	 * user -> PGUSER
+	 * password -> PGPASSWORD
+	 * dbname -> PGDATABASE
+	 * See `go doc pgconn.ParseConfig` for details.
+	 */
+	dbpool, err := pgxpool.Connect(context.Background(), "")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to connect to database: %v\n", err)
+		os.Exit(1)
+	}
+	if err := db.EnsureAPISchema(context.Background(), dbpool); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	pgStore := db.NewPgStore(dbpool)
+	handlers.SetStore(pgStore)
+	if err := authFromEnv(pgStore, pgStore); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	if err := idempotencyFromEnv(pgStore); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	srv := server.New(cfg, setupRouter())
+	srv.OnShutdown(dbpool.Close)
+	handlers.SetReadiness(srv.Ready)
+
+	if err := srv.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
 }
@@ -4,40 +4,136 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"reflect"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"coco-life.de/wapi/internal/auth"
+	"coco-life.de/wapi/internal/handlers"
+	"coco-life.de/wapi/internal/idempotency"
 	m "coco-life.de/wapi/internal/models"
-	"github.com/jackc/pgx/v4/pgxpool"
-	"github.com/joho/godotenv"
+	"coco-life.de/wapi/internal/store"
+	"coco-life.de/wapi/internal/store/memstore"
+	"coco-life.de/wapi/internal/store/storetest"
+	"coco-life.de/wapi/internal/utils"
+	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 )
 
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	// Authentication is tested separately in TestAuth.
+	authn = auth.Disabled{}
+	os.Exit(m.Run())
+}
+
+// clearDB replaces the article store of the handlers by an empty in-memory store.
 func clearDB() {
-	dbpool, err := pgxpool.Connect(context.Background(), "")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to connect to database: %v\n", err)
-		os.Exit(1)
+	handlers.SetStore(memstore.New())
+}
+
+// node is an article of a fixture, see newFixture. A revision with title and content
+// is added unless both are empty.
+type node struct {
+	parentID int
+	slug     string
+	title    string
+	content  string
+}
+
+// newFixture replaces the article store of the handlers by an in-memory store with the
+// root article "Root" and the nodes, created in order like storetest.InsertChild does.
+// The nodes get the IDs 2, 3, ...
+func newFixture(t *testing.T, nodes ...node) *memstore.Store {
+	t.Helper()
+	s := memstore.New()
+	handlers.SetStore(s)
+	storetest.InsertRoot(t, s, "Root")
+	for _, n := range nodes {
+		id, err := storetest.InsertChild(s, n.parentID, n.slug)
+		assert.Nil(t, err)
+		if n.title != "" || n.content != "" {
+			_, err = s.AddWikiArticleRevision(context.Background(), id, n.title, n.content, m.RevisionMeta{})
+			assert.Nil(t, err)
+		}
+	}
+	return s
+}
+
+// serve sends a request to router and returns the response. A string body is sent as
+// is, any other body but nil as JSON. The options adjust the request, e.g. withHeader.
+func serve(t *testing.T, router http.Handler, method string, endpoint string, body interface{}, opts ...func(*http.Request)) *httptest.ResponseRecorder {
+	t.Helper()
+	var data []byte
+	switch b := body.(type) {
+	case nil:
+	case string:
+		data = []byte(b)
+	default:
+		var err error
+		data, err = json.Marshal(b)
+		assert.Nil(t, err)
+	}
+	req, err := http.NewRequest(method, endpoint, bytes.NewBuffer(data))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
+	for _, opt := range opts {
+		opt(req)
 	}
-	_, err = dbpool.Exec(context.Background(), "TRUNCATE wiki_article CASCADE;")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to TRUNCATE wiki_article: %v\n", err)
-		os.Exit(1)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// withHeader sets a header of the request unless value is empty.
+func withHeader(name string, value string) func(*http.Request) {
+	return func(r *http.Request) {
+		if value != "" {
+			r.Header.Set(name, value)
+		}
 	}
 }
 
+// withToken authenticates the request with an API token unless it is empty.
+func withToken(token string) func(*http.Request) {
+	return func(r *http.Request) {
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+}
+
+// withBasicAuth authenticates the request as a Django user unless user is empty.
+func withBasicAuth(user string, password string) func(*http.Request) {
+	return func(r *http.Request) {
+		if user != "" {
+			r.SetBasicAuth(user, password)
+		}
+	}
+}
+
+// withRemoteAddr sets the address of the peer, e.g. "192.0.2.1:4711".
+func withRemoteAddr(addr string) func(*http.Request) {
+	return func(r *http.Request) {
+		r.RemoteAddr = addr
+	}
+}
+
+// decodeJSON unmarshals the body of the response into v.
+func decodeJSON(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	assert.Nil(t, json.Unmarshal(w.Body.Bytes(), v), w.Body.String())
+}
+
 // Create a root and a child article and fetch the child article by its ID.
 func TestGetArticleById(t *testing.T) {
-	// Read the environment variables for the DB connection.
-	godotenv.Load("../../.env")
-	// Override the database name to use the testing database.
-	os.Setenv("PGDATABASE", "go_api_tests")
-
 	clearDB()
 
 	// Create the following article hierarchy:
@@ -121,11 +217,6 @@ func TestGetArticleById(t *testing.T) {
 // Create a second article that is a child of the root article and a sibling to an
 // existing child article.
 func TestAddSecondChildToRoot(t *testing.T) {
-	// Read the environment variables for the DB connection.
-	godotenv.Load("../../.env")
-	// Override the database name to use the testing database.
-	os.Setenv("PGDATABASE", "go_api_tests")
-
 	clearDB()
 
 	// Create the following article hierarchy:
@@ -264,11 +355,6 @@ func TestAddSecondChildToRoot(t *testing.T) {
 
 // Create an article that is a child of the root article.
 func TestAddChildToRoot(t *testing.T) {
-	// Read the environment variables for the DB connection.
-	godotenv.Load("../../.env")
-	// Override the database name to use the testing database.
-	os.Setenv("PGDATABASE", "go_api_tests")
-
 	clearDB()
 
 	// Create the following article hierarchy:
@@ -361,11 +447,6 @@ func TestAddChildToRoot(t *testing.T) {
 }
 
 func TestBasics(t *testing.T) {
-	// Read the environment variables for the DB connection.
-	godotenv.Load("../../.env")
-	// Override the database name to use the testing database.
-	os.Setenv("PGDATABASE", "go_api_tests")
-
 	clearDB()
 
 	cases := []struct {
@@ -378,6 +459,8 @@ func TestBasics(t *testing.T) {
 		expResponse m.Resource
 	}{
 		{"Ping API", "GET", "/ping", nil, http.StatusOK, "pong", nil},
+		{"Liveness probe", "GET", "/healthz", nil, http.StatusOK, `{"status":"alive"}`, nil},
+		{"Readiness probe without root article", "GET", "/readyz", nil, http.StatusServiceUnavailable, "", nil},
 		{"Database healthcheck", "GET", "/db/health", nil, http.StatusOK, "", nil},
 		{"Create root article", "POST", "/articles",
 			&m.RootArticle{
@@ -390,6 +473,14 @@ func TestBasics(t *testing.T) {
 				ArticleBase: m.ArticleBase{
 					Title:   "Root article created from testing",
 					Content: "# Hello World"}}},
+		{"GET root article by path", "GET", "/articles/by-path/", nil, http.StatusOK, "",
+			&m.Article{
+				ArticleBase: m.ArticleBase{
+					Title:   "Root article created from testing",
+					Content: "# Hello World"}}},
+		{"GET unknown path", "GET", "/articles/by-path/foo/bar", nil, http.StatusNotFound, "", nil},
+		{"GET unknown article", "GET", "/articles/4711", nil, http.StatusNotFound, "", nil},
+		{"Readiness probe", "GET", "/readyz?mptt=true", nil, http.StatusOK, "", nil},
 	}
 
 	router := setupRouter()
@@ -423,3 +514,1253 @@ func TestBasics(t *testing.T) {
 		})
 	}
 }
+
+// Requests without a valid token are rejected with 401, requests lacking the scope
+// with 403.
+func TestAuth(t *testing.T) {
+	clearDB()
+	defer func(a auth.Authenticator) { authn = a }(authn)
+
+	tokens := &auth.MemTokens{}
+	ctx := context.Background()
+	newToken := func(name string, scope auth.Scope) string {
+		token, hash, err := auth.NewToken()
+		assert.Nil(t, err)
+		_, err = tokens.InsertAPIToken(ctx, &m.APIToken{Name: name, Hash: hash, Scope: scope.String()})
+		assert.Nil(t, err)
+		return token
+	}
+	readToken := newToken("reader", auth.ScopeRead)
+	writeToken := newToken("writer", auth.ScopeWrite)
+	revokedToken := newToken("revoked", auth.ScopeAdmin)
+	revoked, err := tokens.SelectAPITokenByHash(ctx, auth.HashToken(revokedToken))
+	assert.Nil(t, err)
+	assert.Nil(t, tokens.RevokeAPIToken(ctx, revoked.ID))
+	authn = auth.TokenAuth{Stores: []auth.TokenStore{tokens}}
+	router := setupRouter()
+
+	root := &m.RootArticle{ArticleBase: m.ArticleBase{Title: "Root", Content: "# Root"}}
+	cases := []struct {
+		descr    string
+		httpType string
+		endpoint string
+		token    string
+		bodyJSON interface{}
+		expCode  int
+	}{
+		{"Probes are public", "GET", "/healthz", "", nil, http.StatusOK},
+		{"Missing token", "POST", "/articles", "", root, http.StatusUnauthorized},
+		{"Unknown token", "POST", "/articles", "wapi_unknown", root, http.StatusUnauthorized},
+		{"Revoked token", "POST", "/articles", revokedToken, root, http.StatusUnauthorized},
+		{"Read token cannot write", "POST", "/articles", readToken, root, http.StatusForbidden},
+		{"Write token", "POST", "/articles", writeToken, root, http.StatusCreated},
+		{"Read without token", "GET", "/articles/root", "", nil, http.StatusUnauthorized},
+		{"Read token", "GET", "/articles/root", readToken, nil, http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.descr, func(t *testing.T) {
+			w := serve(t, router, tc.httpType, tc.endpoint, tc.bodyJSON, withToken(tc.token))
+			assert.Equal(t, tc.expCode, w.Code, "expected return code %v, but got %v: %v", tc.expCode, w.Code, w.Body.String())
+			if tc.expCode == http.StatusUnauthorized {
+				assert.Equal(t, `Bearer realm="wapi"`, w.Header().Get("WWW-Authenticate"))
+			}
+			if tc.expCode >= 400 {
+				assert.Contains(t, w.Body.String(), `"error"`)
+			}
+		})
+	}
+}
+
+// Django users authenticate with Basic credentials checked against auth_user.
+func TestDjangoAuth(t *testing.T) {
+	s := memstore.New()
+	handlers.SetStore(s)
+	defer func(a auth.Authenticator) { authn = a }(authn)
+
+	ctx := context.Background()
+	for _, u := range []m.AuthUser{
+		{Username: "admin", Password: auth.MakeDjangoPassword("admin-pw", "salt1", 1000), IsActive: true, IsSuperuser: true},
+		{Username: "alice", Password: auth.MakeDjangoPassword("alice-pw", "salt2", 1000), IsActive: true},
+		{Username: "bob", Password: auth.MakeDjangoPassword("bob-pw", "salt3", 1000), IsActive: false},
+		{Username: "carol", Password: "!unusable", IsActive: true},
+	} {
+		_, err := s.InsertAuthUser(ctx, &u)
+		assert.Nil(t, err)
+	}
+	authn = auth.Any{auth.TokenAuth{}, auth.NewDjangoAuth(s, auth.ScopeRead)}
+	router := setupRouter()
+	// All failures look the same, which keeps callers from probing for accounts.
+	unauthorized := map[string]bool{}
+
+	root := &m.RootArticle{ArticleBase: m.ArticleBase{Title: "Root", Content: "# Root"}}
+	cases := []struct {
+		descr    string
+		httpType string
+		endpoint string
+		user     string
+		password string
+		bodyJSON interface{}
+		expCode  int
+	}{
+		{"Missing credentials", "POST", "/articles", "", "", root, http.StatusUnauthorized},
+		{"Unknown user", "POST", "/articles", "mallory", "x", root, http.StatusUnauthorized},
+		{"Wrong password", "POST", "/articles", "admin", "alice-pw", root, http.StatusUnauthorized},
+		{"Inactive user", "GET", "/articles/root", "bob", "bob-pw", nil, http.StatusUnauthorized},
+		{"Inactive user with wrong password", "GET", "/articles/root", "bob", "x", nil, http.StatusUnauthorized},
+		{"Unusable password", "GET", "/articles/root", "carol", "", nil, http.StatusUnauthorized},
+		{"Regular user has the configured scope", "POST", "/articles", "alice", "alice-pw", root, http.StatusForbidden},
+		{"Superuser has admin scope", "POST", "/articles", "admin", "admin-pw", root, http.StatusCreated},
+		{"Regular user reads", "GET", "/articles/root", "alice", "alice-pw", nil, http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.descr, func(t *testing.T) {
+			w := serve(t, router, tc.httpType, tc.endpoint, tc.bodyJSON, withBasicAuth(tc.user, tc.password))
+			assert.Equal(t, tc.expCode, w.Code, "expected return code %v, but got %v: %v", tc.expCode, w.Code, w.Body.String())
+			if tc.expCode == http.StatusUnauthorized {
+				assert.Contains(t, w.Header().Get("WWW-Authenticate"), `Basic realm="wapi"`)
+				unauthorized[w.Body.String()] = true
+			}
+		})
+	}
+	assert.Len(t, unauthorized, 1, "different responses to failed logins: %v", unauthorized)
+}
+
+// Reads and writes follow django-wiki's owner/group/other permissions.
+func TestPermissions(t *testing.T) {
+	s := memstore.New()
+	handlers.SetStore(s)
+	defer func(a auth.Authenticator) { authn = a }(authn)
+
+	ctx := context.Background()
+	userIDs := map[string]int{}
+	for _, u := range []m.AuthUser{
+		{Username: "admin", IsSuperuser: true},
+		{Username: "alice"},
+		{Username: "bob"},
+		{Username: "carol"},
+	} {
+		u.Password = auth.MakeDjangoPassword(u.Username+"-pw", "salt", 1000)
+		u.IsActive = true
+		id, err := s.InsertAuthUser(ctx, &u)
+		assert.Nil(t, err)
+		userIDs[u.Username] = id
+	}
+	editors, err := s.InsertAuthGroup(ctx, "editors")
+	assert.Nil(t, err)
+	others, err := s.InsertAuthGroup(ctx, "others")
+	assert.Nil(t, err)
+	assert.Nil(t, s.AddAuthUserToGroup(ctx, userIDs["alice"], editors))
+	assert.Nil(t, s.AddAuthUserToGroup(ctx, userIDs["carol"], editors))
+	authn = auth.NewDjangoAuth(s, auth.ScopeWrite)
+	router := setupRouter()
+
+	cases := []struct {
+		descr    string
+		httpType string
+		endpoint string
+		user     string
+		bodyJSON interface{}
+		expCode  int
+	}{
+		{"Create root", "POST", "/articles", "admin",
+			gin.H{"title": "Root"}, http.StatusCreated},
+		{"Create restricted section", "POST", "/articles", "alice",
+			gin.H{"title": "Editors", "parent_art_id": 1, "slug": "editors", "permissions": gin.H{
+				"group_id": editors, "other_read": false, "other_write": false}},
+			http.StatusCreated},
+		{"Group member reads", "GET", "/articles/2", "carol", nil, http.StatusOK},
+		{"Others cannot read", "GET", "/articles/2", "bob", nil, http.StatusForbidden},
+		{"Others cannot read by path", "GET", "/articles/by-path/editors", "bob", nil, http.StatusForbidden},
+		{"Moderator reads", "GET", "/articles/2", "admin", nil, http.StatusOK},
+		{"Others cannot create children", "POST", "/articles", "bob",
+			gin.H{"title": "Spam", "parent_art_id": 2, "slug": "spam"}, http.StatusForbidden},
+		{"Group member creates child", "POST", "/articles", "carol",
+			gin.H{"title": "Child", "parent_art_id": 2, "slug": "child"}, http.StatusCreated},
+		{"Child inherits the permissions", "GET", "/articles/3", "bob", nil, http.StatusForbidden},
+		{"Group member edits", "PUT", "/articles/2", "carol",
+			gin.H{"title": "Editors", "content": "# Editors only"}, http.StatusOK},
+		{"Group member cannot change permissions", "PUT", "/articles/2", "carol",
+			gin.H{"title": "Editors", "permissions": gin.H{"other_read": true}}, http.StatusForbidden},
+		{"Owner cannot assign another owner", "PUT", "/articles/2", "alice",
+			gin.H{"title": "Editors", "permissions": gin.H{"owner_id": userIDs["bob"]}}, http.StatusForbidden},
+		{"Owner cannot assign a foreign group", "PUT", "/articles/2", "alice",
+			gin.H{"title": "Editors", "permissions": gin.H{"group_id": others}}, http.StatusForbidden},
+		{"Owner opens the section for reading", "PUT", "/articles/2", "alice",
+			gin.H{"title": "Editors", "content": "# Editors only", "permissions": gin.H{"other_read": true}}, http.StatusOK},
+		{"Others read", "GET", "/articles/by-path/editors", "bob", nil, http.StatusOK},
+		{"Others cannot copy the section with unreadable children", "POST", "/articles/2/copy", "bob",
+			gin.H{"parent_art_id": 1, "slug": "copy"}, http.StatusForbidden},
+		{"Group member copies the section", "POST", "/articles/2/copy", "carol",
+			gin.H{"parent_art_id": 1, "slug": "copy"}, http.StatusCreated},
+		{"Copies keep the permission flags", "GET", "/articles/by-path/copy/child", "bob", nil, http.StatusForbidden},
+		{"Others still cannot write", "PUT", "/articles/2", "bob",
+			gin.H{"title": "Vandalism"}, http.StatusForbidden},
+		{"Moderator assigns the owner", "PUT", "/articles/2", "admin",
+			gin.H{"title": "Editors", "permissions": gin.H{"owner_id": userIDs["bob"]}}, http.StatusOK},
+		{"New owner writes", "PUT", "/articles/2", "bob",
+			gin.H{"title": "Bob's editors"}, http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.descr, func(t *testing.T) {
+			w := serve(t, router, tc.httpType, tc.endpoint, tc.bodyJSON, withBasicAuth(tc.user, tc.user+"-pw"))
+			assert.Equal(t, tc.expCode, w.Code, "expected return code %v, but got %v: %v", tc.expCode, w.Code, w.Body.String())
+		})
+	}
+
+	a, err := s.SelectArticleByID(ctx, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, m.Permissions{OwnerID: userIDs["bob"], GroupID: editors,
+		GroupRead: true, GroupWrite: true, OtherRead: true}, *a.Permissions)
+	assert.Equal(t, "Bob's editors", a.Title)
+}
+
+// Writes record the user, the client IP, the message and what happened in the
+// revisions. Move and delete add revisions, too.
+func TestRevisionMeta(t *testing.T) {
+	s := memstore.New()
+	handlers.SetStore(s)
+	defer func(a auth.Authenticator) { authn = a }(authn)
+	defer handlers.SetTrustedProxies(nil)
+
+	ctx := context.Background()
+	aliceID, err := s.InsertAuthUser(ctx, &m.AuthUser{Username: "alice", IsActive: true,
+		Password: auth.MakeDjangoPassword("alice-pw", "salt", 1000)})
+	assert.Nil(t, err)
+	_, err = s.InsertAuthUser(ctx, &m.AuthUser{Username: "bob", IsActive: true,
+		Password: auth.MakeDjangoPassword("bob-pw", "salt", 1000)})
+	assert.Nil(t, err)
+	authn = auth.NewDjangoAuth(s, auth.ScopeWrite)
+	proxies, err := utils.ParseTrustedProxies("10.0.0.0/8, 192.0.2.1")
+	assert.Nil(t, err)
+	handlers.SetTrustedProxies(proxies)
+	router := setupRouter()
+
+	cases := []struct {
+		descr      string
+		httpType   string
+		endpoint   string
+		remoteAddr string
+		xff        string
+		bodyJSON   interface{}
+		expCode    int
+	}{
+		{"Create root", "POST", "/articles", "198.51.100.7:4711", "",
+			gin.H{"title": "Root", "user_message": "Initial import"}, http.StatusCreated},
+		{"Create /a", "POST", "/articles", "192.0.2.1:4711", "203.0.113.9",
+			gin.H{"title": "A", "parent_art_id": 1, "slug": "a"}, http.StatusCreated},
+		{"Create /a/b", "POST", "/articles", "10.1.1.1:4711", "203.0.113.9, 198.51.100.8, 10.2.2.2",
+			gin.H{"title": "B", "parent_art_id": 2, "slug": "b"}, http.StatusCreated},
+		{"Untrusted peer", "PUT", "/articles/3", "198.51.100.9:4711", "203.0.113.9",
+			gin.H{"title": "B", "content": "# B", "user_message": "Add header"}, http.StatusOK},
+		{"Unchanged", "PUT", "/articles/3", "198.51.100.9:4711", "",
+			gin.H{"title": "B", "content": "# B"}, http.StatusOK},
+		{"Move below root", "POST", "/articles/3/move", "198.51.100.9:4711", "",
+			gin.H{"parent_art_id": 1, "user_message": "Flatten"}, http.StatusOK},
+		{"Reorder", "POST", "/articles/3/move", "198.51.100.9:4711", "",
+			gin.H{"parent_art_id": 1, "position": 0}, http.StatusOK},
+		{"Cannot move root", "POST", "/articles/1/move", "198.51.100.9:4711", "",
+			gin.H{"parent_art_id": 2}, http.StatusConflict},
+		{"Cannot move below itself", "POST", "/articles/2/move", "198.51.100.9:4711", "",
+			gin.H{"parent_art_id": 2}, http.StatusConflict},
+		{"Delete", "DELETE", "/articles/3", "198.51.100.9:4711", "",
+			gin.H{"user_message": "Obsolete"}, http.StatusOK},
+		{"Delete again", "DELETE", "/articles/3", "198.51.100.9:4711", "", nil, http.StatusOK},
+		{"Restore", "PUT", "/articles/3", "198.51.100.9:4711", "",
+			gin.H{"title": "B", "content": "# B"}, http.StatusOK},
+		{"Cannot delete root", "DELETE", "/articles/1", "198.51.100.9:4711", "", nil, http.StatusConflict},
+		{"Purge requires admin", "DELETE", "/articles/2?purge=true", "198.51.100.9:4711", "", nil, http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		t.Run(tc.descr, func(t *testing.T) {
+			w := serve(t, router, tc.httpType, tc.endpoint, tc.bodyJSON, withRemoteAddr(tc.remoteAddr),
+				withHeader("X-Forwarded-For", tc.xff), withBasicAuth("alice", "alice-pw"))
+			assert.Equal(t, tc.expCode, w.Code, "expected return code %v, but got %v: %v", tc.expCode, w.Code, w.Body.String())
+		})
+	}
+
+	assert.Equal(t, []m.RevisionMeta{{UserID: aliceID, IPAddress: "198.51.100.7",
+		UserMessage: "Initial import", AutomaticLog: "Created via API"}}, s.RevisionMetas(1))
+	assert.Equal(t, "203.0.113.9", s.RevisionMetas(2)[0].IPAddress)
+	ip := "198.51.100.9"
+	assert.Equal(t, []m.RevisionMeta{
+		{UserID: aliceID, IPAddress: "198.51.100.8", AutomaticLog: "Created via API"},
+		{UserID: aliceID, IPAddress: ip, UserMessage: "Add header", AutomaticLog: "Updated via API"},
+		{UserID: aliceID, IPAddress: ip, UserMessage: "Flatten", AutomaticLog: "Moved from /a/b/ to /b/"},
+		{UserID: aliceID, IPAddress: ip, UserMessage: "Obsolete", AutomaticLog: "Deleted via API", Deleted: true},
+		{UserID: aliceID, IPAddress: ip, AutomaticLog: "Restored via API"},
+	}, s.RevisionMetas(3))
+
+	// The admin scope purges the subtree.
+	authn = auth.Disabled{}
+	w := serve(t, setupRouter(), "DELETE", "/articles/2?purge=true", nil)
+	assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+	_, err = s.SelectArticleByID(ctx, 2)
+	assert.True(t, errors.Is(err, store.ErrNotFound), "unexpected error %v", err)
+	_, err = s.SelectArticleByID(ctx, 3)
+	assert.Nil(t, err)
+}
+
+// Locked articles can only be changed by callers with the admin scope.
+func TestLock(t *testing.T) {
+	s := memstore.New()
+	handlers.SetStore(s)
+	defer func(a auth.Authenticator) { authn = a }(authn)
+
+	tokens := &auth.MemTokens{}
+	tokenOf := map[string]string{}
+	for name, scope := range map[string]auth.Scope{"writer": auth.ScopeWrite, "admin": auth.ScopeAdmin} {
+		token, hash, err := auth.NewToken()
+		assert.Nil(t, err)
+		_, err = tokens.InsertAPIToken(context.Background(), &m.APIToken{Name: name, Hash: hash, Scope: scope.String()})
+		assert.Nil(t, err)
+		tokenOf[name] = token
+	}
+	authn = auth.TokenAuth{Stores: []auth.TokenStore{tokens}}
+	router := setupRouter()
+
+	cases := []struct {
+		descr    string
+		httpType string
+		endpoint string
+		token    string
+		bodyJSON interface{}
+		expCode  int
+	}{
+		{"Create root", "POST", "/articles", "admin", gin.H{"title": "Root"}, http.StatusCreated},
+		{"Create /a", "POST", "/articles", "writer",
+			gin.H{"title": "A", "parent_art_id": 1, "slug": "a"}, http.StatusCreated},
+		{"Create /b", "POST", "/articles", "writer",
+			gin.H{"title": "B", "parent_art_id": 1, "slug": "b"}, http.StatusCreated},
+		{"Writer locks", "POST", "/articles/2/lock", "writer",
+			gin.H{"user_message": "Stable"}, http.StatusOK},
+		{"Writer cannot lock again", "POST", "/articles/2/lock", "writer", nil, http.StatusLocked},
+		{"Writer cannot update", "PUT", "/articles/2", "writer", gin.H{"title": "A2"}, http.StatusLocked},
+		{"Writer cannot move", "POST", "/articles/2/move", "writer",
+			gin.H{"parent_art_id": 3}, http.StatusLocked},
+		{"Writer cannot delete", "DELETE", "/articles/2", "writer", nil, http.StatusLocked},
+		{"Writer cannot unlock", "DELETE", "/articles/2/lock", "writer", nil, http.StatusLocked},
+		{"Children may still be added", "POST", "/articles", "writer",
+			gin.H{"title": "C", "parent_art_id": 2, "slug": "c"}, http.StatusCreated},
+		{"Admin updates", "PUT", "/articles/2", "admin", gin.H{"title": "A2"}, http.StatusOK},
+		{"Admin moves", "POST", "/articles/2/move", "admin",
+			gin.H{"parent_art_id": 3}, http.StatusOK},
+		{"Admin unlocks", "DELETE", "/articles/2/lock", "admin", nil, http.StatusOK},
+		{"Writer updates", "PUT", "/articles/2", "writer", gin.H{"title": "A3"}, http.StatusOK},
+		{"Unlocking an unlocked article", "DELETE", "/articles/2/lock", "writer", nil, http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.descr, func(t *testing.T) {
+			w := serve(t, router, tc.httpType, tc.endpoint, tc.bodyJSON, withToken(tokenOf[tc.token]))
+			assert.Equal(t, tc.expCode, w.Code, "expected return code %v, but got %v: %v", tc.expCode, w.Code, w.Body.String())
+		})
+	}
+
+	var logs []string
+	for _, meta := range s.RevisionMetas(2) {
+		logs = append(logs, fmt.Sprintf("%v %v", meta.AutomaticLog, meta.Locked))
+	}
+	assert.Equal(t, []string{
+		"Created via API false",
+		"Locked via API true",
+		"Updated via API true",
+		"Moved from /a/ to /b/a/ true",
+		"Unlocked via API false",
+		"Updated via API false",
+	}, logs)
+}
+
+// Articles carry an ETag. Writes with an outdated If-Match fail with 412, reads with
+// a current If-None-Match return 304.
+func TestETag(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+
+	w := serve(t, router, "POST", "/articles", gin.H{"title": "Root"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	w = serve(t, router, "POST", "/articles", gin.H{"title": "A", "parent_art_id": 1, "slug": "a"})
+	assert.Equal(t, http.StatusCreated, w.Code)
+	created := w.Header().Get("ETag")
+	assert.NotEmpty(t, created)
+
+	w = serve(t, router, "GET", "/articles/2", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, created, w.Header().Get("ETag"))
+	w = serve(t, router, "GET", "/articles/by-path/a", nil, withHeader("If-None-Match", created))
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+	w = serve(t, router, "GET", "/articles/2", nil, withHeader("If-None-Match", `"4711", W/`+created))
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	w = serve(t, router, "GET", "/articles/root", nil, withHeader("If-None-Match", created))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(t, router, "PUT", "/articles/2", gin.H{"title": "A2"}, withHeader("If-Match", created))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	updated := w.Header().Get("ETag")
+	assert.NotEqual(t, created, updated)
+
+	// Permissions change without a revision.
+	w = serve(t, router, "PUT", "/articles/2", gin.H{"title": "A2", "permissions": gin.H{"other_write": false}},
+		withHeader("If-Match", updated))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.NotEqual(t, updated, w.Header().Get("ETag"))
+	w = serve(t, router, "GET", "/articles/2", nil, withHeader("If-None-Match", updated))
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = serve(t, router, "PUT", "/articles/2", gin.H{"title": "A2", "permissions": gin.H{"other_write": true}},
+		withHeader("If-Match", updated))
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code, w.Body.String())
+
+	// A second editor still holding the first revision.
+	cases := []struct {
+		descr    string
+		httpType string
+		endpoint string
+		bodyJSON interface{}
+	}{
+		{"Update", "PUT", "/articles/2", gin.H{"title": "A3"}},
+		{"Move", "POST", "/articles/2/move", gin.H{"parent_art_id": 1}},
+		{"Lock", "POST", "/articles/2/lock", nil},
+		{"Delete", "DELETE", "/articles/2", nil},
+	}
+	for _, tc := range cases {
+		t.Run(tc.descr, func(t *testing.T) {
+			w := serve(t, router, tc.httpType, tc.endpoint, tc.bodyJSON, withHeader("If-Match", created))
+			assert.Equal(t, http.StatusPreconditionFailed, w.Code, w.Body.String())
+		})
+	}
+	w = serve(t, router, "GET", "/articles/2", nil, withHeader("If-None-Match", created))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"A2"`)
+
+	w = serve(t, router, "DELETE", "/articles/2", nil, withHeader("If-Match", "*"))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = serve(t, router, "PUT", "/articles/2", gin.H{"title": "A3"}, withHeader("If-Match", "W/"+w.Header().Get("ETag")))
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code, "weak tags never match If-Match")
+}
+
+// A retried POST with the same Idempotency-Key replays the first response instead of
+// creating another article.
+func TestIdempotencyKey(t *testing.T) {
+	clearDB()
+	defer func(s idempotency.Store) { idempotencyKeys = s }(idempotencyKeys)
+	idempotencyKeys = idempotency.NewMemStore()
+	router := setupRouter()
+	key := func(k string) func(*http.Request) { return withHeader(idempotency.Header, k) }
+
+	assert.Equal(t, http.StatusCreated, serve(t, router, "POST", "/articles", gin.H{"title": "Root"}).Code)
+	child := gin.H{"title": "A", "parent_art_id": 1, "slug": "a"}
+	first := serve(t, router, "POST", "/articles", child, key("import-1"))
+	assert.Equal(t, http.StatusCreated, first.Code, first.Body.String())
+	assert.Empty(t, first.Header().Get("Idempotent-Replayed"))
+
+	retry := serve(t, router, "POST", "/articles", child, key("import-1"))
+	assert.Equal(t, http.StatusCreated, retry.Code, retry.Body.String())
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, first.Header().Get("Location"), retry.Header().Get("Location"))
+	assert.Equal(t, first.Header().Get("ETag"), retry.Header().Get("ETag"))
+
+	w := serve(t, router, "POST", "/articles", gin.H{"title": "B", "parent_art_id": 1, "slug": "b"}, key("import-1"))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code, w.Body.String())
+	w = serve(t, router, "POST", "/articles?mode=upsert", child, key("import-1"))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code, "the query is part of the request: %v", w.Body.String())
+
+	// Failures are not stored, the key can be retried.
+	w = serve(t, router, "POST", "/articles", gin.H{"title": "A", "parent_art_id": 1, "slug": "a"}, key("import-2"))
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	w = serve(t, router, "POST", "/articles", gin.H{"title": "C", "parent_art_id": 4711, "slug": "c"}, key("import-2"))
+	assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+
+	// A request with the same key is still running.
+	running := gin.H{"title": "D", "parent_art_id": 1, "slug": "d"}
+	body, _ := json.Marshal(running)
+	_, err := idempotencyKeys.ReserveIdempotencyKey(context.Background(), &m.IdempotencyKey{
+		Owner: "anonymous", Key: "import-3", Fingerprint: idempotency.Fingerprint("POST", "/articles", body),
+		Expires: time.Now().Add(time.Minute)})
+	assert.Nil(t, err)
+	w = serve(t, router, "POST", "/articles", running, key("import-3"))
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+
+	// Without a key, the retry fails on the duplicate slug.
+	assert.Equal(t, http.StatusConflict, serve(t, router, "POST", "/articles", child).Code)
+
+	var root m.RootArticle
+	decodeJSON(t, serve(t, router, "GET", "/articles/root", nil), &root)
+	assert.Equal(t, 4, root.Right, "expected exactly one child")
+}
+
+// Keys of a token and of a user with the same name do not collide.
+func TestIdempotencyKeyOwner(t *testing.T) {
+	s := memstore.New()
+	handlers.SetStore(s)
+	defer func(a auth.Authenticator) { authn = a }(authn)
+	defer func(s idempotency.Store) { idempotencyKeys = s }(idempotencyKeys)
+	idempotencyKeys = idempotency.NewMemStore()
+
+	ctx := context.Background()
+	_, err := s.InsertAuthUser(ctx, &m.AuthUser{Username: "admin", IsActive: true, IsSuperuser: true,
+		Password: auth.MakeDjangoPassword("admin-pw", "salt1", 1000)})
+	assert.Nil(t, err)
+	tokens := &auth.MemTokens{}
+	token, hash, err := auth.NewToken()
+	assert.Nil(t, err)
+	_, err = tokens.InsertAPIToken(ctx, &m.APIToken{Name: "admin", Hash: hash, Scope: auth.ScopeAdmin.String()})
+	assert.Nil(t, err)
+	authn = auth.Any{auth.TokenAuth{Stores: []auth.TokenStore{tokens}}, auth.NewDjangoAuth(s, auth.ScopeRead)}
+	router := setupRouter()
+
+	w := serve(t, router, "POST", "/articles", gin.H{"title": "Root"},
+		withHeader(idempotency.Header, "k"), withToken(token))
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	w = serve(t, router, "POST", "/articles", gin.H{"title": "A", "parent_art_id": 1, "slug": "a"},
+		withHeader(idempotency.Header, "k"), withBasicAuth("admin", "admin-pw"))
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+}
+
+// POST /articles?mode=upsert creates missing articles and updates existing ones.
+func TestUpsert(t *testing.T) {
+	clearDB()
+	router := setupRouter()
+
+	cases := []struct {
+		descr     string
+		bodyJSON  interface{}
+		expCode   int
+		expResult string
+		expID     int
+	}{
+		{"Create root", gin.H{"title": "Root"}, http.StatusCreated, "created", 1},
+		{"Root unchanged", gin.H{"title": "Root"}, http.StatusOK, "unchanged", 1},
+		{"Create by parent and slug", gin.H{"title": "A", "parent_art_id": 1, "slug": "a"},
+			http.StatusCreated, "created", 2},
+		{"Unchanged by path", gin.H{"title": "A", "path": "/a/"}, http.StatusOK, "unchanged", 2},
+		{"Create by path", gin.H{"title": "B", "content": "# B", "path": "a/b"},
+			http.StatusCreated, "created", 3},
+		{"Update by parent and slug", gin.H{"title": "B", "content": "# B2", "parent_art_id": 2, "slug": "b"},
+			http.StatusOK, "updated", 3},
+		{"Update permissions only", gin.H{"title": "B", "content": "# B2", "path": "a/b",
+			"permissions": gin.H{"other_write": false}}, http.StatusOK, "updated", 3},
+		{"Unchanged", gin.H{"title": "B", "content": "# B2", "path": "a/b"}, http.StatusOK, "unchanged", 3},
+		{"Missing parent", gin.H{"title": "C", "path": "x/c"}, http.StatusNotFound, "", 0},
+		{"Unknown parent ID", gin.H{"title": "C", "parent_art_id": 4711, "slug": "c"}, http.StatusNotFound, "", 0},
+		{"Path and slug", gin.H{"title": "C", "path": "a/c", "slug": "c"}, http.StatusBadRequest, "", 0},
+		{"Parent without slug", gin.H{"title": "Hijack", "parent_art_id": 2}, http.StatusBadRequest, "", 0},
+		{"Slug with trailing slash", gin.H{"title": "Hijack", "parent_art_id": 1, "slug": "a/"}, http.StatusBadRequest, "", 0},
+		{"Slug of a grandchild", gin.H{"title": "Hijack", "parent_art_id": 1, "slug": "a/b"}, http.StatusBadRequest, "", 0},
+		{"Slug without parent", gin.H{"title": "Hijack", "slug": "a"}, http.StatusBadRequest, "", 0},
+		{"Invalid slug in path", gin.H{"title": "C", "path": "a/_c"}, http.StatusBadRequest, "", 0},
+		{"Rejected upserts changed nothing", gin.H{"title": "B", "content": "# B2", "path": "a/b"}, http.StatusOK, "unchanged", 3},
+	}
+	for _, tc := range cases {
+		t.Run(tc.descr, func(t *testing.T) {
+			w := serve(t, router, "POST", "/articles?mode=upsert", tc.bodyJSON)
+			assert.Equal(t, tc.expCode, w.Code, "expected return code %v, but got %v: %v", tc.expCode, w.Code, w.Body.String())
+			if tc.expResult == "" {
+				return
+			}
+			var res struct {
+				Result  string    `json:"result"`
+				Article m.Article `json:"article"`
+			}
+			decodeJSON(t, w, &res)
+			assert.Equal(t, tc.expResult, res.Result)
+			assert.Equal(t, tc.expID, res.Article.ID)
+			get := serve(t, router, "GET", "/articles/"+strconv.Itoa(res.Article.ID), nil)
+			assert.Equal(t, get.Header().Get("ETag"), w.Header().Get("ETag"))
+		})
+	}
+
+	w := serve(t, router, "POST", "/articles?mode=merge", `{"title": "X"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+// POST /articles/batch runs all operations in one transaction.
+func TestBatch(t *testing.T) {
+	s := memstore.New()
+	handlers.SetStore(s)
+	router := setupRouter()
+	type result struct {
+		Index      int    `json:"index"`
+		Op         string `json:"op"`
+		Ref        string `json:"ref"`
+		ID         int    `json:"id"`
+		Status     int    `json:"status"`
+		Result     string `json:"result"`
+		RevisionID int    `json:"revision_id"`
+	}
+
+	w := serve(t, router, "POST", "/articles/batch", gin.H{"operations": []gin.H{
+		{"op": "create", "ref": "root", "title": "Root"},
+		{"op": "create", "ref": "docs", "parent_ref": "root", "slug": "docs", "title": "Docs"},
+		{"op": "create", "ref": "intro", "parent_ref": "docs", "slug": "intro", "title": "Intro"},
+		{"op": "create", "ref": "old", "parent_ref": "root", "slug": "old", "title": "Old"},
+		{"op": "update", "id_ref": "intro", "title": "Intro", "content": "# Intro"},
+		{"op": "update", "id_ref": "docs", "title": "Docs"},
+		{"op": "move", "id_ref": "intro", "parent_ref": "root", "position": 0},
+		{"op": "delete", "id_ref": "old", "user_message": "Obsolete"},
+	}})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var out struct {
+		Results []result `json:"results"`
+	}
+	decodeJSON(t, w, &out)
+	var got []string
+	for i, r := range out.Results {
+		assert.Equal(t, i, r.Index)
+		assert.NotZero(t, r.RevisionID)
+		got = append(got, fmt.Sprintf("%v %v %v %v", r.Op, r.ID, r.Status, r.Result))
+	}
+	assert.Equal(t, []string{
+		"create 1 201 created",
+		"create 2 201 created",
+		"create 3 201 created",
+		"create 4 201 created",
+		"update 3 200 updated",
+		"update 2 200 unchanged",
+		"move 3 200 moved",
+		"delete 4 200 deleted",
+	}, got)
+	intro, err := s.SelectArticleByPath(context.Background(), "intro")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, intro.ID)
+	assert.Equal(t, "# Intro", intro.Content)
+
+	// A failing operation rolls back the whole batch.
+	w = serve(t, router, "POST", "/articles/batch", gin.H{"operations": []gin.H{
+		{"op": "create", "ref": "new", "parent_art_id": 1, "slug": "new", "title": "New"},
+		{"op": "update", "id": 2, "title": "Docs 2"},
+		{"op": "create", "parent_ref": "new", "slug": "a", "title": "A"},
+		{"op": "create", "parent_ref": "new", "slug": "a", "title": "A again"},
+	}})
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	var failure struct {
+		Error string `json:"error"`
+		Index int    `json:"index"`
+	}
+	decodeJSON(t, w, &failure)
+	assert.Equal(t, 3, failure.Index)
+	_, err = s.SelectArticleByPath(context.Background(), "new")
+	assert.True(t, errors.Is(err, store.ErrNotFound), "unexpected error %v", err)
+	docs, err := s.SelectArticleByID(context.Background(), 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "Docs", docs.Title)
+	violations, err := s.MPTTViolations(context.Background())
+	assert.Nil(t, err)
+	assert.Empty(t, violations)
+
+	cases := []struct {
+		descr   string
+		op      gin.H
+		expCode int
+	}{
+		{"Unknown ref", gin.H{"op": "update", "id_ref": "nope", "title": "X"}, http.StatusBadRequest},
+		{"ID and ref", gin.H{"op": "create", "parent_art_id": 1, "parent_ref": "x", "slug": "x"}, http.StatusBadRequest},
+		{"Unknown op", gin.H{"op": "rename", "id": 2}, http.StatusBadRequest},
+		{"Unknown article", gin.H{"op": "delete", "id": 4711}, http.StatusNotFound},
+		{"Outdated If-Match", gin.H{"op": "update", "id": 2, "title": "X", "if_match": `"1"`}, http.StatusPreconditionFailed},
+	}
+	for _, tc := range cases {
+		t.Run(tc.descr, func(t *testing.T) {
+			w := serve(t, router, "POST", "/articles/batch", gin.H{"operations": []gin.H{tc.op}})
+			assert.Equal(t, tc.expCode, w.Code, w.Body.String())
+		})
+	}
+}
+
+func TestImport(t *testing.T) {
+	s := newFixture(t, node{parentID: 1, slug: "docs"})
+	router := setupRouter()
+	docsID := 2
+
+	w := serve(t, router, "POST", "/articles/import", gin.H{"parent_art_id": docsID, "user_message": "Migration", "articles": []gin.H{
+		{"slug": "guide", "title": "Guide", "content": "# Guide", "children": []gin.H{
+			{"slug": "install", "title": "Install"},
+			{"slug": "usage", "title": "Usage"},
+		}},
+		{"slug": "faq", "title": "FAQ"},
+	}})
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var out struct {
+		Articles []struct {
+			ID   int    `json:"id"`
+			Path string `json:"path"`
+		} `json:"articles"`
+	}
+	decodeJSON(t, w, &out)
+	var got []string
+	for _, a := range out.Articles {
+		got = append(got, a.Path)
+		path, err := s.SelectURLPath(context.Background(), a.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, a.Path, path)
+	}
+	assert.Equal(t, []string{"docs/guide/", "docs/guide/install/", "docs/guide/usage/", "docs/faq/"}, got)
+	guide, err := s.SelectArticleByPath(context.Background(), "docs/guide")
+	assert.Nil(t, err)
+	assert.Equal(t, "# Guide", guide.Content)
+	metas := s.RevisionMetas(guide.ID)
+	assert.Len(t, metas, 1)
+	assert.Equal(t, "Migration", metas[0].UserMessage)
+	assert.Equal(t, "Imported via API", metas[0].AutomaticLog)
+	violations, err := s.MPTTViolations(context.Background())
+	assert.Nil(t, err)
+	assert.Empty(t, violations)
+
+	cases := []struct {
+		descr   string
+		payload gin.H
+		expCode int
+	}{
+		{"No parent", gin.H{"articles": []gin.H{{"slug": "x"}}}, http.StatusBadRequest},
+		{"Unknown parent", gin.H{"parent_art_id": 4711, "articles": []gin.H{{"slug": "x"}}}, http.StatusNotFound},
+		{"No slug", gin.H{"parent_art_id": docsID, "articles": []gin.H{{"slug": "x", "children": []gin.H{{"title": "X"}}}}}, http.StatusBadRequest},
+		{"Existing slug", gin.H{"parent_art_id": docsID, "articles": []gin.H{{"slug": "x"}, {"slug": "faq"}}}, http.StatusConflict},
+	}
+	for _, tc := range cases {
+		t.Run(tc.descr, func(t *testing.T) {
+			w := serve(t, router, "POST", "/articles/import", tc.payload)
+			assert.Equal(t, tc.expCode, w.Code, w.Body.String())
+		})
+	}
+	_, err = s.SelectArticleByPath(context.Background(), "docs/x")
+	assert.True(t, errors.Is(err, store.ErrNotFound), "unexpected error %v", err)
+}
+
+func TestSearch(t *testing.T) {
+	newFixture(t,
+		node{parentID: 1, slug: "a2", title: "A2", content: "The wiki API"},
+		node{parentID: 2, slug: "a3", title: "A3", content: "The wiki API"},
+		node{parentID: 1, slug: "a4", title: "A4", content: "The wiki API"})
+	router := setupRouter()
+
+	cases := []struct {
+		query      string
+		expCode    int
+		expTotal   int
+		expLen     int
+		expPath    string
+		expSnippet string
+	}{
+		{"q=wiki", http.StatusOK, 3, 3, "", "The <mark>wiki</mark> API"},
+		{"q=wiki&path=a2&limit=1", http.StatusOK, 2, 1, "", ""},
+		{"q=A3", http.StatusOK, 1, 1, "a2/a3/", ""},
+		{"q=", http.StatusBadRequest, 0, 0, "", ""},
+		{"q=wiki&limit=0", http.StatusBadRequest, 0, 0, "", ""},
+		{"q=wiki&limit=x", http.StatusBadRequest, 0, 0, "", ""},
+		{"q=wiki&offset=-1", http.StatusBadRequest, 0, 0, "", ""},
+		{"q=wiki&path=nope", http.StatusNotFound, 0, 0, "", ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.query, func(t *testing.T) {
+			w := serve(t, router, "GET", "/search?"+tc.query, nil)
+			assert.Equal(t, tc.expCode, w.Code, w.Body.String())
+			if tc.expCode != http.StatusOK {
+				return
+			}
+			var out struct {
+				Total   int `json:"total"`
+				Results []struct {
+					Path    string `json:"path"`
+					Snippet string `json:"snippet"`
+				} `json:"results"`
+			}
+			decodeJSON(t, w, &out)
+			assert.Equal(t, tc.expTotal, out.Total)
+			if !assert.Len(t, out.Results, tc.expLen) {
+				return
+			}
+			if tc.expPath != "" {
+				assert.Equal(t, tc.expPath, out.Results[0].Path)
+			}
+			if tc.expSnippet != "" {
+				assert.Equal(t, tc.expSnippet, out.Results[0].Snippet)
+			}
+		})
+	}
+}
+
+func TestListArticles(t *testing.T) {
+	nodes := []node{}
+	for i := 2; i <= 6; i++ {
+		nodes = append(nodes, node{parentID: 1, slug: fmt.Sprintf("a%v", i)})
+	}
+	newFixture(t, append(nodes, node{parentID: 2, slug: "b"})...)
+	router := setupRouter()
+	type page struct {
+		Articles []struct {
+			ID      int     `json:"id"`
+			Path    string  `json:"path"`
+			Content *string `json:"content"`
+		} `json:"articles"`
+		Next string `json:"next"`
+	}
+
+	var ids []int
+	cursor := ""
+	for i := 0; i < 10; i++ {
+		w := serve(t, router, "GET", "/articles?limit=3&sort=-id&cursor="+cursor, nil)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var out page
+		decodeJSON(t, w, &out)
+		for _, a := range out.Articles {
+			ids = append(ids, a.ID)
+			assert.Nil(t, a.Content)
+		}
+		if cursor = out.Next; cursor == "" {
+			break
+		}
+	}
+	assert.Equal(t, []int{7, 6, 5, 4, 3, 2, 1}, ids)
+
+	cases := []struct {
+		query       string
+		expCode     int
+		expPaths    []string
+		withContent bool
+	}{
+		{"path=a2&fields=id,path,content", http.StatusOK, []string{"a2/b/"}, true},
+		{"level=1&has_children=false&sort=modified", http.StatusOK, []string{"a3/", "a4/", "a5/", "a6/"}, false},
+		{"level=x", http.StatusBadRequest, nil, false},
+		{"modified_since=yesterday", http.StatusBadRequest, nil, false},
+		{"has_children=maybe", http.StatusBadRequest, nil, false},
+		{"deleted=x", http.StatusBadRequest, nil, false},
+		{"sort=title", http.StatusBadRequest, nil, false},
+		{"limit=501", http.StatusBadRequest, nil, false},
+		{"cursor=garbage", http.StatusBadRequest, nil, false},
+		{"fields=html", http.StatusBadRequest, nil, false},
+		{"path=nope", http.StatusNotFound, nil, false},
+		{"deleted=any&created_since=2020-01-01T00:00:00Z", http.StatusOK, nil, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.query, func(t *testing.T) {
+			w := serve(t, router, "GET", "/articles?"+tc.query, nil)
+			assert.Equal(t, tc.expCode, w.Code, w.Body.String())
+			if tc.expPaths == nil {
+				return
+			}
+			var out page
+			decodeJSON(t, w, &out)
+			var paths []string
+			for _, a := range out.Articles {
+				paths = append(paths, a.Path)
+				assert.Equal(t, tc.withContent, a.Content != nil)
+			}
+			assert.ElementsMatch(t, tc.expPaths, paths)
+			assert.Empty(t, out.Next)
+		})
+	}
+}
+
+func TestSparseFields(t *testing.T) {
+	newFixture(t, node{parentID: 1, slug: "a"}, node{parentID: 2, slug: "b"}, node{parentID: 2, slug: "c"})
+	router := setupRouter()
+
+	w := serve(t, router, "GET", "/articles/2?fields=id,title", nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var out map[string]json.RawMessage
+	decodeJSON(t, w, &out)
+	assert.Len(t, out, 2)
+	assert.JSONEq(t, `"a"`, string(out["title"]))
+	weak := w.Header().Get("ETag")
+	assert.True(t, strings.HasPrefix(weak, "W/"), weak)
+	w = serve(t, router, "GET", "/articles/2", nil)
+	assert.Equal(t, "W/"+w.Header().Get("ETag"), weak)
+	w = serve(t, router, "GET", "/articles/by-path/a/b?fields=content", nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	out = nil
+	decodeJSON(t, w, &out)
+	assert.Len(t, out, 1)
+	w = serve(t, router, "GET", "/articles/root?fields=id", nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"id": 1}`, w.Body.String())
+
+	w = serve(t, router, "GET", "/articles/2?fields=id&include=ancestors,children,revisions", nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Empty(t, w.Header().Get("ETag"))
+	out = nil
+	decodeJSON(t, w, &out)
+	var summaries []struct {
+		ID   int    `json:"id"`
+		Path string `json:"path"`
+	}
+	assert.Nil(t, json.Unmarshal(out["ancestors"], &summaries))
+	assert.Len(t, summaries, 1)
+	assert.Equal(t, 1, summaries[0].ID)
+	assert.Nil(t, json.Unmarshal(out["children"], &summaries))
+	assert.Len(t, summaries, 2)
+	assert.Equal(t, "a/b/", summaries[0].Path)
+	var revs []m.Revision
+	assert.Nil(t, json.Unmarshal(out["revisions"], &revs))
+	assert.Len(t, revs, 1)
+
+	cases := []struct {
+		endpoint string
+		expCode  int
+	}{
+		{"/articles/2?fields=html", http.StatusBadRequest},
+		{"/articles/2?include=parent", http.StatusBadRequest},
+		{"/articles/by-path/nope?fields=id", http.StatusNotFound},
+		{"/articles/99?include=children", http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		t.Run(tc.endpoint, func(t *testing.T) {
+			w := serve(t, router, "GET", tc.endpoint, nil)
+			assert.Equal(t, tc.expCode, w.Code, w.Body.String())
+		})
+	}
+}
+
+func TestRenderHTML(t *testing.T) {
+	newFixture(t, node{parentID: 1, slug: "guide", title: "Guide",
+		content: "# Start\n\nSee [article:1] and [new](wiki:new).\n\n<script>x</script>"})
+	router := setupRouter()
+	accept := func(value string) func(*http.Request) { return withHeader("Accept", value) }
+
+	const expHTML = "<h1 id=\"wiki-toc-start\">Start</h1>\n" +
+		"<p>See <a href=\"/\">Root</a> and <a href=\"/guide/new/\" class=\"linknotfound\">new</a>.</p>\n" +
+		"<!-- raw HTML omitted -->\n"
+	w := serve(t, router, "GET", "/articles/2/html", nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, expHTML, w.Body.String())
+	for _, endpoint := range []string{"/articles/2", "/articles/by-path/guide", "/articles/2?fields=id"} {
+		w = serve(t, router, "GET", endpoint, nil, accept("text/html,application/xhtml+xml;q=0.9"))
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, expHTML, w.Body.String())
+		assert.Empty(t, w.Header().Get("ETag"))
+		assert.Equal(t, "Accept", w.Header().Get("Vary"))
+	}
+	w = serve(t, router, "GET", "/articles/2", nil, accept("application/json, text/html"))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Header().Get("Content-Type"), "application/json")
+	assert.NotEmpty(t, w.Header().Get("ETag"))
+
+	cases := []struct {
+		endpoint string
+		expCode  int
+	}{
+		{"/articles/99/html", http.StatusNotFound},
+		{"/articles/x/html", http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		t.Run(tc.endpoint, func(t *testing.T) {
+			w := serve(t, router, "GET", tc.endpoint, nil)
+			assert.Equal(t, tc.expCode, w.Code, w.Body.String())
+		})
+	}
+}
+
+func TestTOC(t *testing.T) {
+	newFixture(t, node{parentID: 1, slug: "guide", title: "Guide", content: "# Guide\n\n## Install\n\n### On Linux\n"})
+	router := setupRouter()
+
+	w := serve(t, router, "GET", "/articles/2/toc", nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"id": 2, "revision_id": 3, "headings": [
+		{"level": 1, "text": "Guide", "anchor": "wiki-toc-guide"},
+		{"level": 2, "text": "Install", "anchor": "wiki-toc-install"},
+		{"level": 3, "text": "On Linux", "anchor": "wiki-toc-on-linux"}]}`, w.Body.String())
+	w = serve(t, router, "GET", "/articles/2/toc", nil, withHeader("If-None-Match", w.Header().Get("ETag")))
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	w = serve(t, router, "GET", "/articles/1/toc", nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"id": 1, "revision_id": 1, "headings": [
+		{"level": 1, "text": "Root", "anchor": "wiki-toc-root"}]}`, w.Body.String())
+	w = serve(t, router, "GET", "/articles/99/toc", nil)
+	assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+}
+
+func TestLinks(t *testing.T) {
+	s := newFixture(t,
+		node{parentID: 1, slug: "a", title: "a", content: "[b](wiki:b) [c](/c/) [gone](/gone/) [article:99]"},
+		node{parentID: 2, slug: "b", title: "b", content: "Up: [article:2]"},
+		node{parentID: 1, slug: "c", title: "c", content: "[home](/) [b](/a/b/#top)"})
+	router := setupRouter()
+	ctx := context.Background()
+
+	w := serve(t, router, "GET", "/articles/2/links", nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"id": 2, "links": [
+		{"from_id": 2, "to_path": "/a/b/", "article_id": 3, "path": "a/b/", "broken": false},
+		{"from_id": 2, "to_path": "/c/", "article_id": 4, "path": "c/", "broken": false},
+		{"from_id": 2, "to_path": "/gone/", "broken": true},
+		{"from_id": 2, "to_id": 99, "broken": true}]}`, w.Body.String())
+	w = serve(t, router, "GET", "/articles/3/backlinks", nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"id": 3, "backlinks": [
+		{"from_id": 2, "to_path": "/a/b/", "from_path": "a/", "from_title": "a"},
+		{"from_id": 4, "to_path": "/a/b/", "from_path": "c/", "from_title": "c"}]}`, w.Body.String())
+	w = serve(t, router, "GET", "/articles/2/backlinks?subtree=true", nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"to_id":2`)
+	assert.Contains(t, w.Body.String(), `"from_id":4`)
+
+	// The index follows new revisions.
+	_, err := s.AddWikiArticleRevision(ctx, 4, "c", "[a](/a/) [x](/x/)", m.RevisionMeta{})
+	assert.Nil(t, err)
+	w = serve(t, router, "GET", "/admin/links/broken", nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"links": [
+		{"from_id": 2, "to_path": "/gone/", "from_path": "a/", "from_title": "a"},
+		{"from_id": 2, "to_id": 99, "from_path": "a/", "from_title": "a"},
+		{"from_id": 4, "to_path": "/x/", "from_path": "c/", "from_title": "c"}]}`, w.Body.String())
+
+	// Moving a follows the relative link of its child b to e, although b gets no
+	// revision.
+	eID, err := storetest.InsertChild(s, 3, "e")
+	assert.Nil(t, err)
+	_, err = s.AddWikiArticleRevision(ctx, 3, "b", "Up: [article:2] [e](wiki:e)", m.RevisionMeta{})
+	assert.Nil(t, err)
+	w = serve(t, router, "GET", "/articles/"+strconv.Itoa(eID)+"/backlinks", nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"to_path":"/a/b/e/"`)
+	w = serve(t, router, "POST", "/articles/2/move", `{"parent_art_id": 4}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = serve(t, router, "GET", "/articles/"+strconv.Itoa(eID)+"/backlinks", nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"id": `+strconv.Itoa(eID)+`, "backlinks": [
+		{"from_id": 3, "to_path": "/c/a/b/e/", "from_path": "c/a/b/", "from_title": "b"}]}`, w.Body.String())
+
+	cases := []struct {
+		endpoint string
+		expCode  int
+	}{
+		{"/articles/99/links", http.StatusNotFound},
+		{"/articles/x/backlinks", http.StatusBadRequest},
+		{"/articles/2/backlinks?subtree=maybe", http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		t.Run(tc.endpoint, func(t *testing.T) {
+			w := serve(t, router, "GET", tc.endpoint, nil)
+			assert.Equal(t, tc.expCode, w.Code, w.Body.String())
+		})
+	}
+}
+
+func TestRewriteLinks(t *testing.T) {
+	s := newFixture(t,
+		node{parentID: 1, slug: "a", title: "a", content: "[b](wiki:b) [c](/c/)"},
+		node{parentID: 2, slug: "b", title: "b", content: "[up](wiki:..) [c](wiki:../../c)"},
+		node{parentID: 1, slug: "c", title: "c", content: "[b](/a/b/#top) [a](wiki:/a) `[a](/a/)`"},
+		node{parentID: 1, slug: "d", title: "d", content: "[x](/x/)"})
+	router := setupRouter()
+	ctx := context.Background()
+	content := func(id int) string {
+		a, err := s.SelectArticleByID(ctx, id)
+		assert.Nil(t, err)
+		return a.Content
+	}
+
+	// Without the option, the links break.
+	w := serve(t, router, "POST", "/articles/2/move", `{"parent_art_id": 5}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "[b](/a/b/#top) [a](wiki:/a) `[a](/a/)`", content(4))
+
+	w = serve(t, router, "POST", "/articles/2/move", `{"parent_art_id": 4, "rewrite_links": true, "user_message": "Regroup"}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "[b](wiki:b) [c](/c/)", content(2))
+	assert.Equal(t, "[up](wiki:..) [c](wiki:/d/c)", content(3))
+	// The links broke with the first move, "wiki:../../c" has pointed to /d/c/ since.
+	assert.Equal(t, "[b](/a/b/#top) [a](wiki:/a) `[a](/a/)`", content(4))
+	revs, err := s.SelectRevisions(ctx, 3)
+	assert.Nil(t, err)
+	last := revs[len(revs)-1]
+	assert.Equal(t, "Updated links from /d/a/ to /c/a/", last.AutomaticLog)
+	assert.Equal(t, "Regroup", last.UserMessage)
+
+	for _, payload := range []string{`{"parent_art_id": 1, "rewrite_links": true}`, `{"parent_art_id": 5, "rewrite_links": true}`} {
+		w = serve(t, router, "POST", "/articles/2/move", payload)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+	assert.Equal(t, "[up](wiki:..) [c](wiki:/d/c)", content(3))
+	assert.Equal(t, "[b](/d/a/b/#top) [a](wiki:/d/a) `[a](/a/)`", content(4))
+	revs, err = s.SelectRevisions(ctx, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, "Updated links from /a/ to /d/a/", revs[len(revs)-1].AutomaticLog)
+}
+
+func TestSlugs(t *testing.T) {
+	s := newFixture(t, node{parentID: 1, slug: "a"}, node{parentID: 1, slug: "b"},
+		node{parentID: 1, slug: "c", title: "c", content: "[a](/a/)"})
+	router := setupRouter()
+	ctx := context.Background()
+	slugOf := func(id int) string {
+		a, err := s.SelectArticleHeader(ctx, id)
+		assert.Nil(t, err)
+		return a.Slug
+	}
+
+	cases := []struct {
+		descr    string
+		method   string
+		endpoint string
+		payload  string
+		expCode  int
+	}{
+		{"Slash", "PATCH", "/articles/2/slug", `{"slug": "x/y"}`, http.StatusBadRequest},
+		{"Invalid character", "PATCH", "/articles/2/slug", `{"slug": "a b"}`, http.StatusBadRequest},
+		{"View", "PATCH", "/articles/2/slug", `{"slug": "_create"}`, http.StatusBadRequest},
+		{"Reserved", "PATCH", "/articles/2/slug", `{"slug": "Admin"}`, http.StatusBadRequest},
+		{"Digits", "PATCH", "/articles/2/slug", `{"slug": "2021"}`, http.StatusBadRequest},
+		{"Too long", "PATCH", "/articles/2/slug", `{"slug": "` + strings.Repeat("x", 51) + `"}`, http.StatusBadRequest},
+		{"Sibling", "PATCH", "/articles/2/slug", `{"slug": "B"}`, http.StatusConflict},
+		{"Root", "PATCH", "/articles/1/slug", `{"slug": "root"}`, http.StatusConflict},
+		{"Missing slug", "PATCH", "/articles/2/slug", `{}`, http.StatusBadRequest},
+		{"Words", "PATCH", "/articles/3/slug", `{"slug": "Getting Started!"}`, http.StatusBadRequest},
+		{"Create with invalid slug", "POST", "/articles", `{"parent_art_id": 1, "slug": "_plugin", "title": "X"}`, http.StatusBadRequest},
+		{"Create with taken slug", "POST", "/articles", `{"parent_art_id": 1, "slug": "A", "title": "X"}`, http.StatusConflict},
+		{"Import with taken slug", "POST", "/articles/import",
+			`{"parent_art_id": 1, "articles": [{"slug": "b", "title": "B"}]}`, http.StatusConflict},
+		{"Import with duplicate slugs", "POST", "/articles/import",
+			`{"parent_art_id": 1, "articles": [{"slug": "x", "title": "X"}, {"slug": "X", "title": "X"}]}`, http.StatusConflict},
+		{"Import with invalid slug", "POST", "/articles/import",
+			`{"parent_art_id": 1, "articles": [{"slug": "x", "title": "X", "children": [{"slug": "1", "title": "1"}]}]}`,
+			http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		t.Run(tc.descr, func(t *testing.T) {
+			w := serve(t, router, tc.method, tc.endpoint, tc.payload)
+			assert.Equal(t, tc.expCode, w.Code, w.Body.String())
+		})
+	}
+	assert.Equal(t, "a", slugOf(2))
+	assert.Equal(t, "b", slugOf(3))
+
+	// Slugs are stored in lower case like django-wiki does.
+	w := serve(t, router, "POST", "/articles", `{"parent_art_id": 1, "slug": "FAQ", "title": "FAQ"}`)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"slug":"faq"`)
+
+	w = serve(t, router, "PATCH", "/articles/2/slug", `{"slug": "Guide", "rewrite_links": true}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "guide", slugOf(2))
+	c, err := s.SelectArticleByID(ctx, 4)
+	assert.Nil(t, err)
+	assert.Equal(t, "[a](/guide/)", c.Content)
+	revs, err := s.SelectRevisions(ctx, 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "Moved from /a/ to /guide/", revs[len(revs)-1].AutomaticLog)
+
+	// A taken slug is rejected with a suggestion, which is only used if sent.
+	suggestion := func(w *httptest.ResponseRecorder) string {
+		var out struct {
+			SuggestedSlug string `json:"suggested_slug"`
+		}
+		decodeJSON(t, w, &out)
+		return out.SuggestedSlug
+	}
+	w = serve(t, router, "PATCH", "/articles/4/slug", `{"slug": "FAQ"}`)
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	assert.Equal(t, "faq-2", suggestion(w))
+	assert.Equal(t, "c", slugOf(4))
+	w = serve(t, router, "PATCH", "/articles/4/slug", `{"slug": "faq-2"}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Equal(t, "faq-2", slugOf(4))
+	w = serve(t, router, "POST", "/articles", `{"parent_art_id": 1, "slug": "faq", "title": "FAQ"}`)
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	assert.Equal(t, "faq-3", suggestion(w))
+	w = serve(t, router, "POST", "/articles/batch", `{"operations": [
+		{"op": "create", "parent_art_id": 1, "slug": "guide", "title": "Guide"}]}`)
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	assert.Equal(t, "guide-2", suggestion(w))
+	assert.Contains(t, w.Body.String(), `"index":0`)
+}
+
+func TestRedirects(t *testing.T) {
+	s := newFixture(t, node{parentID: 1, slug: "a"}, node{parentID: 2, slug: "b"}, node{parentID: 1, slug: "c"})
+	router := setupRouter()
+	ctx := context.Background()
+	var a m.Article
+
+	w := serve(t, router, "POST", "/articles/2/move", `{"parent_art_id": 4, "redirect": true}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = serve(t, router, "GET", "/articles/by-path/a/b", nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decodeJSON(t, w, &a)
+	assert.Equal(t, 3, a.ID)
+	assert.True(t, strings.HasSuffix(w.Header().Get("Content-Location"), "articles/by-path/c/a/b/"),
+		w.Header().Get("Content-Location"))
+	w = serve(t, router, "GET", "/articles/by-path/a/b?follow_redirects=false", nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	decodeJSON(t, w, &a)
+	assert.Equal(t, "Moved: b", a.Title)
+	assert.Equal(t, "Article moved to [wiki:/c/a/b/](wiki:/c/a/b/)", a.Content)
+	assert.Empty(t, w.Header().Get("Content-Location"))
+	revs, err := s.SelectRevisions(ctx, a.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, "Created redirect to /c/a/b/", revs[0].AutomaticLog)
+
+	// Redirects are followed across several moves.
+	w = serve(t, router, "PATCH", "/articles/2/slug", `{"slug": "guide", "redirect": true}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	w = serve(t, router, "GET", "/articles/by-path/a/b?fields=id", nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"id": 3}`, w.Body.String())
+	assert.True(t, strings.HasSuffix(w.Header().Get("Content-Location"), "articles/by-path/c/guide/b/"),
+		w.Header().Get("Content-Location"))
+	decodeJSON(t, serve(t, router, "GET", "/articles/by-path/c/a", nil), &a)
+	assert.Equal(t, 2, a.ID)
+	w = serve(t, router, "GET", "/articles/by-path/c", nil)
+	decodeJSON(t, w, &a)
+	assert.Equal(t, 4, a.ID)
+	assert.Empty(t, w.Header().Get("Content-Location"))
+
+	w = serve(t, router, "GET", "/articles/by-path/a?follow_redirects=maybe", nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+	violations, err := s.MPTTViolations(ctx)
+	assert.Nil(t, err)
+	assert.Empty(t, violations)
+}
+
+func TestCopy(t *testing.T) {
+	s := newFixture(t, node{parentID: 1, slug: "a"}, node{parentID: 2, slug: "b"}, node{parentID: 1, slug: "c"})
+	router := setupRouter()
+	ctx := context.Background()
+	w := serve(t, router, "PUT", "/articles/3", `{"title": "B", "content": "# B, edited"}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = serve(t, router, "POST", "/articles/2/copy", `{"parent_art_id": 4}`)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"articles": [{"id": 5, "path": "c/a/"}, {"id": 6, "path": "c/a/b/"}]}`, w.Body.String())
+	assert.True(t, strings.HasSuffix(w.Header().Get("Location"), "articles/5"), w.Header().Get("Location"))
+	w = serve(t, router, "GET", "/articles/by-path/c/a/b?fields=id,content", nil)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"id": 6, "content": "# B, edited"}`, w.Body.String())
+	revs, err := s.SelectRevisions(ctx, 6)
+	assert.Nil(t, err)
+	if assert.Len(t, revs, 1) {
+		assert.Equal(t, "Copied from /a/", revs[0].AutomaticLog)
+	}
+
+	// With history, all revisions are copied.
+	w = serve(t, router, "POST", "/articles/2/copy", `{"parent_art_id": 1, "slug": "Template", "history": true}`)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.JSONEq(t, `{"articles": [{"id": 7, "path": "template/"}, {"id": 8, "path": "template/b/"}]}`, w.Body.String())
+	revs, err = s.SelectRevisions(ctx, 8)
+	assert.Nil(t, err)
+	assert.Len(t, revs, 2)
+
+	cases := []struct {
+		descr    string
+		endpoint string
+		payload  string
+		expCode  int
+	}{
+		{"Slug taken", "/articles/2/copy", `{"parent_art_id": 4}`, http.StatusConflict},
+		{"Below itself", "/articles/2/copy", `{"parent_art_id": 3, "slug": "x"}`, http.StatusConflict},
+		{"Root", "/articles/1/copy", `{"parent_art_id": 4, "slug": "x"}`, http.StatusConflict},
+		{"Invalid slug", "/articles/2/copy", `{"parent_art_id": 4, "slug": "_x"}`, http.StatusBadRequest},
+		{"Missing parent", "/articles/2/copy", `{"slug": "x"}`, http.StatusBadRequest},
+		{"Unknown parent", "/articles/2/copy", `{"parent_art_id": 99, "slug": "x"}`, http.StatusNotFound},
+		{"Unknown article", "/articles/99/copy", `{"parent_art_id": 4, "slug": "x"}`, http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		t.Run(tc.descr, func(t *testing.T) {
+			w := serve(t, router, "POST", tc.endpoint, tc.payload)
+			assert.Equal(t, tc.expCode, w.Code, w.Body.String())
+		})
+	}
+	violations, err := s.MPTTViolations(ctx)
+	assert.Nil(t, err)
+	assert.Empty(t, violations)
+}
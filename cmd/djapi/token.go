@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"coco-life.de/wapi/internal/auth"
+	"coco-life.de/wapi/internal/db"
+	"coco-life.de/wapi/internal/models"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const tokenUsage = `Usage:
+  djapi token create -name <name> [-scope read|write|admin] [-user-id <id>] [-file]
+  djapi token list
+  djapi token revoke <id>
+
+'create' prints the new token once, only its hash is stored. With -file the token is
+not stored in the database; instead the line to add to WAPI_TOKEN_FILE is printed.
+`
+
+// runTokenCmd implements the subcommand 'djapi token' to manage API tokens in
+// wapi_apitoken.
+func runTokenCmd(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%v", tokenUsage)
+	}
+	ctx := context.Background()
+	connect := func() (*pgxpool.Pool, error) {
+		dbpool, err := pgxpool.Connect(ctx, "")
+		if err != nil {
+			return nil, fmt.Errorf("Unable to connect to database: %v", err)
+		}
+		if err := db.EnsureAPISchema(ctx, dbpool); err != nil {
+			dbpool.Close()
+			return nil, err
+		}
+		return dbpool, nil
+	}
+
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("token create", flag.ContinueOnError)
+		name := fs.String("name", "", "name of the token, e.g. the client using it")
+		scopeName := fs.String("scope", "read", "scope of the token: read, write or admin")
+		userID := fs.Int("user-id", 0, "auth_user-id of the Django user the token acts as")
+		toFile := fs.Bool("file", false, "print a line for WAPI_TOKEN_FILE instead of storing the token")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *name == "" {
+			return fmt.Errorf("-name is required\n%v", tokenUsage)
+		}
+		scope, err := auth.ParseScope(*scopeName)
+		if err != nil {
+			return err
+		}
+		token, hash, err := auth.NewToken()
+		if err != nil {
+			return err
+		}
+		if *toFile {
+			fmt.Fprintf(out, "Token: %v\nLine for WAPI_TOKEN_FILE:\n%v\n",
+				token, auth.TokenFileLine(*name, scope, hash))
+			return nil
+		}
+		dbpool, err := connect()
+		if err != nil {
+			return err
+		}
+		defer dbpool.Close()
+		id, err := db.NewPgStore(dbpool).InsertAPIToken(ctx, &models.APIToken{
+			Name:   *name,
+			Hash:   hash,
+			Scope:  scope.String(),
+			UserID: *userID,
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Created token %v '%v' with scope '%v':\n%v\n", id, *name, scope, token)
+		return nil
+
+	case "list":
+		dbpool, err := connect()
+		if err != nil {
+			return err
+		}
+		defer dbpool.Close()
+		tokens, err := db.NewPgStore(dbpool).SelectAPITokens(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tSCOPE\tUSER\tCREATED\tREVOKED")
+		for _, t := range tokens {
+			revoked := "-"
+			if t.Revoked != nil {
+				revoked = t.Revoked.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\n",
+				t.ID, t.Name, t.Scope, t.UserID, t.Created.Format(time.RFC3339), revoked)
+		}
+		return tw.Flush()
+
+	case "revoke":
+		if len(args) != 2 {
+			return fmt.Errorf("%v", tokenUsage)
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("Token ID needs to be an integer: %v", err)
+		}
+		dbpool, err := connect()
+		if err != nil {
+			return err
+		}
+		defer dbpool.Close()
+		if err := db.NewPgStore(dbpool).RevokeAPIToken(ctx, id); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Revoked token %v\n", id)
+		return nil
+	}
+	return fmt.Errorf("Unknown command 'token %v'\n%v", args[0], tokenUsage)
+}
@@ -0,0 +1,139 @@
+// Package auth authenticates API requests and checks the scope of the caller.
+//
+// Protected routes use Authenticate followed by Require:
+//   r.POST("/articles", auth.Authenticate(a), auth.Require(auth.ScopeWrite), handler)
+// Authenticate stores the caller's Identity in the gin context, see FromContext.
+package auth
+
+import (
+	"fmt"
+	"net/http"
+
+	"coco-life.de/wapi/internal/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// Scope is the permission level of a caller. Every scope includes the lower ones.
+type Scope int
+
+const (
+	// ScopeRead permits reading articles.
+	ScopeRead Scope = iota + 1
+	// ScopeWrite permits creating and changing articles.
+	ScopeWrite
+	// ScopeAdmin permits everything, e.g. overriding locks.
+	ScopeAdmin
+)
+
+var scopeNames = map[Scope]string{
+	ScopeRead:  "read",
+	ScopeWrite: "write",
+	ScopeAdmin: "admin",
+}
+
+func (s Scope) String() string {
+	return scopeNames[s]
+}
+
+// ParseScope parses "read", "write" or "admin".
+func ParseScope(name string) (Scope, error) {
+	for s, n := range scopeNames {
+		if n == name {
+			return s, nil
+		}
+	}
+	return 0, fmt.Errorf("Invalid scope '%v', expected one of read, write, admin", name)
+}
+
+var (
+	// ErrUnauthorized is returned if credentials are missing or invalid.
+	ErrUnauthorized = utils.NewStatusError(http.StatusUnauthorized, "missing or invalid credentials")
+	// ErrForbidden is returned if the caller lacks the required scope.
+	ErrForbidden = utils.NewStatusError(http.StatusForbidden, "insufficient scope")
+)
+
+// Identity is the authenticated caller.
+type Identity struct {
+	// Name is the name of the API token or the user.
+	Name string
+	// Key identifies the caller by kind and ID, e.g. "token:0:3" or "user:7". Unlike
+	// Name it cannot be the same for a token and a user.
+	Key   string
+	Scope Scope
+	// UserID is auth_user-id of the Django user the caller acts as, 0 if none.
+	UserID int
+	// GroupIDs are auth_group-id of the groups of the user.
+	GroupIDs []int
+}
+
+// Authenticator determines the identity of the caller of a request.
+type Authenticator interface {
+	// Authenticate returns the identity of the caller or ErrUnauthorized.
+	Authenticate(c *gin.Context) (*Identity, error)
+	// Challenge is the value of the WWW-Authenticate header of a 401 response.
+	Challenge() string
+}
+
+// identityKey is the key of the Identity in the gin context.
+const identityKey = "wapi.identity"
+
+// Disabled is an Authenticator that grants admin scope to every caller. It is meant
+// for local development only.
+type Disabled struct{}
+
+// Authenticate returns an anonymous identity with admin scope.
+func (Disabled) Authenticate(c *gin.Context) (*Identity, error) {
+	return &Identity{Name: "anonymous", Key: "anonymous", Scope: ScopeAdmin}, nil
+}
+
+// Challenge is never used.
+func (Disabled) Challenge() string {
+	return ""
+}
+
+// Authenticate returns a middleware that authenticates the request with a and
+// aborts it with 401 if that fails.
+func Authenticate(a Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := a.Authenticate(c)
+		if err != nil {
+			if utils.StatusOf(err) == http.StatusUnauthorized && a.Challenge() != "" {
+				c.Header("WWW-Authenticate", a.Challenge())
+			}
+			utils.HandleErr(c, &err, "Authentication failed: %v\n")
+			c.Abort()
+			return
+		}
+		c.Set(identityKey, id)
+		c.Next()
+	}
+}
+
+// Require returns a middleware that aborts the request with 403 unless the caller
+// has at least scope s. It has to run after Authenticate.
+func Require(s Scope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := FromContext(c)
+		var err error
+		if id == nil {
+			err = ErrUnauthorized
+		} else if id.Scope < s {
+			err = fmt.Errorf("%w: '%v' requires scope '%v'", ErrForbidden, c.FullPath(), s)
+		}
+		if notOK := utils.HandleErr(c, &err, "Authorization failed: %v\n"); notOK {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// FromContext returns the identity stored by Authenticate or nil.
+func FromContext(c *gin.Context) *Identity {
+	v, ok := c.Get(identityKey)
+	if !ok {
+		return nil
+	}
+	id, _ := v.(*Identity)
+	return id
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"coco-life.de/wapi/internal/store"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// djangoAlgorithm is the only password hasher of Django that is supported.
+const djangoAlgorithm = "pbkdf2_sha256"
+
+// CheckDjangoPassword reports whether password matches encoded, a password hash in
+// the format of Django's PBKDF2PasswordHasher:
+//   pbkdf2_sha256$<iterations>$<salt>$<base64 encoded hash>
+// An error is returned if encoded uses another format, e.g. an unusable password
+// starting with '!'.
+func CheckDjangoPassword(encoded string, password string) (bool, error) {
+	parts := strings.SplitN(encoded, "$", 4)
+	if len(parts) != 4 || parts[0] != djangoAlgorithm {
+		return false, fmt.Errorf("Unsupported password hash, expected '%v'", djangoAlgorithm)
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations <= 0 {
+		return false, fmt.Errorf("Invalid iterations '%v' of password hash", parts[1])
+	}
+	expected, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil || len(expected) == 0 {
+		return false, fmt.Errorf("Invalid password hash")
+	}
+	actual := pbkdf2.Key([]byte(password), []byte(parts[2]), iterations, len(expected), sha256.New)
+	return subtle.ConstantTimeCompare(actual, expected) == 1, nil
+}
+
+// MakeDjangoPassword returns the hash of password like Django's PBKDF2PasswordHasher.
+func MakeDjangoPassword(password string, salt string, iterations int) string {
+	key := pbkdf2.Key([]byte(password), []byte(salt), iterations, sha256.Size, sha256.New)
+	return fmt.Sprintf("%v$%v$%v$%v", djangoAlgorithm, iterations, salt,
+		base64.StdEncoding.EncodeToString(key))
+}
+
+// dummyPassword is checked instead of the password of an unknown user or of a user
+// with an unusable password, so that the response takes as long as for a wrong
+// password and its timing does not tell which accounts exist. It has Django's default
+// iterations and matches no password.
+const dummyPassword = djangoAlgorithm + "$260000$wapi-dummy$AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA="
+
+// verifiedTTL is how long a successfully checked password is remembered. PBKDF2 is
+// deliberately slow, Django's default of 260000 iterations takes about 100ms.
+const verifiedTTL = 5 * time.Minute
+
+// maxVerified limits the number of remembered passwords.
+const maxVerified = 1000
+
+// DjangoAuth authenticates requests with HTTP Basic credentials of the Django users
+// in auth_user. Inactive users are rejected. Superusers get ScopeAdmin, all other
+// users the configured scope. Use NewDjangoAuth.
+type DjangoAuth struct {
+	users store.UserStore
+	scope Scope
+
+	mu sync.Mutex
+	// verified maps the hash of an encoded password and a plaintext password to the
+	// expiry of a successful check. Changing the password in Django changes the key.
+	verified map[[sha256.Size]byte]time.Time
+}
+
+// NewDjangoAuth returns a DjangoAuth granting scope to active non-superusers.
+func NewDjangoAuth(users store.UserStore, scope Scope) *DjangoAuth {
+	return &DjangoAuth{users: users, scope: scope, verified: map[[sha256.Size]byte]time.Time{}}
+}
+
+// Authenticate checks the Basic credentials of the request against auth_user.
+func (a *DjangoAuth) Authenticate(c *gin.Context) (*Identity, error) {
+	username, password, ok := c.Request.BasicAuth()
+	if !ok {
+		return nil, ErrUnauthorized
+	}
+	user, err := a.users.SelectAuthUserByName(c.Request.Context(), username)
+	if errors.Is(err, store.ErrNotFound) {
+		CheckDjangoPassword(dummyPassword, password)
+		return nil, ErrUnauthorized
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Failed to look up user: %w", err)
+	}
+	// Inactive users are only rejected after the password check and with the same
+	// error, so the response does not tell which accounts exist.
+	if !a.checkPassword(user.Password, password) || !user.IsActive {
+		return nil, ErrUnauthorized
+	}
+	scope := a.scope
+	if user.IsSuperuser {
+		scope = ScopeAdmin
+	}
+	groupIDs, err := a.users.SelectAuthUserGroupIDs(c.Request.Context(), user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to look up groups of user: %w", err)
+	}
+	return &Identity{Name: user.Username, Key: fmt.Sprintf("user:%v", user.ID), Scope: scope, UserID: user.ID, GroupIDs: groupIDs}, nil
+}
+
+// checkPassword calls CheckDjangoPassword unless the same password has been checked
+// successfully within verifiedTTL.
+func (a *DjangoAuth) checkPassword(encoded string, password string) bool {
+	key := sha256.Sum256([]byte(encoded + "\x00" + password))
+	now := time.Now()
+	a.mu.Lock()
+	expiry, ok := a.verified[key]
+	a.mu.Unlock()
+	if ok && now.Before(expiry) {
+		return true
+	}
+
+	match, err := CheckDjangoPassword(encoded, password)
+	if err != nil {
+		CheckDjangoPassword(dummyPassword, password)
+		return false
+	}
+	if !match {
+		return false
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.verified) >= maxVerified {
+		a.verified = map[[sha256.Size]byte]time.Time{}
+	}
+	a.verified[key] = now.Add(verifiedTTL)
+	return true
+}
+
+// Challenge asks for Basic credentials.
+func (a *DjangoAuth) Challenge() string {
+	return `Basic realm="wapi", charset="UTF-8"`
+}
+
+// Any is an Authenticator that tries all authenticators in order and returns the
+// first identity.
+type Any []Authenticator
+
+// Authenticate returns the first identity. If all authenticators fail, the most
+// specific error is returned, that is, the first one other than a bare
+// ErrUnauthorized.
+func (any Any) Authenticate(c *gin.Context) (*Identity, error) {
+	var firstErr error
+	for _, a := range any {
+		id, err := a.Authenticate(c)
+		if err == nil {
+			return id, nil
+		}
+		if firstErr == nil || firstErr == ErrUnauthorized {
+			firstErr = err
+		}
+	}
+	if firstErr == nil {
+		firstErr = ErrUnauthorized
+	}
+	return nil, firstErr
+}
+
+// Challenge lists the challenges of all authenticators.
+func (any Any) Challenge() string {
+	var challenges []string
+	for _, a := range any {
+		if ch := a.Challenge(); ch != "" {
+			challenges = append(challenges, ch)
+		}
+	}
+	return strings.Join(challenges, ", ")
+}
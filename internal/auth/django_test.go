@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckDjangoPassword(t *testing.T) {
+	// Computed with Python's hashlib.pbkdf2_hmac like Django's PBKDF2PasswordHasher.
+	const encoded = "pbkdf2_sha256$260000$seasalt$YlZ2Vggtqdc61YjArZuoApoBh9JNGYoDRBUGu6tcJQo="
+
+	cases := []struct {
+		descr    string
+		encoded  string
+		password string
+		expMatch bool
+		expErr   bool
+	}{
+		{"Correct password", encoded, "lètmein", true, false},
+		{"Wrong password", encoded, "letmein", false, false},
+		{"Round trip", MakeDjangoPassword("secret", "salt", 1000), "secret", true, false},
+		{"Unusable password", "!unusable", "", false, true},
+		{"Other hasher", "argon2$argon2id$v=19$m=102400,t=2,p=8$c2FsdA$aGFzaA", "x", false, true},
+		{"Invalid iterations", "pbkdf2_sha256$many$salt$aGFzaA==", "x", false, true},
+		{"Dummy for unknown users", dummyPassword, "", false, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.descr, func(t *testing.T) {
+			match, err := CheckDjangoPassword(tc.encoded, tc.password)
+			assert.Equal(t, tc.expErr, err != nil, "error: %v", err)
+			assert.Equal(t, tc.expMatch, match)
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"fmt"
+
+	"coco-life.de/wapi/internal/models"
+)
+
+// The functions below follow django-wiki's wiki/core/permissions.py with its default
+// settings: Anonymous callers, that is, identities without a Django user, are
+// treated like "other" users, and moderators are callers with ScopeAdmin, e.g.
+// superusers.
+
+// isModerator reports whether id may do everything, like django-wiki's can_moderate.
+func (id *Identity) isModerator() bool {
+	return id.Scope >= ScopeAdmin
+}
+
+// inGroup reports whether the user of id is a member of group groupID.
+func (id *Identity) inGroup(groupID int) bool {
+	if id.UserID == 0 || groupID == 0 {
+		return false
+	}
+	for _, g := range id.GroupIDs {
+		if g == groupID {
+			return true
+		}
+	}
+	return false
+}
+
+// isOwner reports whether the user of id owns an article with permissions p.
+func (id *Identity) isOwner(p models.Permissions) bool {
+	return id.UserID != 0 && id.UserID == p.OwnerID
+}
+
+// CanRead reports whether id may read an article with permissions p.
+func (id *Identity) CanRead(p models.Permissions) bool {
+	switch {
+	case p.OtherRead, id.isOwner(p), p.GroupRead && id.inGroup(p.GroupID), id.isModerator():
+		return true
+	}
+	return false
+}
+
+// ReadFilter returns the filter of the articles id may read, see CanRead.
+func (id *Identity) ReadFilter() models.ReadFilter {
+	f := models.ReadFilter{All: id.isModerator(), UserID: id.UserID}
+	if id.UserID != 0 {
+		f.GroupIDs = id.GroupIDs
+	}
+	return f
+}
+
+// CanWrite reports whether id may change an article with permissions p or create
+// articles below it.
+func (id *Identity) CanWrite(p models.Permissions) bool {
+	switch {
+	case p.OtherWrite, id.isOwner(p), p.GroupWrite && id.inGroup(p.GroupID), id.isModerator():
+		return true
+	}
+	return false
+}
+
+// CanChangePermissions reports whether id may change the group and the permission
+// flags of an article with permissions p: Only the owner and moderators may.
+func (id *Identity) CanChangePermissions(p models.Permissions) bool {
+	return id.isOwner(p) || id.isModerator()
+}
+
+// CanAssignOwner reports whether id may change the owner of an article: Only
+// moderators may.
+func (id *Identity) CanAssignOwner() bool {
+	return id.isModerator()
+}
+
+// CheckPermissionsChange returns ErrForbidden if id may not change the permissions of
+// an article from cur to next:
+// - Only moderators may assign another owner.
+// - Only the owner and moderators may change the group or the flags.
+// - Users who are not moderators may only assign groups they are a member of.
+func (id *Identity) CheckPermissionsChange(cur models.Permissions, next models.Permissions) error {
+	if next.OwnerID != cur.OwnerID && !id.CanAssignOwner() {
+		return fmt.Errorf("%w: only moderators may assign the owner", ErrForbidden)
+	}
+	ownerless := cur
+	ownerless.OwnerID = next.OwnerID
+	if ownerless != next && !id.CanChangePermissions(cur) {
+		return fmt.Errorf("%w: only the owner may change the permissions", ErrForbidden)
+	}
+	if next.GroupID != cur.GroupID && next.GroupID != 0 && !id.isModerator() && !id.inGroup(next.GroupID) {
+		return fmt.Errorf("%w: not a member of group %v", ErrForbidden, next.GroupID)
+	}
+	return nil
+}
@@ -0,0 +1,224 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"coco-life.de/wapi/internal/models"
+	"coco-life.de/wapi/internal/store"
+	"github.com/gin-gonic/gin"
+)
+
+// tokenPrefix makes API tokens recognizable, e.g. for secret scanners.
+const tokenPrefix = "wapi_"
+
+// TokenStore persists API tokens. Only hashes are stored, see HashToken.
+type TokenStore interface {
+	// InsertAPIToken stores a token and returns its ID.
+	InsertAPIToken(ctx context.Context, t *models.APIToken) (int, error)
+	// SelectAPITokenByHash returns the token with the given hash or
+	// store.ErrNotFound.
+	SelectAPITokenByHash(ctx context.Context, hash string) (*models.APIToken, error)
+	// SelectAPITokens returns all tokens including the revoked ones.
+	SelectAPITokens(ctx context.Context) ([]models.APIToken, error)
+	// RevokeAPIToken marks a token as revoked.
+	RevokeAPIToken(ctx context.Context, id int) error
+}
+
+// NewToken returns a random token and its hash.
+func NewToken() (token string, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("Failed to generate token: %v", err)
+	}
+	token = tokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+// HashToken returns the hex encoded SHA-256 hash of a token. API tokens are random
+// and long, hence a fast hash is sufficient.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// TokenAuth authenticates requests carrying 'Authorization: Bearer <token>'. The
+// token is looked up in all stores in order.
+type TokenAuth struct {
+	Stores []TokenStore
+	// Users resolves the Django user of tokens acting as a user. Tokens of inactive
+	// users are rejected. If Users is nil, the user is not checked.
+	Users store.UserStore
+}
+
+// Authenticate looks up the bearer token of the request.
+func (a TokenAuth) Authenticate(c *gin.Context) (*Identity, error) {
+	header := c.GetHeader("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, ErrUnauthorized
+	}
+	hash := HashToken(strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")))
+	for i, s := range a.Stores {
+		t, err := s.SelectAPITokenByHash(c.Request.Context(), hash)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to look up API token: %w", err)
+		}
+		if t.Revoked != nil {
+			return nil, fmt.Errorf("%w: token '%v' has been revoked", ErrUnauthorized, t.Name)
+		}
+		scope, err := ParseScope(t.Scope)
+		if err != nil {
+			return nil, fmt.Errorf("%w: token '%v': %v", ErrUnauthorized, t.Name, err)
+		}
+		// IDs are only unique within a store.
+		id := &Identity{Name: t.Name, Key: fmt.Sprintf("token:%v:%v", i, t.ID), Scope: scope, UserID: t.UserID}
+		if a.Users != nil && t.UserID != 0 {
+			if err := a.resolveUser(c, id); err != nil {
+				return nil, err
+			}
+		}
+		return id, nil
+	}
+	return nil, ErrUnauthorized
+}
+
+// resolveUser checks that the user of the token is active and adds the groups of the
+// user to id.
+func (a TokenAuth) resolveUser(c *gin.Context, id *Identity) error {
+	user, err := a.Users.SelectAuthUserByID(c.Request.Context(), id.UserID)
+	if err != nil {
+		return fmt.Errorf("Failed to look up user of token '%v': %w", id.Name, err)
+	}
+	if !user.IsActive {
+		return fmt.Errorf("%w: user '%v' of token '%v' is inactive", ErrUnauthorized, user.Username, id.Name)
+	}
+	id.GroupIDs, err = a.Users.SelectAuthUserGroupIDs(c.Request.Context(), id.UserID)
+	if err != nil {
+		return fmt.Errorf("Failed to look up groups of user: %w", err)
+	}
+	return nil
+}
+
+// Challenge asks for a bearer token.
+func (a TokenAuth) Challenge() string {
+	return `Bearer realm="wapi"`
+}
+
+// MemTokens is an in-memory TokenStore, e.g. for tokens from a config file.
+type MemTokens struct {
+	mu     sync.Mutex
+	tokens []models.APIToken
+}
+
+// InsertAPIToken stores a token and returns its ID.
+func (m *MemTokens) InsertAPIToken(ctx context.Context, t *models.APIToken) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, o := range m.tokens {
+		if o.Name == t.Name || o.Hash == t.Hash {
+			return -1, fmt.Errorf("%w: API token '%v' already exists", store.ErrConstraint, t.Name)
+		}
+	}
+	cp := *t
+	cp.ID = len(m.tokens) + 1
+	if cp.Created.IsZero() {
+		cp.Created = time.Now()
+	}
+	m.tokens = append(m.tokens, cp)
+	return cp.ID, nil
+}
+
+// SelectAPITokenByHash returns the token with the given hash.
+func (m *MemTokens) SelectAPITokenByHash(ctx context.Context, hash string) (*models.APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.tokens {
+		if t.Hash == hash {
+			cp := t
+			return &cp, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: API token", store.ErrNotFound)
+}
+
+// SelectAPITokens returns all tokens.
+func (m *MemTokens) SelectAPITokens(ctx context.Context) ([]models.APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]models.APIToken{}, m.tokens...), nil
+}
+
+// RevokeAPIToken marks a token as revoked.
+func (m *MemTokens) RevokeAPIToken(ctx context.Context, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.tokens {
+		if m.tokens[i].ID == id {
+			now := time.Now()
+			m.tokens[i].Revoked = &now
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: API token %v", store.ErrNotFound, id)
+}
+
+// TokenFileLine returns the line of a token file for the given token, see
+// LoadTokenFile.
+func TokenFileLine(name string, scope Scope, hash string) string {
+	return fmt.Sprintf("%v %v %v", name, scope, hash)
+}
+
+// LoadTokenFile reads API tokens from a file. Every line has the format
+//   <name> <scope> <hex encoded SHA-256 hash of the token>
+// Empty lines and lines starting with '#' are ignored.
+func LoadTokenFile(path string) (*MemTokens, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open token file: %v", err)
+	}
+	defer f.Close()
+
+	tokens := &MemTokens{}
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%v:%v: expected '<name> <scope> <hash>'", path, n)
+		}
+		if _, err := ParseScope(fields[1]); err != nil {
+			return nil, fmt.Errorf("%v:%v: %v", path, n, err)
+		}
+		if _, err := hex.DecodeString(fields[2]); err != nil || len(fields[2]) != 2*sha256.Size {
+			return nil, fmt.Errorf("%v:%v: invalid SHA-256 hash", path, n)
+		}
+		_, err := tokens.InsertAPIToken(context.Background(), &models.APIToken{
+			Name:  fields[0],
+			Scope: fields[1],
+			Hash:  fields[2],
+		})
+		if err != nil {
+			return nil, fmt.Errorf("%v:%v: %v", path, n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Failed to read token file: %v", err)
+	}
+	return tokens, nil
+}
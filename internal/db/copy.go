@@ -0,0 +1,228 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"coco-life.de/wapi/internal/models"
+	"coco-life.de/wapi/internal/store"
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgx/v4"
+)
+
+// copyNode is a node of the copied subtree with its wiki_article record.
+type copyNode struct {
+	PathID            int    `db:"path_id"`
+	Slug              string `db:"slug"`
+	Left              int    `db:"lft"`
+	Right             int    `db:"rght"`
+	Level             int    `db:"level"`
+	ParentPathID      int    `db:"parent_id"`
+	MovedToID         int    `db:"moved_to_id"`
+	HdrID             int    `db:"article_id"`
+	GroupID           int    `db:"group_id"`
+	GroupRead         bool   `db:"group_read"`
+	GroupWrite        bool   `db:"group_write"`
+	OtherRead         bool   `db:"other_read"`
+	OtherWrite        bool   `db:"other_write"`
+	CurrentRevisionID int    `db:"current_revision_id"`
+}
+
+// copyRevision is a revision of an article of the copied subtree.
+type copyRevision struct {
+	ID                 int       `db:"id"`
+	HdrID              int       `db:"article_id"`
+	RevisionNumber     int       `db:"revision_number"`
+	UserMessage        string    `db:"user_message"`
+	AutomaticLog       string    `db:"automatic_log"`
+	IPAddress          string    `db:"ip_address"`
+	Modified           time.Time `db:"modified"`
+	Created            time.Time `db:"created"`
+	Deleted            bool      `db:"deleted"`
+	Locked             bool      `db:"locked"`
+	Content            string    `db:"content"`
+	Title              string    `db:"title"`
+	PreviousRevisionID int       `db:"previous_revision_id"`
+	UserID             int       `db:"user_id"`
+}
+
+// CopyWikiArticles copies the article hdrID with all its descendants as the last child
+// of the article parentHdrID and returns wiki_article-id of the copies in pre-order.
+// The copies keep the slugs, group and flags of the originals, except for opts.Slug and
+// opts.OwnerID. Without opts.History, only the current revisions are copied, see
+// models.CopyOptions. Redirects within the subtree point to their copies.
+//
+// Like ImportWikiArticles, the nested set is shifted once, the IDs are reserved with a
+// single batch and the records are written with COPY.
+func CopyWikiArticles(ctx context.Context, conn Conn, hdrID int, parentHdrID int, opts models.CopyOptions) ([]int, error) {
+	var hdrIDs []int
+	err := conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		var prtPathID, prtLeft, prtRight, prtLvl, treeID int
+		err := tx.QueryRow(ctx,
+			`select id, lft, rght, level, tree_id
+            from wiki_urlpath
+            where article_id = $1
+            for update;`, parentHdrID).Scan(&prtPathID, &prtLeft, &prtRight, &prtLvl, &treeID)
+		if err != nil {
+			return fmt.Errorf("Failed to select wiki_urlpath of article %v: %w", parentHdrID, err)
+		}
+		var srcLeft, srcRight, srcLvl, srcTreeID int
+		err = tx.QueryRow(ctx,
+			`select lft, rght, level, tree_id
+            from wiki_urlpath
+            where article_id = $1;`, hdrID).Scan(&srcLeft, &srcRight, &srcLvl, &srcTreeID)
+		if err != nil {
+			return fmt.Errorf("Failed to select wiki_urlpath of article %v: %w", hdrID, err)
+		}
+		if srcTreeID == treeID && prtLeft >= srcLeft && prtRight <= srcRight {
+			return fmt.Errorf("%w: wiki_article %v cannot be copied below itself or its descendant %v",
+				store.ErrConstraint, hdrID, parentHdrID)
+		}
+
+		var nodes []copyNode
+		err = pgxscan.Select(ctx, tx, &nodes,
+			`select p.id as path_id,
+                    coalesce(p.slug, '') as slug,
+                    p.lft,
+                    p.rght,
+                    p.level,
+                    coalesce(p.parent_id, 0) as parent_id,
+                    coalesce(p.moved_to_id, 0) as moved_to_id,
+                    a.id as article_id,
+                    coalesce(a.group_id, 0) as group_id,
+                    a.group_read,
+                    a.group_write,
+                    a.other_read,
+                    a.other_write,
+                    coalesce(a.current_revision_id, 0) as current_revision_id
+            from wiki_urlpath as p
+                inner join wiki_article as a
+                    on a.id = p.article_id
+            where p.tree_id = $1
+                  and p.lft between $2 and $3
+            order by p.lft;`, srcTreeID, srcLeft, srcRight)
+		if err != nil {
+			return fmt.Errorf("Failed to select the subtree of article %v: %w", hdrID, err)
+		}
+		var revs []copyRevision
+		err = pgxscan.Select(ctx, tx, &revs,
+			`select r.id,
+                    r.article_id,
+                    r.revision_number,
+                    r.user_message,
+                    r.automatic_log,
+                    coalesce(host(r.ip_address), '') as ip_address,
+                    r.modified,
+                    r.created,
+                    r.deleted,
+                    r.locked,
+                    r.content,
+                    r.title,
+                    coalesce(r.previous_revision_id, 0) as previous_revision_id,
+                    coalesce(r.user_id, 0) as user_id
+            from wiki_urlpath as p
+                inner join wiki_article as a
+                    on a.id = p.article_id
+                inner join wiki_articlerevision as r
+                    on r.article_id = a.id
+                       and ($4 or r.id = a.current_revision_id)
+            where p.tree_id = $1
+                  and p.lft between $2 and $3
+            order by r.article_id, r.revision_number;`, srcTreeID, srcLeft, srcRight, opts.History)
+		if err != nil {
+			return fmt.Errorf("Failed to select the revisions of the subtree of article %v: %w", hdrID, err)
+		}
+		n := len(nodes)
+
+		_, err = tx.Exec(ctx,
+			`update wiki_urlpath
+            set lft = case when lft >= $2 then lft + $3 else lft end,
+                rght = rght + $3
+            where tree_id = $1
+                  and rght >= $2;`, treeID, prtRight, 2*n)
+		if err != nil {
+			return fmt.Errorf("Failed to update 'lft' and 'rght' in wiki_urlpath: %w", err)
+		}
+
+		ids, err := reserveIDs(ctx, tx, []string{"wiki_article", "wiki_articlerevision", "wiki_urlpath"}, []int{n, len(revs), n})
+		if err != nil {
+			return err
+		}
+		artIDs, revIDs, pathIDs := ids[0], ids[1], ids[2]
+		newArtIDs := map[int]int32{}
+		newPathIDs := map[int]int32{}
+		for i, node := range nodes {
+			newArtIDs[node.HdrID] = artIDs[i]
+			newPathIDs[node.PathID] = pathIDs[i]
+		}
+		newRevIDs := map[int]int32{}
+		for i, rev := range revs {
+			newRevIDs[rev.ID] = revIDs[i]
+		}
+
+		now := time.Now()
+		metaIP, err := ipAddress(opts.Meta.IPAddress)
+		if err != nil {
+			return err
+		}
+		revRows := make([][]interface{}, len(revs))
+		for i, rev := range revs {
+			if !opts.History {
+				revRows[i] = []interface{}{revIDs[i], 1, opts.Meta.UserMessage, opts.Meta.AutomaticLog, metaIP,
+					now, now, rev.Deleted, rev.Locked, rev.Content, rev.Title, newArtIDs[rev.HdrID], nil,
+					nullIfZero(opts.Meta.UserID)}
+				continue
+			}
+			ip, err := ipAddress(rev.IPAddress)
+			if err != nil {
+				return err
+			}
+			var prevID interface{}
+			if id, ok := newRevIDs[rev.PreviousRevisionID]; ok {
+				prevID = id
+			}
+			revRows[i] = []interface{}{revIDs[i], rev.RevisionNumber, rev.UserMessage, rev.AutomaticLog, ip,
+				rev.Modified, rev.Created, rev.Deleted, rev.Locked, rev.Content, rev.Title,
+				newArtIDs[rev.HdrID], prevID, nullIfZero(rev.UserID)}
+		}
+		artRows := make([][]interface{}, n)
+		pathRows := make([][]interface{}, n)
+		for i, node := range nodes {
+			var revID interface{}
+			if id, ok := newRevIDs[node.CurrentRevisionID]; ok {
+				revID = id
+			}
+			artRows[i] = []interface{}{artIDs[i], now, now, nullIfZero(opts.OwnerID), nullIfZero(node.GroupID),
+				node.GroupRead, node.GroupWrite, node.OtherRead, node.OtherWrite, revID}
+			slug := node.Slug
+			if i == 0 && opts.Slug != "" {
+				slug = opts.Slug
+			}
+			// Redirects within the subtree point to the copies, others to the same node.
+			movedToID := nullIfZero(node.MovedToID)
+			if id, ok := newPathIDs[node.MovedToID]; ok {
+				movedToID = id
+			}
+			pathRows[i] = []interface{}{pathIDs[i], slug, node.Left - srcLeft + prtRight,
+				node.Right - srcLeft + prtRight, treeID, node.Level - srcLvl + prtLvl + 1,
+				artIDs[i], newPathIDs[node.ParentPathID], 1, movedToID}
+		}
+		// The root of the copy is the last child of the parent.
+		pathRows[0][7] = int32(prtPathID)
+
+		if err := copyArticleRows(ctx, tx, artRows, revRows, pathRows); err != nil {
+			return err
+		}
+
+		hdrIDs = make([]int, n)
+		for i, id := range artIDs {
+			hdrIDs[i] = int(id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hdrIDs, nil
+}
@@ -5,51 +5,86 @@ import (
 	"fmt"
 
 	"coco-life.de/wapi/internal/models"
+	"coco-life.de/wapi/internal/store"
 	"github.com/georgysavva/scany/pgxscan"
 	"github.com/jackc/pgconn"
-	"github.com/jackc/pgx/v4/pgxpool"
 )
 
 // SelectRootArticle selects the root article from the database.
-func SelectRootArticle(dbpool *pgxpool.Pool) (*models.RootArticle, error) {
-	var article models.RootArticle
+func SelectRootArticle(ctx context.Context, conn Conn) (*models.RootArticle, error) {
+	var row struct {
+		models.RootArticle
+		models.Permissions
+	}
 	err := pgxscan.Get(
-		context.Background(), dbpool, &article,
+		ctx, conn, &row,
 		`select
             hdr.id,
             rev.id as rev_id,
             rev.title,
             rev.content,
+            rev.deleted,
+            rev.locked,
             path.lft,
-            path.rght
+            path.rght,
+            coalesce(hdr.owner_id, 0) as owner_id,
+            coalesce(hdr.group_id, 0) as group_id,
+            hdr.group_read,
+            hdr.group_write,
+            hdr.other_read,
+            hdr.other_write
         from wiki_article as hdr
             inner join wiki_articlerevision as rev
-                on hdr.id = rev.article_id
+                on hdr.current_revision_id = rev.id
             inner join wiki_urlpath as path
                 on hdr.id = path.article_id  
         where path.level = 0;`)
-	return &article, err
+	row.RootArticle.Permissions = &row.Permissions
+	return &row.RootArticle, err
 }
 
 // SelectArticleByID selects a specific article by wiki_article-id.
-func SelectArticleByID(dbpool *pgxpool.Pool, id int) (*models.Article, error) {
-	var article models.Article
+func SelectArticleByID(ctx context.Context, conn Conn, id int) (*models.Article, error) {
+	return selectArticle(ctx, conn, id, "rev.content")
+}
+
+// SelectArticleHeader selects an article like SelectArticleByID but leaves the
+// content empty. The content is not even read from wiki_articlerevision.
+func SelectArticleHeader(ctx context.Context, conn Conn, id int) (*models.Article, error) {
+	return selectArticle(ctx, conn, id, "''")
+}
+
+// selectArticle selects an article with content being the SQL expression of its
+// content.
+func selectArticle(ctx context.Context, conn Conn, id int, content string) (*models.Article, error) {
+	var row struct {
+		models.Article
+		models.Permissions
+	}
 	err := pgxscan.Get(
-		context.Background(), dbpool, &article,
+		ctx, conn, &row,
 		`select
             hdr.id,
             rev.id as rev_id,
             rev.title,
-            rev.content,
+            `+content+` as content,
+            rev.deleted,
+            rev.locked,
             COALESCE(path.slug, '') as slug,
             path.id as path_id,
             path.level,
             path.lft,
             path.rght,
-            COALESCE(parent_hdr.id, -1) as parent_art_id
+            COALESCE(parent_hdr.id, -1) as parent_art_id,
+            coalesce(hdr.owner_id, 0) as owner_id,
+            coalesce(hdr.group_id, 0) as group_id,
+            hdr.group_read,
+            hdr.group_write,
+            hdr.other_read,
+            hdr.other_write
         from wiki_article as hdr
             inner join wiki_articlerevision as rev
-                on hdr.id = rev.article_id
+                on hdr.current_revision_id = rev.id
             inner join wiki_urlpath as path
                 on hdr.id = path.article_id  
             left join wiki_urlpath as parent_path
@@ -57,42 +92,83 @@ func SelectArticleByID(dbpool *pgxpool.Pool, id int) (*models.Article, error) {
             left join wiki_article as parent_hdr
                 on parent_path.article_id = parent_hdr.id
         where hdr.id = $1;`, id)
-	return &article, err
+	row.Article.Permissions = &row.Permissions
+	return &row.Article, err
 }
 
-// SelectArticleBySlug selects a specific article by its slug.
-func SelectArticleBySlug(dbpool *pgxpool.Pool, slug string) (*models.Article, error) {
-	var article models.Article
-	err := pgxscan.Get(
-		context.Background(), dbpool, &article,
-		`select
-            hdr.id,
-            rev.id as rev_id,
-            rev.title,
-            rev.content,
-            path.slug,
-            COALESCE(path.parent_id, -1) as parent_path_id
-        from wiki_article as hdr
-            inner join wiki_articlerevision as rev
-                on hdr.id = rev.article_id
-            inner join wiki_urlpath as path
-                on hdr.id = path.article_id  
-        where path.slug = $1;`, slug)
-	return &article, err
+// SelectArticleByPath selects a specific article by its URL path, e.g. "foo/bar".
+// An empty path selects the root article.
+func SelectArticleByPath(ctx context.Context, conn Conn, path string) (*models.Article, error) {
+	hdrID, err := SelectArticleIDByPath(ctx, conn, path)
+	if err != nil {
+		return nil, err
+	}
+	return SelectArticleByID(ctx, conn, hdrID)
+}
+
+// SelectArticleIDByPath returns wiki_article-id of the article with the URL path, see
+// SelectArticleByPath.
+func SelectArticleIDByPath(ctx context.Context, conn Conn, path string) (int, error) {
+	var pathID, hdrID int
+	err := conn.QueryRow(ctx,
+		`select id, article_id
+        from wiki_urlpath
+        where level = 0;`).Scan(&pathID, &hdrID)
+	if err != nil {
+		return -1, fmt.Errorf("Failed to select root from wiki_urlpath: %w", err)
+	}
+	for _, slug := range store.SplitPath(path) {
+		err = conn.QueryRow(ctx,
+			`select id, article_id
+            from wiki_urlpath
+            where parent_id = $1
+                  and slug = $2;`, pathID, slug).Scan(&pathID, &hdrID)
+		if err != nil {
+			return -1, fmt.Errorf("Failed to select slug '%v' from wiki_urlpath: %w", slug, err)
+		}
+	}
+	return hdrID, nil
+}
+
+// SelectRevisions selects all revisions of an article ordered by revision number.
+func SelectRevisions(ctx context.Context, conn Conn, hdrID int) ([]models.Revision, error) {
+	var revs []models.Revision
+	err := pgxscan.Select(ctx, conn, &revs,
+		`select id,
+                revision_number,
+                title,
+                created,
+                coalesce(user_id, 0) as user_id,
+                user_message,
+                automatic_log,
+                deleted,
+                locked
+        from wiki_articlerevision
+        where article_id = $1
+        order by revision_number;`, hdrID)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to select revisions of wiki_article %v: %w", hdrID, err)
+	}
+	return revs, nil
 }
 
-// MPTTCalcForIns calculates the 'level', 'left' and 'right' for a node under a parent.
-// prtRgh is the 'right' value of the parent. The 'right' value is the anchor for being 
-// able to add new child nodes as right siblings to other already existing children.
-func MPTTCalcForIns(prtLvl int, prtRght int) (lvl int, left int, right int) {
-    // Insert a new article `n` as child to parent `p`:
-    // Set `lft` and `rght` of `n` based on `p`.
-    // - `n.lft = p.rght`
-    // - `n.rght = p.rght + 1`
-    chLft := prtRght
-    chRght := prtRght + 1
-    chLvl := prtLvl + 1
-    return chLvl, chLft, chRght
+// SelectURLPath returns the URL path of the article hdrID, that is, the slugs of its
+// ancestors and itself each followed by '/'. The path of the root article is "".
+func SelectURLPath(ctx context.Context, conn Conn, hdrID int) (string, error) {
+	var path string
+	err := conn.QueryRow(ctx,
+		`select coalesce(string_agg(anc.slug || '/', '' order by anc.lft), '')
+        from wiki_urlpath as node
+            inner join wiki_urlpath as anc
+                on anc.tree_id = node.tree_id
+                   and anc.lft <= node.lft
+                   and anc.rght >= node.rght
+        where node.article_id = $1
+        group by node.id;`, hdrID).Scan(&path)
+	if err != nil {
+		return "", fmt.Errorf("Failed to select URL path of wiki_article %v: %w", hdrID, err)
+	}
+	return path, nil
 }
 
 // MPTTUpdWikiURLPathForInsert updates all wiki_urlpath records after another node has been 
@@ -115,16 +191,16 @@ func MPTTCalcForIns(prtLvl int, prtRght int) (lvl int, left int, right int) {
 // this condition. Example: Parent node has `r.lft = 1 and r.rght = 2`. New 
 // node is inserted with `n.lft = 2 and n.rght = 3`. `r.rght` has to be set to 
 // `4`.
-func MPTTUpdWikiURLPathForInsert(conn *pgxpool.Pool, newArtPathID, nLft int) error {
+func MPTTUpdWikiURLPathForInsert(ctx context.Context, conn Conn, newArtPathID, nLft int) error {
 	var err error
 	sqlUpdLft := `update wiki_urlpath
         set lft = lft + 2
         where lft >= $1
               and not id = $2
               `
-	_, err = conn.Exec(context.Background(), sqlUpdLft, nLft, newArtPathID)
+	_, err = conn.Exec(ctx, sqlUpdLft, nLft, newArtPathID)
 	if err != nil {
-		return fmt.Errorf("Failed to update record in wiki_urlpath: %v", err)
+		return fmt.Errorf("Failed to update record in wiki_urlpath: %w", err)
 	}
 
     // These two SQL statements cannot be merged into one as for some nodes, e.g. 
@@ -134,9 +210,9 @@ func MPTTUpdWikiURLPathForInsert(conn *pgxpool.Pool, newArtPathID, nLft int) err
         where rght >= $1
               and not id = $2
                `
-	_, err = conn.Exec(context.Background(), sqlUpdRght, nLft, newArtPathID)
+	_, err = conn.Exec(ctx, sqlUpdRght, nLft, newArtPathID)
 	if err != nil {
-		return fmt.Errorf("Failed to update record in wiki_urlpath: %v", err)
+		return fmt.Errorf("Failed to update record in wiki_urlpath: %w", err)
 	}
 
 	return nil
@@ -145,7 +221,8 @@ func MPTTUpdWikiURLPathForInsert(conn *pgxpool.Pool, newArtPathID, nLft int) err
 // InsertWikiURLPathChild inserts the record into wiki_urlpath for any child article.
 // parentPathId is the value of wiki_urlpath-id of the parent's node.
 // It returns wiki_urlpath-id.
-func InsertWikiURLPathChild(conn *pgxpool.Pool,
+func InsertWikiURLPathChild(ctx context.Context,
+                            conn Conn,
                             slug string,
                             hdrID int,
                             lvl int,
@@ -177,7 +254,7 @@ func InsertWikiURLPathChild(conn *pgxpool.Pool,
         $6
       )
       returning id`
-      row := conn.QueryRow(context.Background(),
+      row := conn.QueryRow(ctx,
                                 sql,
                                 slug,
                                 left,
@@ -188,13 +265,13 @@ func InsertWikiURLPathChild(conn *pgxpool.Pool,
 	var pathID int
 	err := row.Scan(&pathID)
 	if err != nil {
-		return -1, fmt.Errorf("Failed to insert record into wiki_urlpath: %v", err)
+		return -1, fmt.Errorf("Failed to insert record into wiki_urlpath: %w", err)
 	}
 	return pathID, nil
 }
 
 // InsertWikiURLPathRoot inserts the record into wiki_urlpath for the root article.
-func InsertWikiURLPathRoot(conn *pgxpool.Pool, hdrID int) error {
+func InsertWikiURLPathRoot(ctx context.Context, conn Conn, hdrID int) error {
 	// TODO: Adjust lft and rght.
 	sql := `insert into
       wiki_urlpath
@@ -217,9 +294,9 @@ func InsertWikiURLPathRoot(conn *pgxpool.Pool, hdrID int) error {
       )`
 	var commandTag pgconn.CommandTag
 	var err error
-	commandTag, err = conn.Exec(context.Background(), sql, hdrID)
+	commandTag, err = conn.Exec(ctx, sql, hdrID)
 	if err != nil {
-		return fmt.Errorf("Failed to insert record into wiki_urlpath: %v", err)
+		return fmt.Errorf("Failed to insert record into wiki_urlpath: %w", err)
 	}
 	if commandTag.RowsAffected() != 1 {
 		return fmt.Errorf("Failed to insert record into wiki_urlpath")
@@ -228,7 +305,7 @@ func InsertWikiURLPathRoot(conn *pgxpool.Pool, hdrID int) error {
 }
 
 // InsertWikiURLPath inserts the record into wiki_urlpath for a non-root article.
-func InsertWikiURLPath(conn *pgxpool.Pool, hdrID int, slug string, parentID int) error {
+func InsertWikiURLPath(ctx context.Context, conn Conn, hdrID int, slug string, parentID int) error {
 	sql := `insert into
       wiki_urlpath
       (
@@ -254,9 +331,9 @@ func InsertWikiURLPath(conn *pgxpool.Pool, hdrID int, slug string, parentID int)
       )`
 	var commandTag pgconn.CommandTag
 	var err error
-	commandTag, err = conn.Exec(context.Background(), sql, hdrID, slug, parentID)
+	commandTag, err = conn.Exec(ctx, sql, hdrID, slug, parentID)
 	if err != nil {
-		return fmt.Errorf("Failed to insert record into wiki_urlpath: %v", err)
+		return fmt.Errorf("Failed to insert record into wiki_urlpath: %w", err)
 	}
 	if commandTag.RowsAffected() != 1 {
 		return fmt.Errorf("Failed to insert record into wiki_urlpath")
@@ -266,7 +343,7 @@ func InsertWikiURLPath(conn *pgxpool.Pool, hdrID int, slug string, parentID int)
 
 // InsertWikiArticleRevision creates the record in wiki_articlerevision.
 // It returns wiki_articlerevision-id.
-func InsertWikiArticleRevision(conn *pgxpool.Pool, hdrID int, title string, content string) (int, error) {
+func InsertWikiArticleRevision(ctx context.Context, conn Conn, hdrID int, title string, content string, meta models.RevisionMeta) (int, error) {
 	sql := `insert into
       wiki_articlerevision
       (
@@ -279,6 +356,8 @@ func InsertWikiArticleRevision(conn *pgxpool.Pool, hdrID int, title string, cont
         modified,
         deleted,
         locked,
+        user_id,
+        ip_address,
         user_message,
         automatic_log
       )
@@ -291,28 +370,33 @@ func InsertWikiArticleRevision(conn *pgxpool.Pool, hdrID int, title string, cont
         $3,
         CURRENT_TIMESTAMP,
         CURRENT_TIMESTAMP,
-        false,
-        false,
-        '',
-        ''
+        $4,
+        $9,
+        nullif($5, 0),
+        nullif($6, '')::inet,
+        $7,
+        $8
       )
       returning id as rev_id;`
-	row := conn.QueryRow(context.Background(), sql, hdrID, title, content)
+	row := conn.QueryRow(ctx, sql, hdrID, title, content,
+		meta.Deleted, meta.UserID, meta.IPAddress, meta.UserMessage, meta.AutomaticLog, meta.Locked)
 	var revID int
 	err := row.Scan(&revID)
 	if err != nil {
-		return -1, fmt.Errorf("Failed to insert record into wiki_articlerevision: %v", err)
+		return -1, fmt.Errorf("Failed to insert record into wiki_articlerevision: %w", err)
 	}
 	return revID, nil
 }
 
-// InsertWikiArticle a record into wiki_article.
-func InsertWikiArticle(conn *pgxpool.Pool) (int, error) {
+// InsertWikiArticle a record into wiki_article with the given permissions.
+func InsertWikiArticle(ctx context.Context, conn Conn, perms models.Permissions) (int, error) {
 	sql := `insert into
       wiki_article
       (
         created,
         modified,
+        owner_id,
+        group_id,
         group_read,
         group_write,
         other_read,
@@ -323,30 +407,108 @@ func InsertWikiArticle(conn *pgxpool.Pool) (int, error) {
       (
         CURRENT_TIMESTAMP,
         CURRENT_TIMESTAMP,
-        true,
-        true,
-        true,
-        true,
+        nullif($1, 0),
+        nullif($2, 0),
+        $3,
+        $4,
+        $5,
+        $6,
         null -- revision_id has a UNIQUE constraint. We can set it once the revision is created.
       )
       returning id as hdr_id;`
-	row := conn.QueryRow(context.Background(), sql)
+	row := conn.QueryRow(ctx, sql, perms.OwnerID, perms.GroupID,
+		perms.GroupRead, perms.GroupWrite, perms.OtherRead, perms.OtherWrite)
 	var hdrID int
 	err := row.Scan(&hdrID)
 	if err != nil {
-		return -1, fmt.Errorf("Failed to insert record into wiki_articlerevision: %v", err)
+		return -1, fmt.Errorf("Failed to insert record into wiki_articlerevision: %w", err)
 	}
 	return hdrID, nil
 }
 
+// UpdateWikiArticlePermissions sets owner, group and the permission flags of
+// wiki_article.
+func UpdateWikiArticlePermissions(ctx context.Context, conn Conn, hdrID int, perms models.Permissions) error {
+	sql := `update wiki_article
+                set owner_id = nullif($2, 0),
+                    group_id = nullif($3, 0),
+                    group_read = $4,
+                    group_write = $5,
+                    other_read = $6,
+                    other_write = $7,
+                    modified = CURRENT_TIMESTAMP
+                where id = $1;`
+	commandTag, err := conn.Exec(ctx, sql, hdrID, perms.OwnerID, perms.GroupID,
+		perms.GroupRead, perms.GroupWrite, perms.OtherRead, perms.OtherWrite)
+	if err != nil {
+		return fmt.Errorf("Failed to update permissions in wiki_article: %w", err)
+	}
+	if commandTag.RowsAffected() != 1 {
+		return fmt.Errorf("%w: wiki_article %v", store.ErrNotFound, hdrID)
+	}
+	return nil
+}
+
+// AddWikiArticleRevision creates the next record in wiki_articlerevision following
+// the current revision of the article and makes it the current revision.
+// It returns wiki_articlerevision-id.
+func AddWikiArticleRevision(ctx context.Context, conn Conn, hdrID int, title string, content string, meta models.RevisionMeta) (int, error) {
+	sql := `insert into
+      wiki_articlerevision
+      (
+        article_id,
+        revision_number,
+        previous_revision_id,
+        title,
+        content,
+        created,
+        modified,
+        deleted,
+        locked,
+        user_id,
+        ip_address,
+        user_message,
+        automatic_log
+      )
+      select
+        hdr.id,
+        cur.revision_number + 1,
+        cur.id,
+        $2,
+        $3,
+        CURRENT_TIMESTAMP,
+        CURRENT_TIMESTAMP,
+        $4,
+        $9,
+        nullif($5, 0),
+        nullif($6, '')::inet,
+        $7,
+        $8
+      from wiki_article as hdr
+        inner join wiki_articlerevision as cur
+          on hdr.current_revision_id = cur.id
+      where hdr.id = $1
+      returning id as rev_id;`
+	var revID int
+	err := conn.QueryRow(ctx, sql, hdrID, title, content,
+		meta.Deleted, meta.UserID, meta.IPAddress, meta.UserMessage, meta.AutomaticLog, meta.Locked).Scan(&revID)
+	if err != nil {
+		return -1, fmt.Errorf("Failed to insert record into wiki_articlerevision: %w", err)
+	}
+	if err := SetWikiArticleRevision(ctx, conn, hdrID, revID); err != nil {
+		return -1, err
+	}
+	return revID, nil
+}
+
 // SetWikiArticleRevision database table wiki_article and sets the revision.
-func SetWikiArticleRevision(conn *pgxpool.Pool, hdrID int, revID int) error {
+func SetWikiArticleRevision(ctx context.Context, conn Conn, hdrID int, revID int) error {
 	sql := `update wiki_article
                 set current_revision_id = $2
                 where id = $1;`
-	commandTag, err := conn.Exec(context.Background(), sql, hdrID, revID)
+	commandTag, err := conn.Exec(ctx, sql, hdrID, revID)
 	if err != nil {
-		return fmt.Errorf("Failed to update 'current_revision_id' in wiki_article: %v", err)
+		return fmt.Errorf("Failed to update 'current_revision_id' in wiki_article: %w", err)
 	}
 	if commandTag.RowsAffected() != 1 {
 		return fmt.Errorf("Failed to update 'current_revision_id' in wiki_article")
@@ -0,0 +1,209 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"coco-life.de/wapi/internal/auth"
+	"coco-life.de/wapi/internal/db"
+	"coco-life.de/wapi/internal/dbtest"
+	"coco-life.de/wapi/internal/idempotency"
+	"coco-life.de/wapi/internal/models"
+	"coco-life.de/wapi/internal/store"
+	"coco-life.de/wapi/internal/store/storetest"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPgStore runs the store test suite against a throwaway schema, see package
+// dbtest.
+func TestPgStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.ArticleStore {
+		pool := dbtest.NewPool(t)
+		require.Nil(t, db.EnsureAPISchema(context.Background(), pool))
+		return db.NewPgStore(pool)
+	})
+}
+
+// TestPgStoreMPTT runs the randomized nested set test against a throwaway schema. The
+// sequences are shorter than for the in-memory store as every step verifies all
+// articles with separate queries.
+func TestPgStoreMPTT(t *testing.T) {
+	storetest.RunMPTT(t, func(t *testing.T) (store.ArticleStore, func()) {
+		pool, drop := dbtest.OpenPool(t)
+		return db.NewPgStore(pool), drop
+	}, storetest.MPTTConfig{Sequences: 5, Steps: 60})
+}
+
+// BenchmarkImport compares creating articles one by one with importing them in bulk,
+// see ImportWikiArticles. Every iteration adds n articles below a new article. It needs
+// a database like the tests, see package dbtest:
+//
+//   go test -run x -bench Import ./internal/db/
+func BenchmarkImport(b *testing.B) {
+	for _, n := range []int{100, 1000} {
+		nodes := make([]models.ArticleNode, n)
+		for i := range nodes {
+			nodes[i] = models.ArticleNode{Slug: fmt.Sprintf("a%v", i), Title: "A", Content: "# A"}
+		}
+		b.Run(fmt.Sprintf("PerRow/%v", n), func(b *testing.B) {
+			s := db.NewPgStore(dbtest.NewPool(b))
+			rootID := storetest.InsertRoot(b, s, "Root")
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				parentID, err := storetest.InsertChild(s, rootID, fmt.Sprintf("p%v", i))
+				require.Nil(b, err)
+				for _, node := range nodes {
+					_, err := storetest.InsertChild(s, parentID, node.Slug)
+					require.Nil(b, err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("Bulk/%v", n), func(b *testing.B) {
+			s := db.NewPgStore(dbtest.NewPool(b))
+			rootID := storetest.InsertRoot(b, s, "Root")
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				parentID, err := storetest.InsertChild(s, rootID, fmt.Sprintf("p%v", i))
+				require.Nil(b, err)
+				_, err = s.ImportWikiArticles(context.Background(), parentID, nodes, models.DefaultPermissions, models.RevisionMeta{})
+				require.Nil(b, err)
+			}
+		})
+	}
+}
+
+// TestPgStoreAPITokens stores, looks up and revokes an API token.
+func TestPgStoreAPITokens(t *testing.T) {
+	ctx := context.Background()
+	pool := dbtest.NewPool(t)
+	require.Nil(t, db.EnsureAPISchema(ctx, pool))
+	// EnsureAPISchema is idempotent.
+	require.Nil(t, db.EnsureAPISchema(ctx, pool))
+	s := db.NewPgStore(pool)
+
+	id, err := s.InsertAPIToken(ctx, &models.APIToken{Name: "ci", Hash: auth.HashToken("secret"), Scope: "write"})
+	require.Nil(t, err)
+	_, err = s.InsertAPIToken(ctx, &models.APIToken{Name: "ci", Hash: auth.HashToken("other"), Scope: "read"})
+	require.True(t, errors.Is(err, store.ErrConstraint), "duplicate name: %v", err)
+	_, err = s.InsertAPIToken(ctx, &models.APIToken{Name: "bad", Hash: auth.HashToken("bad"), Scope: "root"})
+	require.True(t, errors.Is(err, store.ErrConstraint), "invalid scope: %v", err)
+
+	tok, err := s.SelectAPITokenByHash(ctx, auth.HashToken("secret"))
+	require.Nil(t, err)
+	require.Equal(t, id, tok.ID)
+	require.Equal(t, "write", tok.Scope)
+	require.Nil(t, tok.Revoked)
+	_, err = s.SelectAPITokenByHash(ctx, auth.HashToken("unknown"))
+	require.True(t, errors.Is(err, store.ErrNotFound), "unknown token: %v", err)
+
+	require.Nil(t, s.RevokeAPIToken(ctx, id))
+	tokens, err := s.SelectAPITokens(ctx)
+	require.Nil(t, err)
+	require.Len(t, tokens, 1)
+	require.NotNil(t, tokens[0].Revoked)
+	require.True(t, errors.Is(s.RevokeAPIToken(ctx, id+1), store.ErrNotFound))
+}
+
+// TestPgStoreIdempotencyKeys reserves, completes and releases idempotency keys.
+func TestPgStoreIdempotencyKeys(t *testing.T) {
+	ctx := context.Background()
+	pool := dbtest.NewPool(t)
+	require.Nil(t, db.EnsureAPISchema(ctx, pool))
+	s := db.NewPgStore(pool)
+
+	k := &models.IdempotencyKey{Owner: "ci", Key: "k1", Fingerprint: idempotency.Fingerprint("POST", "/articles", nil),
+		Expires: time.Now().Add(time.Minute)}
+	existing, err := s.ReserveIdempotencyKey(ctx, k)
+	require.Nil(t, err)
+	require.Nil(t, existing)
+	existing, err = s.ReserveIdempotencyKey(ctx, k)
+	require.Nil(t, err)
+	require.NotNil(t, existing)
+	require.Equal(t, 0, existing.Status)
+
+	k.Status, k.ContentType, k.Body = 201, "application/json", []byte(`{"id":1}`)
+	require.Nil(t, s.CompleteIdempotencyKey(ctx, k))
+	existing, err = s.ReserveIdempotencyKey(ctx, &models.IdempotencyKey{Owner: "ci", Key: "k1", Expires: k.Expires})
+	require.Nil(t, err)
+	require.Equal(t, k.Fingerprint, existing.Fingerprint)
+	require.Equal(t, 201, existing.Status)
+	require.Equal(t, k.Body, existing.Body)
+
+	// Keys of other callers and expired keys are free.
+	existing, err = s.ReserveIdempotencyKey(ctx, &models.IdempotencyKey{Owner: "other", Key: "k1", Expires: k.Expires})
+	require.Nil(t, err)
+	require.Nil(t, existing)
+	k.Expires = time.Now().Add(-time.Minute)
+	require.Nil(t, s.CompleteIdempotencyKey(ctx, k))
+	existing, err = s.ReserveIdempotencyKey(ctx, &models.IdempotencyKey{Owner: "ci", Key: "k1", Expires: time.Now().Add(time.Minute)})
+	require.Nil(t, err)
+	require.Nil(t, existing)
+
+	require.Nil(t, s.ReleaseIdempotencyKey(ctx, "ci", "k1"))
+	require.True(t, errors.Is(s.CompleteIdempotencyKey(ctx, k), store.ErrNotFound))
+}
+
+// TestPgStoreAuthUser selects a Django user by name.
+func TestPgStoreAuthUser(t *testing.T) {
+	ctx := context.Background()
+	pool := dbtest.NewPool(t)
+	_, err := pool.Exec(ctx,
+		`insert into auth_user (password, is_superuser, username, first_name, last_name,
+                               email, is_staff, is_active, date_joined)
+        values ($1, false, 'alice', '', '', '', false, true, now());`,
+		auth.MakeDjangoPassword("secret", "salt", 1000))
+	require.Nil(t, err)
+	s := db.NewPgStore(pool)
+
+	u, err := s.SelectAuthUserByName(ctx, "alice")
+	require.Nil(t, err)
+	require.True(t, u.IsActive)
+	require.False(t, u.IsSuperuser)
+	match, err := auth.CheckDjangoPassword(u.Password, "secret")
+	require.Nil(t, err)
+	require.True(t, match)
+	_, err = s.SelectAuthUserByName(ctx, "bob")
+	require.True(t, errors.Is(err, store.ErrNotFound), "unknown user: %v", err)
+}
+
+// TestPgStoreOtherTree checks that a second tree, whose lft and rght overlap those of
+// the first, never shows up in subtree queries. The store itself only builds tree 1.
+func TestPgStoreOtherTree(t *testing.T) {
+	ctx := context.Background()
+	pool := dbtest.NewPool(t)
+	s := db.NewPgStore(pool)
+	rootID := storetest.InsertRoot(t, s, "Root")
+	aID, err := storetest.InsertChild(s, rootID, "a")
+	require.Nil(t, err)
+	otherID := storetest.InsertRoot(t, s, "Install")
+	_, err = pool.Exec(ctx, `update wiki_urlpath set tree_id = 2, lft = 2, rght = 3, level = 1
+		where article_id = $1`, otherID)
+	require.Nil(t, err)
+
+	root, err := s.SelectArticleByID(ctx, rootID)
+	require.Nil(t, err)
+	a, err := s.SelectArticleByID(ctx, aID)
+	require.Nil(t, err)
+	all := models.ReadFilter{All: true}
+	ids := func(q models.ArticleQuery) []int {
+		q.Reader, q.SortBy, q.Limit = all, "id", 10
+		summaries, err := s.SelectArticles(ctx, q)
+		require.Nil(t, err)
+		ids := []int{}
+		for _, sum := range summaries {
+			ids = append(ids, sum.ID)
+		}
+		return ids
+	}
+	require.Equal(t, []int{aID}, ids(models.ArticleQuery{Left: root.Left, Right: root.Right, PathID: root.PathID}))
+	require.Equal(t, []int{rootID}, ids(models.ArticleQuery{ContainsLeft: a.Left, ContainsRight: a.Right, PathID: a.PathID}))
+
+	hits, total, err := s.SearchArticles(ctx, models.SearchQuery{Query: "install", Config: "simple",
+		Left: root.Left, Right: root.Right, PathID: root.PathID, Reader: all, Limit: 10})
+	require.Nil(t, err)
+	require.Empty(t, hits)
+	require.Equal(t, 0, total)
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"fmt"
+)
+
+// RequiredTables are the django-wiki tables the API reads from and writes to.
+var RequiredTables = []string{"wiki_article", "wiki_articlerevision", "wiki_urlpath"}
+
+// Ping acquires a connection and sends a round trip to the database.
+func Ping(ctx context.Context, conn Conn) error {
+	if _, err := conn.Exec(ctx, "select 1;"); err != nil {
+		return fmt.Errorf("Failed to ping database: %w", err)
+	}
+	return nil
+}
+
+// MissingTables returns the subset of tables that does not exist in the database.
+func MissingTables(ctx context.Context, conn Conn, tables []string) ([]string, error) {
+	rows, err := conn.Query(ctx,
+		`select t
+        from unnest($1::text[]) as t
+        where to_regclass(t) is null;`, tables)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to look up tables: %w", err)
+	}
+	defer rows.Close()
+	var missing []string
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err != nil {
+			return nil, fmt.Errorf("Failed to look up tables: %w", err)
+		}
+		missing = append(missing, t)
+	}
+	return missing, rows.Err()
+}
+
+// CountRootArticles returns the number of articles on level 0 of wiki_urlpath.
+func CountRootArticles(ctx context.Context, conn Conn) (int, error) {
+	var n int
+	err := conn.QueryRow(ctx,
+		`select count(*) from wiki_urlpath where level = 0;`).Scan(&n)
+	if err != nil {
+		return -1, fmt.Errorf("Failed to count root articles: %w", err)
+	}
+	return n, nil
+}
+
+// MPTTViolations checks the nested set of wiki_urlpath and returns a description of
+// every violated rule. The rules are
+// - lft < rght for every node,
+// - every lft and rght value is unique within the tree,
+// - the root node spans the whole tree, that is, root.rght = 2 * <number of nodes>,
+// - every child lies within the bounds of its parent and has level = parent.level + 1.
+func MPTTViolations(ctx context.Context, conn Conn) ([]string, error) {
+	checks := []struct {
+		descr string
+		sql   string
+	}{
+		{"nodes with lft >= rght",
+			`select count(*) from wiki_urlpath where lft >= rght;`},
+		{"duplicate lft/rght values",
+			`select count(*) - count(distinct v)
+            from (select lft as v from wiki_urlpath
+                  union all
+                  select rght from wiki_urlpath) as bounds;`},
+		{"roots not spanning the tree",
+			`select count(*)
+            from wiki_urlpath as root
+            where root.level = 0
+                  and (root.lft != 1
+                       or root.rght != 2 * (select count(*)
+                                            from wiki_urlpath as n
+                                            where n.tree_id = root.tree_id));`},
+		{"children outside of their parent",
+			`select count(*)
+            from wiki_urlpath as child
+                inner join wiki_urlpath as parent
+                    on child.parent_id = parent.id
+            where child.lft <= parent.lft
+                  or child.rght >= parent.rght
+                  or child.level != parent.level + 1;`},
+	}
+	var violations []string
+	for _, c := range checks {
+		var n int
+		if err := conn.QueryRow(ctx, c.sql).Scan(&n); err != nil {
+			return nil, fmt.Errorf("Failed to check %v: %w", c.descr, err)
+		}
+		if n > 0 {
+			violations = append(violations, fmt.Sprintf("%v: %v", c.descr, n))
+		}
+	}
+	return violations, nil
+}
@@ -0,0 +1,120 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"coco-life.de/wapi/internal/idempotency"
+	"coco-life.de/wapi/internal/models"
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgx/v4"
+)
+
+// IdempotencySchema contains the table of the idempotency keys, see EnsureAPISchema.
+const IdempotencySchema = `
+create table if not exists wapi_idempotencykey (
+  owner varchar(150) not null,
+  key varchar(255) not null,
+  fingerprint char(64) not null,
+  status integer not null default 0,
+  content_type varchar(255) not null default '',
+  location text not null default '',
+  etag varchar(255) not null default '',
+  body bytea null,
+  expires timestamp with time zone not null,
+  primary key (owner, key)
+);
+create index if not exists wapi_idempotencykey_expires on wapi_idempotencykey (expires);`
+
+var _ idempotency.Store = (*PgStore)(nil)
+
+// ReserveIdempotencyKey deletes all expired records of wapi_idempotencykey and inserts
+// k unless a record with the same owner and key exists. It returns nil if k has been
+// inserted and the existing record otherwise.
+func ReserveIdempotencyKey(ctx context.Context, conn Conn, k *models.IdempotencyKey) (*models.IdempotencyKey, error) {
+	var existing *models.IdempotencyKey
+	err := conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx,
+			`delete from wapi_idempotencykey
+            where expires < now();`)
+		if err != nil {
+			return fmt.Errorf("Failed to delete expired records of wapi_idempotencykey: %w", err)
+		}
+		var owner string
+		err = tx.QueryRow(ctx,
+			`insert into wapi_idempotencykey (owner, key, fingerprint, expires)
+            values ($1, $2, $3, $4)
+            on conflict (owner, key) do nothing
+            returning owner;`, k.Owner, k.Key, k.Fingerprint, k.Expires).Scan(&owner)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("Failed to insert into wapi_idempotencykey: %w", err)
+		}
+		var o models.IdempotencyKey
+		err = pgxscan.Get(ctx, tx, &o,
+			`select owner, key, fingerprint, status, content_type, location, etag,
+                coalesce(body, '') as body, expires
+            from wapi_idempotencykey
+            where owner = $1
+                  and key = $2;`, k.Owner, k.Key)
+		if err != nil {
+			return fmt.Errorf("Failed to select from wapi_idempotencykey: %w", err)
+		}
+		existing = &o
+		return nil
+	})
+	return existing, err
+}
+
+// CompleteIdempotencyKey stores the response and the expiry of a record of
+// wapi_idempotencykey.
+func CompleteIdempotencyKey(ctx context.Context, conn Conn, k *models.IdempotencyKey) error {
+	var owner string
+	err := conn.QueryRow(ctx,
+		`update wapi_idempotencykey
+        set status = $3,
+            content_type = $4,
+            location = $5,
+            etag = $6,
+            body = $7,
+            expires = $8
+        where owner = $1
+              and key = $2
+        returning owner;`,
+		k.Owner, k.Key, k.Status, k.ContentType, k.Location, k.ETag, k.Body, k.Expires).Scan(&owner)
+	if err != nil {
+		return fmt.Errorf("Failed to update wapi_idempotencykey: %w", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey deletes a record of wapi_idempotencykey.
+func ReleaseIdempotencyKey(ctx context.Context, conn Conn, owner string, key string) error {
+	_, err := conn.Exec(ctx,
+		`delete from wapi_idempotencykey
+        where owner = $1
+              and key = $2;`, owner, key)
+	if err != nil {
+		return fmt.Errorf("Failed to delete from wapi_idempotencykey: %w", err)
+	}
+	return nil
+}
+
+// ReserveIdempotencyKey reserves an idempotency key.
+func (s *PgStore) ReserveIdempotencyKey(ctx context.Context, k *models.IdempotencyKey) (*models.IdempotencyKey, error) {
+	existing, err := ReserveIdempotencyKey(ctx, s.conn, k)
+	return existing, mapErr(err)
+}
+
+// CompleteIdempotencyKey stores the response of an idempotency key.
+func (s *PgStore) CompleteIdempotencyKey(ctx context.Context, k *models.IdempotencyKey) error {
+	return mapErr(CompleteIdempotencyKey(ctx, s.conn, k))
+}
+
+// ReleaseIdempotencyKey removes an idempotency key.
+func (s *PgStore) ReleaseIdempotencyKey(ctx context.Context, owner string, key string) error {
+	return mapErr(ReleaseIdempotencyKey(ctx, s.conn, owner, key))
+}
@@ -0,0 +1,164 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"coco-life.de/wapi/internal/models"
+	"coco-life.de/wapi/internal/store"
+	"github.com/jackc/pgx/v4"
+)
+
+// ImportWikiArticles inserts the trees nodes as the last children of the article
+// parentHdrID. All articles get perms and a first revision with meta. It returns
+// wiki_article-id of the new articles in pre-order, see store.MPTTCalcForImport.
+//
+// Unlike inserting the articles one by one, the number of statements does not depend
+// on the number of articles: The nested set is shifted once, the IDs are reserved with
+// a single batch and the records are written with COPY. The foreign keys of the
+// django-wiki schema are deferred, so the records can reference each other before
+// they exist.
+func ImportWikiArticles(ctx context.Context, conn Conn, parentHdrID int, nodes []models.ArticleNode, perms models.Permissions, meta models.RevisionMeta) ([]int, error) {
+	var hdrIDs []int
+	err := conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		var prtPathID, prtRight, prtLvl, treeID int
+		err := tx.QueryRow(ctx,
+			`select id, rght, level, tree_id
+            from wiki_urlpath
+            where article_id = $1
+            for update;`, parentHdrID).Scan(&prtPathID, &prtRight, &prtLvl, &treeID)
+		if err != nil {
+			return fmt.Errorf("Failed to select wiki_urlpath of article %v: %w", parentHdrID, err)
+		}
+		placements := store.MPTTCalcForImport(nodes, prtLvl, prtRight)
+		n := len(placements)
+		if n == 0 {
+			return nil
+		}
+
+		_, err = tx.Exec(ctx,
+			`update wiki_urlpath
+            set lft = case when lft >= $2 then lft + $3 else lft end,
+                rght = rght + $3
+            where tree_id = $1
+                  and rght >= $2;`, treeID, prtRight, 2*n)
+		if err != nil {
+			return fmt.Errorf("Failed to update 'lft' and 'rght' in wiki_urlpath: %w", err)
+		}
+
+		ids, err := reserveIDs(ctx, tx, []string{"wiki_article", "wiki_articlerevision", "wiki_urlpath"}, []int{n, n, n})
+		if err != nil {
+			return err
+		}
+		artIDs, revIDs, pathIDs := ids[0], ids[1], ids[2]
+
+		now := time.Now()
+		ip, err := ipAddress(meta.IPAddress)
+		if err != nil {
+			return err
+		}
+		artRows := make([][]interface{}, n)
+		revRows := make([][]interface{}, n)
+		pathRows := make([][]interface{}, n)
+		for i, p := range placements {
+			artRows[i] = []interface{}{artIDs[i], now, now, nullIfZero(perms.OwnerID), nullIfZero(perms.GroupID),
+				perms.GroupRead, perms.GroupWrite, perms.OtherRead, perms.OtherWrite, revIDs[i]}
+			revRows[i] = []interface{}{revIDs[i], 1, meta.UserMessage, meta.AutomaticLog, ip, now, now,
+				meta.Deleted, meta.Locked, p.Node.Content, p.Node.Title, artIDs[i], nil, nullIfZero(meta.UserID)}
+			parentID := int32(prtPathID)
+			if p.Parent >= 0 {
+				parentID = pathIDs[p.Parent]
+			}
+			pathRows[i] = []interface{}{pathIDs[i], p.Node.Slug, p.Left, p.Right, treeID, p.Level,
+				artIDs[i], parentID, 1, nil}
+		}
+
+		if err := copyArticleRows(ctx, tx, artRows, revRows, pathRows); err != nil {
+			return err
+		}
+
+		hdrIDs = make([]int, n)
+		for i, id := range artIDs {
+			hdrIDs[i] = int(id)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return hdrIDs, nil
+}
+
+// nullIfZero returns nil for the ID 0, which denotes a missing foreign key.
+func nullIfZero(id int) interface{} {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// reserveIDs returns counts[i] values of the ID sequence of tables[i] each, reserved in
+// a single round trip.
+func reserveIDs(ctx context.Context, tx pgx.Tx, tables []string, counts []int) ([][]int32, error) {
+	batch := &pgx.Batch{}
+	for i, table := range tables {
+		batch.Queue(
+			`select coalesce(array_agg(nextval(pg_get_serial_sequence($1, 'id'))), '{}')
+            from generate_series(1, $2);`, table, counts[i])
+	}
+	ids := make([][]int32, len(tables))
+	br := tx.SendBatch(ctx, batch)
+	for i, table := range tables {
+		if err := br.QueryRow().Scan(&ids[i]); err != nil {
+			br.Close()
+			return nil, fmt.Errorf("Failed to reserve IDs of %v: %w", table, err)
+		}
+	}
+	if err := br.Close(); err != nil {
+		return nil, fmt.Errorf("Failed to reserve IDs: %w", err)
+	}
+	return ids, nil
+}
+
+// ipAddress returns the value of wiki_articlerevision-ip_address for the address s,
+// nil if it is empty.
+func ipAddress(s string) (interface{}, error) {
+	addr := net.ParseIP(s)
+	if addr == nil {
+		if s != "" {
+			return nil, fmt.Errorf("Invalid IP address '%v'", s)
+		}
+		return nil, nil
+	}
+	if v4 := addr.To4(); v4 != nil {
+		addr = v4
+	}
+	return addr, nil
+}
+
+// copyArticleRows writes the rows of wiki_article, wiki_articlerevision and
+// wiki_urlpath with COPY.
+func copyArticleRows(ctx context.Context, tx pgx.Tx, artRows [][]interface{}, revRows [][]interface{}, pathRows [][]interface{}) error {
+	copies := []struct {
+		table   string
+		columns []string
+		rows    [][]interface{}
+	}{
+		{"wiki_article", []string{"id", "created", "modified", "owner_id", "group_id",
+			"group_read", "group_write", "other_read", "other_write", "current_revision_id"}, artRows},
+		{"wiki_articlerevision", []string{"id", "revision_number", "user_message", "automatic_log",
+			"ip_address", "modified", "created", "deleted", "locked", "content", "title", "article_id",
+			"previous_revision_id", "user_id"}, revRows},
+		{"wiki_urlpath", []string{"id", "slug", "lft", "rght", "tree_id", "level", "article_id",
+			"parent_id", "site_id", "moved_to_id"}, pathRows},
+	}
+	for _, cp := range copies {
+		_, err := tx.CopyFrom(ctx, pgx.Identifier{cp.table}, cp.columns, pgx.CopyFromRows(cp.rows))
+		if err != nil {
+			return fmt.Errorf("Failed to copy records into %v: %w", cp.table, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"coco-life.de/wapi/internal/models"
+	"github.com/georgysavva/scany/pgxscan"
+	"github.com/jackc/pgx/v4"
+)
+
+// LinkSchema contains the tables of the link index, see EnsureAPISchema.
+const LinkSchema = `
+create table if not exists wapi_linkindex (
+  article_id integer primary key
+    references wiki_article (id) on delete cascade deferrable initially deferred,
+  revision_id integer not null
+);
+
+create table if not exists wapi_articlelink (
+  from_article_id integer not null
+    references wiki_article (id) on delete cascade deferrable initially deferred,
+  to_article_id integer not null default 0,
+  to_path text not null default '',
+  primary key (from_article_id, to_article_id, to_path)
+);
+create index if not exists wapi_articlelink_to_article_id on wapi_articlelink (to_article_id)
+  where to_article_id <> 0;
+create index if not exists wapi_articlelink_to_path on wapi_articlelink (to_path)
+  where to_article_id = 0;`
+
+// SelectUnindexedArticles selects up to limit articles whose current revision differs
+// from the revision their links in wapi_articlelink were indexed for, e.g. because
+// django-wiki changed them. The path of a source is relative, e.g. "guide/".
+func SelectUnindexedArticles(ctx context.Context, conn Conn, limit int) ([]models.LinkSource, error) {
+	var sources []models.LinkSource
+	err := pgxscan.Select(ctx, conn, &sources,
+		`select hdr.id as article_id,
+                rev.id as rev_id,
+                rev.content,
+                (select coalesce(string_agg(anc.slug || '/', '' order by anc.lft), '')
+                 from wiki_urlpath as anc
+                 where anc.tree_id = node.tree_id
+                       and anc.lft <= node.lft
+                       and anc.rght >= node.rght) as path
+        from wiki_article as hdr
+            inner join wiki_articlerevision as rev
+                on hdr.current_revision_id = rev.id
+            inner join wiki_urlpath as node
+                on node.article_id = hdr.id
+            left join wapi_linkindex as idx
+                on idx.article_id = hdr.id
+        where idx.revision_id is distinct from rev.id
+        order by hdr.id
+        limit $1;`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to select unindexed articles: %w", err)
+	}
+	return sources, nil
+}
+
+// ReplaceArticleLinks replaces the links of article hdrID in wapi_articlelink and
+// records revID as indexed revision in wapi_linkindex.
+func ReplaceArticleLinks(ctx context.Context, conn Conn, hdrID int, revID int, links []models.ArticleLink) error {
+	toIDs := make([]int, len(links))
+	toPaths := make([]string, len(links))
+	for i, l := range links {
+		toIDs[i], toPaths[i] = l.ToID, l.ToPath
+	}
+	return conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `delete from wapi_articlelink where from_article_id = $1;`, hdrID); err != nil {
+			return fmt.Errorf("Failed to delete links of wiki_article %v: %w", hdrID, err)
+		}
+		// Concurrent indexing of the same revision inserts the same links.
+		_, err := tx.Exec(ctx,
+			`insert into wapi_articlelink (from_article_id, to_article_id, to_path)
+            select $1, link.to_id, link.to_path
+            from unnest($2::integer[], $3::text[]) as link(to_id, to_path)
+            on conflict do nothing;`, hdrID, toIDs, toPaths)
+		if err != nil {
+			return fmt.Errorf("Failed to insert links of wiki_article %v: %w", hdrID, err)
+		}
+		_, err = tx.Exec(ctx,
+			`insert into wapi_linkindex (article_id, revision_id)
+            values ($1, $2)
+            on conflict (article_id) do update set revision_id = excluded.revision_id;`, hdrID, revID)
+		if err != nil {
+			return fmt.Errorf("Failed to update wapi_linkindex of wiki_article %v: %w", hdrID, err)
+		}
+		return nil
+	})
+}
+
+// DeleteArticleLinks deletes the links of the article hdrID and its descendants from
+// wapi_articlelink together with their rows in wapi_linkindex.
+func DeleteArticleLinks(ctx context.Context, conn Conn, hdrID int) error {
+	return conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		var hdrIDs []int32
+		err := tx.QueryRow(ctx,
+			`select coalesce(array_agg(node.article_id), '{}')
+            from wiki_urlpath as top
+                inner join wiki_urlpath as node
+                    on node.tree_id = top.tree_id
+                       and node.lft between top.lft and top.rght
+            where top.article_id = $1;`, hdrID).Scan(&hdrIDs)
+		if err != nil {
+			return fmt.Errorf("Failed to select the subtree of wiki_article %v: %w", hdrID, err)
+		}
+		statements := []struct {
+			descr string
+			sql   string
+		}{
+			{"delete from wapi_articlelink",
+				`delete from wapi_articlelink
+                where from_article_id = any($1);`},
+			{"delete from wapi_linkindex",
+				`delete from wapi_linkindex
+                where article_id = any($1);`},
+		}
+		for _, st := range statements {
+			if _, err := tx.Exec(ctx, st.sql, hdrIDs); err != nil {
+				return fmt.Errorf("Failed to %v: %w", st.descr, err)
+			}
+		}
+		return nil
+	})
+}
+
+// SelectArticleLinks selects the links of an article.
+func SelectArticleLinks(ctx context.Context, conn Conn, hdrID int) ([]models.ArticleLink, error) {
+	var links []models.ArticleLink
+	err := pgxscan.Select(ctx, conn, &links,
+		`select from_article_id, to_article_id, to_path
+        from wapi_articlelink
+        where from_article_id = $1
+        order by to_article_id, to_path;`, hdrID)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to select links of wiki_article %v: %w", hdrID, err)
+	}
+	return links, nil
+}
+
+// SelectBacklinks selects the links to the article hdrID by ID or by its absolute URL
+// path and, with subtree, to its descendants.
+func SelectBacklinks(ctx context.Context, conn Conn, hdrID int, subtree bool) ([]models.ArticleLink, error) {
+	var links []models.ArticleLink
+	err := pgxscan.Select(ctx, conn, &links,
+		`with target as (
+            select node.article_id,
+                   (select '/' || coalesce(string_agg(anc.slug || '/', '' order by anc.lft), '')
+                    from wiki_urlpath as anc
+                    where anc.tree_id = node.tree_id
+                          and anc.lft <= node.lft
+                          and anc.rght >= node.rght) as path
+            from wiki_urlpath as top
+                inner join wiki_urlpath as node
+                    on node.tree_id = top.tree_id
+                       and node.lft >= top.lft
+                       and node.lft <= case when $2 then top.rght else top.lft end
+            where top.article_id = $1)
+        select from_article_id, to_article_id, to_path
+        from wapi_articlelink
+        where to_article_id in (select article_id from target)
+              or (to_article_id = 0 and to_path in (select path from target))
+        order by from_article_id, to_article_id, to_path;`, hdrID, subtree)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to select links to wiki_article %v: %w", hdrID, err)
+	}
+	return links, nil
+}
+
+// SelectBrokenLinks selects the links to IDs and absolute URL paths without article.
+func SelectBrokenLinks(ctx context.Context, conn Conn) ([]models.ArticleLink, error) {
+	var links []models.ArticleLink
+	err := pgxscan.Select(ctx, conn, &links,
+		`select from_article_id, to_article_id, to_path
+        from wapi_articlelink as link
+        where (to_article_id <> 0
+               and not exists (select 1 from wiki_urlpath where article_id = link.to_article_id))
+              or (to_article_id = 0
+                  and to_path not in (
+                      select '/' || coalesce(string_agg(anc.slug || '/', '' order by anc.lft), '')
+                      from wiki_urlpath as node
+                          inner join wiki_urlpath as anc
+                              on anc.tree_id = node.tree_id
+                                 and anc.lft <= node.lft
+                                 and anc.rght >= node.rght
+                      group by node.id))
+        order by from_article_id, to_article_id, to_path;`)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to select broken links: %w", err)
+	}
+	return links, nil
+}
+
+// SelectUnindexedArticles selects articles whose links are not indexed, see
+// SelectUnindexedArticles.
+func (s *PgStore) SelectUnindexedArticles(ctx context.Context, limit int) ([]models.LinkSource, error) {
+	sources, err := SelectUnindexedArticles(ctx, s.conn, limit)
+	return sources, mapErr(err)
+}
+
+// ReplaceArticleLinks replaces the indexed links of an article.
+func (s *PgStore) ReplaceArticleLinks(ctx context.Context, hdrID int, revID int, links []models.ArticleLink) error {
+	return mapErr(ReplaceArticleLinks(ctx, s.conn, hdrID, revID, links))
+}
+
+// DeleteArticleLinks removes the indexed links of a subtree.
+func (s *PgStore) DeleteArticleLinks(ctx context.Context, hdrID int) error {
+	return mapErr(DeleteArticleLinks(ctx, s.conn, hdrID))
+}
+
+// SelectArticleLinks selects the links of an article.
+func (s *PgStore) SelectArticleLinks(ctx context.Context, hdrID int) ([]models.ArticleLink, error) {
+	links, err := SelectArticleLinks(ctx, s.conn, hdrID)
+	return links, mapErr(err)
+}
+
+// SelectBacklinks selects the links to an article, see SelectBacklinks.
+func (s *PgStore) SelectBacklinks(ctx context.Context, hdrID int, subtree bool) ([]models.ArticleLink, error) {
+	links, err := SelectBacklinks(ctx, s.conn, hdrID, subtree)
+	return links, mapErr(err)
+}
+
+// SelectBrokenLinks selects the links to articles that do not exist.
+func (s *PgStore) SelectBrokenLinks(ctx context.Context) ([]models.ArticleLink, error) {
+	links, err := SelectBrokenLinks(ctx, s.conn)
+	return links, mapErr(err)
+}
@@ -0,0 +1,139 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"coco-life.de/wapi/internal/models"
+	"github.com/georgysavva/scany/pgxscan"
+)
+
+// SelectArticles selects up to q.Limit article summaries matching the filters of q.
+// The page is found by comparing the sort key with q.After, so deep pages are as fast
+// as the first one.
+func SelectArticles(ctx context.Context, conn Conn, q models.ArticleQuery) ([]models.ArticleSummary, error) {
+	var where []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if q.TitlePrefix != "" {
+		p := arg(q.TitlePrefix)
+		where = append(where, fmt.Sprintf("lower(left(rev.title, char_length(%v))) = lower(%v)", p, p))
+	}
+	if q.Slug != "" {
+		where = append(where, "path.slug = "+arg(q.Slug))
+	}
+	if q.Level != nil {
+		where = append(where, "path.level = "+arg(*q.Level))
+	}
+	if !q.ModifiedSince.IsZero() {
+		where = append(where, "rev.modified >= "+arg(q.ModifiedSince))
+	}
+	if !q.CreatedSince.IsZero() {
+		where = append(where, "hdr.created >= "+arg(q.CreatedSince))
+	}
+	if q.Left != 0 {
+		where = append(where, fmt.Sprintf("path.lft > %v and path.lft < %v", arg(q.Left), arg(q.Right)))
+	}
+	if q.ContainsLeft != 0 {
+		where = append(where, fmt.Sprintf("path.lft < %v and path.rght > %v", arg(q.ContainsLeft), arg(q.ContainsRight)))
+	}
+	if q.Left != 0 || q.ContainsLeft != 0 {
+		where = append(where, fmt.Sprintf("path.tree_id = (select tree_id from wiki_urlpath where id = %v)", arg(q.PathID)))
+	}
+	if q.HasChildren != nil {
+		where = append(where, "(path.rght - path.lft > 1) = "+arg(*q.HasChildren))
+	}
+	if q.Deleted != nil {
+		where = append(where, "rev.deleted = "+arg(*q.Deleted))
+	}
+	if q.IDs != nil {
+		where = append(where, fmt.Sprintf("hdr.id = any(%v)", arg(q.IDs)))
+	}
+	if !q.Reader.All {
+		userID := arg(q.Reader.UserID)
+		groupIDs := q.Reader.GroupIDs
+		if groupIDs == nil {
+			groupIDs = []int{}
+		}
+		where = append(where, fmt.Sprintf(`(hdr.other_read
+                   or hdr.owner_id = nullif(%v, 0)
+                   or (hdr.group_read and nullif(%v, 0) is not null and hdr.group_id = any(%v)))`,
+			userID, userID, arg(groupIDs)))
+	}
+
+	cmp, dir := ">", "asc"
+	if q.Desc {
+		cmp, dir = "<", "desc"
+	}
+	order := fmt.Sprintf("id %v", dir)
+	if q.SortBy == "modified" {
+		order = fmt.Sprintf("modified %v, id %v", dir, dir)
+	}
+	if q.After != nil {
+		if q.SortBy == "modified" {
+			where = append(where, fmt.Sprintf("(rev.modified, hdr.id) %v (%v, %v)", cmp, arg(q.After.Modified), arg(q.After.ID)))
+		} else {
+			where = append(where, fmt.Sprintf("hdr.id %v %v", cmp, arg(q.After.ID)))
+		}
+	}
+	if len(where) == 0 {
+		where = append(where, "true")
+	}
+	content := "null::text"
+	if q.WithContent {
+		content = "rev.content"
+	}
+
+	// The paths are only computed for the articles of the page.
+	sql := fmt.Sprintf(`select page.*,
+            (select coalesce(string_agg(anc.slug || '/', '' order by anc.lft), '')
+             from wiki_urlpath as anc
+             where anc.tree_id = page.tree_id
+                   and anc.lft <= page.lft
+                   and anc.rght >= page.rght) as path
+        from (select hdr.id,
+                     rev.id as rev_id,
+                     coalesce(parent_path.article_id, -1) as parent_art_id,
+                     coalesce(path.slug, '') as slug,
+                     rev.title,
+                     path.level,
+                     path.tree_id,
+                     path.lft,
+                     path.rght,
+                     hdr.created,
+                     rev.modified,
+                     rev.deleted,
+                     rev.locked,
+                     path.rght - path.lft > 1 as has_children,
+                     %v as content
+              from wiki_article as hdr
+                  inner join wiki_articlerevision as rev
+                      on hdr.current_revision_id = rev.id
+                  inner join wiki_urlpath as path
+                      on hdr.id = path.article_id
+                  left join wiki_urlpath as parent_path
+                      on path.parent_id = parent_path.id
+              where %v
+              order by %v
+              limit %v) as page
+        order by %v;`,
+		content, strings.Join(where, "\n                    and "), order, arg(q.Limit), order)
+
+	var rows []struct {
+		models.ArticleSummary
+		TreeID int `db:"tree_id"`
+	}
+	if err := pgxscan.Select(ctx, conn, &rows, sql, args...); err != nil {
+		return nil, fmt.Errorf("Failed to select articles: %w", err)
+	}
+	summaries := make([]models.ArticleSummary, len(rows))
+	for i, r := range rows {
+		summaries[i] = r.ArticleSummary
+	}
+	return summaries, nil
+}
@@ -0,0 +1,188 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"coco-life.de/wapi/internal/store"
+	"github.com/jackc/pgx/v4"
+)
+
+// nodeBounds selects 'lft', 'rght', 'level' and 'tree_id' of a wiki_urlpath record.
+func nodeBounds(ctx context.Context, conn Conn, pathID int) (left, right, lvl, treeID int, err error) {
+	err = conn.QueryRow(ctx,
+		`select lft, rght, level, tree_id
+        from wiki_urlpath
+        where id = $1;`, pathID).Scan(&left, &right, &lvl, &treeID)
+	if err != nil {
+		err = fmt.Errorf("Failed to select wiki_urlpath %v: %w", pathID, err)
+	}
+	return
+}
+
+// MoveWikiURLPath moves the subtree of the wiki_urlpath record pathID below
+// newParentPathID and makes it the pos-th child (starting at 0) of the new parent.
+// If pos is negative or not less than the number of the other children, the subtree
+// becomes the last child. Moving a subtree within its parent reorders the children.
+//
+// The nested set is updated with a single UPDATE statement, see store.MPTTCalcForMove.
+func MoveWikiURLPath(ctx context.Context, conn Conn, pathID int, newParentPathID int, pos int) error {
+	return conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		left, right, lvl, treeID, err := nodeBounds(ctx, tx, pathID)
+		if err != nil {
+			return err
+		}
+		prtLeft, prtRight, prtLvl, _, err := nodeBounds(ctx, tx, newParentPathID)
+		if err != nil {
+			return err
+		}
+		if prtLeft >= left && prtRight <= right {
+			return fmt.Errorf("%w: wiki_urlpath %v cannot be moved below itself or its descendant %v",
+				store.ErrConstraint, pathID, newParentPathID)
+		}
+
+		// The position is given by the sibling that is currently at pos, ignoring the
+		// moved node itself.
+		target := prtRight
+		if pos >= 0 {
+			err = tx.QueryRow(ctx,
+				`select lft
+                from wiki_urlpath
+                where parent_id = $1
+                      and id != $2
+                order by lft
+                offset $3
+                limit 1;`, newParentPathID, pathID, pos).Scan(&target)
+			if errors.Is(err, pgx.ErrNoRows) {
+				target = prtRight
+			} else if err != nil {
+				return fmt.Errorf("Failed to select siblings in wiki_urlpath: %w", err)
+			}
+		}
+
+		delta, lo, hi, shift := store.MPTTCalcForMove(left, right, target)
+		sqlUpd := `update wiki_urlpath
+            set lft = case
+                        when lft between $1 and $2 then lft + $3
+                        when lft between $4 and $5 then lft + $6
+                        else lft
+                      end,
+                rght = case
+                        when rght between $1 and $2 then rght + $3
+                        when rght between $4 and $5 then rght + $6
+                        else rght
+                      end,
+                level = case
+                        when lft between $1 and $2 then level + $7
+                        else level
+                      end
+            where tree_id = $8
+                  and (lft between least($1, $4) and greatest($2, $5)
+                       or rght between least($1, $4) and greatest($2, $5));`
+		_, err = tx.Exec(ctx, sqlUpd, left, right, delta, lo, hi, shift, prtLvl+1-lvl, treeID)
+		if err != nil {
+			return fmt.Errorf("Failed to update wiki_urlpath for MOVE according to MPTT: %w", err)
+		}
+
+		_, err = tx.Exec(ctx,
+			`update wiki_urlpath
+            set parent_id = $2
+            where id = $1;`, pathID, newParentPathID)
+		if err != nil {
+			return fmt.Errorf("Failed to update 'parent_id' in wiki_urlpath: %w", err)
+		}
+		return nil
+	})
+}
+
+// UpdateWikiURLPathSlug sets wiki_urlpath-slug of pathID. The nested set does not
+// change. django-wiki's unique constraint on parent and slug rejects duplicates.
+func UpdateWikiURLPathSlug(ctx context.Context, conn Conn, pathID int, slug string) error {
+	commandTag, err := conn.Exec(ctx,
+		`update wiki_urlpath
+        set slug = $2
+        where id = $1
+              and parent_id is not null;`, pathID, slug)
+	if err != nil {
+		return fmt.Errorf("Failed to update 'slug' in wiki_urlpath: %w", err)
+	}
+	if commandTag.RowsAffected() != 1 {
+		return fmt.Errorf("%w: wiki_urlpath %v", store.ErrNotFound, pathID)
+	}
+	return nil
+}
+
+// DeleteWikiURLPath deletes the subtree of the wiki_urlpath record pathID together
+// with the wiki_article and wiki_articlerevision records of all its nodes. The gap in
+// the nested set is closed afterwards.
+func DeleteWikiURLPath(ctx context.Context, conn Conn, pathID int) error {
+	return conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		left, right, _, treeID, err := nodeBounds(ctx, tx, pathID)
+		if err != nil {
+			return err
+		}
+
+		var hdrIDs []int32
+		err = tx.QueryRow(ctx,
+			`select coalesce(array_agg(article_id), '{}')
+            from wiki_urlpath
+            where tree_id = $1
+                  and lft between $2 and $3;`, treeID, left, right).Scan(&hdrIDs)
+		if err != nil {
+			return fmt.Errorf("Failed to select articles of wiki_urlpath %v: %w", pathID, err)
+		}
+
+		statements := []struct {
+			descr string
+			sql   string
+			args  []interface{}
+		}{
+			// Django sets 'moved_to' to null if the target is deleted.
+			{"reset 'moved_to_id' in wiki_urlpath",
+				`update wiki_urlpath
+                set moved_to_id = null
+                where moved_to_id in (select id
+                                      from wiki_urlpath
+                                      where tree_id = $1
+                                            and lft between $2 and $3);`,
+				[]interface{}{treeID, left, right}},
+			{"delete from wiki_urlpath",
+				`delete from wiki_urlpath
+                where tree_id = $1
+                      and lft between $2 and $3;`,
+				[]interface{}{treeID, left, right}},
+			{"reset 'current_revision_id' in wiki_article",
+				`update wiki_article
+                set current_revision_id = null
+                where id = any($1);`,
+				[]interface{}{hdrIDs}},
+			{"delete from wiki_articlerevision",
+				`delete from wiki_articlerevision
+                where article_id = any($1);`,
+				[]interface{}{hdrIDs}},
+			{"delete from wiki_article",
+				`delete from wiki_article
+                where id = any($1);`,
+				[]interface{}{hdrIDs}},
+			{"update 'lft' in wiki_urlpath",
+				`update wiki_urlpath
+                set lft = lft - $3
+                where tree_id = $1
+                      and lft > $2;`,
+				[]interface{}{treeID, right, store.MPTTCalcForDelete(left, right)}},
+			{"update 'rght' in wiki_urlpath",
+				`update wiki_urlpath
+                set rght = rght - $3
+                where tree_id = $1
+                      and rght > $2;`,
+				[]interface{}{treeID, right, store.MPTTCalcForDelete(left, right)}},
+		}
+		for _, st := range statements {
+			if _, err := tx.Exec(ctx, st.sql, st.args...); err != nil {
+				return fmt.Errorf("Failed to %v: %w", st.descr, err)
+			}
+		}
+		return nil
+	})
+}
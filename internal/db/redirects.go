@@ -0,0 +1,51 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"coco-life.de/wapi/internal/store"
+)
+
+// SetWikiURLPathMovedTo sets wiki_urlpath-moved_to_id of pathID. django-wiki redirects
+// from the node to movedToPathID.
+func SetWikiURLPathMovedTo(ctx context.Context, conn Conn, pathID int, movedToPathID int) error {
+	commandTag, err := conn.Exec(ctx,
+		`update wiki_urlpath
+        set moved_to_id = $2
+        where id = $1;`, pathID, movedToPathID)
+	if err != nil {
+		return fmt.Errorf("Failed to update 'moved_to_id' in wiki_urlpath: %w", err)
+	}
+	if commandTag.RowsAffected() != 1 {
+		return fmt.Errorf("%w: wiki_urlpath %v", store.ErrNotFound, pathID)
+	}
+	return nil
+}
+
+// SelectMovedTo returns wiki_article-id of the node wiki_urlpath-moved_to_id of the
+// article hdrID points to, 0 if it is null.
+func SelectMovedTo(ctx context.Context, conn Conn, hdrID int) (int, error) {
+	var movedTo int
+	err := conn.QueryRow(ctx,
+		`select coalesce(dst.article_id, 0)
+        from wiki_urlpath as src
+            left join wiki_urlpath as dst
+                on dst.id = src.moved_to_id
+        where src.article_id = $1;`, hdrID).Scan(&movedTo)
+	if err != nil {
+		return 0, fmt.Errorf("Failed to select 'moved_to_id' of wiki_article %v: %w", hdrID, err)
+	}
+	return movedTo, nil
+}
+
+// SetWikiURLPathMovedTo makes a node of wiki_urlpath a redirect.
+func (s *PgStore) SetWikiURLPathMovedTo(ctx context.Context, pathID int, movedToPathID int) error {
+	return mapErr(SetWikiURLPathMovedTo(ctx, s.conn, pathID, movedToPathID))
+}
+
+// SelectMovedTo returns the target of a redirect, see SelectMovedTo.
+func (s *PgStore) SelectMovedTo(ctx context.Context, hdrID int) (int, error) {
+	id, err := SelectMovedTo(ctx, s.conn, hdrID)
+	return id, mapErr(err)
+}
@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"coco-life.de/wapi/internal/models"
+)
+
+// headlineOptions configure the snippets of ts_headline. The content is HTML-escaped
+// like html.EscapeString before, so the <mark> tags are the only markup of a snippet.
+const headlineOptions = "StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=20, MinWords=5, FragmentDelimiter=\" … \""
+
+// SearchArticles searches title and content of the current revisions with
+// websearch_to_tsquery. Matches in the title rank higher than matches in the content.
+// It returns one page of hits ordered by rank and the total number of hits.
+//
+// Ranking and paging need all matches, but snippets and paths are only computed for
+// the hits of the page.
+func SearchArticles(ctx context.Context, conn Conn, q models.SearchQuery) ([]models.SearchHit, int, error) {
+	sql := `with matches as (
+        select art.id,
+               rev.title,
+               rev.content,
+               path.tree_id,
+               path.lft,
+               path.rght,
+               query.q,
+               ts_rank(doc.vec, query.q) as rank
+        from wiki_article as art
+            inner join wiki_articlerevision as rev
+                on rev.id = art.current_revision_id
+            inner join wiki_urlpath as path
+                on path.article_id = art.id
+            cross join (select websearch_to_tsquery($1::regconfig, $2) as q) as query
+            cross join lateral (select setweight(to_tsvector($1::regconfig, rev.title), 'A')
+                                       || setweight(to_tsvector($1::regconfig, rev.content), 'B') as vec) as doc
+        where doc.vec @@ query.q
+              and not rev.deleted
+              and ($3 = 0 or (path.lft between $3 and $4
+                              and path.tree_id = (select tree_id from wiki_urlpath where id = $11)))
+              and ($5
+                   or art.other_read
+                   or art.owner_id = nullif($6, 0)
+                   or (art.group_read and nullif($6, 0) is not null and art.group_id = any($7)))
+      ),
+      page as (
+        select *
+        from matches
+        order by rank desc, id
+        limit $8
+        offset $9
+      )
+      select (select count(*) from matches) as total,
+             coalesce((select json_agg(hit order by hit.rank desc, hit.id)
+                       from (select page.id,
+                                    page.title,
+                                    page.rank,
+                                    ts_headline($1::regconfig,
+                                                replace(replace(replace(replace(replace(page.content,
+                                                    '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&#34;'), '''', '&#39;'),
+                                                page.q, $10) as snippet,
+                                    (select coalesce(string_agg(anc.slug || '/', '' order by anc.lft), '')
+                                     from wiki_urlpath as anc
+                                     where anc.tree_id = page.tree_id
+                                           and anc.lft <= page.lft
+                                           and anc.rght >= page.rght) as path
+                             from page) as hit), '[]') as hits;`
+	var total int
+	var hits []models.SearchHit
+	groupIDs := q.Reader.GroupIDs
+	if groupIDs == nil {
+		groupIDs = []int{}
+	}
+	err := conn.QueryRow(ctx, sql, q.Config, q.Query, q.Left, q.Right,
+		q.Reader.All, q.Reader.UserID, groupIDs, q.Limit, q.Offset, headlineOptions, q.PathID).Scan(&total, &hits)
+	if err != nil {
+		return nil, 0, fmt.Errorf("Failed to search wiki_articlerevision: %w", err)
+	}
+	return hits, total, nil
+}
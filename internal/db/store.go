@@ -0,0 +1,202 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"coco-life.de/wapi/internal/models"
+	"coco-life.de/wapi/internal/store"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+// Conn is the subset of pgx functionality used by this package. It is implemented by
+// *pgxpool.Pool, *pgxpool.Conn, *pgx.Conn and pgx.Tx.
+type Conn interface {
+	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+	BeginFunc(ctx context.Context, f func(pgx.Tx) error) error
+}
+
+// PgStore implements store.ArticleStore on top of Postgres.
+type PgStore struct {
+	conn Conn
+}
+
+var _ store.ArticleStore = (*PgStore)(nil)
+
+// NewPgStore returns a store using conn, usually a *pgxpool.Pool.
+func NewPgStore(conn Conn) *PgStore {
+	return &PgStore{conn: conn}
+}
+
+// mapErr wraps errors of the Postgres driver into the errors of package store.
+func mapErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("%w: %v", store.ErrNotFound, err)
+	}
+	var pgErr *pgconn.PgError
+	// Class 23 — Integrity Constraint Violation
+	if errors.As(err, &pgErr) && len(pgErr.Code) == 5 && pgErr.Code[:2] == "23" {
+		return fmt.Errorf("%w: %v", store.ErrConstraint, err)
+	}
+	return err
+}
+
+// InTx runs f within a transaction. Nested calls use savepoints.
+func (s *PgStore) InTx(ctx context.Context, f func(tx store.ArticleStore) error) error {
+	// Deferred foreign keys are only checked on commit.
+	return mapErr(s.conn.BeginFunc(ctx, func(tx pgx.Tx) error {
+		return f(&PgStore{conn: tx})
+	}))
+}
+
+// SelectRootArticle selects the root article with its current revision.
+func (s *PgStore) SelectRootArticle(ctx context.Context) (*models.RootArticle, error) {
+	a, err := SelectRootArticle(ctx, s.conn)
+	return a, mapErr(err)
+}
+
+// SelectArticleByID selects an article with its current revision by wiki_article-id.
+func (s *PgStore) SelectArticleByID(ctx context.Context, id int) (*models.Article, error) {
+	a, err := SelectArticleByID(ctx, s.conn, id)
+	return a, mapErr(err)
+}
+
+// SelectArticleByPath selects an article with its current revision by its URL path.
+func (s *PgStore) SelectArticleByPath(ctx context.Context, path string) (*models.Article, error) {
+	a, err := SelectArticleByPath(ctx, s.conn, path)
+	return a, mapErr(err)
+}
+
+// SelectArticleHeader selects an article without its content.
+func (s *PgStore) SelectArticleHeader(ctx context.Context, id int) (*models.Article, error) {
+	a, err := SelectArticleHeader(ctx, s.conn, id)
+	return a, mapErr(err)
+}
+
+// SelectArticleIDByPath returns the ID of the article with the URL path.
+func (s *PgStore) SelectArticleIDByPath(ctx context.Context, path string) (int, error) {
+	id, err := SelectArticleIDByPath(ctx, s.conn, path)
+	return id, mapErr(err)
+}
+
+// SelectRevisions selects the revisions of an article.
+func (s *PgStore) SelectRevisions(ctx context.Context, hdrID int) ([]models.Revision, error) {
+	revs, err := SelectRevisions(ctx, s.conn, hdrID)
+	return revs, mapErr(err)
+}
+
+// InsertWikiArticle inserts a record into wiki_article and returns its ID.
+func (s *PgStore) InsertWikiArticle(ctx context.Context, perms models.Permissions) (int, error) {
+	id, err := InsertWikiArticle(ctx, s.conn, perms)
+	return id, mapErr(err)
+}
+
+// UpdateWikiArticlePermissions sets owner, group and permission flags of an article.
+func (s *PgStore) UpdateWikiArticlePermissions(ctx context.Context, hdrID int, perms models.Permissions) error {
+	return mapErr(UpdateWikiArticlePermissions(ctx, s.conn, hdrID, perms))
+}
+
+// InsertWikiArticleRevision inserts the first revision of an article.
+func (s *PgStore) InsertWikiArticleRevision(ctx context.Context, hdrID int, title string, content string, meta models.RevisionMeta) (int, error) {
+	id, err := InsertWikiArticleRevision(ctx, s.conn, hdrID, title, content, meta)
+	return id, mapErr(err)
+}
+
+// AddWikiArticleRevision adds a revision and makes it the current one.
+func (s *PgStore) AddWikiArticleRevision(ctx context.Context, hdrID int, title string, content string, meta models.RevisionMeta) (int, error) {
+	id, err := AddWikiArticleRevision(ctx, s.conn, hdrID, title, content, meta)
+	return id, mapErr(err)
+}
+
+// SetWikiArticleRevision sets wiki_article-current_revision_id.
+func (s *PgStore) SetWikiArticleRevision(ctx context.Context, hdrID int, revID int) error {
+	return mapErr(SetWikiArticleRevision(ctx, s.conn, hdrID, revID))
+}
+
+// InsertWikiURLPathRoot inserts the wiki_urlpath record of the root article.
+func (s *PgStore) InsertWikiURLPathRoot(ctx context.Context, hdrID int) error {
+	return mapErr(InsertWikiURLPathRoot(ctx, s.conn, hdrID))
+}
+
+// InsertWikiURLPathChild inserts the wiki_urlpath record of a child article.
+func (s *PgStore) InsertWikiURLPathChild(ctx context.Context, slug string, hdrID int, lvl int, left int, right int, parentPathID int) (int, error) {
+	id, err := InsertWikiURLPathChild(ctx, s.conn, slug, hdrID, lvl, left, right, parentPathID)
+	return id, mapErr(err)
+}
+
+// MPTTUpdWikiURLPathForInsert shifts 'lft' and 'rght' of all other nodes.
+func (s *PgStore) MPTTUpdWikiURLPathForInsert(ctx context.Context, newArtPathID int, nLft int) error {
+	return mapErr(MPTTUpdWikiURLPathForInsert(ctx, s.conn, newArtPathID, nLft))
+}
+
+// SelectURLPath returns the URL path of an article.
+func (s *PgStore) SelectURLPath(ctx context.Context, hdrID int) (string, error) {
+	p, err := SelectURLPath(ctx, s.conn, hdrID)
+	return p, mapErr(err)
+}
+
+// MoveWikiURLPath moves a subtree of wiki_urlpath.
+func (s *PgStore) MoveWikiURLPath(ctx context.Context, pathID int, newParentPathID int, pos int) error {
+	return mapErr(MoveWikiURLPath(ctx, s.conn, pathID, newParentPathID, pos))
+}
+
+// UpdateWikiURLPathSlug renames a subtree of wiki_urlpath.
+func (s *PgStore) UpdateWikiURLPathSlug(ctx context.Context, pathID int, slug string) error {
+	return mapErr(UpdateWikiURLPathSlug(ctx, s.conn, pathID, slug))
+}
+
+// DeleteWikiURLPath deletes a subtree of wiki_urlpath and its articles.
+func (s *PgStore) DeleteWikiURLPath(ctx context.Context, pathID int) error {
+	return mapErr(DeleteWikiURLPath(ctx, s.conn, pathID))
+}
+
+// ImportWikiArticles inserts article trees, see ImportWikiArticles.
+func (s *PgStore) ImportWikiArticles(ctx context.Context, parentHdrID int, nodes []models.ArticleNode, perms models.Permissions, meta models.RevisionMeta) ([]int, error) {
+	ids, err := ImportWikiArticles(ctx, s.conn, parentHdrID, nodes, perms, meta)
+	return ids, mapErr(err)
+}
+
+// CopyWikiArticles copies a subtree, see CopyWikiArticles.
+func (s *PgStore) CopyWikiArticles(ctx context.Context, hdrID int, parentHdrID int, opts models.CopyOptions) ([]int, error) {
+	ids, err := CopyWikiArticles(ctx, s.conn, hdrID, parentHdrID, opts)
+	return ids, mapErr(err)
+}
+
+// SelectArticles lists article summaries, see SelectArticles.
+func (s *PgStore) SelectArticles(ctx context.Context, q models.ArticleQuery) ([]models.ArticleSummary, error) {
+	summaries, err := SelectArticles(ctx, s.conn, q)
+	return summaries, mapErr(err)
+}
+
+// SearchArticles runs a full-text search, see SearchArticles.
+func (s *PgStore) SearchArticles(ctx context.Context, q models.SearchQuery) ([]models.SearchHit, int, error) {
+	hits, total, err := SearchArticles(ctx, s.conn, q)
+	return hits, total, mapErr(err)
+}
+
+// Ping checks that the database is reachable.
+func (s *PgStore) Ping(ctx context.Context) error {
+	return Ping(ctx, s.conn)
+}
+
+// MissingTables returns the subset of tables that does not exist.
+func (s *PgStore) MissingTables(ctx context.Context, tables []string) ([]string, error) {
+	return MissingTables(ctx, s.conn, tables)
+}
+
+// CountRootArticles returns the number of articles on level 0.
+func (s *PgStore) CountRootArticles(ctx context.Context) (int, error) {
+	return CountRootArticles(ctx, s.conn)
+}
+
+// MPTTViolations returns a description of every violated nested set rule.
+func (s *PgStore) MPTTViolations(ctx context.Context) ([]string, error) {
+	return MPTTViolations(ctx, s.conn)
+}
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"coco-life.de/wapi/internal/auth"
+	"coco-life.de/wapi/internal/models"
+	"github.com/georgysavva/scany/pgxscan"
+)
+
+// APISchema contains the table of the API tokens, see EnsureAPISchema. The statements
+// of the schemas the API adds to the django-wiki schema are idempotent.
+const APISchema = `
+create table if not exists wapi_apitoken (
+  id serial primary key,
+  name varchar(100) not null unique,
+  token_hash char(64) not null unique,
+  scope varchar(10) not null check (scope in ('read', 'write', 'admin')),
+  user_id integer null
+    references auth_user (id) on delete cascade deferrable initially deferred,
+  created timestamp with time zone not null default now(),
+  revoked timestamp with time zone null
+);`
+
+// EnsureAPISchema creates the tables of APISchema, IdempotencySchema and LinkSchema
+// unless they exist.
+func EnsureAPISchema(ctx context.Context, conn Conn) error {
+	for _, schema := range []string{APISchema, IdempotencySchema, LinkSchema} {
+		if _, err := conn.Exec(ctx, schema); err != nil {
+			return fmt.Errorf("Failed to create API tables: %w", err)
+		}
+	}
+	return nil
+}
+
+var _ auth.TokenStore = (*PgStore)(nil)
+
+const sqlSelectAPIToken = `select
+        id,
+        name,
+        token_hash,
+        scope,
+        coalesce(user_id, 0) as user_id,
+        created,
+        revoked
+    from wapi_apitoken`
+
+// InsertAPIToken inserts a record into wapi_apitoken and returns its ID.
+func InsertAPIToken(ctx context.Context, conn Conn, t *models.APIToken) (int, error) {
+	var id int
+	err := conn.QueryRow(ctx,
+		`insert into wapi_apitoken (name, token_hash, scope, user_id)
+        values ($1, $2, $3, nullif($4, 0))
+        returning id;`, t.Name, t.Hash, t.Scope, t.UserID).Scan(&id)
+	if err != nil {
+		return -1, fmt.Errorf("Failed to insert into wapi_apitoken: %w", err)
+	}
+	return id, nil
+}
+
+// SelectAPITokenByHash selects the wapi_apitoken record with the given hash.
+func SelectAPITokenByHash(ctx context.Context, conn Conn, hash string) (*models.APIToken, error) {
+	var t models.APIToken
+	err := pgxscan.Get(ctx, conn, &t, sqlSelectAPIToken+` where token_hash = $1;`, hash)
+	return &t, err
+}
+
+// SelectAPITokens selects all wapi_apitoken records ordered by ID.
+func SelectAPITokens(ctx context.Context, conn Conn) ([]models.APIToken, error) {
+	var tokens []models.APIToken
+	err := pgxscan.Select(ctx, conn, &tokens, sqlSelectAPIToken+` order by id;`)
+	return tokens, err
+}
+
+// RevokeAPIToken sets wapi_apitoken-revoked unless it is set already.
+func RevokeAPIToken(ctx context.Context, conn Conn, id int) error {
+	var revokedID int
+	err := conn.QueryRow(ctx,
+		`update wapi_apitoken
+        set revoked = coalesce(revoked, now())
+        where id = $1
+        returning id;`, id).Scan(&revokedID)
+	if err != nil {
+		return fmt.Errorf("Failed to revoke API token %v: %w", id, err)
+	}
+	return nil
+}
+
+// InsertAPIToken stores an API token.
+func (s *PgStore) InsertAPIToken(ctx context.Context, t *models.APIToken) (int, error) {
+	id, err := InsertAPIToken(ctx, s.conn, t)
+	return id, mapErr(err)
+}
+
+// SelectAPITokenByHash selects an API token by the hash of the token.
+func (s *PgStore) SelectAPITokenByHash(ctx context.Context, hash string) (*models.APIToken, error) {
+	t, err := SelectAPITokenByHash(ctx, s.conn, hash)
+	return t, mapErr(err)
+}
+
+// SelectAPITokens selects all API tokens.
+func (s *PgStore) SelectAPITokens(ctx context.Context) ([]models.APIToken, error) {
+	tokens, err := SelectAPITokens(ctx, s.conn)
+	return tokens, mapErr(err)
+}
+
+// RevokeAPIToken revokes an API token.
+func (s *PgStore) RevokeAPIToken(ctx context.Context, id int) error {
+	return mapErr(RevokeAPIToken(ctx, s.conn, id))
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"context"
+
+	"coco-life.de/wapi/internal/models"
+	"coco-life.de/wapi/internal/store"
+	"github.com/georgysavva/scany/pgxscan"
+)
+
+var _ store.UserStore = (*PgStore)(nil)
+
+const sqlSelectAuthUser = `select
+        id,
+        username,
+        password,
+        is_active,
+        is_superuser
+    from auth_user`
+
+// SelectAuthUserByName selects the auth_user record with the given username.
+func SelectAuthUserByName(ctx context.Context, conn Conn, username string) (*models.AuthUser, error) {
+	var user models.AuthUser
+	err := pgxscan.Get(ctx, conn, &user, sqlSelectAuthUser+` where username = $1;`, username)
+	return &user, err
+}
+
+// SelectAuthUserByID selects the auth_user record with the given ID.
+func SelectAuthUserByID(ctx context.Context, conn Conn, id int) (*models.AuthUser, error) {
+	var user models.AuthUser
+	err := pgxscan.Get(ctx, conn, &user, sqlSelectAuthUser+` where id = $1;`, id)
+	return &user, err
+}
+
+// SelectAuthUserGroupIDs selects auth_group-id of all groups of a user from
+// auth_user_groups.
+func SelectAuthUserGroupIDs(ctx context.Context, conn Conn, userID int) ([]int, error) {
+	var ids []int
+	err := pgxscan.Select(ctx, conn, &ids,
+		`select group_id
+        from auth_user_groups
+        where user_id = $1
+        order by group_id;`, userID)
+	return ids, err
+}
+
+// SelectAuthUserByName selects a Django user by username.
+func (s *PgStore) SelectAuthUserByName(ctx context.Context, username string) (*models.AuthUser, error) {
+	u, err := SelectAuthUserByName(ctx, s.conn, username)
+	return u, mapErr(err)
+}
+
+// SelectAuthUserByID selects a Django user by ID.
+func (s *PgStore) SelectAuthUserByID(ctx context.Context, id int) (*models.AuthUser, error) {
+	u, err := SelectAuthUserByID(ctx, s.conn, id)
+	return u, mapErr(err)
+}
+
+// SelectAuthUserGroupIDs selects the groups of a Django user.
+func (s *PgStore) SelectAuthUserGroupIDs(ctx context.Context, userID int) ([]int, error) {
+	ids, err := SelectAuthUserGroupIDs(ctx, s.conn, userID)
+	return ids, mapErr(err)
+}
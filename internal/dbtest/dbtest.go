@@ -0,0 +1,124 @@
+// Package dbtest creates throwaway copies of the django-wiki schema on a local
+// Postgres for tests.
+//
+// The connection parameters are read from the PG* environment variables and the file
+// .env in the project root, see README.md. Every call to NewPool creates a new
+// Postgres schema, applies schema.sql to it and drops it once the test has finished,
+// OpenPool leaves dropping it to the caller.
+// Tests are skipped if the database cannot be reached unless WAPI_TEST_REQUIRE_DB is
+// set.
+package dbtest
+
+import (
+	"context"
+	_ "embed" // for schema.sql
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/joho/godotenv"
+)
+
+// Schema contains the DDL of the django-wiki tables the API works with.
+//go:embed schema.sql
+var Schema string
+
+// seq makes schema names unique within the test binary.
+var seq int64
+
+// NewPool creates a fresh schema containing the django-wiki tables and returns a pool
+// whose connections use it as search_path. The schema is dropped and the pool is
+// closed by t.Cleanup.
+func NewPool(t testing.TB) *pgxpool.Pool {
+	t.Helper()
+	pool, drop := OpenPool(t)
+	t.Cleanup(drop)
+	return pool
+}
+
+// OpenPool creates a schema and a pool like NewPool but leaves it to the caller to
+// close the pool and drop the schema with the returned function. Tests that need many
+// short-lived stores use it to release the connections early.
+func OpenPool(t testing.TB) (*pgxpool.Pool, func()) {
+	t.Helper()
+	loadEnv()
+	ctx := context.Background()
+
+	admin, err := pgx.Connect(ctx, "")
+	if err != nil {
+		skipOrFail(t, "Unable to connect to database: %v", err)
+	}
+	defer admin.Close(ctx)
+
+	schema := fmt.Sprintf("wapi_test_%d_%d_%d",
+		os.Getpid(), time.Now().UnixNano()%1e6, atomic.AddInt64(&seq, 1))
+	if _, err := admin.Exec(ctx, "create schema "+schema+";"); err != nil {
+		t.Fatalf("Failed to create schema %v: %v", schema, err)
+	}
+	dropSchema := func() {
+		conn, err := pgx.Connect(context.Background(), "")
+		if err != nil {
+			t.Errorf("Failed to drop schema %v: %v", schema, err)
+			return
+		}
+		defer conn.Close(context.Background())
+		if _, err := conn.Exec(context.Background(), "drop schema "+schema+" cascade;"); err != nil {
+			t.Errorf("Failed to drop schema %v: %v", schema, err)
+		}
+	}
+
+	cfg, err := pgxpool.ParseConfig("")
+	if err != nil {
+		t.Fatalf("Failed to parse database config: %v", err)
+	}
+	cfg.ConnConfig.RuntimeParams["search_path"] = schema
+	pool, err := pgxpool.ConnectConfig(ctx, cfg)
+	if err != nil {
+		dropSchema()
+		t.Fatalf("Unable to connect to database: %v", err)
+	}
+	drop := func() {
+		pool.Close()
+		dropSchema()
+	}
+
+	if _, err := pool.Exec(ctx, Schema); err != nil {
+		drop()
+		t.Fatalf("Failed to apply schema: %v", err)
+	}
+	return pool, drop
+}
+
+// skipOrFail skips the test unless WAPI_TEST_REQUIRE_DB is set.
+func skipOrFail(t testing.TB, format string, args ...interface{}) {
+	t.Helper()
+	if os.Getenv("WAPI_TEST_REQUIRE_DB") != "" {
+		t.Fatalf(format, args...)
+	}
+	t.Skipf(format, args...)
+}
+
+// loadEnv reads .env from the project root, that is, the first parent directory of
+// the working directory that contains go.mod.
+func loadEnv() {
+	dir, err := os.Getwd()
+	if err != nil {
+		return
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			godotenv.Load(filepath.Join(dir, ".env"))
+			return
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return
+		}
+		dir = parent
+	}
+}
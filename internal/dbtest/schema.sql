@@ -0,0 +1,103 @@
+-- Subset of the Django and django-wiki schema the API works with. The definitions
+-- follow the migrations of Django 3.2 and django-wiki 0.7 on Postgres: Foreign keys
+-- are DEFERRABLE INITIALLY DEFERRED and IDs are serials.
+
+create table django_site (
+  id serial primary key,
+  domain varchar(100) not null unique,
+  name varchar(50) not null
+);
+
+create table auth_group (
+  id serial primary key,
+  name varchar(150) not null unique
+);
+
+create table auth_user (
+  id serial primary key,
+  password varchar(128) not null,
+  last_login timestamp with time zone null,
+  is_superuser boolean not null,
+  username varchar(150) not null unique,
+  first_name varchar(150) not null,
+  last_name varchar(150) not null,
+  email varchar(254) not null,
+  is_staff boolean not null,
+  is_active boolean not null,
+  date_joined timestamp with time zone not null
+);
+
+create table auth_user_groups (
+  id serial primary key,
+  user_id integer not null
+    references auth_user (id) deferrable initially deferred,
+  group_id integer not null
+    references auth_group (id) deferrable initially deferred,
+  unique (user_id, group_id)
+);
+
+create table wiki_article (
+  id serial primary key,
+  created timestamp with time zone not null,
+  modified timestamp with time zone not null,
+  group_read boolean not null,
+  group_write boolean not null,
+  other_read boolean not null,
+  other_write boolean not null,
+  -- The foreign key to wiki_articlerevision is added below.
+  current_revision_id integer null unique,
+  group_id integer null
+    references auth_group (id) deferrable initially deferred,
+  owner_id integer null
+    references auth_user (id) deferrable initially deferred
+);
+
+create table wiki_articlerevision (
+  id serial primary key,
+  revision_number integer not null,
+  user_message text not null,
+  automatic_log text not null,
+  ip_address inet null,
+  modified timestamp with time zone not null,
+  created timestamp with time zone not null,
+  deleted boolean not null,
+  locked boolean not null,
+  content text not null,
+  title varchar(512) not null,
+  article_id integer not null
+    references wiki_article (id) deferrable initially deferred,
+  previous_revision_id integer null
+    references wiki_articlerevision (id) deferrable initially deferred,
+  user_id integer null
+    references auth_user (id) deferrable initially deferred,
+  unique (article_id, revision_number)
+);
+
+alter table wiki_article
+  add constraint wiki_article_current_revision_id_fk
+  foreign key (current_revision_id)
+  references wiki_articlerevision (id) deferrable initially deferred;
+
+create table wiki_urlpath (
+  id serial primary key,
+  slug varchar(255) null,
+  lft integer not null check (lft >= 0),
+  rght integer not null check (rght >= 0),
+  tree_id integer not null check (tree_id >= 0),
+  level integer not null check (level >= 0),
+  article_id integer not null
+    references wiki_article (id) deferrable initially deferred,
+  parent_id integer null
+    references wiki_urlpath (id) deferrable initially deferred,
+  site_id integer not null
+    references django_site (id) deferrable initially deferred,
+  moved_to_id integer null
+    references wiki_urlpath (id) deferrable initially deferred,
+  unique (site_id, parent_id, slug)
+);
+
+create index wiki_urlpath_slug on wiki_urlpath (slug);
+create index wiki_urlpath_tree_id on wiki_urlpath (tree_id);
+create index wiki_urlpath_parent_id on wiki_urlpath (parent_id);
+
+insert into django_site (id, domain, name) values (1, 'example.com', 'example.com');
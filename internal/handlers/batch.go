@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"coco-life.de/wapi/internal/models"
+	"coco-life.de/wapi/internal/store"
+	"coco-life.de/wapi/internal/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// maxBatchOperations limits the number of operations of a single batch.
+const maxBatchOperations = 1000
+
+// batchOperation is an operation of POST /articles/batch. Articles are given by their
+// ID or, if they are created within the same batch, by the 'ref' of the creating
+// operation.
+type batchOperation struct {
+	// Op is one of "create", "update", "move" or "delete".
+	Op string `json:"op"`
+	// Ref names the article created by this operation.
+	Ref string `json:"ref"`
+	// ID or IDRef is the article to update, move or delete.
+	ID    int    `json:"id"`
+	IDRef string `json:"id_ref"`
+	// ParentArtID or ParentRef is the parent of a created or moved article.
+	ParentArtID int    `json:"parent_art_id"`
+	ParentRef   string `json:"parent_ref"`
+
+	Slug        string                  `json:"slug"`
+	Title       string                  `json:"title"`
+	Content     string                  `json:"content"`
+	Permissions models.PermissionsInput `json:"permissions"`
+	UserMessage string                  `json:"user_message"`
+	// Position is the index among the new siblings of a moved article.
+	Position *int `json:"position"`
+	// relocateOptions are the options of a move, see MoveArticle.
+	relocateOptions
+	// Purge removes a deleted article from the database, see DeleteArticle.
+	Purge bool `json:"purge"`
+	// IfMatch is the ETag the article needs to have, see checkIfMatch.
+	IfMatch string `json:"if_match"`
+}
+
+// batchPayload is the payload of POST /articles/batch.
+type batchPayload struct {
+	Operations []batchOperation `json:"operations"`
+}
+
+// batchResult is the outcome of a single operation.
+type batchResult struct {
+	Index int    `json:"index"`
+	Op    string `json:"op"`
+	Ref   string `json:"ref,omitempty"`
+	// ID is wiki_article-id of the affected article.
+	ID int `json:"id"`
+	// Status is the HTTP status the single request would have returned.
+	Status int `json:"status"`
+	// Result is one of "created", "updated", "unchanged", "moved", "deleted" or
+	// "purged".
+	Result string `json:"result"`
+	// RevisionID is the current revision after the operation, 0 if purged.
+	RevisionID int `json:"revision_id,omitempty"`
+}
+
+// batchError is a failed operation. It rolls back the whole batch.
+type batchError struct {
+	Index int
+	Err   error
+}
+
+func (e *batchError) Error() string {
+	return fmt.Sprintf("operation %v: %v", e.Index, e.Err)
+}
+
+func (e *batchError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorDetails adds the index of the operation to the response, see utils.HandleErr.
+func (e *batchError) ErrorDetails() gin.H {
+	return gin.H{"index": e.Index}
+}
+
+// BatchArticles executes the list 'operations' in order within a single transaction.
+// Either all operations succeed or none has any effect. Every operation behaves like
+// the corresponding single request, see batchOperation. The response lists the result
+// of every operation; if one fails, the response has its status and 'index'.
+func BatchArticles(c *gin.Context) {
+	var batchIn batchPayload
+	err := c.ShouldBindJSON(&batchIn)
+	if notOK := utils.HandleErr(c, &err, "BatchArticles: Failed to bind 'batchPayload': %v\n"); notOK {
+		return
+	}
+	if len(batchIn.Operations) > maxBatchOperations {
+		err = fmt.Errorf("A batch must not contain more than %v operations", maxBatchOperations)
+		utils.HandleErr(c, &err, "BatchArticles: %v\n")
+		return
+	}
+
+	ctx := c.Request.Context()
+	var results []batchResult
+	err = articles.InTx(ctx, func(tx store.ArticleStore) error {
+		refs := map[string]int{}
+		results = make([]batchResult, 0, len(batchIn.Operations))
+		for i := range batchIn.Operations {
+			res, err := runBatchOperation(c, tx, &batchIn.Operations[i], refs)
+			if err != nil {
+				return &batchError{Index: i, Err: err}
+			}
+			res.Index = i
+			results = append(results, res)
+		}
+		return nil
+	})
+	if notOK := utils.HandleErr(c, &err, "BatchArticles: %v\n"); notOK {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+// runBatchOperation executes op and records the ID of a created article in refs.
+func runBatchOperation(c *gin.Context, tx store.ArticleStore, op *batchOperation, refs map[string]int) (batchResult, error) {
+	ctx := c.Request.Context()
+	res := batchResult{Op: op.Op, Ref: op.Ref, Status: http.StatusOK}
+	if op.Ref != "" && op.Op != "create" {
+		return res, fmt.Errorf("'ref' names a created article, use 'id_ref' to refer to it")
+	}
+
+	var cur *models.Article
+	if op.Op != "create" {
+		artID, err := resolveRef(op.ID, op.IDRef, refs, "id", "id_ref")
+		if err != nil {
+			return res, err
+		}
+		if cur, err = tx.SelectArticleByID(ctx, artID); err != nil {
+			return res, fmt.Errorf("Failed to READ the article: %w", err)
+		}
+		res.ID = cur.ID
+	}
+
+	var err error
+	switch op.Op {
+	case "create":
+		if _, exists := refs[op.Ref]; exists && op.Ref != "" {
+			return res, fmt.Errorf("'ref' '%v' is used twice", op.Ref)
+		}
+		parentID, err := resolveRef(op.ParentArtID, op.ParentRef, refs, "parent_art_id", "parent_ref")
+		if err != nil {
+			return res, err
+		}
+		meta := revisionMeta(c, nil, op.UserMessage, "Created via API")
+		artIn := models.Article{
+			ArticleBase: models.ArticleBase{Title: op.Title, Content: op.Content, ParentArtID: parentID},
+			Slug:        op.Slug,
+		}
+		if parentID == 0 {
+			res.ID, err = insertRoot(ctx, tx, identity(c), &artIn.ArticleBase, op.Permissions, meta)
+		} else {
+			res.ID, err = insertChild(ctx, tx, identity(c), &artIn, op.Permissions, meta)
+		}
+		if err != nil {
+			return res, err
+		}
+		if op.Ref != "" {
+			refs[op.Ref] = res.ID
+		}
+		res.Status, res.Result = http.StatusCreated, "created"
+
+	case "update":
+		artIn := models.ArticleBase{Title: op.Title, Content: op.Content}
+		var changed bool
+		changed, err = updateArticle(c, tx, cur, &artIn, &writePayload{Permissions: op.Permissions, UserMessage: op.UserMessage}, op.IfMatch)
+		res.Result = "unchanged"
+		if changed {
+			res.Result = "updated"
+		}
+
+	case "move":
+		parentID, err := resolveRef(op.ParentArtID, op.ParentRef, refs, "parent_art_id", "parent_ref")
+		if err != nil {
+			return res, err
+		}
+		pos := -1
+		if op.Position != nil {
+			pos = *op.Position
+		}
+		if err := moveArticle(c, tx, cur, parentID, pos, op.relocateOptions, op.UserMessage, op.IfMatch); err != nil {
+			return res, err
+		}
+		res.Result = "moved"
+
+	case "delete":
+		err = deleteArticle(c, tx, cur, op.Purge, op.UserMessage, op.IfMatch)
+		res.Result = "deleted"
+		if cur.Deleted {
+			res.Result = "unchanged"
+		}
+		if op.Purge {
+			res.Status, res.Result = http.StatusNoContent, "purged"
+			return res, err
+		}
+
+	default:
+		return res, fmt.Errorf("Invalid op '%v', expected one of create, update, move, delete", op.Op)
+	}
+	if err != nil {
+		return res, err
+	}
+
+	out, err := tx.SelectArticleByID(ctx, res.ID)
+	if err != nil {
+		return res, fmt.Errorf("Failed to query database table wiki_article: %w", err)
+	}
+	res.RevisionID = out.RevisionID
+	return res, nil
+}
+
+// resolveRef returns id or, if ref is set, the ID of the article created by the
+// operation named ref.
+func resolveRef(id int, ref string, refs map[string]int, idField string, refField string) (int, error) {
+	if ref == "" {
+		return id, nil
+	}
+	if id != 0 {
+		return -1, fmt.Errorf("Either '%v' or '%v' may be set, not both", idField, refField)
+	}
+	refID, ok := refs[ref]
+	if !ok {
+		return -1, fmt.Errorf("Unknown ref '%v', it has to be created by a previous operation", ref)
+	}
+	return refID, nil
+}
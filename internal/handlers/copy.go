@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"coco-life.de/wapi/internal/auth"
+	"coco-life.de/wapi/internal/models"
+	"coco-life.de/wapi/internal/store"
+	"coco-life.de/wapi/internal/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// copyPayload is the payload of POST /articles/:id/copy.
+type copyPayload struct {
+	ParentArtID int `json:"parent_art_id" binding:"required"`
+	// Slug is the slug of the copy, "" keeps the slug of the article.
+	Slug string `json:"slug"`
+	// History copies all revisions instead of the current ones, see
+	// models.CopyOptions.
+	History     bool   `json:"history"`
+	UserMessage string `json:"user_message"`
+}
+
+// CopyArticle copies an article given by its ID with all its descendants below
+// 'parent_art_id', e.g. to set up a new project from a template section. The copies
+// keep slugs, group and permission flags with the caller as owner. By default, the
+// current revision of every article becomes the first revision of its copy, 'history'
+// copies all revisions. Like an import, the number of statements does not grow with
+// the number of articles, see db.CopyWikiArticles. The caller needs read permission
+// for the whole subtree and write permission for the parent. The response lists the
+// copies with every parent preceding its descendants.
+func CopyArticle(c *gin.Context) {
+	articleID, err := strconv.Atoi(c.Param("id"))
+	if notOK := utils.HandleErr(c, &err, "Article ID needs to be an integer: %v\n"); notOK {
+		return
+	}
+	var copyIn copyPayload
+	err = c.ShouldBindJSON(&copyIn)
+	if notOK := utils.HandleErr(c, &err, "CopyArticle: Failed to bind 'copyPayload': %v\n"); notOK {
+		return
+	}
+	if copyIn.Slug != "" {
+		copyIn.Slug, err = cleanSlug(copyIn.Slug)
+		if notOK := utils.HandleErr(c, &err, "CopyArticle: %v\n"); notOK {
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	id := identity(c)
+	var out []importedArticle
+	err = articles.InTx(ctx, func(tx store.ArticleStore) error {
+		cur, err := tx.SelectArticleHeader(ctx, articleID)
+		if err != nil {
+			return fmt.Errorf("Failed to READ the article: %w", err)
+		}
+		if cur.Level == 0 {
+			return fmt.Errorf("%w: the root article cannot be copied", store.ErrConstraint)
+		}
+		if err := checkRead(c, &cur.ArticleBase); err != nil {
+			return err
+		}
+		n := (cur.Right - cur.Left + 1) / 2
+		if n > maxImportArticles {
+			return fmt.Errorf("A copy must not contain more than %v articles", maxImportArticles)
+		}
+		descendants, err := tx.SelectArticles(ctx, models.ArticleQuery{Left: cur.Left, Right: cur.Right, PathID: cur.PathID,
+			Reader: models.ReadFilter{All: true}, SortBy: "id", Limit: n})
+		if err != nil {
+			return fmt.Errorf("Failed to READ the descendants: %w", err)
+		}
+		readable, err := tx.SelectArticles(ctx, models.ArticleQuery{Left: cur.Left, Right: cur.Right, PathID: cur.PathID,
+			Reader: id.ReadFilter(), SortBy: "id", Limit: n})
+		if err != nil {
+			return fmt.Errorf("Failed to READ the descendants: %w", err)
+		}
+		if len(readable) != len(descendants) {
+			return fmt.Errorf("%w: no read permission for %v descendants of article %v",
+				auth.ErrForbidden, len(descendants)-len(readable), cur.ID)
+		}
+
+		parent, err := tx.SelectArticleByID(ctx, copyIn.ParentArtID)
+		if err != nil {
+			return fmt.Errorf("Failed to READ the parent article: %w", err)
+		}
+		if !id.CanWrite(*parent.Permissions) {
+			return fmt.Errorf("%w: no write permission for parent article %v", auth.ErrForbidden, parent.ID)
+		}
+		slug := copyIn.Slug
+		if slug == "" {
+			slug = strings.ToLower(cur.Slug)
+		}
+		taken, err := childSlugs(ctx, tx, parent, 0)
+		if err != nil {
+			return err
+		}
+		if err := checkSlugFree(slug, taken, parent.ID); err != nil {
+			return err
+		}
+		srcPath, err := tx.SelectURLPath(ctx, cur.ID)
+		if err != nil {
+			return fmt.Errorf("Failed to select the path of the article: %w", err)
+		}
+		prtPath, err := tx.SelectURLPath(ctx, parent.ID)
+		if err != nil {
+			return fmt.Errorf("Failed to select the path of the parent article: %w", err)
+		}
+
+		opts := models.CopyOptions{Slug: slug, OwnerID: id.UserID, History: copyIn.History,
+			Meta: revisionMeta(c, nil, copyIn.UserMessage, fmt.Sprintf("Copied from /%v", srcPath))}
+		hdrIDs, err := tx.CopyWikiArticles(ctx, cur.ID, parent.ID, opts)
+		if err != nil {
+			return fmt.Errorf("Failed to copy the articles: %w", err)
+		}
+
+		// The IDs are in the pre-order of the nested set.
+		sort.Slice(descendants, func(i, j int) bool { return descendants[i].Left < descendants[j].Left })
+		newPath := prtPath + slug + "/"
+		out = make([]importedArticle, 0, len(hdrIDs))
+		out = append(out, importedArticle{ID: hdrIDs[0], Path: newPath})
+		for i, d := range descendants {
+			out = append(out, importedArticle{ID: hdrIDs[i+1], Path: newPath + strings.TrimPrefix(d.Path, srcPath)})
+		}
+		return nil
+	})
+	if notOK := utils.HandleErr(c, &err, "CopyArticle: %v\n"); notOK {
+		return
+	}
+	c.Header("Location", baseURL+"articles/"+strconv.Itoa(out[0].ID))
+	c.JSON(http.StatusCreated, gin.H{"articles": out})
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strings"
+
+	"coco-life.de/wapi/internal/models"
+	"coco-life.de/wapi/internal/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// errPreconditionFailed is returned if the article does not match If-Match.
+var errPreconditionFailed = utils.NewStatusError(http.StatusPreconditionFailed, "precondition failed")
+
+// etag returns the entity tag of an article. Changes of title, content, deletion and
+// lock add a revision, so wiki_article-current_revision_id covers them. Permissions and
+// the position in the tree change without a revision, e.g. when an ancestor is moved,
+// so they are hashed into the tag as well.
+func etag(a *models.ArticleBase) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%v %v %v %v %v %v", a.ParentArtID, a.PathID, a.Left, a.Right, a.Deleted, a.Locked)
+	if a.Permissions != nil {
+		fmt.Fprintf(h, " %+v", *a.Permissions)
+	}
+	return fmt.Sprintf(`"%v-%x"`, a.RevisionID, h.Sum64())
+}
+
+// matchETag reports whether the value of an If-Match or If-None-Match header lists
+// tag or is "*". With weak set, weak entity tags ("W/...") match as well.
+func matchETag(header string, tag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == "*" || candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch returns errPreconditionFailed if header, the value of an If-Match
+// header, is set and does not match the current state of the article, that is, the
+// article has been changed since the caller read it.
+func checkIfMatch(header string, a *models.ArticleBase) error {
+	if header == "" || matchETag(header, etag(a), false) {
+		return nil
+	}
+	return fmt.Errorf("%w: article %v has ETag %v, not %v", errPreconditionFailed, a.ID, etag(a), header)
+}
+
+// notModified sets the ETag header of the response. If the request has an
+// If-None-Match header matching the article, it responds with 304 and returns true.
+// With weak set, the header is a weak ETag, for responses that are not the full
+// representation of the article, e.g. with 'fields'. If-None-Match compares weakly,
+// so a weak tag still matches the strong one of the same state.
+func notModified(c *gin.Context, a *models.ArticleBase, weak bool) bool {
+	tag := etag(a)
+	if weak {
+		c.Header("ETag", "W/"+tag)
+	} else {
+		c.Header("ETag", tag)
+	}
+	if header := c.GetHeader("If-None-Match"); header != "" && matchETag(header, tag, true) {
+		c.Status(http.StatusNotModified)
+		return true
+	}
+	return false
+}
@@ -2,79 +2,164 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 
-	"coco-life.de/wapi/internal/db"
+	"coco-life.de/wapi/internal/auth"
+	"coco-life.de/wapi/internal/markup"
 	"coco-life.de/wapi/internal/models"
+	"coco-life.de/wapi/internal/store"
 	"coco-life.de/wapi/internal/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
-	"github.com/jackc/pgx/v4/pgxpool"
 )
 
 var baseURL string
 
-// RetrieveRootArticle selects the root article from the database.
+// articles is the store shared by all handlers, see SetStore.
+var articles store.ArticleStore
+
+// trustedProxies are the proxies whose client IP headers are honoured, see
+// SetTrustedProxies.
+var trustedProxies []*net.IPNet
+
+// ready reports whether the server accepts new requests, see SetReadiness.
+var ready = func() bool { return true }
+
+// RetrieveRootArticle selects the root article from the database. Like all article
+// endpoints it responds with the rendered content if the caller accepts HTML, see
+// RetrieveArticleHTML.
 func RetrieveRootArticle(c *gin.Context) {
-	dbpool, err := pgxpool.Connect(context.Background(), "")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to connect to database: %v\n", err)
-		os.Exit(1)
+	opts, err := parseSparse(c, articleFields, articleIncludes)
+	if notOK := utils.HandleErr(c, &err, "RetrieveRootArticle: %v\n"); notOK {
+		return
+	}
+	c.Header("Vary", "Accept")
+	if opts.sparse() && !acceptsHTML(c) {
+		retrieveSparse(c, func(ctx context.Context) (int, error) {
+			return articles.SelectArticleIDByPath(ctx, "")
+		}, opts)
+		return
 	}
-	defer dbpool.Close()
 
-	article, err := db.SelectRootArticle(dbpool)
+	article, err := articles.SelectRootArticle(c.Request.Context())
 	if notOK := utils.HandleErr(c, &err, "Failed to query database table wiki_article: %v\n"); notOK {
 		return
 	}
+	err = checkRead(c, &article.ArticleBase)
+	if notOK := utils.HandleErr(c, &err, "RetrieveRootArticle: %v\n"); notOK {
+		return
+	}
+	if acceptsHTML(c) {
+		renderHTML(c, &article.ArticleBase)
+		return
+	}
 
+	if notModified(c, &article.ArticleBase, false) {
+		return
+	}
 	c.JSON(http.StatusOK, article)
 }
 
 // RetrieveArticleByID returns an article given by its ID.
 func RetrieveArticleByID(c *gin.Context) {
-	dbpool, err := pgxpool.Connect(context.Background(), "")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to connect to database: %v\n", err)
-		os.Exit(1)
-	}
-	defer dbpool.Close()
-
     articleID, err := strconv.Atoi(c.Param("id"))
 	if notOK := utils.HandleErr(c, &err, "Article ID needs to be an integer: %v\n"); notOK {
 		return
 	}
 
-	article, err := db.SelectArticleByID(dbpool, articleID)
+	opts, err := parseSparse(c, articleFields, articleIncludes)
+	if notOK := utils.HandleErr(c, &err, "RetrieveArticleByID: %v\n"); notOK {
+		return
+	}
+	c.Header("Vary", "Accept")
+	if opts.sparse() && !acceptsHTML(c) {
+		retrieveSparse(c, func(ctx context.Context) (int, error) { return articleID, nil }, opts)
+		return
+	}
+
+	article, err := articles.SelectArticleByID(c.Request.Context(), articleID)
 	if notOK := utils.HandleErr(c, &err, "Failed to query database table wiki_article: %v\n"); notOK {
 		return
 	}
+	err = checkRead(c, &article.ArticleBase)
+	if notOK := utils.HandleErr(c, &err, "RetrieveArticleByID: %v\n"); notOK {
+		return
+	}
+	if acceptsHTML(c) {
+		renderHTML(c, &article.ArticleBase)
+		return
+	}
 
+	if notModified(c, &article.ArticleBase, false) {
+		return
+	}
 	c.JSON(http.StatusOK, article)
 }
 
-// RetrieveArticleBySlug returns an article given by its slug.
-func RetrieveArticleBySlug(c *gin.Context) {
-	dbpool, err := pgxpool.Connect(context.Background(), "")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to connect to database: %v\n", err)
-		os.Exit(1)
+// RetrieveArticleByPath returns an article given by its URL path, e.g.
+// /articles/by-path/foo/bar. Redirects left by moves are followed unless
+// '?follow_redirects=false', see followRedirects.
+func RetrieveArticleByPath(c *gin.Context) {
+	opts, err := parseSparse(c, articleFields, articleIncludes)
+	if notOK := utils.HandleErr(c, &err, "RetrieveArticleByPath: %v\n"); notOK {
+		return
+	}
+	follow := true
+	if v := c.Query("follow_redirects"); v != "" {
+		follow, err = strconv.ParseBool(v)
+		if notOK := utils.HandleErr(c, &err, "RetrieveArticleByPath: Invalid 'follow_redirects': %v\n"); notOK {
+			return
+		}
+	}
+	resolve := func(ctx context.Context) (int, error) {
+		hdrID, err := articles.SelectArticleIDByPath(ctx, c.Param("path"))
+		if err != nil || !follow {
+			return hdrID, err
+		}
+		return followRedirects(c, ctx, hdrID)
+	}
+	c.Header("Vary", "Accept")
+	if opts.sparse() && !acceptsHTML(c) {
+		retrieveSparse(c, resolve, opts)
+		return
 	}
-	defer dbpool.Close()
 
-	article, err := db.SelectArticleBySlug(dbpool, c.Param("slug"))
+	ctx := c.Request.Context()
+	hdrID, err := resolve(ctx)
+	if notOK := utils.HandleErr(c, &err, "Failed to query database table wiki_urlpath: %v\n"); notOK {
+		return
+	}
+	article, err := articles.SelectArticleByID(ctx, hdrID)
 	if notOK := utils.HandleErr(c, &err, "Failed to query database table wiki_article: %v\n"); notOK {
 		return
 	}
+	err = checkRead(c, &article.ArticleBase)
+	if notOK := utils.HandleErr(c, &err, "RetrieveArticleByPath: %v\n"); notOK {
+		return
+	}
+	if acceptsHTML(c) {
+		renderHTML(c, &article.ArticleBase)
+		return
+	}
 
+	if notModified(c, &article.ArticleBase, false) {
+		return
+	}
 	c.JSON(http.StatusOK, article)
 }
 
-// InsertArticle creates/overwrites an article. All the article data needs to be passed
-// as POST data.
+// InsertArticle creates an article. All the article data needs to be passed as POST
+// data. The optional object 'permissions' sets owner, group and the flags, see
+// models.PermissionsInput. By default, the caller owns the new article and it
+// inherits group and flags of its parent like in django-wiki. The optional
+// 'user_message' is stored with the first revision. With '?mode=upsert' an existing
+// article is updated instead, see upsertArticle.
 func InsertArticle(c *gin.Context) {
 	var artIn models.ArticleBase
 	if err := c.ShouldBindBodyWith(&artIn, binding.JSON); err != nil {
@@ -82,6 +167,23 @@ func InsertArticle(c *gin.Context) {
 			return
 		}
 	}
+	var extra writePayload
+	if err := c.ShouldBindBodyWith(&extra, binding.JSON); err != nil {
+		if notOK := utils.HandleErr(c, &err, "InsertArticle: Failed to bind 'PermissionsInput': %v\n"); notOK {
+			return
+		}
+	}
+	switch mode := c.Query("mode"); mode {
+	case "", "create":
+	case "upsert":
+		upsertArticle(c, &extra)
+		return
+	default:
+		err := fmt.Errorf("Invalid mode '%v', expected 'create' or 'upsert'", mode)
+		utils.HandleErr(c, &err, "InsertArticle: %v\n")
+		return
+	}
+	meta := revisionMeta(c, nil, extra.UserMessage, "Created via API")
 
 	// If the article has an initial ParentID, it is the root article.
 	if artIn.ParentArtID == 0 {
@@ -91,7 +193,7 @@ func InsertArticle(c *gin.Context) {
 				return
 			}
 		}
-		addRootArticle(c, &root)
+		addRootArticle(c, &root, extra.Permissions, meta)
 		return
 	}
 
@@ -101,159 +203,688 @@ func InsertArticle(c *gin.Context) {
 			return
 		}
 	}
-	addChildArticle(c, &child)
+	addChildArticle(c, &child, extra.Permissions, meta)
 }
 
 // addChildArticle add/sets a child article.
-func addChildArticle(c *gin.Context, child *models.Article) {
-	dbpool, err := pgxpool.Connect(context.Background(), "")
-	if notOK := utils.HandleErr(c, &err, "addChildArticle: Unable to connect to database: %v\n"); notOK {
+func addChildArticle(c *gin.Context, child *models.Article, permsIn models.PermissionsInput, meta models.RevisionMeta) {
+	ctx := c.Request.Context()
+	var newArtID int
+	err := articles.InTx(ctx, func(tx store.ArticleStore) error {
+		var err error
+		newArtID, err = insertChild(ctx, tx, identity(c), child, permsIn, meta)
+		return err
+	})
+	if notOK := utils.HandleErr(c, &err, "addChildArticle: %v\n"); notOK {
 		return
 	}
-	defer dbpool.Close()
 
-	tx, err := dbpool.Begin(context.Background())
-	if notOK := utils.HandleErr(c, &err, "addChildArticle: Failed to create transaction: %v\n"); notOK {
-		tx.Rollback(context.Background())
+	articleOut, err := articles.SelectArticleByID(ctx, newArtID)
+	if notOK := utils.HandleErr(c, &err, "addChildArticle: Failed to query database table wiki_article: %v\n"); notOK {
 		return
 	}
+	c.Header("Location", buildResourceURL(baseURL, articleOut))
+	c.Header("ETag", etag(&articleOut.ArticleBase))
+	c.JSON(http.StatusCreated, articleOut)
+}
+
+// insertChild inserts a child article below child.ParentArtID and returns its ID.
+func insertChild(ctx context.Context, tx store.ArticleStore, id *auth.Identity, child *models.Article, permsIn models.PermissionsInput, meta models.RevisionMeta) (int, error) {
+	parent, err := tx.SelectArticleByID(ctx, child.ParentArtID)
+	if err != nil {
+		return -1, fmt.Errorf("Failed to READ the parent article: %w", err)
+	}
+	if !id.CanWrite(*parent.Permissions) {
+		return -1, fmt.Errorf("%w: no write permission for parent article %v", auth.ErrForbidden, parent.ID)
+	}
+	slug, err := cleanSlug(child.Slug)
+	if err != nil {
+		return -1, err
+	}
+	taken, err := childSlugs(ctx, tx, parent, 0)
+	if err != nil {
+		return -1, err
+	}
+	if err := checkSlugFree(slug, taken, parent.ID); err != nil {
+		return -1, err
+	}
+	inherited := *parent.Permissions
+	inherited.OwnerID = id.UserID
+	perms := permsIn.Apply(inherited)
+	if err := id.CheckPermissionsChange(inherited, perms); err != nil {
+		return -1, err
+	}
+	newArtID, _, err := insertNode(ctx, tx, parent, slug, child.Title, child.Content, perms, meta)
+	return newArtID, err
+}
+
+// insertNode inserts an article with its first revision as the last child of parent
+// without any checks. It returns wiki_article-id and wiki_urlpath-id.
+func insertNode(ctx context.Context, tx store.ArticleStore, parent *models.Article, slug string, title string, content string, perms models.Permissions, meta models.RevisionMeta) (int, int, error) {
+	newArtID, err := tx.InsertWikiArticle(ctx, perms)
+	if err != nil {
+		return -1, -1, fmt.Errorf("Failed to INSERT into wiki_article: %w", err)
+	}
+
+	revID, err := tx.InsertWikiArticleRevision(ctx, newArtID, title, content, meta)
+	if err != nil {
+		return -1, -1, fmt.Errorf("Failed to INSERT into wiki_articlerevision: %w", err)
+	}
+
+	err = tx.SetWikiArticleRevision(ctx, newArtID, revID)
+	if err != nil {
+		return -1, -1, fmt.Errorf("Failed to set article revision ID in wiki_articlerevision: %w", err)
+	}
+
+	// Calculate 'left', 'right' and 'level' for the child article using the MPTT
+	// algorithm.
+	lvl, left, right := store.MPTTCalcForIns(parent.Level, parent.Right)
+	pathID, err := tx.InsertWikiURLPathChild(ctx, slug, newArtID, lvl, left, right, parent.PathID)
+	if err != nil {
+		return -1, -1, fmt.Errorf("Failed to INSERT into wiki_urlpath: %w", err)
+	}
+	// Update all other articles according to the MPTT algorithm.
+	err = tx.MPTTUpdWikiURLPathForInsert(ctx, pathID, left)
+	if err != nil {
+		return -1, -1, fmt.Errorf("Failed to update wiki_urlpath for INSERT according to MPTT: %w", err)
+	}
+	return newArtID, pathID, nil
+}
 
-	newArtID, err := db.InsertWikiArticle(dbpool)
-	if notOK := utils.HandleErr(c, &err, "addChildArticle: Failed to INSERT into wiki_article: %v\n"); notOK {
-		tx.Rollback(context.Background())
+// addRootArticle adds/sets the root article.
+func addRootArticle(c *gin.Context, root *models.RootArticle, permsIn models.PermissionsInput, meta models.RevisionMeta) {
+	ctx := c.Request.Context()
+	err := articles.InTx(ctx, func(tx store.ArticleStore) error {
+		_, err := insertRoot(ctx, tx, identity(c), &root.ArticleBase, permsIn, meta)
+		return err
+	})
+	if notOK := utils.HandleErr(c, &err, "addRootArticle: %v\n"); notOK {
 		return
 	}
 
-	revID, err := db.InsertWikiArticleRevision(dbpool, newArtID, child.Title, child.Content)
-	if notOK := utils.HandleErr(c, &err, "addChildArticle: Failed to INSERT into wiki_articlerevision: %v\n"); notOK {
-		tx.Rollback(context.Background())
+	articleOut, err := articles.SelectRootArticle(ctx)
+	if notOK := utils.HandleErr(c, &err, "addRootArticle: Failed to query database table wiki_article: %v\n"); notOK {
 		return
 	}
+	c.Header("Location", buildResourceURL(baseURL, articleOut))
+	c.Header("ETag", etag(&articleOut.ArticleBase))
+	c.JSON(http.StatusCreated, articleOut)
+}
+
+// insertRoot inserts the root article and returns its ID.
+func insertRoot(ctx context.Context, tx store.ArticleStore, id *auth.Identity, root *models.ArticleBase, permsIn models.PermissionsInput, meta models.RevisionMeta) (int, error) {
+	defaults := models.DefaultPermissions
+	defaults.OwnerID = id.UserID
+	perms := permsIn.Apply(defaults)
+	if err := id.CheckPermissionsChange(defaults, perms); err != nil {
+		return -1, err
+	}
+
+	hdrID, err := tx.InsertWikiArticle(ctx, perms)
+	if err != nil {
+		return -1, fmt.Errorf("Failed to INSERT into wiki_article: %w", err)
+	}
+
+	revID, err := tx.InsertWikiArticleRevision(ctx, hdrID, root.Title, root.Content, meta)
+	if err != nil {
+		return -1, fmt.Errorf("Failed to INSERT into wiki_articlerevision: %w", err)
+	}
 
-	parent, err := db.SelectArticleByID(dbpool, child.ParentArtID)
-	if notOK := utils.HandleErr(c, &err, "addChildArticle: Failed to READ the parent article: %v\n"); notOK {
-		tx.Rollback(context.Background())
+	err = tx.InsertWikiURLPathRoot(ctx, hdrID)
+	if err != nil {
+		return -1, fmt.Errorf("Failed to INSERT into wiki_urlpath: %w", err)
+	}
+
+	err = tx.SetWikiArticleRevision(ctx, hdrID, revID)
+	if err != nil {
+		return -1, fmt.Errorf("Failed to set article revision ID in wiki_articlerevision: %w", err)
+	}
+	return hdrID, nil
+}
+
+// Results of an upsert.
+const (
+	upsertCreated   = "created"
+	upsertUpdated   = "updated"
+	upsertUnchanged = "unchanged"
+)
+
+// upsertResult is the response of POST /articles?mode=upsert.
+type upsertResult struct {
+	// Result is one of "created", "updated" or "unchanged".
+	Result  string          `json:"result"`
+	Article *models.Article `json:"article"`
+}
+
+// upsertPayload identifies the article of an upsert by its URL path instead of
+// 'parent_art_id' and 'slug'.
+type upsertPayload struct {
+	Path *string `json:"path"`
+}
+
+// upsertArticle creates or updates the article identified by 'path', e.g. "foo/bar",
+// or by 'parent_art_id' and 'slug'. Without both, it is the root article. An existing
+// article is updated like UpdateArticle, that is, a new revision is only added if
+// title or content change. The parent has to exist. The response says whether the
+// article has been created, updated or left unchanged.
+func upsertArticle(c *gin.Context, extra *writePayload) {
+	var artIn models.Article
+	err := c.ShouldBindBodyWith(&artIn, binding.JSON)
+	if notOK := utils.HandleErr(c, &err, "upsertArticle: Failed to bind 'Article': %v\n"); notOK {
+		return
+	}
+	var pathIn upsertPayload
+	err = c.ShouldBindBodyWith(&pathIn, binding.JSON)
+	if notOK := utils.HandleErr(c, &err, "upsertArticle: Failed to bind 'path': %v\n"); notOK {
 		return
 	}
 
-	err = db.SetWikiArticleRevision(dbpool, newArtID, revID)
-	if notOK := utils.HandleErr(c,
-                                &err,
-                                "addChildArticle: Failed to set article revision ID in wiki_articlerevision: %v\n");
-    notOK {
-		tx.Rollback(context.Background())
+	ctx := c.Request.Context()
+	id := identity(c)
+	var artID int
+	var result string
+	err = articles.InTx(ctx, func(tx store.ArticleStore) error {
+		if pathIn.Path != nil {
+			if artIn.ParentArtID != 0 || artIn.Slug != "" {
+				return fmt.Errorf("Either 'path' or 'parent_art_id' and 'slug' identify the article, not both")
+			}
+			slugs := store.SplitPath(*pathIn.Path)
+			if len(slugs) > 0 {
+				parent, err := tx.SelectArticleByPath(ctx, strings.Join(slugs[:len(slugs)-1], "/"))
+				if err != nil {
+					return fmt.Errorf("Failed to READ the parent article: %w", err)
+				}
+				artIn.ParentArtID = parent.ID
+				artIn.Slug = slugs[len(slugs)-1]
+			}
+		}
+		// The slug is looked up below the parent, so "" would select the parent itself
+		// and "x/y" a grandchild.
+		if artIn.ParentArtID != 0 {
+			slug, err := cleanSlug(artIn.Slug)
+			if err != nil {
+				return err
+			}
+			artIn.Slug = slug
+		} else if artIn.Slug != "" {
+			return fmt.Errorf("'slug' requires 'parent_art_id'")
+		}
+
+		var cur *models.Article
+		var err error
+		if artIn.ParentArtID == 0 {
+			var root *models.RootArticle
+			if root, err = tx.SelectRootArticle(ctx); err == nil {
+				cur, err = tx.SelectArticleByID(ctx, root.ID)
+			}
+		} else {
+			var parentPath string
+			if parentPath, err = tx.SelectURLPath(ctx, artIn.ParentArtID); err != nil {
+				return fmt.Errorf("Failed to READ the parent article: %w", err)
+			}
+			cur, err = tx.SelectArticleByPath(ctx, parentPath+artIn.Slug)
+		}
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return fmt.Errorf("Failed to READ the article: %w", err)
+		}
+
+		if cur == nil {
+			result = upsertCreated
+			meta := revisionMeta(c, nil, extra.UserMessage, "Created via API")
+			if artIn.ParentArtID == 0 {
+				artID, err = insertRoot(ctx, tx, id, &artIn.ArticleBase, extra.Permissions, meta)
+			} else {
+				artID, err = insertChild(ctx, tx, id, &artIn, extra.Permissions, meta)
+			}
+			return err
+		}
+		artID = cur.ID
+		result = upsertUnchanged
+		changed, err := updateArticle(c, tx, cur, &artIn.ArticleBase, extra, c.GetHeader("If-Match"))
+		if changed {
+			result = upsertUpdated
+		}
+		return err
+	})
+	if notOK := utils.HandleErr(c, &err, "upsertArticle: %v\n"); notOK {
 		return
 	}
 
-	// Calculate 'left', 'right' and 'level' for the child article using the MPTT
-	// algorithm.
-	lvl, left, right := db.MPTTCalcForIns(parent.Level, parent.Right)
-    pathID, err := db.InsertWikiURLPathChild(dbpool, child.Slug, newArtID, lvl, left, right, parent.PathID)
-	if notOK := utils.HandleErr(c, &err, "addChildArticle: Failed to INSERT into wiki_urlpath: %v\n"); notOK {
-		tx.Rollback(context.Background())
+	articleOut, err := articles.SelectArticleByID(ctx, artID)
+	if notOK := utils.HandleErr(c, &err, "upsertArticle: Failed to query database table wiki_article: %v\n"); notOK {
 		return
 	}
-	// Update all other articles according to the MPTT algorithm.
-	err = db.MPTTUpdWikiURLPathForInsert(dbpool, pathID, left)
-	if notOK := utils.HandleErr(c, &err, "addChildArticle: Failed to update wiki_urlpath for INSERT according to MPTT: %v\n"); notOK {
-		tx.Rollback(context.Background())
+	c.Header("ETag", etag(&articleOut.ArticleBase))
+	code := http.StatusOK
+	if result == upsertCreated {
+		code = http.StatusCreated
+		c.Header("Location", buildResourceURL(baseURL, articleOut))
+	}
+	c.JSON(code, upsertResult{Result: result, Article: articleOut})
+}
+
+// UpdateArticle updates title, content and permissions of an article given by its ID.
+// A new revision is only created if title or content change or if the article is
+// deleted, which restores it. Omitted permission fields of the object 'permissions'
+// are kept, see models.PermissionsInput. The optional 'user_message' is stored with
+// the new revision.
+func UpdateArticle(c *gin.Context) {
+	articleID, err := strconv.Atoi(c.Param("id"))
+	if notOK := utils.HandleErr(c, &err, "Article ID needs to be an integer: %v\n"); notOK {
+		return
+	}
+	var artIn models.ArticleBase
+	err = c.ShouldBindBodyWith(&artIn, binding.JSON)
+	if notOK := utils.HandleErr(c, &err, "UpdateArticle: Failed to bind 'ArticleBase': %v\n"); notOK {
+		return
+	}
+	var extra writePayload
+	err = c.ShouldBindBodyWith(&extra, binding.JSON)
+	if notOK := utils.HandleErr(c, &err, "UpdateArticle: Failed to bind 'PermissionsInput': %v\n"); notOK {
 		return
 	}
 
-	err = tx.Commit(context.Background())
-	if notOK := utils.HandleErr(c, &err, "addChildArticle: Failed to commit transaction to insert new article: %v\n"); notOK {
-		tx.Rollback(context.Background())
+	ctx := c.Request.Context()
+	err = articles.InTx(ctx, func(tx store.ArticleStore) error {
+		cur, err := tx.SelectArticleByID(ctx, articleID)
+		if err != nil {
+			return fmt.Errorf("Failed to READ the article: %w", err)
+		}
+		_, err = updateArticle(c, tx, cur, &artIn, &extra, c.GetHeader("If-Match"))
+		return err
+	})
+	if notOK := utils.HandleErr(c, &err, "UpdateArticle: %v\n"); notOK {
 		return
 	}
 
-	articleOut, err := db.SelectArticleByID(dbpool, newArtID)
-	if notOK := utils.HandleErr(c, &err, "addChildArticle: Failed to query database table wiki_article: %v\n"); notOK {
+	articleOut, err := articles.SelectArticleByID(ctx, articleID)
+	if notOK := utils.HandleErr(c, &err, "UpdateArticle: Failed to query database table wiki_article: %v\n"); notOK {
 		return
 	}
-	c.JSON(http.StatusCreated, articleOut)
-	c.Header("Location", buildResourceURL(baseURL, articleOut))
+	c.Header("ETag", etag(&articleOut.ArticleBase))
+	c.JSON(http.StatusOK, articleOut)
 }
 
-// addRootArticle adds/sets the root article.
-func addRootArticle(c *gin.Context, root *models.RootArticle) {
-	dbpool, err := pgxpool.Connect(context.Background(), "")
-	if notOK := utils.HandleErr(c, &err, "addRootArticle: Unable to connect to database: %v\n"); notOK {
+// updateArticle sets title, content and permissions of the article cur and reports
+// whether anything changed, see UpdateArticle.
+func updateArticle(c *gin.Context, tx store.ArticleStore, cur *models.Article, artIn *models.ArticleBase, extra *writePayload, ifMatch string) (bool, error) {
+	ctx := c.Request.Context()
+	id := identity(c)
+	if err := checkWrite(id, cur); err != nil {
+		return false, err
+	}
+	if err := checkIfMatch(ifMatch, &cur.ArticleBase); err != nil {
+		return false, err
+	}
+
+	changed := false
+	perms := extra.Permissions.Apply(*cur.Permissions)
+	if perms != *cur.Permissions {
+		if err := id.CheckPermissionsChange(*cur.Permissions, perms); err != nil {
+			return false, err
+		}
+		if err := tx.UpdateWikiArticlePermissions(ctx, cur.ID, perms); err != nil {
+			return false, fmt.Errorf("Failed to update permissions in wiki_article: %w", err)
+		}
+		changed = true
+	}
+	if artIn.Title != cur.Title || artIn.Content != cur.Content || cur.Deleted {
+		log := "Updated via API"
+		if cur.Deleted {
+			log = "Restored via API"
+		}
+		meta := revisionMeta(c, cur, extra.UserMessage, log)
+		meta.Deleted = false
+		if _, err := tx.AddWikiArticleRevision(ctx, cur.ID, artIn.Title, artIn.Content, meta); err != nil {
+			return false, fmt.Errorf("Failed to INSERT into wiki_articlerevision: %w", err)
+		}
+		changed = true
+	}
+	return changed, nil
+}
+
+// MoveArticle moves an article given by its ID with all its descendants below the
+// article 'parent_art_id' as its 'position'-th child (starting at 0, default: last
+// child). The move is recorded as a new revision of the moved article. With
+// 'rewrite_links', the links of other articles to the subtree are updated, with
+// 'redirect' the old URL paths redirect to the new ones.
+func MoveArticle(c *gin.Context) {
+	articleID, err := strconv.Atoi(c.Param("id"))
+	if notOK := utils.HandleErr(c, &err, "Article ID needs to be an integer: %v\n"); notOK {
+		return
+	}
+	var moveIn movePayload
+	err = c.ShouldBindJSON(&moveIn)
+	if notOK := utils.HandleErr(c, &err, "MoveArticle: Failed to bind 'movePayload': %v\n"); notOK {
 		return
 	}
-	defer dbpool.Close()
+	pos := -1
+	if moveIn.Position != nil {
+		pos = *moveIn.Position
+	}
 
-	tx, err := dbpool.Begin(context.Background())
-	if notOK := utils.HandleErr(c, &err, "addRootArticle: Failed to create transaction: %v\n"); notOK {
-		tx.Rollback(context.Background())
+	ctx := c.Request.Context()
+	err = articles.InTx(ctx, func(tx store.ArticleStore) error {
+		cur, err := tx.SelectArticleByID(ctx, articleID)
+		if err != nil {
+			return fmt.Errorf("Failed to READ the article: %w", err)
+		}
+		return moveArticle(c, tx, cur, moveIn.ParentArtID, pos, moveIn.relocateOptions, moveIn.UserMessage, c.GetHeader("If-Match"))
+	})
+	if notOK := utils.HandleErr(c, &err, "MoveArticle: %v\n"); notOK {
 		return
 	}
 
-	hdrID, err := db.InsertWikiArticle(dbpool)
-	if notOK := utils.HandleErr(c, &err, "addRootArticle: Failed to INSERT into wiki_article: %v\n"); notOK {
-		tx.Rollback(context.Background())
+	articleOut, err := articles.SelectArticleByID(ctx, articleID)
+	if notOK := utils.HandleErr(c, &err, "MoveArticle: Failed to query database table wiki_article: %v\n"); notOK {
 		return
 	}
+	c.Header("ETag", etag(&articleOut.ArticleBase))
+	c.JSON(http.StatusOK, articleOut)
+}
+
+// moveArticle moves the article cur below parentArtID, see MoveArticle.
+func moveArticle(c *gin.Context, tx store.ArticleStore, cur *models.Article, parentArtID int, pos int, opts relocateOptions, userMessage string, ifMatch string) error {
+	ctx := c.Request.Context()
+	id := identity(c)
+	if cur.Level == 0 {
+		return fmt.Errorf("%w: the root article cannot be moved", store.ErrConstraint)
+	}
+	if err := checkWrite(id, cur); err != nil {
+		return err
+	}
+	if err := checkIfMatch(ifMatch, &cur.ArticleBase); err != nil {
+		return err
+	}
+	parent, err := tx.SelectArticleByID(ctx, parentArtID)
+	if err != nil {
+		return fmt.Errorf("Failed to READ the new parent article: %w", err)
+	}
+	if !id.CanWrite(*parent.Permissions) {
+		return fmt.Errorf("%w: no write permission for parent article %v", auth.ErrForbidden, parent.ID)
+	}
+
+	return relocate(c, tx, cur, opts, userMessage, func() error {
+		if err := tx.MoveWikiURLPath(ctx, cur.PathID, parent.PathID, pos); err != nil {
+			return fmt.Errorf("Failed to move wiki_urlpath: %w", err)
+		}
+		return nil
+	})
+}
+
+// relocateOptions are the options of moving and renaming articles.
+type relocateOptions struct {
+	// RewriteLinks updates the links to the subtree, see rewriteLinks.
+	RewriteLinks bool `json:"rewrite_links"`
+	// Redirect leaves redirects at the old URL paths, see createRedirects.
+	Redirect bool `json:"redirect"`
+}
+
+// relocate changes the URL path of the article cur and its descendants with change and
+// records the new path as a revision of cur, see relocateOptions.
+func relocate(c *gin.Context, tx store.ArticleStore, cur *models.Article, opts relocateOptions, userMessage string, change func() error) error {
+	ctx := c.Request.Context()
+	oldPath, err := tx.SelectURLPath(ctx, cur.ID)
+	if err != nil {
+		return fmt.Errorf("Failed to READ the URL path: %w", err)
+	}
+	var sources map[int]string
+	if opts.RewriteLinks {
+		if sources, err = linkSources(ctx, tx, cur); err != nil {
+			return err
+		}
+	}
+	if err := change(); err != nil {
+		return err
+	}
+	newPath, err := tx.SelectURLPath(ctx, cur.ID)
+	if err != nil {
+		return fmt.Errorf("Failed to READ the URL path: %w", err)
+	}
+	if newPath == oldPath {
+		// Reordering the children of a parent does not change the article.
+		return nil
+	}
+	// The descendants get no revision, but their relative links resolve differently.
+	if err := tx.DeleteArticleLinks(ctx, cur.ID); err != nil {
+		return fmt.Errorf("Failed to delete the indexed links: %w", err)
+	}
+	content := cur.Content
+	if opts.RewriteLinks {
+		content = markup.RewriteLinks(content, oldPath, newPath, pathRewriter(oldPath, newPath))
+	}
+	meta := revisionMeta(c, cur, userMessage, fmt.Sprintf("Moved from /%v to /%v", oldPath, newPath))
+	if _, err := tx.AddWikiArticleRevision(ctx, cur.ID, cur.Title, content, meta); err != nil {
+		return fmt.Errorf("Failed to INSERT into wiki_articlerevision: %w", err)
+	}
+	if opts.RewriteLinks {
+		if err := rewriteLinks(c, tx, sources, oldPath, newPath, userMessage); err != nil {
+			return err
+		}
+	}
+	if opts.Redirect {
+		return createRedirects(c, tx, cur, oldPath, newPath, userMessage)
+	}
+	return nil
+}
 
-	revID, err := db.InsertWikiArticleRevision(dbpool, hdrID, root.Title, root.Content)
-	if notOK := utils.HandleErr(c, &err, "addRootArticle: Failed to INSERT into wiki_articlerevision: %v\n"); notOK {
-		tx.Rollback(context.Background())
+// DeleteArticle marks an article given by its ID as deleted like django-wiki does, by
+// adding a deleted revision. PUT restores it. With '?purge=true' the article and all
+// its descendants are removed from the database instead, which requires the admin
+// scope. The root article cannot be deleted.
+func DeleteArticle(c *gin.Context) {
+	articleID, err := strconv.Atoi(c.Param("id"))
+	if notOK := utils.HandleErr(c, &err, "Article ID needs to be an integer: %v\n"); notOK {
 		return
 	}
+	purge := c.Query("purge") == "true"
+	var msgIn writePayload
+	if c.Request.ContentLength > 0 {
+		err = c.ShouldBindJSON(&msgIn)
+		if notOK := utils.HandleErr(c, &err, "DeleteArticle: Failed to bind 'user_message': %v\n"); notOK {
+			return
+		}
+	}
 
-	err = db.InsertWikiURLPathRoot(dbpool, hdrID)
-	if notOK := utils.HandleErr(c, &err, "addRootArticle: Failed to INSERT into wiki_urlpath: %v\n"); notOK {
-		tx.Rollback(context.Background())
+	ctx := c.Request.Context()
+	err = articles.InTx(ctx, func(tx store.ArticleStore) error {
+		cur, err := tx.SelectArticleByID(ctx, articleID)
+		if err != nil {
+			return fmt.Errorf("Failed to READ the article: %w", err)
+		}
+		return deleteArticle(c, tx, cur, purge, msgIn.UserMessage, c.GetHeader("If-Match"))
+	})
+	if notOK := utils.HandleErr(c, &err, "DeleteArticle: %v\n"); notOK {
 		return
 	}
 
-	db.SetWikiArticleRevision(dbpool, hdrID, revID)
-	if notOK := utils.HandleErr(c, &err, "addRootArticle: Failed to set article revision ID in wiki_articlerevision: %v\n"); notOK {
-		tx.Rollback(context.Background())
+	if purge {
+		c.Status(http.StatusNoContent)
 		return
 	}
+	articleOut, err := articles.SelectArticleByID(ctx, articleID)
+	if notOK := utils.HandleErr(c, &err, "DeleteArticle: Failed to query database table wiki_article: %v\n"); notOK {
+		return
+	}
+	c.Header("ETag", etag(&articleOut.ArticleBase))
+	c.JSON(http.StatusOK, articleOut)
+}
+
+// deleteArticle marks the article cur as deleted or purges it, see DeleteArticle.
+func deleteArticle(c *gin.Context, tx store.ArticleStore, cur *models.Article, purge bool, userMessage string, ifMatch string) error {
+	ctx := c.Request.Context()
+	id := identity(c)
+	if cur.Level == 0 {
+		return fmt.Errorf("%w: the root article cannot be deleted", store.ErrConstraint)
+	}
+	if err := checkWrite(id, cur); err != nil {
+		return err
+	}
+	if err := checkIfMatch(ifMatch, &cur.ArticleBase); err != nil {
+		return err
+	}
+
+	if purge {
+		if id.Scope < auth.ScopeAdmin {
+			return fmt.Errorf("%w: purging requires scope '%v'", auth.ErrForbidden, auth.ScopeAdmin)
+		}
+		if err := tx.DeleteWikiURLPath(ctx, cur.PathID); err != nil {
+			return fmt.Errorf("Failed to delete wiki_urlpath: %w", err)
+		}
+		return nil
+	}
+	if cur.Deleted {
+		return nil
+	}
+	meta := revisionMeta(c, cur, userMessage, "Deleted via API")
+	meta.Deleted = true
+	if _, err := tx.AddWikiArticleRevision(ctx, cur.ID, cur.Title, cur.Content, meta); err != nil {
+		return fmt.Errorf("Failed to INSERT into wiki_articlerevision: %w", err)
+	}
+	return nil
+}
+
+// LockArticle locks an article given by its ID by adding a locked revision. Afterwards
+// only callers with the admin scope may change, move, delete or unlock it. Locking
+// requires write permission for the article.
+func LockArticle(c *gin.Context) {
+	setLock(c, true)
+}
 
-	err = tx.Commit(context.Background())
-	if notOK := utils.HandleErr(c, &err, "addRootArticle: Failed to commit transaction to insert new article: %v\n"); notOK {
-		tx.Rollback(context.Background())
+// UnlockArticle unlocks an article given by its ID by adding an unlocked revision. It
+// requires the admin scope.
+func UnlockArticle(c *gin.Context) {
+	setLock(c, false)
+}
+
+// setLock adds a revision with 'locked' set to locked unless the article already is
+// in that state.
+func setLock(c *gin.Context, locked bool) {
+	articleID, err := strconv.Atoi(c.Param("id"))
+	if notOK := utils.HandleErr(c, &err, "Article ID needs to be an integer: %v\n"); notOK {
 		return
 	}
+	var msgIn writePayload
+	if c.Request.ContentLength > 0 {
+		err = c.ShouldBindJSON(&msgIn)
+		if notOK := utils.HandleErr(c, &err, "setLock: Failed to bind 'user_message': %v\n"); notOK {
+			return
+		}
+	}
 
-	articleOut, err := db.SelectRootArticle(dbpool)
-	if notOK := utils.HandleErr(c, &err, "addRootArticle: Failed to query database table wiki_article: %v\n"); notOK {
+	ctx := c.Request.Context()
+	id := identity(c)
+	err = articles.InTx(ctx, func(tx store.ArticleStore) error {
+		cur, err := tx.SelectArticleByID(ctx, articleID)
+		if err != nil {
+			return fmt.Errorf("Failed to READ the article: %w", err)
+		}
+		if err := checkWrite(id, cur); err != nil {
+			return err
+		}
+		if err := checkIfMatch(c.GetHeader("If-Match"), &cur.ArticleBase); err != nil {
+			return err
+		}
+		if cur.Locked == locked {
+			return nil
+		}
+		log := "Locked via API"
+		if !locked {
+			log = "Unlocked via API"
+		}
+		meta := revisionMeta(c, cur, msgIn.UserMessage, log)
+		meta.Locked = locked
+		if _, err := tx.AddWikiArticleRevision(ctx, cur.ID, cur.Title, cur.Content, meta); err != nil {
+			return fmt.Errorf("Failed to INSERT into wiki_articlerevision: %w", err)
+		}
+		return nil
+	})
+	if notOK := utils.HandleErr(c, &err, "setLock: %v\n"); notOK {
 		return
 	}
-	c.JSON(http.StatusCreated, articleOut)
-	c.Header("Location", buildResourceURL(baseURL, articleOut))
+
+	articleOut, err := articles.SelectArticleByID(ctx, articleID)
+	if notOK := utils.HandleErr(c, &err, "setLock: Failed to query database table wiki_article: %v\n"); notOK {
+		return
+	}
+	c.Header("ETag", etag(&articleOut.ArticleBase))
+	c.JSON(http.StatusOK, articleOut)
 }
 
-// DbHealthCheck returns HTTP 200 if the database connection works.
-func DbHealthCheck(c *gin.Context) {
-	/* The database connection parameters will be loaded from environment variables.
-	 * user=<PGUSER> host=<PGHOST> password=<PGPASSWORD> port=<PGPORT>
-	 * dbname=<PGDATABASE>
-	 * The mapping of environment variables to keyboard is as follows:
-	 * hostaddr -> PGHOST
-	 * port -> PGPORT
-	 * user -> PGUSER
-	 * password -> PGPASSWORD
-	 * dbname -> PGDATABASE
-	 * See `go doc pgconn.ParseConfig` for details.
-	 */
-	dbpool, err := pgxpool.Connect(context.Background(), "")
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Unable to connect to database: %v\n", err)
-		os.Exit(1)
+// writePayload are the fields of a POST or PUT payload besides the article itself.
+type writePayload struct {
+	Permissions models.PermissionsInput `json:"permissions"`
+	// UserMessage describes the change like a commit message.
+	UserMessage string `json:"user_message"`
+}
+
+// movePayload is the payload of MoveArticle.
+type movePayload struct {
+	ParentArtID int    `json:"parent_art_id" binding:"required"`
+	Position    *int   `json:"position"`
+	UserMessage string `json:"user_message"`
+	relocateOptions
+}
+
+// revisionMeta returns the metadata of a revision written by the caller. Like in
+// django-wiki, the revision inherits 'deleted' and 'locked' from the current revision
+// cur, which is nil for a new article.
+func revisionMeta(c *gin.Context, cur *models.Article, userMessage string, automaticLog string) models.RevisionMeta {
+	meta := models.RevisionMeta{
+		UserID:       identity(c).UserID,
+		IPAddress:    utils.ClientIP(c.Request, trustedProxies),
+		UserMessage:  userMessage,
+		AutomaticLog: automaticLog,
+	}
+	if cur != nil {
+		meta.Deleted = cur.Deleted
+		meta.Locked = cur.Locked
+	}
+	return meta
+}
+
+// identity returns the authenticated caller. Routes without authentication are
+// served to an anonymous caller without scope.
+func identity(c *gin.Context) *auth.Identity {
+	if id := auth.FromContext(c); id != nil {
+		return id
 	}
-	defer dbpool.Close()
+	return &auth.Identity{Name: "anonymous"}
+}
 
-	var greeting string
-	err = dbpool.QueryRow(context.Background(), "select 'Hello, world!';").Scan(&greeting)
+// errLocked is returned if a caller without the admin scope changes a locked article.
+var errLocked = utils.NewStatusError(http.StatusLocked, "article is locked")
+
+// checkWrite returns auth.ErrForbidden unless the caller may write the article and
+// errLocked if the article is locked and the caller is not a moderator.
+func checkWrite(id *auth.Identity, a *models.Article) error {
+	if !id.CanWrite(*a.Permissions) {
+		return fmt.Errorf("%w: no write permission for article %v", auth.ErrForbidden, a.ID)
+	}
+	if a.Locked && id.Scope < auth.ScopeAdmin {
+		return fmt.Errorf("%w: article %v can only be changed by callers with scope '%v'", errLocked, a.ID, auth.ScopeAdmin)
+	}
+	return nil
+}
+
+// checkRead returns auth.ErrForbidden unless the caller may read the article.
+func checkRead(c *gin.Context, a *models.ArticleBase) error {
+	if !identity(c).CanRead(*a.Permissions) {
+		return fmt.Errorf("%w: no read permission for article %v", auth.ErrForbidden, a.ID)
+	}
+	return nil
+}
+
+// DbHealthCheck returns HTTP 200 if the database connection works.
+func DbHealthCheck(c *gin.Context) {
+	err := articles.Ping(c.Request.Context())
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "QueryRow failed: %v\n", err)
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "Ping failed: %v\n", err)
+		c.String(http.StatusServiceUnavailable, "Database connection failed.")
+		return
 	}
 
-	c.String(http.StatusOK, fmt.Sprintln(greeting)+"Database connection up and running.")
+	c.String(http.StatusOK, "Database connection up and running.")
 }
 
 // buildResourceURL creates a fully qualified URL to access the resource.
@@ -265,3 +896,19 @@ func buildResourceURL(baseURL string, r models.Resource) string {
 func SetBaseURL(new string) {
 	baseURL = new
 }
+
+// SetStore sets the article store used by all handlers.
+func SetStore(s store.ArticleStore) {
+	articles = s
+}
+
+// SetTrustedProxies sets the proxies whose X-Forwarded-For and X-Real-IP headers are
+// used to determine the client IP recorded with revisions.
+func SetTrustedProxies(nets []*net.IPNet) {
+	trustedProxies = nets
+}
+
+// SetReadiness sets the function that reports whether the server accepts new requests.
+func SetReadiness(f func() bool) {
+	ready = f
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"coco-life.de/wapi/internal/db"
+	"github.com/gin-gonic/gin"
+)
+
+// readinessTimeout bounds the time all readiness checks together may take.
+const readinessTimeout = 5 * time.Second
+
+// checkResult is the outcome of a single readiness check.
+type checkResult struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Liveness returns HTTP 200 as long as the process is able to serve requests. It does
+// not touch the database on purpose: A database outage must not get the process
+// restarted.
+func Liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "alive"})
+}
+
+// Readiness returns HTTP 200 if the server accepts new requests and the database is
+// usable, HTTP 503 otherwise. The checks are
+// - the server is not shutting down,
+// - a connection can be acquired from the pool,
+// - the django-wiki tables exist,
+// - exactly one root article exists,
+// - optionally, if called with '?mptt=true', the nested set of wiki_urlpath is sound.
+func Readiness(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := []checkResult{
+		runCheck("accepting_requests", func() error {
+			if !ready() {
+				return fmt.Errorf("server is shutting down")
+			}
+			return nil
+		}),
+		runCheck("pool", func() error {
+			return articles.Ping(ctx)
+		}),
+		runCheck("tables", func() error {
+			missing, err := articles.MissingTables(ctx, db.RequiredTables)
+			if err != nil {
+				return err
+			}
+			if len(missing) > 0 {
+				return fmt.Errorf("missing tables: %v", strings.Join(missing, ", "))
+			}
+			return nil
+		}),
+		runCheck("root_article", func() error {
+			n, err := articles.CountRootArticles(ctx)
+			if err != nil {
+				return err
+			}
+			if n != 1 {
+				return fmt.Errorf("expected 1 root article, found %v", n)
+			}
+			return nil
+		}),
+	}
+	if withMPTT, _ := strconv.ParseBool(c.Query("mptt")); withMPTT {
+		checks = append(checks, runCheck("mptt", func() error {
+			violations, err := articles.MPTTViolations(ctx)
+			if err != nil {
+				return err
+			}
+			if len(violations) > 0 {
+				return fmt.Errorf("%v", strings.Join(violations, "; "))
+			}
+			return nil
+		}))
+	}
+
+	code, status := http.StatusOK, "ready"
+	for _, chk := range checks {
+		if chk.Status != "ok" {
+			code, status = http.StatusServiceUnavailable, "not ready"
+			break
+		}
+	}
+	c.JSON(code, gin.H{"status": status, "checks": checks})
+}
+
+// runCheck executes f and measures its latency.
+func runCheck(name string, f func() error) checkResult {
+	start := time.Now()
+	err := f()
+	res := checkResult{
+		Name:      name,
+		Status:    "ok",
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		res.Status = "failed"
+		res.Error = err.Error()
+	}
+	return res
+}
@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"coco-life.de/wapi/internal/markup"
+	"coco-life.de/wapi/internal/models"
+	"coco-life.de/wapi/internal/store"
+	"coco-life.de/wapi/internal/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// wikiURL is the URL django-wiki is served at, see SetWikiURL.
+var wikiURL = "/"
+
+// linkResolver resolves the links of a rendered article to the articles the reader
+// may read.
+type linkResolver struct {
+	ctx    context.Context
+	reader models.ReadFilter
+}
+
+// ResolveID returns the article with wiki_article-id id.
+func (r linkResolver) ResolveID(id int) (markup.Target, error) {
+	a, err := articles.SelectArticleHeader(r.ctx, id)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && !r.reader.Allows(*a.Permissions)) {
+		return markup.Target{}, nil
+	} else if err != nil {
+		return markup.Target{}, err
+	}
+	path, err := articles.SelectURLPath(r.ctx, id)
+	if err != nil {
+		return markup.Target{}, err
+	}
+	return markup.Target{URL: wikiURL + path, ID: id, Path: path, Title: a.Title, Found: true}, nil
+}
+
+// ResolvePath returns the article with the URL path.
+func (r linkResolver) ResolvePath(path string) (markup.Target, error) {
+	t := markup.Target{URL: wikiURL + path, Path: path}
+	id, err := articles.SelectArticleIDByPath(r.ctx, path)
+	if errors.Is(err, store.ErrNotFound) {
+		return t, nil
+	} else if err != nil {
+		return t, err
+	}
+	target, err := r.ResolveID(id)
+	target.URL = t.URL
+	return target, err
+}
+
+// acceptsHTML reports whether the caller prefers HTML to JSON according to the Accept
+// header.
+func acceptsHTML(c *gin.Context) bool {
+	return c.NegotiateFormat(gin.MIMEJSON, gin.MIMEHTML) == gin.MIMEHTML
+}
+
+// renderHTML responds with the content of article rendered to HTML, see package
+// markup. The links to other articles point to wikiURL. The response has no ETag as
+// the targets of the links change independently of the article.
+func renderHTML(c *gin.Context, article *models.ArticleBase) {
+	ctx := c.Request.Context()
+	path, err := articles.SelectURLPath(ctx, article.ID)
+	if notOK := utils.HandleErr(c, &err, "renderHTML: Failed to query database table wiki_urlpath: %v\n"); notOK {
+		return
+	}
+	html, err := markup.HTML(article.Content, path, linkResolver{ctx: ctx, reader: identity(c).ReadFilter()})
+	if notOK := utils.HandleErr(c, &err, "renderHTML: %v\n"); notOK {
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(html))
+}
+
+// RetrieveArticleHTML returns the content of the current revision of an article
+// rendered to HTML.
+func RetrieveArticleHTML(c *gin.Context) {
+	articleID, err := strconv.Atoi(c.Param("id"))
+	if notOK := utils.HandleErr(c, &err, "Article ID needs to be an integer: %v\n"); notOK {
+		return
+	}
+	article, err := articles.SelectArticleByID(c.Request.Context(), articleID)
+	if notOK := utils.HandleErr(c, &err, "Failed to query database table wiki_article: %v\n"); notOK {
+		return
+	}
+	err = checkRead(c, &article.ArticleBase)
+	if notOK := utils.HandleErr(c, &err, "RetrieveArticleHTML: %v\n"); notOK {
+		return
+	}
+	renderHTML(c, &article.ArticleBase)
+}
+
+// RetrieveArticleTOC returns the headings of the current revision of an article with
+// the anchors of the rendered article, see markup.TOC. The TOC only depends on the
+// revision, so the response has the ETag of the article, as a weak one since it is
+// not the article itself.
+func RetrieveArticleTOC(c *gin.Context) {
+	articleID, err := strconv.Atoi(c.Param("id"))
+	if notOK := utils.HandleErr(c, &err, "Article ID needs to be an integer: %v\n"); notOK {
+		return
+	}
+	article, err := articles.SelectArticleByID(c.Request.Context(), articleID)
+	if notOK := utils.HandleErr(c, &err, "Failed to query database table wiki_article: %v\n"); notOK {
+		return
+	}
+	err = checkRead(c, &article.ArticleBase)
+	if notOK := utils.HandleErr(c, &err, "RetrieveArticleTOC: %v\n"); notOK {
+		return
+	}
+	if notModified(c, &article.ArticleBase, true) {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": article.ID, "revision_id": article.RevisionID, "headings": markup.TOC(article.Content)})
+}
+
+// SetWikiURL sets the URL django-wiki is served at, e.g. "https://wiki.example.com/".
+// The links of rendered articles point to it. The default "/" results in links
+// relative to the host.
+func SetWikiURL(url string) {
+	wikiURL = url
+}
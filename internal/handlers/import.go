@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"coco-life.de/wapi/internal/auth"
+	"coco-life.de/wapi/internal/models"
+	"coco-life.de/wapi/internal/store"
+	"coco-life.de/wapi/internal/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// maxImportArticles limits the number of articles of a single import.
+const maxImportArticles = 100000
+
+// importPayload is the payload of POST /articles/import.
+type importPayload struct {
+	ParentArtID int                  `json:"parent_art_id" binding:"required"`
+	Articles    []models.ArticleNode `json:"articles"`
+	UserMessage string               `json:"user_message"`
+}
+
+// importedArticle is an article created by an import.
+type importedArticle struct {
+	ID   int    `json:"id"`
+	Path string `json:"path"`
+}
+
+// ImportArticles creates the trees 'articles' below 'parent_art_id' in a single
+// transaction. The articles inherit the permissions of the parent with the caller as
+// owner. Other than creating the articles one by one, the number of statements does
+// not grow with the number of articles, see db.ImportWikiArticles. The response lists
+// the created articles with every parent preceding its descendants.
+func ImportArticles(c *gin.Context) {
+	var importIn importPayload
+	err := c.ShouldBindJSON(&importIn)
+	if notOK := utils.HandleErr(c, &err, "ImportArticles: Failed to bind 'importPayload': %v\n"); notOK {
+		return
+	}
+	paths, count := map[*models.ArticleNode]string{}, 0
+	var check func(nodes []models.ArticleNode, prefix string) error
+	check = func(nodes []models.ArticleNode, prefix string) error {
+		siblings := map[string]bool{}
+		for i := range nodes {
+			n := &nodes[i]
+			if n.Slug == "" {
+				return fmt.Errorf("Article %v/%v has no slug", prefix, i)
+			}
+			slug, err := cleanSlug(n.Slug)
+			if err != nil {
+				return fmt.Errorf("Article %v%v: %w", prefix, n.Slug, err)
+			}
+			if siblings[slug] {
+				return fmt.Errorf("%w: slug '%v' occurs twice below '%v'", store.ErrConstraint, slug, prefix)
+			}
+			siblings[slug], n.Slug = true, slug
+			if count++; count > maxImportArticles {
+				return fmt.Errorf("An import must not contain more than %v articles", maxImportArticles)
+			}
+			paths[n] = prefix + n.Slug + "/"
+			if err := check(n.Children, paths[n]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err = check(importIn.Articles, ""); err != nil {
+		utils.HandleErr(c, &err, "ImportArticles: %v\n")
+		return
+	}
+
+	ctx := c.Request.Context()
+	id := identity(c)
+	var out []importedArticle
+	err = articles.InTx(ctx, func(tx store.ArticleStore) error {
+		parent, err := tx.SelectArticleByID(ctx, importIn.ParentArtID)
+		if err != nil {
+			return fmt.Errorf("Failed to READ the parent article: %w", err)
+		}
+		if !id.CanWrite(*parent.Permissions) {
+			return fmt.Errorf("%w: no write permission for parent article %v", auth.ErrForbidden, parent.ID)
+		}
+		taken, err := childSlugs(ctx, tx, parent, 0)
+		if err != nil {
+			return err
+		}
+		for _, n := range importIn.Articles {
+			if err := checkSlugFree(n.Slug, taken, parent.ID); err != nil {
+				return err
+			}
+		}
+		prtPath, err := tx.SelectURLPath(ctx, parent.ID)
+		if err != nil {
+			return fmt.Errorf("Failed to select the path of the parent article: %w", err)
+		}
+		perms := *parent.Permissions
+		perms.OwnerID = id.UserID
+		meta := revisionMeta(c, nil, importIn.UserMessage, "Imported via API")
+		hdrIDs, err := tx.ImportWikiArticles(ctx, parent.ID, importIn.Articles, perms, meta)
+		if err != nil {
+			return fmt.Errorf("Failed to import the articles: %w", err)
+		}
+
+		// The IDs are in the same pre-order as the nodes.
+		out = make([]importedArticle, 0, len(hdrIDs))
+		var collect func(nodes []models.ArticleNode)
+		collect = func(nodes []models.ArticleNode) {
+			for i := range nodes {
+				out = append(out, importedArticle{ID: hdrIDs[len(out)], Path: prtPath + paths[&nodes[i]]})
+				collect(nodes[i].Children)
+			}
+		}
+		collect(importIn.Articles)
+		return nil
+	})
+	if notOK := utils.HandleErr(c, &err, "ImportArticles: %v\n"); notOK {
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{"articles": out})
+}
@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"coco-life.de/wapi/internal/markup"
+	"coco-life.de/wapi/internal/models"
+	"coco-life.de/wapi/internal/store"
+	"coco-life.de/wapi/internal/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// linkIndexBatch is the number of articles refreshLinkIndex indexes per transaction.
+const linkIndexBatch = 200
+
+// refreshLinkIndex indexes the links of all articles of s whose current revision has
+// not been indexed yet. As django-wiki changes articles without the API, the index is
+// brought up to date before it is queried instead of on every write.
+func refreshLinkIndex(ctx context.Context, s store.ArticleStore) error {
+	for {
+		var n int
+		err := s.InTx(ctx, func(tx store.ArticleStore) error {
+			sources, err := tx.SelectUnindexedArticles(ctx, linkIndexBatch)
+			if err != nil {
+				return err
+			}
+			n = len(sources)
+			for _, src := range sources {
+				var links []models.ArticleLink
+				for _, l := range markup.Links(src.Content, src.Path) {
+					link := models.ArticleLink{ToID: l.ArticleID}
+					if l.ArticleID == 0 {
+						link.ToPath = "/" + l.Path
+					}
+					links = append(links, link)
+				}
+				if err := tx.ReplaceArticleLinks(ctx, src.ArticleID, src.RevisionID, links); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil || n < linkIndexBatch {
+			return err
+		}
+	}
+}
+
+// linkSources returns wiki_article-id and URL path of the articles whose links may
+// break when the subtree of article cur changes its URL path: the articles linking
+// into the subtree according to the link index and the descendants of cur, whose
+// relative links may leave the subtree. cur itself is not included.
+func linkSources(ctx context.Context, tx store.ArticleStore, cur *models.Article) (map[int]string, error) {
+	if err := refreshLinkIndex(ctx, tx); err != nil {
+		return nil, fmt.Errorf("Failed to index the links: %w", err)
+	}
+	links, err := tx.SelectBacklinks(ctx, cur.ID, true)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to READ the backlinks: %w", err)
+	}
+	sources := map[int]string{}
+	for _, l := range links {
+		if _, ok := sources[l.FromID]; ok || l.FromID == cur.ID {
+			continue
+		}
+		path, err := tx.SelectURLPath(ctx, l.FromID)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to READ the URL path: %w", err)
+		}
+		sources[l.FromID] = path
+	}
+	descendants, err := tx.SelectArticles(ctx, models.ArticleQuery{Left: cur.Left, Right: cur.Right, PathID: cur.PathID,
+		Reader: models.ReadFilter{All: true}, SortBy: "id", Limit: (cur.Right - cur.Left + 1) / 2})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to READ the descendants: %w", err)
+	}
+	for _, d := range descendants {
+		sources[d.ID] = d.Path
+	}
+	return sources, nil
+}
+
+// pathRewriter returns the function markup.RewriteLinks needs to adapt the links to
+// the subtree that moved from oldPath to newPath.
+func pathRewriter(oldPath string, newPath string) func(string) (string, bool) {
+	return func(p string) (string, bool) {
+		if !strings.HasPrefix(p, oldPath) {
+			return p, false
+		}
+		return newPath + strings.TrimPrefix(p, oldPath), true
+	}
+}
+
+// rewriteLinks adds a revision to every article of sources whose links change as the
+// subtree at oldPath moved to newPath, see linkSources and markup.RewriteLinks. It
+// fails unless the caller may change all these articles.
+func rewriteLinks(c *gin.Context, tx store.ArticleStore, sources map[int]string, oldPath string, newPath string, userMessage string) error {
+	ctx := c.Request.Context()
+	ids := make([]int, 0, len(sources))
+	for id := range sources {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	rewrite := pathRewriter(oldPath, newPath)
+	for _, id := range ids {
+		a, err := tx.SelectArticleByID(ctx, id)
+		if err != nil {
+			return fmt.Errorf("Failed to READ article %v: %w", id, err)
+		}
+		newBase, err := tx.SelectURLPath(ctx, id)
+		if err != nil {
+			return fmt.Errorf("Failed to READ the URL path: %w", err)
+		}
+		content := markup.RewriteLinks(a.Content, sources[id], newBase, rewrite)
+		if content == a.Content {
+			continue
+		}
+		if err := checkWrite(identity(c), a); err != nil {
+			return fmt.Errorf("cannot update its links: %w", err)
+		}
+		meta := revisionMeta(c, a, userMessage, fmt.Sprintf("Updated links from /%v to /%v", oldPath, newPath))
+		if _, err := tx.AddWikiArticleRevision(ctx, id, a.Title, content, meta); err != nil {
+			return fmt.Errorf("Failed to INSERT into wiki_articlerevision: %w", err)
+		}
+	}
+	return nil
+}
+
+// outgoingLink is a link of ListArticleLinks with the article it points to.
+type outgoingLink struct {
+	models.ArticleLink
+	// ArticleID and Path identify the target, Path is nil if it is unknown.
+	ArticleID int     `json:"article_id,omitempty"`
+	Path      *string `json:"path,omitempty"`
+	// Broken is true if the target does not exist or the caller may not read it.
+	Broken bool `json:"broken"`
+}
+
+// sourcedLink is a link of ListBacklinks and ListBrokenLinks with the article it is
+// part of.
+type sourcedLink struct {
+	models.ArticleLink
+	FromPath  string `json:"from_path"`
+	FromTitle string `json:"from_title"`
+}
+
+// withSources adds path and title of the source to the links. Links in articles the
+// reader may not read are dropped.
+func withSources(ctx context.Context, links []models.ArticleLink, reader models.ReadFilter) ([]sourcedLink, error) {
+	out := []sourcedLink{}
+	if len(links) == 0 {
+		return out, nil
+	}
+	ids := []int{}
+	for _, l := range links {
+		if len(ids) == 0 || ids[len(ids)-1] != l.FromID {
+			ids = append(ids, l.FromID)
+		}
+	}
+	summaries, err := articles.SelectArticles(ctx, models.ArticleQuery{IDs: ids, Reader: reader, SortBy: "id", Limit: len(ids)})
+	if err != nil {
+		return nil, err
+	}
+	sources := make(map[int]models.ArticleSummary, len(summaries))
+	for _, s := range summaries {
+		sources[s.ID] = s
+	}
+	for _, l := range links {
+		if src, ok := sources[l.FromID]; ok {
+			out = append(out, sourcedLink{ArticleLink: l, FromPath: src.Path, FromTitle: src.Title})
+		}
+	}
+	return out, nil
+}
+
+// readableArticle returns the header of the article with the ID in the URL if the
+// caller may read it.
+func readableArticle(c *gin.Context) (*models.Article, error) {
+	articleID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return nil, err
+	}
+	article, err := articles.SelectArticleHeader(c.Request.Context(), articleID)
+	if err != nil {
+		return nil, err
+	}
+	return article, checkRead(c, &article.ArticleBase)
+}
+
+// ListArticleLinks returns the links of the current revision of an article to other
+// articles, see markup.Links, with the articles they point to.
+func ListArticleLinks(c *gin.Context) {
+	ctx := c.Request.Context()
+	article, err := readableArticle(c)
+	if notOK := utils.HandleErr(c, &err, "ListArticleLinks: %v\n"); notOK {
+		return
+	}
+	err = refreshLinkIndex(ctx, articles)
+	if notOK := utils.HandleErr(c, &err, "ListArticleLinks: Failed to index links: %v\n"); notOK {
+		return
+	}
+	links, err := articles.SelectArticleLinks(ctx, article.ID)
+	if notOK := utils.HandleErr(c, &err, "ListArticleLinks: %v\n"); notOK {
+		return
+	}
+
+	r := linkResolver{ctx: ctx, reader: identity(c).ReadFilter()}
+	out := make([]outgoingLink, len(links))
+	for i, l := range links {
+		var t markup.Target
+		if l.ToID != 0 {
+			t, err = r.ResolveID(l.ToID)
+		} else {
+			t, err = r.ResolvePath(l.ToPath[1:])
+		}
+		if notOK := utils.HandleErr(c, &err, "ListArticleLinks: %v\n"); notOK {
+			return
+		}
+		out[i] = outgoingLink{ArticleLink: l, ArticleID: t.ID, Broken: !t.Found}
+		if t.Found {
+			path := t.Path
+			out[i].Path = &path
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"id": article.ID, "links": out})
+}
+
+// ListBacklinks returns the links of other articles to an article by its ID or its
+// URL path. With '?subtree=true' the links to its descendants are included, that is,
+// the links a move or deletion of the article breaks. Links in articles the caller may
+// not read are left out.
+func ListBacklinks(c *gin.Context) {
+	ctx := c.Request.Context()
+	article, err := readableArticle(c)
+	if notOK := utils.HandleErr(c, &err, "ListBacklinks: %v\n"); notOK {
+		return
+	}
+	subtree := false
+	if v := c.Query("subtree"); v != "" {
+		subtree, err = strconv.ParseBool(v)
+		if notOK := utils.HandleErr(c, &err, "ListBacklinks: Invalid 'subtree': %v\n"); notOK {
+			return
+		}
+	}
+	err = refreshLinkIndex(ctx, articles)
+	if notOK := utils.HandleErr(c, &err, "ListBacklinks: Failed to index links: %v\n"); notOK {
+		return
+	}
+	links, err := articles.SelectBacklinks(ctx, article.ID, subtree)
+	if notOK := utils.HandleErr(c, &err, "ListBacklinks: %v\n"); notOK {
+		return
+	}
+	out, err := withSources(ctx, links, identity(c).ReadFilter())
+	if notOK := utils.HandleErr(c, &err, "ListBacklinks: %v\n"); notOK {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": article.ID, "backlinks": out})
+}
+
+// ListBrokenLinks returns all links to articles that do not exist.
+func ListBrokenLinks(c *gin.Context) {
+	ctx := c.Request.Context()
+	err := refreshLinkIndex(ctx, articles)
+	if notOK := utils.HandleErr(c, &err, "ListBrokenLinks: Failed to index links: %v\n"); notOK {
+		return
+	}
+	links, err := articles.SelectBrokenLinks(ctx)
+	if notOK := utils.HandleErr(c, &err, "ListBrokenLinks: %v\n"); notOK {
+		return
+	}
+	out, err := withSources(ctx, links, identity(c).ReadFilter())
+	if notOK := utils.HandleErr(c, &err, "ListBrokenLinks: %v\n"); notOK {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"links": out})
+}
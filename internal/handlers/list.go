@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"coco-life.de/wapi/internal/models"
+	"coco-life.de/wapi/internal/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// Page sizes of GET /articles.
+const (
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+// listCursor is the decoded 'cursor' of GET /articles.
+type listCursor struct {
+	ID       int       `json:"id"`
+	Modified time.Time `json:"modified"`
+}
+
+// encodeCursor returns the cursor of the page following the article with key k.
+func encodeCursor(k models.ArticleKey) string {
+	b, _ := json.Marshal(listCursor{ID: k.ID, Modified: k.Modified})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor is the inverse of encodeCursor.
+func decodeCursor(s string) (*models.ArticleKey, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	var cur listCursor
+	if err == nil {
+		err = json.Unmarshal(b, &cur)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Invalid 'cursor', pass 'next' of the previous page")
+	}
+	return &models.ArticleKey{ID: cur.ID, Modified: cur.Modified}, nil
+}
+
+// ListArticles lists summaries of the articles the caller may read. The query
+// parameters filter the articles:
+// - 'title_prefix': the title starts with the value ignoring case,
+// - 'slug', 'level': exact match,
+// - 'modified_since', 'created_since': RFC 3339 timestamps,
+// - 'path': descendants of the article with the URL path,
+// - 'has_children': 'true' or 'false',
+// - 'deleted': 'true', 'false' (default) or 'any'.
+// 'sort' is 'id' (default) or 'modified', prefixed with '-' for descending order.
+// The response contains up to 'limit' (default 50, at most 500) articles and the
+// cursor 'next' of the following page, which is passed as 'cursor'. 'fields' selects
+// the fields of the summaries, see summaryFields; the content is only included if it
+// is selected.
+func ListArticles(c *gin.Context) {
+	q, err := parseArticleQuery(c)
+	if notOK := utils.HandleErr(c, &err, "ListArticles: %v\n"); notOK {
+		return
+	}
+	opts, err := parseSparse(c, summaryFields, nil)
+	if notOK := utils.HandleErr(c, &err, "ListArticles: %v\n"); notOK {
+		return
+	}
+	q.WithContent = opts.fields["content"]
+	ctx := c.Request.Context()
+	if path, ok := c.GetQuery("path"); ok {
+		parent, err := articles.SelectArticleByPath(ctx, path)
+		if notOK := utils.HandleErr(c, &err, "ListArticles: Failed to query database table wiki_urlpath: %v\n"); notOK {
+			return
+		}
+		q.Left, q.Right, q.PathID = parent.Left, parent.Right, parent.PathID
+	}
+
+	// One more article tells whether there is a next page.
+	limit := q.Limit
+	q.Limit++
+	summaries, err := articles.SelectArticles(ctx, q)
+	if notOK := utils.HandleErr(c, &err, "ListArticles: %v\n"); notOK {
+		return
+	}
+	next := ""
+	if len(summaries) > limit {
+		summaries = summaries[:limit]
+		next = encodeCursor(summaries[limit-1].Key())
+	}
+	out := make([]interface{}, len(summaries))
+	for i := range summaries {
+		if out[i], err = opts.pick(&summaries[i]); err != nil {
+			utils.HandleErr(c, &err, "ListArticles: %v\n")
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"articles": out, "next": next})
+}
+
+// parseArticleQuery reads the query parameters of ListArticles except 'path'.
+func parseArticleQuery(c *gin.Context) (models.ArticleQuery, error) {
+	q := models.ArticleQuery{
+		TitlePrefix: c.Query("title_prefix"),
+		Slug:        c.Query("slug"),
+		Reader:      identity(c).ReadFilter(),
+		SortBy:      "id",
+		Limit:       defaultListLimit,
+	}
+	var err error
+	if v := c.Query("level"); v != "" {
+		lvl, err := strconv.Atoi(v)
+		if err != nil {
+			return q, fmt.Errorf("Invalid 'level': %v", err)
+		}
+		q.Level = &lvl
+	}
+	for param, t := range map[string]*time.Time{"modified_since": &q.ModifiedSince, "created_since": &q.CreatedSince} {
+		if v := c.Query(param); v != "" {
+			if *t, err = time.Parse(time.RFC3339, v); err != nil {
+				return q, fmt.Errorf("Invalid '%v', expected an RFC 3339 timestamp: %v", param, err)
+			}
+		}
+	}
+	if v := c.Query("has_children"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return q, fmt.Errorf("Invalid 'has_children': %v", err)
+		}
+		q.HasChildren = &b
+	}
+	switch v := c.DefaultQuery("deleted", "false"); v {
+	case "any":
+	default:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return q, fmt.Errorf("Invalid 'deleted', expected 'true', 'false' or 'any'")
+		}
+		q.Deleted = &b
+	}
+	if sort := c.Query("sort"); sort != "" {
+		q.Desc = strings.HasPrefix(sort, "-")
+		q.SortBy = strings.TrimPrefix(sort, "-")
+		if q.SortBy != "id" && q.SortBy != "modified" {
+			return q, fmt.Errorf("Invalid 'sort' '%v', expected 'id' or 'modified'", sort)
+		}
+	}
+	if v := c.Query("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 1 || q.Limit > maxListLimit {
+			return q, fmt.Errorf("'limit' must be between 1 and %v", maxListLimit)
+		}
+	}
+	if v := c.Query("cursor"); v != "" {
+		if q.After, err = decodeCursor(v); err != nil {
+			return q, err
+		}
+	}
+	return q, nil
+}
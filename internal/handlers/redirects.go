@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"coco-life.de/wapi/internal/auth"
+	"coco-life.de/wapi/internal/models"
+	"coco-life.de/wapi/internal/store"
+	"coco-life.de/wapi/internal/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// maxRedirects limits the redirects followRedirects follows, which guards against
+// cycles.
+const maxRedirects = 10
+
+// Title and content of the stub django-wiki leaves at the old URL path of a moved
+// article.
+const (
+	redirectTitle   = "Moved: %v"
+	redirectContent = "Article moved to [wiki:/%[1]v](wiki:/%[1]v)"
+)
+
+// createRedirects leaves a redirect at the old URL path of every article of the
+// subtree of cur, which moved from oldPath to newPath, like django-wiki's move with
+// "redirect": a stub article whose wiki_urlpath-moved_to_id points to the moved
+// article. The stubs get the permissions of cur with the caller as owner. The caller
+// needs write permission for the old parent.
+func createRedirects(c *gin.Context, tx store.ArticleStore, cur *models.Article, oldPath string, newPath string, userMessage string) error {
+	ctx := c.Request.Context()
+	id := identity(c)
+	moved, err := tx.SelectArticleHeader(ctx, cur.ID)
+	if err != nil {
+		return fmt.Errorf("Failed to READ the article: %w", err)
+	}
+	descendants, err := tx.SelectArticles(ctx, models.ArticleQuery{Left: moved.Left, Right: moved.Right, PathID: moved.PathID,
+		Reader: models.ReadFilter{All: true}, SortBy: "id", Limit: (moved.Right - moved.Left + 1) / 2})
+	if err != nil {
+		return fmt.Errorf("Failed to READ the descendants: %w", err)
+	}
+	// The stub of a parent has to exist before the stubs of its children.
+	sort.Slice(descendants, func(i, j int) bool { return descendants[i].Left < descendants[j].Left })
+	nodes := append([]models.ArticleSummary{{ID: moved.ID, Path: newPath, Title: moved.Title}}, descendants...)
+
+	perms := *cur.Permissions
+	perms.OwnerID = id.UserID
+	for i, n := range nodes {
+		slugs := store.SplitPath(oldPath + strings.TrimPrefix(n.Path, newPath))
+		parent, err := tx.SelectArticleByPath(ctx, strings.Join(slugs[:len(slugs)-1], "/"))
+		if err != nil {
+			return fmt.Errorf("Failed to READ the old parent article: %w", err)
+		}
+		if i == 0 && !id.CanWrite(*parent.Permissions) {
+			return fmt.Errorf("%w: no write permission for parent article %v", auth.ErrForbidden, parent.ID)
+		}
+		meta := revisionMeta(c, nil, userMessage, fmt.Sprintf("Created redirect to /%v", n.Path))
+		_, pathID, err := insertNode(ctx, tx, parent, slugs[len(slugs)-1],
+			fmt.Sprintf(redirectTitle, n.Title), fmt.Sprintf(redirectContent, n.Path), perms, meta)
+		if err != nil {
+			return err
+		}
+		target, err := tx.SelectArticleHeader(ctx, n.ID)
+		if err != nil {
+			return fmt.Errorf("Failed to READ the article: %w", err)
+		}
+		if err := tx.SetWikiURLPathMovedTo(ctx, pathID, target.PathID); err != nil {
+			return fmt.Errorf("Failed to set 'moved_to_id' in wiki_urlpath: %w", err)
+		}
+	}
+	return nil
+}
+
+// followRedirects returns the article the redirects starting at the article hdrID
+// lead to, see createRedirects. If it is another article, the header
+// Content-Location tells its canonical location below /articles/by-path/.
+func followRedirects(c *gin.Context, ctx context.Context, hdrID int) (int, error) {
+	target := hdrID
+	for i := 0; ; i++ {
+		movedTo, err := articles.SelectMovedTo(ctx, target)
+		if err != nil {
+			return -1, fmt.Errorf("Failed to READ the redirect: %w", err)
+		}
+		if movedTo == 0 {
+			break
+		}
+		if i == maxRedirects {
+			return -1, utils.NewStatusError(http.StatusLoopDetected, fmt.Sprintf("More than %v redirects from article %v", maxRedirects, hdrID))
+		}
+		target = movedTo
+	}
+	if target != hdrID {
+		path, err := articles.SelectURLPath(ctx, target)
+		if err != nil {
+			return -1, fmt.Errorf("Failed to READ the URL path: %w", err)
+		}
+		c.Header("Content-Location", baseURL+"articles/by-path/"+path)
+	}
+	return target, nil
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"coco-life.de/wapi/internal/models"
+	"coco-life.de/wapi/internal/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// Page sizes of GET /search.
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// searchConfig is the Postgres text search configuration, see SetSearchConfig.
+var searchConfig = "simple"
+
+// SearchArticles searches the titles and contents of all articles the caller may read
+// that are not deleted. 'q' is the query in the syntax of web search engines, see
+// websearch_to_tsquery. The optional 'path' restricts the search to an article and
+// its descendants. The hits are ordered by rank and paged by 'limit' (default 20, at
+// most 100) and 'offset'.
+func SearchArticles(c *gin.Context) {
+	q := models.SearchQuery{
+		Query:  c.Query("q"),
+		Config: searchConfig,
+		Reader: identity(c).ReadFilter(),
+		Limit:  defaultSearchLimit,
+	}
+	var err error
+	if q.Query == "" {
+		err = fmt.Errorf("The query 'q' must not be empty")
+	}
+	if v := c.Query("limit"); v != "" && err == nil {
+		if q.Limit, err = strconv.Atoi(v); err == nil && (q.Limit < 1 || q.Limit > maxSearchLimit) {
+			err = fmt.Errorf("'limit' must be between 1 and %v", maxSearchLimit)
+		}
+	}
+	if v := c.Query("offset"); v != "" && err == nil {
+		if q.Offset, err = strconv.Atoi(v); err == nil && q.Offset < 0 {
+			err = fmt.Errorf("'offset' must not be negative")
+		}
+	}
+	if notOK := utils.HandleErr(c, &err, "SearchArticles: %v\n"); notOK {
+		return
+	}
+
+	ctx := c.Request.Context()
+	if path, ok := c.GetQuery("path"); ok {
+		subtree, err := articles.SelectArticleByPath(ctx, path)
+		if notOK := utils.HandleErr(c, &err, "SearchArticles: Failed to query database table wiki_urlpath: %v\n"); notOK {
+			return
+		}
+		q.Left, q.Right, q.PathID = subtree.Left, subtree.Right, subtree.PathID
+	}
+
+	hits, total, err := articles.SearchArticles(ctx, q)
+	if notOK := utils.HandleErr(c, &err, "SearchArticles: %v\n"); notOK {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"query":   q.Query,
+		"total":   total,
+		"limit":   q.Limit,
+		"offset":  q.Offset,
+		"results": hits,
+	})
+}
+
+// SetSearchConfig sets the Postgres text search configuration of SearchArticles, e.g.
+// "english" or "german". The default "simple" does not stem words.
+func SetSearchConfig(config string) {
+	searchConfig = config
+}
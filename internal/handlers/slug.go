@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"coco-life.de/wapi/internal/markup"
+	"coco-life.de/wapi/internal/models"
+	"coco-life.de/wapi/internal/store"
+	"coco-life.de/wapi/internal/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// maxSlugLength is the length of wiki_urlpath-slug, django-wiki's SLUG_MAX_LENGTH.
+const maxSlugLength = 50
+
+// reservedSlugs are the slugs django-wiki does not permit besides those starting with
+// "_", which name its views, e.g. "_create" and "_plugin".
+var reservedSlugs = map[string]bool{"admin": true}
+
+var (
+	slugCharsRe = regexp.MustCompile(`^[-a-zA-Z0-9_]+$`)
+	digitsRe    = regexp.MustCompile(`^[0-9]+$`)
+)
+
+// cleanSlug checks slug against django-wiki's rules and returns it in lower case, as
+// django-wiki stores it: A slug consists of up to maxSlugLength letters, digits, "-"
+// and "_", does not start with "_", is not made of digits only, which would be taken
+// for an article ID, and is not reserved.
+func cleanSlug(slug string) (string, error) {
+	switch {
+	case slug == "":
+		return "", fmt.Errorf("The slug must not be empty")
+	case strings.Contains(slug, "/"):
+		return "", fmt.Errorf("The slug '%v' must not contain '/'", slug)
+	case len(slug) > maxSlugLength:
+		return "", fmt.Errorf("The slug '%v' is longer than %v characters", slug, maxSlugLength)
+	case !slugCharsRe.MatchString(slug):
+		return "", fmt.Errorf("The slug '%v' may only contain letters, digits, '-' and '_'", slug)
+	case strings.HasPrefix(slug, "_"):
+		return "", fmt.Errorf("The slug '%v' must not start with '_'", slug)
+	case digitsRe.MatchString(slug):
+		return "", fmt.Errorf("The slug '%v' must not consist of digits only", slug)
+	case reservedSlugs[strings.ToLower(slug)]:
+		return "", fmt.Errorf("The slug '%v' is reserved", slug)
+	}
+	return strings.ToLower(slug), nil
+}
+
+// childSlugs returns the slugs of the children of parent except the article hdrID in
+// lower case. django-wiki compares slugs ignoring case.
+func childSlugs(ctx context.Context, tx store.ArticleStore, parent *models.Article, hdrID int) (map[string]bool, error) {
+	lvl := parent.Level + 1
+	children, err := tx.SelectArticles(ctx, models.ArticleQuery{Left: parent.Left, Right: parent.Right, PathID: parent.PathID, Level: &lvl,
+		Reader: models.ReadFilter{All: true}, SortBy: "id", Limit: (parent.Right - parent.Left + 1) / 2})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to READ the children of article %v: %w", parent.ID, err)
+	}
+	taken := map[string]bool{}
+	for _, child := range children {
+		if child.ID != hdrID {
+			taken[strings.ToLower(child.Slug)] = true
+		}
+	}
+	return taken, nil
+}
+
+// checkSlugFree returns store.ErrConstraint if taken, see childSlugs, contains slug.
+// The error suggests a free slug, see slugTakenError.
+func checkSlugFree(slug string, taken map[string]bool, parentID int) error {
+	if taken[slug] {
+		return &slugTakenError{
+			Err:        fmt.Errorf("%w: slug '%v' already exists below article %v", store.ErrConstraint, slug, parentID),
+			Suggestion: suggestSlug(slug, taken),
+		}
+	}
+	return nil
+}
+
+// slugTakenError is a slug taken by a sibling with a free slug derived from it.
+type slugTakenError struct {
+	Err        error
+	Suggestion string
+}
+
+func (e *slugTakenError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *slugTakenError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorDetails adds the suggestion to the response, see utils.HandleErr. The client
+// decides whether to send it.
+func (e *slugTakenError) ErrorDetails() gin.H {
+	return gin.H{"suggested_slug": e.Suggestion}
+}
+
+// suggestSlug derives a valid slug that is not taken from s, e.g. "getting-started"
+// for "Getting Started!" or "faq-2" for "FAQ" if "faq" is taken.
+func suggestSlug(s string, taken map[string]bool) string {
+	base := strings.Trim(markup.Slugify(s), "-_")
+	if base == "" {
+		base = "article"
+	}
+	for n := 1; ; n++ {
+		slug, suffix := base, ""
+		if n > 1 {
+			suffix = "-" + strconv.Itoa(n)
+		}
+		if len(slug)+len(suffix) > maxSlugLength {
+			slug = strings.TrimRight(slug[:maxSlugLength-len(suffix)], "-_")
+		}
+		slug += suffix
+		if _, err := cleanSlug(slug); err == nil && !taken[slug] {
+			return slug
+		}
+	}
+}
+
+// slugPayload is the payload of RenameArticle.
+type slugPayload struct {
+	Slug        string `json:"slug" binding:"required"`
+	UserMessage string `json:"user_message"`
+	relocateOptions
+}
+
+// RenameArticle sets the slug of an article given by its ID, which changes the URL
+// paths of the article and its descendants. The slug has to follow django-wiki's
+// rules, see cleanSlug, and must not be taken by a sibling, otherwise the 409
+// response suggests a free one. Like a move, the rename is recorded as a new
+// revision, 'rewrite_links' updates the links of other articles and 'redirect' leaves
+// redirects at the old URL paths.
+func RenameArticle(c *gin.Context) {
+	articleID, err := strconv.Atoi(c.Param("id"))
+	if notOK := utils.HandleErr(c, &err, "Article ID needs to be an integer: %v\n"); notOK {
+		return
+	}
+	var slugIn slugPayload
+	err = c.ShouldBindJSON(&slugIn)
+	if notOK := utils.HandleErr(c, &err, "RenameArticle: Failed to bind 'slugPayload': %v\n"); notOK {
+		return
+	}
+
+	ctx := c.Request.Context()
+	err = articles.InTx(ctx, func(tx store.ArticleStore) error {
+		cur, err := tx.SelectArticleByID(ctx, articleID)
+		if err != nil {
+			return fmt.Errorf("Failed to READ the article: %w", err)
+		}
+		return renameArticle(c, tx, cur, &slugIn, c.GetHeader("If-Match"))
+	})
+	if notOK := utils.HandleErr(c, &err, "RenameArticle: %v\n"); notOK {
+		return
+	}
+
+	articleOut, err := articles.SelectArticleByID(ctx, articleID)
+	if notOK := utils.HandleErr(c, &err, "RenameArticle: Failed to query database table wiki_article: %v\n"); notOK {
+		return
+	}
+	c.Header("ETag", etag(&articleOut.ArticleBase))
+	c.JSON(http.StatusOK, articleOut)
+}
+
+// renameArticle sets the slug of the article cur, see RenameArticle.
+func renameArticle(c *gin.Context, tx store.ArticleStore, cur *models.Article, slugIn *slugPayload, ifMatch string) error {
+	ctx := c.Request.Context()
+	if cur.Level == 0 {
+		return fmt.Errorf("%w: the root article has no slug", store.ErrConstraint)
+	}
+	if err := checkWrite(identity(c), cur); err != nil {
+		return err
+	}
+	if err := checkIfMatch(ifMatch, &cur.ArticleBase); err != nil {
+		return err
+	}
+	parent, err := tx.SelectArticleByID(ctx, cur.ParentArtID)
+	if err != nil {
+		return fmt.Errorf("Failed to READ the parent article: %w", err)
+	}
+	taken, err := childSlugs(ctx, tx, parent, cur.ID)
+	if err != nil {
+		return err
+	}
+	slug, err := cleanSlug(slugIn.Slug)
+	if err != nil {
+		return err
+	}
+	if err := checkSlugFree(slug, taken, parent.ID); err != nil {
+		return err
+	}
+	return relocate(c, tx, cur, slugIn.relocateOptions, slugIn.UserMessage, func() error {
+		if err := tx.UpdateWikiURLPathSlug(ctx, cur.PathID, slug); err != nil {
+			return fmt.Errorf("Failed to rename wiki_urlpath: %w", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"coco-life.de/wapi/internal/models"
+	"coco-life.de/wapi/internal/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// maxIncludedChildren limits the children of '?include=children'.
+const maxIncludedChildren = 1000
+
+// articleFields are the fields of an article 'fields' may select.
+var articleFields = []string{"id", "title", "content", "revision_id", "parent_art_id", "path_id",
+	"left", "right", "deleted", "locked", "permissions", "slug", "level"}
+
+// summaryFields are the fields of a summary of GET /articles 'fields' may select.
+var summaryFields = []string{"id", "revision_id", "parent_art_id", "slug", "path", "title", "level",
+	"left", "right", "created", "modified", "deleted", "locked", "has_children", "content"}
+
+// articleIncludes are the expansions of an article 'include' may add.
+var articleIncludes = []string{"ancestors", "children", "revisions"}
+
+// sparseOptions are the query parameters 'fields' and 'include', e.g.
+// '?fields=id,title&include=children'.
+type sparseOptions struct {
+	// fields are the selected fields, nil for the default fields.
+	fields  map[string]bool
+	include map[string]bool
+}
+
+// parseSparse reads 'fields', a comma separated list of valid fields, and 'include',
+// a comma separated list of includes.
+func parseSparse(c *gin.Context, valid []string, includes []string) (*sparseOptions, error) {
+	o := &sparseOptions{include: map[string]bool{}}
+	parse := func(param string, valid []string, dst map[string]bool) error {
+		for _, name := range strings.Split(c.Query(param), ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			ok := false
+			for _, v := range valid {
+				ok = ok || v == name
+			}
+			if !ok {
+				return fmt.Errorf("Invalid %v '%v', expected some of %v", param, name, strings.Join(valid, ", "))
+			}
+			dst[name] = true
+		}
+		return nil
+	}
+	if _, ok := c.GetQuery("fields"); ok {
+		o.fields = map[string]bool{}
+		if err := parse("fields", valid, o.fields); err != nil {
+			return nil, err
+		}
+	}
+	if err := parse("include", includes, o.include); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// sparse reports whether the response differs from the default one.
+func (o *sparseOptions) sparse() bool {
+	return o.fields != nil || len(o.include) > 0
+}
+
+// wants reports whether field is selected. Without 'fields' all fields are.
+func (o *sparseOptions) wants(field string) bool {
+	return o.fields == nil || o.fields[field]
+}
+
+// pick returns the JSON object of v reduced to the selected fields.
+func (o *sparseOptions) pick(v interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(b, &all); err != nil {
+		return nil, err
+	}
+	out := make(map[string]interface{}, len(all))
+	for name, value := range all {
+		if o.wants(name) {
+			out[name] = value
+		}
+	}
+	return out, nil
+}
+
+// retrieveSparse responds with the fields and includes of o of the article whose ID
+// resolve returns. The content is only read if it is selected. Responses with only
+// fields have a weak ETag, responses with includes have none as the included
+// articles change independently.
+func retrieveSparse(c *gin.Context, resolve func(ctx context.Context) (int, error), o *sparseOptions) {
+	ctx := c.Request.Context()
+	hdrID, err := resolve(ctx)
+	if notOK := utils.HandleErr(c, &err, "Failed to query database table wiki_urlpath: %v\n"); notOK {
+		return
+	}
+	var article *models.Article
+	if o.wants("content") {
+		article, err = articles.SelectArticleByID(ctx, hdrID)
+	} else {
+		article, err = articles.SelectArticleHeader(ctx, hdrID)
+	}
+	if notOK := utils.HandleErr(c, &err, "Failed to query database table wiki_article: %v\n"); notOK {
+		return
+	}
+	err = checkRead(c, &article.ArticleBase)
+	if notOK := utils.HandleErr(c, &err, "retrieveSparse: %v\n"); notOK {
+		return
+	}
+	if len(o.include) == 0 && notModified(c, &article.ArticleBase, o.fields != nil) {
+		return
+	}
+
+	out, err := o.pick(article)
+	if notOK := utils.HandleErr(c, &err, "retrieveSparse: %v\n"); notOK {
+		return
+	}
+	reader := identity(c).ReadFilter()
+	byLeft := func(s []models.ArticleSummary) []models.ArticleSummary {
+		sort.Slice(s, func(i, j int) bool { return s[i].Left < s[j].Left })
+		if s == nil {
+			s = []models.ArticleSummary{}
+		}
+		return s
+	}
+	if o.include["ancestors"] {
+		ancestors, err := articles.SelectArticles(ctx, models.ArticleQuery{Reader: reader, SortBy: "id",
+			Limit: article.Level + 1, ContainsLeft: article.Left, ContainsRight: article.Right, PathID: article.PathID})
+		if notOK := utils.HandleErr(c, &err, "retrieveSparse: Failed to select the ancestors: %v\n"); notOK {
+			return
+		}
+		out["ancestors"] = byLeft(ancestors)
+	}
+	if o.include["children"] {
+		lvl := article.Level + 1
+		children, err := articles.SelectArticles(ctx, models.ArticleQuery{Reader: reader, SortBy: "id",
+			Limit: maxIncludedChildren, Left: article.Left, Right: article.Right, PathID: article.PathID, Level: &lvl})
+		if notOK := utils.HandleErr(c, &err, "retrieveSparse: Failed to select the children: %v\n"); notOK {
+			return
+		}
+		out["children"] = byLeft(children)
+	}
+	if o.include["revisions"] {
+		revs, err := articles.SelectRevisions(ctx, article.ID)
+		if notOK := utils.HandleErr(c, &err, "retrieveSparse: Failed to select the revisions: %v\n"); notOK {
+			return
+		}
+		if revs == nil {
+			revs = []models.Revision{}
+		}
+		out["revisions"] = revs
+	}
+	c.JSON(http.StatusOK, out)
+}
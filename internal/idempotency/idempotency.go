@@ -0,0 +1,215 @@
+// Package idempotency makes retries of non-idempotent requests safe. A client sends
+// the header 'Idempotency-Key' with a unique value, e.g. a UUID. The first request
+// with a key is executed and its successful response is stored; a retry with the same
+// key and body gets the stored response instead of executing the request again.
+//
+// Usage:
+//   r.POST("/articles", idempotency.Middleware(s, 24*time.Hour), handler)
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"coco-life.de/wapi/internal/auth"
+	"coco-life.de/wapi/internal/models"
+	"coco-life.de/wapi/internal/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// Header is the request header carrying the key.
+const Header = "Idempotency-Key"
+
+// maxKeyLength is the maximum length of a key.
+const maxKeyLength = 255
+
+// pendingTTL is how long a key stays reserved while its request is in progress. It
+// frees the key if the server dies before storing the response.
+const pendingTTL = 5 * time.Minute
+
+var (
+	// ErrInProgress is returned if a request with the same key is still running.
+	ErrInProgress = utils.NewStatusError(http.StatusConflict, "a request with this Idempotency-Key is in progress")
+	// ErrMismatch is returned if a key is reused for a different request.
+	ErrMismatch = utils.NewStatusError(http.StatusUnprocessableEntity, "Idempotency-Key has been used for a different request")
+)
+
+// Store persists idempotency keys and the responses of their requests.
+type Store interface {
+	// ReserveIdempotencyKey stores k as in progress unless an unexpired record with
+	// the same owner and key exists. It returns nil if k has been stored and the
+	// existing record otherwise. Expired records are removed.
+	ReserveIdempotencyKey(ctx context.Context, k *models.IdempotencyKey) (*models.IdempotencyKey, error)
+	// CompleteIdempotencyKey stores the response and the expiry of a reserved key.
+	CompleteIdempotencyKey(ctx context.Context, k *models.IdempotencyKey) error
+	// ReleaseIdempotencyKey removes a key, e.g. after its request failed.
+	ReleaseIdempotencyKey(ctx context.Context, owner string, key string) error
+}
+
+// Fingerprint returns the hex encoded SHA-256 hash of method, URI and body of a
+// request. The URI includes the query, e.g. '?mode=upsert'.
+func Fingerprint(method string, uri string, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%v %v\n", method, uri)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Middleware returns a middleware that replays the stored response of requests whose
+// Idempotency-Key has been seen within ttl. Only 2xx responses are stored; after a
+// failure the key can be retried. Requests without the header are passed through. It
+// has to run after auth.Authenticate because keys are scoped to the caller.
+func Middleware(s Store, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(Header)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxKeyLength {
+			err := fmt.Errorf("%v must not be longer than %v characters", Header, maxKeyLength)
+			utils.HandleErr(c, &err, "Idempotency: %v\n")
+			c.Abort()
+			return
+		}
+		body, err := io.ReadAll(c.Request.Body)
+		if notOK := utils.HandleErr(c, &err, "Idempotency: Failed to read the request: %v\n"); notOK {
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		owner := "anonymous"
+		if id := auth.FromContext(c); id != nil && id.Key != "" {
+			owner = id.Key
+		}
+		rec := &models.IdempotencyKey{
+			Owner:       owner,
+			Key:         key,
+			Fingerprint: Fingerprint(c.Request.Method, c.Request.URL.RequestURI(), body),
+			Expires:     time.Now().Add(pendingTTL),
+		}
+		existing, err := s.ReserveIdempotencyKey(c.Request.Context(), rec)
+		if err == nil && existing != nil {
+			switch {
+			case existing.Fingerprint != rec.Fingerprint:
+				err = ErrMismatch
+			case existing.Status == 0:
+				err = ErrInProgress
+			}
+		}
+		if notOK := utils.HandleErr(c, &err, "Idempotency: %v\n"); notOK {
+			c.Abort()
+			return
+		}
+		if existing != nil {
+			replay(c, existing)
+			c.Abort()
+			return
+		}
+
+		w := &recorder{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+
+		// The response has been sent, the client may be gone already.
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		status := w.Status()
+		if status < 200 || status > 299 {
+			err = s.ReleaseIdempotencyKey(ctx, rec.Owner, rec.Key)
+		} else {
+			rec.Status = status
+			rec.ContentType = w.Header().Get("Content-Type")
+			rec.Location = w.Header().Get("Location")
+			rec.ETag = w.Header().Get("ETag")
+			rec.Body = w.body.Bytes()
+			rec.Expires = time.Now().Add(ttl)
+			err = s.CompleteIdempotencyKey(ctx, rec)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Idempotency: Failed to store key '%v' of '%v': %v\n", rec.Key, rec.Owner, err)
+		}
+	}
+}
+
+// replay sends a stored response.
+func replay(c *gin.Context, k *models.IdempotencyKey) {
+	for name, value := range map[string]string{"Location": k.Location, "ETag": k.ETag} {
+		if value != "" {
+			c.Header(name, value)
+		}
+	}
+	c.Header("Idempotent-Replayed", "true")
+	c.Data(k.Status, k.ContentType, k.Body)
+}
+
+// recorder keeps a copy of the response body.
+type recorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *recorder) WriteString(s string) (int, error) {
+	r.body.WriteString(s)
+	return r.ResponseWriter.WriteString(s)
+}
+
+// MemStore is an in-memory Store for a single instance of the API and for tests.
+type MemStore struct {
+	mu   sync.Mutex
+	keys map[[2]string]models.IdempotencyKey
+}
+
+// NewMemStore returns an empty MemStore.
+func NewMemStore() *MemStore {
+	return &MemStore{keys: map[[2]string]models.IdempotencyKey{}}
+}
+
+// ReserveIdempotencyKey stores k unless an unexpired record exists.
+func (m *MemStore) ReserveIdempotencyKey(ctx context.Context, k *models.IdempotencyKey) (*models.IdempotencyKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	for id, o := range m.keys {
+		if o.Expires.Before(now) {
+			delete(m.keys, id)
+		}
+	}
+	if o, ok := m.keys[[2]string{k.Owner, k.Key}]; ok {
+		return &o, nil
+	}
+	m.keys[[2]string{k.Owner, k.Key}] = *k
+	return nil, nil
+}
+
+// CompleteIdempotencyKey stores the response of a reserved key.
+func (m *MemStore) CompleteIdempotencyKey(ctx context.Context, k *models.IdempotencyKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cp := *k
+	cp.Body = append([]byte{}, k.Body...)
+	m.keys[[2]string{k.Owner, k.Key}] = cp
+	return nil
+}
+
+// ReleaseIdempotencyKey removes a key.
+func (m *MemStore) ReleaseIdempotencyKey(ctx context.Context, owner string, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.keys, [2]string{owner, key})
+	return nil
+}
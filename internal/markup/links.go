@@ -0,0 +1,306 @@
+package markup
+
+import (
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+)
+
+// Schemes of the links to other articles.
+const (
+	// articleScheme links by wiki_article-id, e.g. "[Install](article:7)" or
+	// "[article:7]", which has the title of the article as text.
+	articleScheme = "article:"
+	// wikiScheme links by URL path like django-wiki's wikipath extension, e.g.
+	// "[Install](wiki:/guide/install/)". Paths without a leading slash are relative to
+	// the linking article.
+	wikiScheme = "wiki:"
+)
+
+// brokenLinkClass is the class of links to articles that do not exist, as in
+// django-wiki.
+const brokenLinkClass = "linknotfound"
+
+// Target is the article a link points to.
+type Target struct {
+	// URL is where the wiki shows the article.
+	URL string
+	// ID and Path are wiki_article-id and URL path of the article.
+	ID    int
+	Path  string
+	Title string
+	// Found is false if the article does not exist or the reader may not read it.
+	Found bool
+}
+
+// Resolver resolves the links to other articles.
+type Resolver interface {
+	// ResolveID returns the article with wiki_article-id id.
+	ResolveID(id int) (Target, error)
+	// ResolvePath returns the article with the URL path, e.g. "foo/bar/". URL has to
+	// be set even if the article is not found.
+	ResolvePath(path string) (Target, error)
+}
+
+// articleLinkRe matches the shorthand "[article:ID]".
+var articleLinkRe = regexp.MustCompile(`^\[article:([0-9]+)\]`)
+
+// articleLinkParser parses "[article:ID]" into a link to "article:ID" without text.
+// "[article:ID](...)" and "[article:ID][...]" are left to the link parser.
+type articleLinkParser struct{}
+
+func (articleLinkParser) Trigger() []byte {
+	return []byte{'['}
+}
+
+func (articleLinkParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	m := articleLinkRe.FindSubmatch(line)
+	if m == nil || (len(line) > len(m[0]) && (line[len(m[0])] == '(' || line[len(m[0])] == '[')) {
+		return nil
+	}
+	block.Advance(len(m[0]))
+	link := ast.NewLink()
+	link.Destination = []byte(articleScheme + string(m[1]))
+	return link
+}
+
+// resolveLinks replaces the destinations of the links with the schemes "article:" and
+// "wiki:" by the URLs r returns. Links to articles r does not find get the class
+// brokenLinkClass. Links without text get the title of the article.
+func resolveLinks(doc ast.Node, base string, r Resolver) error {
+	return ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		link, ok := n.(*ast.Link)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		dest, fragment := string(link.Destination), ""
+		if i := strings.Index(dest, "#"); i >= 0 {
+			dest, fragment = dest[:i], dest[i:]
+		}
+
+		var t Target
+		var err error
+		switch {
+		case strings.HasPrefix(dest, articleScheme):
+			id, convErr := strconv.Atoi(strings.TrimPrefix(dest, articleScheme))
+			if convErr == nil {
+				t, err = r.ResolveID(id)
+			}
+		case strings.HasPrefix(dest, wikiScheme):
+			t, err = r.ResolvePath(joinPath(base, strings.TrimPrefix(dest, wikiScheme)))
+		default:
+			return ast.WalkContinue, nil
+		}
+		if err != nil {
+			return ast.WalkStop, err
+		}
+
+		link.Destination = []byte(t.URL + fragment)
+		if t.URL == "" {
+			link.Destination = []byte("#")
+		}
+		if !t.Found {
+			link.SetAttributeString("class", []byte(brokenLinkClass))
+		}
+		if !link.HasChildren() {
+			label := t.Title
+			if label == "" {
+				label = dest
+			}
+			link.AppendChild(link, ast.NewString([]byte(label)))
+		}
+		return ast.WalkSkipChildren, nil
+	})
+}
+
+// joinPath returns the URL path of the article p points to from the article with the
+// URL path base, e.g. "foo/baz/" for base "foo/bar/" and p "../baz".
+func joinPath(base string, p string) string {
+	if !strings.HasPrefix(p, "/") {
+		p = "/" + base + "/" + p
+	}
+	p = strings.Trim(path.Clean(p), "/")
+	if p == "" {
+		return ""
+	}
+	return p + "/"
+}
+
+// slugRe matches the slugs django-wiki allows.
+var slugRe = regexp.MustCompile(`^[-\w]+$`)
+
+// Link is a link to another article.
+type Link struct {
+	// ArticleID is wiki_article-id of a link with the scheme "article:", else 0.
+	ArticleID int
+	// Path is the URL path of other links, e.g. "foo/bar/" or "" for the root article.
+	Path string
+}
+
+// Links returns the distinct links of the Markdown src of the article with the URL
+// path base to other articles in document order. Besides the links with the schemes
+// "article:" and "wiki:" these are links to absolute paths, e.g.
+// "[Install](/guide/install/)", whose segments are slugs. Segments starting with "_"
+// denote django-wiki's views, e.g. "/_search/", and are no articles.
+func Links(src string, base string) []Link {
+	source := []byte(src)
+	doc := md.Parser().Parse(text.NewReader(source))
+	var links []Link
+	seen := map[Link]bool{}
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		link, ok := n.(*ast.Link)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		dest := string(link.Destination)
+		if i := strings.IndexAny(dest, "?#"); i >= 0 {
+			dest = dest[:i]
+		}
+		var l Link
+		if strings.HasPrefix(dest, articleScheme) {
+			id, err := strconv.Atoi(strings.TrimPrefix(dest, articleScheme))
+			if err != nil {
+				return ast.WalkSkipChildren, nil
+			}
+			l.ArticleID = id
+		} else if p, ok := linkPath(dest, base); ok {
+			l.Path = p
+		} else {
+			return ast.WalkSkipChildren, nil
+		}
+		if !seen[l] {
+			seen[l] = true
+			links = append(links, l)
+		}
+		return ast.WalkSkipChildren, nil
+	})
+	return links
+}
+
+// linkPath returns the URL path of the article the destination dest without query and
+// fragment points to from the article with the URL path base, see Links. ok is false
+// for destinations that are no paths of articles.
+func linkPath(dest string, base string) (p string, ok bool) {
+	switch {
+	case strings.HasPrefix(dest, wikiScheme):
+		return joinPath(base, strings.TrimPrefix(dest, wikiScheme)), true
+	case strings.HasPrefix(dest, "/") && !strings.HasPrefix(dest, "//"):
+		for _, s := range strings.Split(strings.Trim(dest, "/"), "/") {
+			if s != "" && (!slugRe.MatchString(s) || strings.HasPrefix(s, "_")) {
+				return "", false
+			}
+		}
+		return joinPath("", dest), true
+	}
+	return "", false
+}
+
+// destRe matches the destinations of inline links, e.g. "](/a/b/)", and of link
+// reference definitions, e.g. "[a]: /a/b/". Submatch 1 is the destination.
+var destRe = regexp.MustCompile(`(?m)(?:\]\(|^ {0,3}\[[^\]\n]+\]:)[ \t]*<?([^\s<>()]+)`)
+
+// RewriteLinks returns the Markdown src of an article that moved from the URL path
+// base to newBase with its links to other articles, see Links, adapted to moved
+// targets. rewrite returns the new URL path of a target and true if the target has
+// moved. Relative "wiki:" links become absolute if they would point elsewhere from
+// newBase. Query and fragment are kept, code spans and blocks are left alone.
+func RewriteLinks(src string, base string, newBase string, rewrite func(path string) (string, bool)) string {
+	code := codeRanges(md.Parser().Parse(text.NewReader([]byte(src))))
+	var b strings.Builder
+	last := 0
+	for _, m := range destRe.FindAllStringSubmatchIndex(src, -1) {
+		start, end := m[2], m[3]
+		if inRanges(code, start) {
+			continue
+		}
+		if dest, ok := rewriteDest(src[start:end], base, newBase, rewrite); ok {
+			b.WriteString(src[last:start])
+			b.WriteString(dest)
+			last = end
+		}
+	}
+	b.WriteString(src[last:])
+	return b.String()
+}
+
+// rewriteDest returns the destination dest of a link in an article that moved from
+// base to newBase adapted to its moved target, see RewriteLinks. ok is false if dest
+// does not change.
+func rewriteDest(dest string, base string, newBase string, rewrite func(path string) (string, bool)) (string, bool) {
+	p, suffix := dest, ""
+	if i := strings.IndexAny(p, "?#"); i >= 0 {
+		p, suffix = p[:i], p[i:]
+	}
+	target, ok := linkPath(p, base)
+	if !ok {
+		return "", false
+	}
+	newTarget, moved := rewrite(target)
+	if !moved {
+		newTarget = target
+	}
+	if strings.HasPrefix(p, wikiScheme) && !strings.HasPrefix(p, wikiScheme+"/") {
+		if t, _ := linkPath(p, newBase); t == newTarget {
+			return "", false
+		}
+	} else if !moved {
+		return "", false
+	}
+
+	prefix := "/"
+	if strings.HasPrefix(p, wikiScheme) {
+		prefix = wikiScheme + "/"
+	}
+	out := prefix + newTarget
+	if !strings.HasSuffix(p, "/") && newTarget != "" {
+		out = strings.TrimSuffix(out, "/")
+	}
+	return out + suffix, true
+}
+
+// codeRanges returns the ranges of the source of doc that are code or raw HTML.
+func codeRanges(doc ast.Node) [][2]int {
+	var ranges [][2]int
+	add := func(segs ...text.Segment) {
+		for _, s := range segs {
+			ranges = append(ranges, [2]int{s.Start, s.Stop})
+		}
+	}
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.FencedCodeBlock, *ast.CodeBlock, *ast.HTMLBlock:
+			add(n.Lines().Sliced(0, n.Lines().Len())...)
+			return ast.WalkSkipChildren, nil
+		case *ast.CodeSpan:
+			for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+				if t, ok := c.(*ast.Text); ok {
+					add(t.Segment)
+				}
+			}
+			return ast.WalkSkipChildren, nil
+		case *ast.RawHTML:
+			add(n.Segments.Sliced(0, n.Segments.Len())...)
+		}
+		return ast.WalkContinue, nil
+	})
+	return ranges
+}
+
+// inRanges reports whether the offset i lies within one of ranges.
+func inRanges(ranges [][2]int, i int) bool {
+	for _, r := range ranges {
+		if i >= r[0] && i < r[1] {
+			return true
+		}
+	}
+	return false
+}
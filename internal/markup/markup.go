@@ -0,0 +1,78 @@
+// Package markup renders the Markdown of django-wiki articles to HTML. The renderer is
+// configured like the one of django-wiki, that is, Python-Markdown with the extension
+// "extra": Besides CommonMark with fenced code blocks it supports tables, footnotes,
+// definition lists and attribute lists, the marker [TOC] and links to other articles.
+//
+// Raw HTML in the Markdown is dropped, attribute lists only set "id" and "class" and
+// links with dangerous schemes like "javascript:" lose their target, so the output is
+// safe to embed into other pages.
+package markup
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// md is the Markdown renderer. It never renders raw HTML.
+var md = goldmark.New(
+	goldmark.WithExtensions(extension.Table, extension.Footnote, extension.DefinitionList),
+	goldmark.WithParserOptions(
+		parser.WithAttribute(),
+		parser.WithInlineParsers(util.Prioritized(articleLinkParser{}, 199)),
+	),
+	goldmark.WithRendererOptions(
+		renderer.WithNodeRenderers(util.Prioritized(tocRenderer{}, 500)),
+	),
+)
+
+// HTML renders the Markdown src of the article with the URL path base, e.g. "foo/bar/",
+// to HTML. r resolves the links to other articles, see Resolver. The first error of r
+// aborts rendering.
+func HTML(src string, base string, r Resolver) (string, error) {
+	source := []byte(src)
+	doc := md.Parser().Parse(text.NewReader(source))
+	stripAttributes(doc)
+	if err := resolveLinks(doc, base, r); err != nil {
+		return "", err
+	}
+	insertTOC(doc, source, anchorHeadings(doc, source))
+
+	var buf bytes.Buffer
+	if err := md.Renderer().Render(&buf, source, doc); err != nil {
+		return "", fmt.Errorf("Failed to render the Markdown: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// allowedAttributes are the attributes an attribute list like "{#setup .note}" may
+// set. Others, e.g. "style" or "onclick", would bypass the sanitizing.
+var allowedAttributes = map[string]bool{"id": true, "class": true}
+
+// stripAttributes removes all attributes of the nodes of doc but allowedAttributes.
+func stripAttributes(doc ast.Node) {
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering || n.Attributes() == nil {
+			return ast.WalkContinue, nil
+		}
+		var kept []ast.Attribute
+		for _, attr := range n.Attributes() {
+			if allowedAttributes[strings.ToLower(string(attr.Name))] {
+				kept = append(kept, attr)
+			}
+		}
+		n.RemoveAttributes()
+		for _, attr := range kept {
+			n.SetAttribute(attr.Name, attr.Value)
+		}
+		return ast.WalkContinue, nil
+	})
+}
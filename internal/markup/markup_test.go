@@ -0,0 +1,143 @@
+package markup
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mapResolver resolves the articles of a map from URL path to ID.
+type mapResolver map[string]int
+
+func (m mapResolver) ResolveID(id int) (Target, error) {
+	for path, v := range m {
+		if v == id {
+			return Target{URL: "https://wiki/" + path, Title: "Title " + path, Found: true}, nil
+		}
+	}
+	return Target{}, nil
+}
+
+func (m mapResolver) ResolvePath(path string) (Target, error) {
+	_, ok := m[path]
+	return Target{URL: "https://wiki/" + path, Found: ok}, nil
+}
+
+// Attribute lists cannot add styles or event handlers.
+func TestHTMLAttributes(t *testing.T) {
+	onRe := regexp.MustCompile(`(?i)\son\w+=`)
+	for _, src := range []string{
+		`# H {style="color:red"}`,
+		`# H {onclick="alert(1)" .note}`,
+		"Setext {ONMOUSEOVER=\"alert(1)\" #s}\n===",
+		`## H {#h style="x" data-x="y" on="z"}`,
+	} {
+		html, err := HTML(src, "", mapResolver{})
+		assert.Nil(t, err)
+		assert.NotContains(t, html, "style=", src)
+		assert.NotContains(t, html, "data-x", src)
+		assert.False(t, onRe.MatchString(html), "%v: %v", src, html)
+	}
+}
+
+func TestHTML(t *testing.T) {
+	r := mapResolver{"": 1, "guide/": 2, "guide/install/": 3}
+	cases := []struct {
+		descr string
+		src   string
+		exp   string
+	}{
+		{"Heading anchors", "# Über uns!\n## Über uns!",
+			"<h1 id=\"wiki-toc-uber-uns\">Über uns!</h1>\n<h2 id=\"wiki-toc-uber-uns_1\">Über uns!</h2>\n"},
+		{"Explicit anchor", "## Setup {#setup}", "<h2 id=\"setup\">Setup</h2>\n"},
+		{"Attributes besides id and class", `# H {style="color:red" onclick="alert(1)" OnMouseOver="x" .note #h}`,
+			"<h1 class=\"note\" id=\"h\">H</h1>\n"},
+		{"Table", "| a | b |\n|---|---|\n| 1 | 2 |",
+			"<table>\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>\n</tr>\n</thead>\n<tbody>\n<tr>\n<td>1</td>\n<td>2</td>\n</tr>\n</tbody>\n</table>\n"},
+		{"Fenced code", "```go\n[article:2]\n```", "<pre><code class=\"language-go\">[article:2]\n</code></pre>\n"},
+		{"Raw HTML", "<script>alert(1)</script>\n\nx <b>y</b>",
+			"<!-- raw HTML omitted -->\n<p>x <!-- raw HTML omitted -->y<!-- raw HTML omitted --></p>\n"},
+		{"Dangerous URL", "[x](javascript:alert(1))", "<p><a href=\"\">x</a></p>\n"},
+		{"Article by ID", "See [article:3].", "<p>See <a href=\"https://wiki/guide/install/\">Title guide/install/</a>.</p>\n"},
+		{"Article by ID with text", "[here](article:2#top)", "<p><a href=\"https://wiki/guide/#top\">here</a></p>\n"},
+		{"Missing article", "[article:9]", "<p><a href=\"#\" class=\"linknotfound\">article:9</a></p>\n"},
+		{"Absolute wiki path", "[i](wiki:/guide/install)", "<p><a href=\"https://wiki/guide/install/\">i</a></p>\n"},
+		{"Relative wiki path", "[i](wiki:install)", "<p><a href=\"https://wiki/guide/install/\">i</a></p>\n"},
+		{"Parent wiki path", "[r](wiki:..)", "<p><a href=\"https://wiki/\">r</a></p>\n"},
+		{"Broken wiki path", "[n](wiki:new)", "<p><a href=\"https://wiki/guide/new/\" class=\"linknotfound\">n</a></p>\n"},
+		{"Other links", "[a](/x/) [b](https://example.com)",
+			"<p><a href=\"/x/\">a</a> <a href=\"https://example.com\">b</a></p>\n"},
+		{"TOC", "[TOC]\n\n## A\n### B\n## C",
+			"<div class=\"toc\"><span class=\"toctitle\">Contents</span><ul>\n" +
+				"<li><a href=\"#wiki-toc-a\">A</a><ul>\n<li><a href=\"#wiki-toc-b\">B</a></li>\n</ul>\n</li>\n" +
+				"<li><a href=\"#wiki-toc-c\">C</a></li>\n</ul>\n</div>\n" +
+				"<h2 id=\"wiki-toc-a\">A</h2>\n<h3 id=\"wiki-toc-b\">B</h3>\n<h2 id=\"wiki-toc-c\">C</h2>\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.descr, func(t *testing.T) {
+			html, err := HTML(tc.src, "guide/", r)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.exp, html)
+		})
+	}
+}
+
+func TestTOC(t *testing.T) {
+	src := "# Guide\n\n[TOC]\n\n## *Quick* start\n\n```\n# no heading\n```\n\nSetup\n-----\n\n## Setup {#own}\n\n## Setup\n"
+	assert.Equal(t, []Heading{
+		{Level: 1, Text: "Guide", Anchor: "wiki-toc-guide"},
+		{Level: 2, Text: "Quick start", Anchor: "wiki-toc-quick-start"},
+		{Level: 2, Text: "Setup", Anchor: "wiki-toc-setup"},
+		{Level: 2, Text: "Setup", Anchor: "own"},
+		{Level: 2, Text: "Setup", Anchor: "wiki-toc-setup_1"},
+	}, TOC(src))
+	assert.Equal(t, []Heading{}, TOC("no headings"))
+}
+
+func TestLinks(t *testing.T) {
+	src := "[a](article:7) [article:7] [b](wiki:../c#x) [d](/d/e/?q=1) [r](/) [e](/d/e/)\n\n" +
+		"[s](/_search/) [f](/static/x.css) [h](https://example.com/a/) [p](//host/a/) [m](mailto:x@example.com)\n\n" +
+		"`[article:8]` ![img](/images/x/)"
+	assert.Equal(t, []Link{{ArticleID: 7}, {Path: "guide/c/"}, {Path: "d/e/"}, {Path: ""}}, Links(src, "guide/install/"))
+	assert.Nil(t, Links("no links", ""))
+}
+
+func TestRewriteLinks(t *testing.T) {
+	// guide/ moved to docs/guide/, the article moved from guide/install/ to
+	// docs/guide/install/.
+	rewrite := func(p string) (string, bool) {
+		if strings.HasPrefix(p, "guide/") {
+			return "docs/" + p, true
+		}
+		return p, false
+	}
+	cases := []struct {
+		descr string
+		src   string
+		base  string
+		exp   string
+	}{
+		{"Absolute path", "[a](/guide/install/#top) [b](/guide?x=1) [c](/faq/)", "faq/",
+			"[a](/docs/guide/install/#top) [b](/docs/guide?x=1) [c](/faq/)"},
+		{"Wiki path", "[a](wiki:/guide/install) [b](<wiki:/guide/>)", "faq/",
+			"[a](wiki:/docs/guide/install) [b](<wiki:/docs/guide/>)"},
+		{"Relative path", "[a](wiki:../guide/install/) [b](wiki:guide)", "",
+			"[a](wiki:/docs/guide/install/) [b](wiki:/docs/guide)"},
+		{"Relative path within the subtree", "[a](wiki:../) [b](wiki:step/) [c](wiki:../../faq)", "guide/install/",
+			"[a](wiki:../) [b](wiki:step/) [c](wiki:/faq)"},
+		{"Reference definition", "[a][x]\n\n[x]: /guide/ \"Guide\"\n", "",
+			"[a][x]\n\n[x]: /docs/guide/ \"Guide\"\n"},
+		{"Code", "`[a](/guide/)`\n\n```\n[a](/guide/)\n```\n\n    [a](/guide/)\n", "",
+			"`[a](/guide/)`\n\n```\n[a](/guide/)\n```\n\n    [a](/guide/)\n"},
+		{"Other links", "[a](article:2) [b](https://example.com/guide/) [c](/_search/?q=guide)", "",
+			"[a](article:2) [b](https://example.com/guide/) [c](/_search/?q=guide)"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.descr, func(t *testing.T) {
+			newBase, _ := rewrite(tc.base)
+			assert.Equal(t, tc.exp, RewriteLinks(tc.src, tc.base, newBase, rewrite))
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package markup
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+	"golang.org/x/text/unicode/norm"
+)
+
+// tocMarker is a paragraph replaced by the table of contents.
+const tocMarker = "[TOC]"
+
+// tocTitle is the title of the table of contents as in django-wiki.
+const tocTitle = "Contents"
+
+// anchorPrefix is the prefix django-wiki gives the anchors of the headings.
+const anchorPrefix = "wiki-toc-"
+
+// Heading is a heading of an article.
+type Heading struct {
+	// Level is 1 for <h1> to 6 for <h6>.
+	Level int `json:"level"`
+	// Text is the text of the heading without markup.
+	Text string `json:"text"`
+	// Anchor is the id of the heading, e.g. "wiki-toc-quick-start".
+	Anchor string `json:"anchor"`
+}
+
+var (
+	nonWordRe = regexp.MustCompile(`[^\w\s-]`)
+	dashesRe  = regexp.MustCompile(`[-\s]+`)
+	countedRe = regexp.MustCompile(`^(.*)_([0-9]+)$`)
+)
+
+// Slugify returns the slug of s like Django's slugify: Accents are removed, other
+// non-ASCII characters and punctuation dropped and runs of spaces and dashes replaced
+// by a single dash, e.g. "uber-uns" for "Über uns!".
+func Slugify(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFKD.String(s) {
+		if r < utf8.RuneSelf {
+			b.WriteRune(r)
+		}
+	}
+	s = strings.ToLower(strings.TrimSpace(nonWordRe.ReplaceAllString(b.String(), "")))
+	return dashesRe.ReplaceAllString(s, "-")
+}
+
+// slugify returns the anchor of a heading like django-wiki's wiki_slugify, e.g.
+// "wiki-toc-uber-uns" for "Über uns!".
+func slugify(heading string) string {
+	return anchorPrefix + Slugify(heading)
+}
+
+// unique makes id unique among used like Python-Markdown by appending "_1" or
+// incrementing a trailing number, and adds it to used.
+func unique(id string, used map[string]bool) string {
+	for used[id] {
+		if m := countedRe.FindStringSubmatch(id); m != nil {
+			n, _ := strconv.Atoi(m[2])
+			id = fmt.Sprintf("%s_%d", m[1], n+1)
+		} else {
+			id += "_1"
+		}
+	}
+	used[id] = true
+	return id
+}
+
+// TOC returns the headings of the Markdown src in document order with the anchors HTML
+// gives them.
+func TOC(src string) []Heading {
+	source := []byte(src)
+	headings := anchorHeadings(md.Parser().Parse(text.NewReader(source)), source)
+	if headings == nil {
+		headings = []Heading{}
+	}
+	return headings
+}
+
+// anchorHeadings gives every heading of doc without an id, see attribute lists, the
+// anchor of its text and returns the headings in document order.
+func anchorHeadings(doc ast.Node, source []byte) []Heading {
+	var headings []Heading
+	used := map[string]bool{}
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		h, ok := n.(*ast.Heading)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		title := string(h.Text(source))
+		var id string
+		if v, ok := h.AttributeString("id"); ok {
+			if b, ok := v.([]byte); ok {
+				id = string(b)
+				used[id] = true
+			}
+		}
+		if id == "" {
+			id = unique(slugify(title), used)
+			h.SetAttributeString("id", []byte(id))
+		}
+		headings = append(headings, Heading{Level: h.Level, Text: title, Anchor: id})
+		return ast.WalkSkipChildren, nil
+	})
+	return headings
+}
+
+// KindTOC is the kind of the table of contents.
+var KindTOC = ast.NewNodeKind("TOC")
+
+// tocNode is the table of contents of an article.
+type tocNode struct {
+	ast.BaseBlock
+	headings []Heading
+}
+
+func (n *tocNode) Kind() ast.NodeKind {
+	return KindTOC
+}
+
+func (n *tocNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// insertTOC replaces the top-level paragraphs consisting of tocMarker by the table of
+// contents of headings.
+func insertTOC(doc ast.Node, source []byte, headings []Heading) {
+	for n := doc.FirstChild(); n != nil; n = n.NextSibling() {
+		if p, ok := n.(*ast.Paragraph); ok && strings.TrimSpace(string(p.Text(source))) == tocMarker {
+			toc := &tocNode{headings: headings}
+			doc.ReplaceChild(doc, p, toc)
+			n = toc
+		}
+	}
+}
+
+// tocRenderer renders the table of contents like Python-Markdown as nested lists.
+type tocRenderer struct{}
+
+func (r tocRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindTOC, r.render)
+}
+
+func (r tocRenderer) render(w util.BufWriter, source []byte, n ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	w.WriteString(`<div class="toc"><span class="toctitle">` + tocTitle + "</span>")
+	// levels are the levels of the open lists.
+	var levels []int
+	for _, h := range n.(*tocNode).headings {
+		switch {
+		case len(levels) == 0 || h.Level > levels[len(levels)-1]:
+			w.WriteString("<ul>\n")
+			levels = append(levels, h.Level)
+		default:
+			w.WriteString("</li>\n")
+			for len(levels) > 1 && h.Level < levels[len(levels)-1] && h.Level <= levels[len(levels)-2] {
+				w.WriteString("</ul>\n</li>\n")
+				levels = levels[:len(levels)-1]
+			}
+		}
+		fmt.Fprintf(w, `<li><a href="#%s">%s</a>`, util.EscapeHTML([]byte(h.Anchor)), util.EscapeHTML([]byte(h.Text)))
+	}
+	for range levels {
+		w.WriteString("</li>\n</ul>\n")
+	}
+	w.WriteString("</div>\n")
+	return ast.WalkSkipChildren, nil
+}
@@ -1,6 +1,9 @@
 package models
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // Resource is the result of an API call.
 type Resource interface {
@@ -28,6 +31,109 @@ type ArticleBase struct {
 	PathID int `json:"path_id" db:"path_id"`
     Left   int `json:"left" db:"lft"`
 	Right  int `json:"right" db:"rght"`
+	// Deleted is 'true' if the current revision marks the article as deleted.
+	Deleted bool `json:"deleted" db:"deleted"`
+	// Locked is 'true' if the current revision is locked, see RevisionMeta.
+	Locked bool `json:"locked" db:"locked"`
+	// Permissions are always set by the store. In a POST or PUT payload the nested
+	// object is read as PermissionsInput.
+	Permissions *Permissions `json:"permissions,omitempty" db:"-"`
+}
+
+// RevisionMeta are the columns of a new wiki_articlerevision record besides title and
+// content. They are shown on the history page of Django Wiki.
+type RevisionMeta struct {
+	// UserID is auth_user-id of the author, 0 if unknown.
+	UserID int
+	// IPAddress is the IP address of the author, "" if unknown.
+	IPAddress string
+	// UserMessage is the message the author entered, like a commit message.
+	UserMessage string
+	// AutomaticLog describes the change, e.g. "Moved from /a/ to /b/a/".
+	AutomaticLog string
+	// Deleted marks the article as deleted.
+	Deleted bool
+	// Locked protects the article against changes by anyone but moderators.
+	Locked bool
+}
+
+// Permissions are the django-wiki permissions of an article stored in wiki_article.
+// The owner may always read and write, members of the group if group_read/group_write
+// are set and all other users if other_read/other_write are set.
+type Permissions struct {
+	// OwnerID is auth_user-id of the owner, 0 if none.
+	OwnerID int `json:"owner_id" db:"owner_id"`
+	// GroupID is auth_group-id of the group, 0 if none.
+	GroupID    int  `json:"group_id" db:"group_id"`
+	GroupRead  bool `json:"group_read" db:"group_read"`
+	GroupWrite bool `json:"group_write" db:"group_write"`
+	OtherRead  bool `json:"other_read" db:"other_read"`
+	OtherWrite bool `json:"other_write" db:"other_write"`
+}
+
+// DefaultPermissions are the permissions of django-wiki's wiki_article model
+// defaults: Everybody may read and write.
+var DefaultPermissions = Permissions{GroupRead: true, GroupWrite: true, OtherRead: true, OtherWrite: true}
+
+// ReadFilter restricts a query to the articles a caller may read, see
+// auth.Identity.ReadFilter.
+type ReadFilter struct {
+	// All lets the caller read every article.
+	All bool
+	// UserID is auth_user-id of the caller, 0 if none.
+	UserID int
+	// GroupIDs are auth_group-id of the groups of the caller.
+	GroupIDs []int
+}
+
+// Allows reports whether the filter lets an article with permissions p pass.
+func (f ReadFilter) Allows(p Permissions) bool {
+	if f.All || p.OtherRead || (f.UserID != 0 && f.UserID == p.OwnerID) {
+		return true
+	}
+	if p.GroupRead && f.UserID != 0 && p.GroupID != 0 {
+		for _, g := range f.GroupIDs {
+			if g == p.GroupID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PermissionsInput are the permissions of a POST or PUT payload, that is, the object
+// 'permissions'. Omitted fields keep their current value. An ID of 0 clears the owner
+// or the group.
+type PermissionsInput struct {
+	OwnerID    *int  `json:"owner_id"`
+	GroupID    *int  `json:"group_id"`
+	GroupRead  *bool `json:"group_read"`
+	GroupWrite *bool `json:"group_write"`
+	OtherRead  *bool `json:"other_read"`
+	OtherWrite *bool `json:"other_write"`
+}
+
+// Apply returns p overridden by all given fields of in.
+func (in PermissionsInput) Apply(p Permissions) Permissions {
+	if in.OwnerID != nil {
+		p.OwnerID = *in.OwnerID
+	}
+	if in.GroupID != nil {
+		p.GroupID = *in.GroupID
+	}
+	if in.GroupRead != nil {
+		p.GroupRead = *in.GroupRead
+	}
+	if in.GroupWrite != nil {
+		p.GroupWrite = *in.GroupWrite
+	}
+	if in.OtherRead != nil {
+		p.OtherRead = *in.OtherRead
+	}
+	if in.OtherWrite != nil {
+		p.OtherWrite = *in.OtherWrite
+	}
+	return p
 }
 
 // RootArticle is the root Wiki article.
@@ -99,3 +205,208 @@ func (a Article) IsRoot() bool {
 func (a RootArticle) IsRoot() bool {
 	return true
 }
+
+// APIToken is an API token stored in wapi_apitoken. Only the SHA-256 hash of the
+// token is stored, the token itself is shown once on creation.
+type APIToken struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// Hash is the hex encoded SHA-256 hash of the token.
+	Hash string `json:"-" db:"token_hash"`
+	// Scope is one of 'read', 'write' or 'admin'.
+	Scope string `json:"scope"`
+	// UserID is auth_user-id of the Django user the token acts as, 0 if none.
+	UserID  int        `json:"user_id" db:"user_id"`
+	Created time.Time  `json:"created"`
+	Revoked *time.Time `json:"revoked,omitempty"`
+}
+
+// ArticleNode is an article with its descendants, e.g. of a bulk import.
+type ArticleNode struct {
+	Slug     string        `json:"slug"`
+	Title    string        `json:"title"`
+	Content  string        `json:"content"`
+	Children []ArticleNode `json:"children"`
+}
+
+// CopyOptions control how ArticleStore.CopyWikiArticles copies a subtree.
+type CopyOptions struct {
+	// Slug is the slug of the copy of the subtree's root, "" keeps its slug.
+	Slug string
+	// OwnerID owns the copies, 0 for none. Group and flags are copied.
+	OwnerID int
+	// History copies all revisions. Otherwise, the current revision of an article
+	// becomes the first revision of its copy with Meta, keeping 'deleted' and 'locked'.
+	History bool
+	Meta    RevisionMeta
+}
+
+// ArticleQuery filters, sorts and pages a listing of articles. Zero values and nil
+// pointers do not filter.
+type ArticleQuery struct {
+	// TitlePrefix matches the beginning of the title ignoring case.
+	TitlePrefix string
+	Slug        string
+	Level       *int
+	// ModifiedSince and CreatedSince match articles modified or created at or after
+	// the time.
+	ModifiedSince time.Time
+	CreatedSince  time.Time
+	// Left and Right restrict the listing to the descendants of the node spanning
+	// [Left, Right]. Both are 0 to list all articles.
+	Left  int
+	Right int
+	// ContainsLeft and ContainsRight restrict the listing to the ancestors of the node
+	// spanning [ContainsLeft, ContainsRight]. Both are 0 to list all articles.
+	ContainsLeft  int
+	ContainsRight int
+	// PathID is wiki_urlpath-id of the node given by Left and Right or by ContainsLeft
+	// and ContainsRight. It selects the tree, as 'lft' and 'rght' of the trees of
+	// django-wiki overlap.
+	PathID      int
+	HasChildren *bool
+	Deleted     *bool
+	// IDs restricts the listing to the articles with the given wiki_article-ids unless
+	// it is nil.
+	IDs []int
+	// Reader restricts the listing to the articles the caller may read.
+	Reader ReadFilter
+
+	// SortBy is "id" or "modified". Articles modified at the same time are sorted by
+	// ID.
+	SortBy string
+	Desc   bool
+	// After is the key of the last article of the previous page, nil for the first
+	// page.
+	After *ArticleKey
+	Limit int
+	// WithContent includes the content in the summaries.
+	WithContent bool
+}
+
+// ArticleKey is the position of an article in a listing sorted by ArticleQuery.SortBy.
+type ArticleKey struct {
+	ID       int
+	Modified time.Time
+}
+
+// ArticleSummary is an article of a listing.
+type ArticleSummary struct {
+	ID          int    `json:"id" db:"id"`
+	RevisionID  int    `json:"revision_id" db:"rev_id"`
+	ParentArtID int    `json:"parent_art_id" db:"parent_art_id"`
+	Slug        string `json:"slug" db:"slug"`
+	// Path is the URL path like Django Wiki shows it, e.g. "a/b/".
+	Path  string `json:"path" db:"path"`
+	Title string `json:"title" db:"title"`
+	Level int    `json:"level" db:"level"`
+	Left  int    `json:"left" db:"lft"`
+	Right int    `json:"right" db:"rght"`
+	// Created is the creation of the article, Modified of its current revision.
+	Created     time.Time `json:"created" db:"created"`
+	Modified    time.Time `json:"modified" db:"modified"`
+	Deleted     bool      `json:"deleted" db:"deleted"`
+	Locked      bool      `json:"locked" db:"locked"`
+	HasChildren bool      `json:"has_children" db:"has_children"`
+	// Content is only set if requested by ArticleQuery.WithContent.
+	Content *string `json:"content,omitempty" db:"content"`
+}
+
+// Key returns the position of the article in a listing.
+func (a *ArticleSummary) Key() ArticleKey {
+	return ArticleKey{ID: a.ID, Modified: a.Modified}
+}
+
+// Revision is a record of wiki_articlerevision without the content, as shown on the
+// history page of Django Wiki.
+type Revision struct {
+	ID             int       `json:"id" db:"id"`
+	RevisionNumber int       `json:"revision_number" db:"revision_number"`
+	Title          string    `json:"title" db:"title"`
+	Created        time.Time `json:"created" db:"created"`
+	// UserID is auth_user-id of the author, 0 if unknown.
+	UserID       int    `json:"user_id" db:"user_id"`
+	UserMessage  string `json:"user_message" db:"user_message"`
+	AutomaticLog string `json:"automatic_log" db:"automatic_log"`
+	Deleted      bool   `json:"deleted" db:"deleted"`
+	Locked       bool   `json:"locked" db:"locked"`
+}
+
+// ArticleLink is a link in the current revision of an article to another article, see
+// markup.Links. Exactly one of ToID and ToPath is set.
+type ArticleLink struct {
+	FromID int `json:"from_id" db:"from_article_id"`
+	// ToID is wiki_article-id of a link by ID like [article:7], else 0.
+	ToID int `json:"to_id,omitempty" db:"to_article_id"`
+	// ToPath is the absolute URL path of a link by path like
+	// [Install](/guide/install/), e.g. "/guide/install/" or "/" for the root article,
+	// else "".
+	ToPath string `json:"to_path,omitempty" db:"to_path"`
+}
+
+// LinkSource is the current revision of an article whose links are to be indexed.
+type LinkSource struct {
+	ArticleID  int    `db:"article_id"`
+	RevisionID int    `db:"rev_id"`
+	Path       string `db:"path"`
+	Content    string `db:"content"`
+}
+
+// SearchQuery is a full-text search over the current revisions of all articles that
+// are not deleted.
+type SearchQuery struct {
+	// Query is in the syntax of web search engines, e.g. `wiki -django "full text"`.
+	Query string
+	// Config is the Postgres text search configuration, e.g. "english".
+	Config string
+	// Left and Right restrict the search to the subtree spanning [Left, Right]. Both
+	// are 0 to search all articles. PathID is wiki_urlpath-id of the root of the
+	// subtree, which selects its tree, see ArticleQuery.
+	Left   int
+	Right  int
+	PathID int
+	// Reader restricts the search to the articles the caller may read.
+	Reader ReadFilter
+	Limit  int
+	Offset int
+}
+
+// SearchHit is an article matching a SearchQuery.
+type SearchHit struct {
+	ID    int    `json:"id" db:"id"`
+	Path  string `json:"path" db:"path"`
+	Title string `json:"title" db:"title"`
+	// Snippet is an excerpt of the content with the matches enclosed in <mark> and
+	// </mark>. The content is not escaped.
+	Snippet string  `json:"snippet" db:"snippet"`
+	Rank    float64 `json:"rank" db:"rank"`
+}
+
+// IdempotencyKey is the record of a request carrying an Idempotency-Key header and
+// its response, stored in wapi_idempotencykey.
+type IdempotencyKey struct {
+	// Owner is the name of the caller, keys of different callers never clash.
+	Owner string `db:"owner"`
+	Key   string `db:"key"`
+	// Fingerprint is the hex encoded SHA-256 hash of method, path and body of the
+	// request.
+	Fingerprint string `db:"fingerprint"`
+	// Status is the HTTP status of the response, 0 while the request is in progress.
+	Status      int    `db:"status"`
+	ContentType string `db:"content_type"`
+	Location    string `db:"location"`
+	ETag        string `db:"etag"`
+	Body        []byte `db:"body"`
+	// Expires is the time after which the key may be reused.
+	Expires time.Time `db:"expires"`
+}
+
+// AuthUser is a Django user stored in auth_user.
+type AuthUser struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+	// Password is the encoded password hash, e.g. 'pbkdf2_sha256$<iterations>$<salt>$<hash>'.
+	Password    string `json:"-"`
+	IsActive    bool   `json:"is_active" db:"is_active"`
+	IsSuperuser bool   `json:"is_superuser" db:"is_superuser"`
+}
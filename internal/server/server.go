@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Config holds the listen address and the timeouts of the HTTP server.
+type Config struct {
+	Addr              string
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// DrainDelay is the time the server keeps serving after it has been flagged as not
+	// ready. It gives the load balancer the chance to notice the failing readiness probe
+	// and stop routing new requests to this instance.
+	DrainDelay time.Duration
+	// ShutdownTimeout is the maximum time to wait for in-flight requests to finish.
+	ShutdownTimeout time.Duration
+}
+
+// DefaultConfig returns the configuration used if no environment variables are set.
+func DefaultConfig() Config {
+	return Config{
+		Addr:              ":8080",
+		ReadTimeout:       15 * time.Second,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       60 * time.Second,
+		DrainDelay:        5 * time.Second,
+		ShutdownTimeout:   20 * time.Second,
+	}
+}
+
+// ConfigFromEnv returns DefaultConfig overridden by the following environment
+// variables. Durations use the format of time.ParseDuration, e.g. "10s".
+//   WAPI_ADDR
+//   WAPI_READ_TIMEOUT
+//   WAPI_READ_HEADER_TIMEOUT
+//   WAPI_WRITE_TIMEOUT
+//   WAPI_IDLE_TIMEOUT
+//   WAPI_DRAIN_DELAY
+//   WAPI_SHUTDOWN_TIMEOUT
+func ConfigFromEnv() (Config, error) {
+	cfg := DefaultConfig()
+	if addr := os.Getenv("WAPI_ADDR"); addr != "" {
+		cfg.Addr = addr
+	}
+	durations := []struct {
+		env string
+		dst *time.Duration
+	}{
+		{"WAPI_READ_TIMEOUT", &cfg.ReadTimeout},
+		{"WAPI_READ_HEADER_TIMEOUT", &cfg.ReadHeaderTimeout},
+		{"WAPI_WRITE_TIMEOUT", &cfg.WriteTimeout},
+		{"WAPI_IDLE_TIMEOUT", &cfg.IdleTimeout},
+		{"WAPI_DRAIN_DELAY", &cfg.DrainDelay},
+		{"WAPI_SHUTDOWN_TIMEOUT", &cfg.ShutdownTimeout},
+	}
+	for _, d := range durations {
+		v := os.Getenv(d.env)
+		if v == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return cfg, fmt.Errorf("Invalid duration in %v: %v", d.env, err)
+		}
+		*d.dst = parsed
+	}
+	return cfg, nil
+}
+
+// Server wraps http.Server with signal handling and a readiness flag.
+type Server struct {
+	cfg        Config
+	srv        *http.Server
+	ready      int32
+	onShutdown []func()
+}
+
+// New creates a server for the given handler. The server is flagged as ready.
+func New(cfg Config, h http.Handler) *Server {
+	s := &Server{
+		cfg: cfg,
+		srv: &http.Server{
+			Addr:              cfg.Addr,
+			Handler:           h,
+			ReadTimeout:       cfg.ReadTimeout,
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			WriteTimeout:      cfg.WriteTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+		},
+	}
+	s.SetReady(true)
+	return s
+}
+
+// Ready returns 'true' as long as the server accepts new requests.
+func (s *Server) Ready() bool {
+	return atomic.LoadInt32(&s.ready) == 1
+}
+
+// SetReady sets the readiness flag.
+func (s *Server) SetReady(ready bool) {
+	var v int32
+	if ready {
+		v = 1
+	}
+	atomic.StoreInt32(&s.ready, v)
+}
+
+// OnShutdown registers a function that is called after all in-flight requests have
+// been drained, e.g. to close the database pool. Functions are called in reverse order
+// of registration.
+func (s *Server) OnShutdown(f func()) {
+	s.onShutdown = append(s.onShutdown, f)
+}
+
+// Run starts the server and blocks until SIGINT or SIGTERM is received or the
+// listener fails. On a signal the server
+// 1. flags itself as not ready,
+// 2. keeps serving for Config.DrainDelay,
+// 3. stops accepting connections and waits up to Config.ShutdownTimeout for in-flight
+//    requests,
+// 4. calls the functions registered with OnShutdown.
+func (s *Server) Run() error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	return s.run(ctx)
+}
+
+func (s *Server) run(ctx context.Context) error {
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		s.cleanup()
+		return err
+	case <-ctx.Done():
+	}
+
+	fmt.Fprintf(os.Stderr, "Shutting down, draining for %v\n", s.cfg.DrainDelay)
+	s.SetReady(false)
+	time.Sleep(s.cfg.DrainDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancel()
+	err := s.srv.Shutdown(shutdownCtx)
+	if lerr := <-errc; lerr != nil && !errors.Is(lerr, http.ErrServerClosed) && err == nil {
+		err = lerr
+	}
+	s.cleanup()
+	if err != nil {
+		return fmt.Errorf("Failed to shut down server gracefully: %v", err)
+	}
+	return nil
+}
+
+// cleanup calls the functions registered with OnShutdown.
+func (s *Server) cleanup() {
+	for i := len(s.onShutdown) - 1; i >= 0; i-- {
+		s.onShutdown[i]()
+	}
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Cancelling the context flips the readiness flag before the server stops and closes
+// the registered resources afterwards.
+func TestRunShutdown(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Addr = "127.0.0.1:0"
+	cfg.DrainDelay = 50 * time.Millisecond
+	cfg.ShutdownTimeout = time.Second
+
+	s := New(cfg, http.NotFoundHandler())
+	assert.True(t, s.Ready())
+
+	var readyDuringCleanup = true
+	s.OnShutdown(func() { readyDuringCleanup = s.Ready() })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.run(ctx) }()
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shut down")
+	}
+	assert.False(t, s.Ready())
+	assert.False(t, readyDuringCleanup)
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	os.Setenv("WAPI_WRITE_TIMEOUT", "3s")
+	defer os.Unsetenv("WAPI_WRITE_TIMEOUT")
+	cfg, err := ConfigFromEnv()
+	assert.Nil(t, err)
+	assert.Equal(t, 3*time.Second, cfg.WriteTimeout)
+	assert.Equal(t, DefaultConfig().ReadTimeout, cfg.ReadTimeout)
+
+	os.Setenv("WAPI_WRITE_TIMEOUT", "soon")
+	_, err = ConfigFromEnv()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,156 @@
+package memstore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"coco-life.de/wapi/internal/models"
+	"coco-life.de/wapi/internal/store"
+)
+
+// containsInt reports whether ids contains id.
+func containsInt(ids []int, id int) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// sortLinks sorts links by source and target.
+func sortLinks(links []models.ArticleLink) {
+	sort.Slice(links, func(i, j int) bool {
+		a, b := links[i], links[j]
+		if a.FromID != b.FromID {
+			return a.FromID < b.FromID
+		}
+		if a.ToID != b.ToID {
+			return a.ToID < b.ToID
+		}
+		return a.ToPath < b.ToPath
+	})
+}
+
+// urlPathOf returns the URL path of the wiki_urlpath record p, e.g. "guide/".
+func (t *tables) urlPathOf(p *urlPath) string {
+	path := ""
+	for n := p; n != nil && n.ParentID != 0; n = t.paths[n.ParentID] {
+		path = n.Slug + "/" + path
+	}
+	return path
+}
+
+// SelectUnindexedArticles returns articles whose links are not indexed, see
+// db.SelectUnindexedArticles.
+func (s *Store) SelectUnindexedArticles(ctx context.Context, limit int) ([]models.LinkSource, error) {
+	defer s.lock()()
+	var sources []models.LinkSource
+	for _, p := range s.t.paths {
+		a := s.t.articles[p.ArticleID]
+		rev, ok := s.t.revisions[a.CurrentRevisionID]
+		if indexed, found := s.t.linkIndex[a.ID]; !ok || (found && indexed == rev.ID) {
+			continue
+		}
+		sources = append(sources, models.LinkSource{ArticleID: a.ID, RevisionID: rev.ID,
+			Path: s.t.urlPathOf(p), Content: rev.Content})
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].ArticleID < sources[j].ArticleID })
+	if len(sources) > limit {
+		sources = sources[:limit]
+	}
+	return sources, nil
+}
+
+// ReplaceArticleLinks replaces the indexed links of an article, see
+// db.ReplaceArticleLinks.
+func (s *Store) ReplaceArticleLinks(ctx context.Context, hdrID int, revID int, links []models.ArticleLink) error {
+	defer s.lock()()
+	if _, ok := s.t.articles[hdrID]; !ok {
+		return fmt.Errorf("%w: wapi_articlelink references missing wiki_article %v", store.ErrConstraint, hdrID)
+	}
+	var records []models.ArticleLink
+	seen := map[models.ArticleLink]bool{}
+	for _, l := range links {
+		l.FromID = hdrID
+		if !seen[l] {
+			seen[l] = true
+			records = append(records, l)
+		}
+	}
+	s.t.links[hdrID] = records
+	s.t.linkIndex[hdrID] = revID
+	return nil
+}
+
+// DeleteArticleLinks removes the indexed links of an article and its descendants, see
+// db.DeleteArticleLinks.
+func (s *Store) DeleteArticleLinks(ctx context.Context, hdrID int) error {
+	defer s.lock()()
+	top := s.t.pathByArticle(hdrID)
+	if top == nil {
+		return nil
+	}
+	for _, p := range s.t.paths {
+		if p.TreeID == top.TreeID && p.Lft >= top.Lft && p.Lft <= top.Rght {
+			delete(s.t.links, p.ArticleID)
+			delete(s.t.linkIndex, p.ArticleID)
+		}
+	}
+	return nil
+}
+
+// SelectArticleLinks returns the indexed links of an article.
+func (s *Store) SelectArticleLinks(ctx context.Context, hdrID int) ([]models.ArticleLink, error) {
+	defer s.lock()()
+	links := append([]models.ArticleLink{}, s.t.links[hdrID]...)
+	sortLinks(links)
+	return links, nil
+}
+
+// SelectBacklinks returns the indexed links to an article, see db.SelectBacklinks.
+func (s *Store) SelectBacklinks(ctx context.Context, hdrID int, subtree bool) ([]models.ArticleLink, error) {
+	defer s.lock()()
+	top := s.t.pathByArticle(hdrID)
+	if top == nil {
+		return nil, nil
+	}
+	ids := map[int]bool{}
+	paths := map[string]bool{}
+	for _, p := range s.t.paths {
+		if p == top || (subtree && p.TreeID == top.TreeID && p.Lft > top.Lft && p.Lft < top.Rght) {
+			ids[p.ArticleID] = true
+			paths["/"+s.t.urlPathOf(p)] = true
+		}
+	}
+	var backlinks []models.ArticleLink
+	for _, links := range s.t.links {
+		for _, l := range links {
+			if ids[l.ToID] || (l.ToID == 0 && paths[l.ToPath]) {
+				backlinks = append(backlinks, l)
+			}
+		}
+	}
+	sortLinks(backlinks)
+	return backlinks, nil
+}
+
+// SelectBrokenLinks returns the indexed links to articles that do not exist.
+func (s *Store) SelectBrokenLinks(ctx context.Context) ([]models.ArticleLink, error) {
+	defer s.lock()()
+	paths := map[string]bool{}
+	for _, p := range s.t.paths {
+		paths["/"+s.t.urlPathOf(p)] = true
+	}
+	var broken []models.ArticleLink
+	for _, links := range s.t.links {
+		for _, l := range links {
+			if (l.ToID != 0 && s.t.pathByArticle(l.ToID) == nil) || (l.ToID == 0 && !paths[l.ToPath]) {
+				broken = append(broken, l)
+			}
+		}
+	}
+	sortLinks(broken)
+	return broken, nil
+}
@@ -0,0 +1,1039 @@
+package memstore
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"coco-life.de/wapi/internal/models"
+	"coco-life.de/wapi/internal/store"
+)
+
+// Store is an in-memory store.ArticleStore and store.UserStore. It models the
+// django-wiki tables wiki_article, wiki_articlerevision and wiki_urlpath as well as
+// the Django tables auth_user, auth_group and auth_user_groups and enforces the same
+// constraints as the Postgres schema:
+// - foreign keys between the tables,
+// - wiki_article-current_revision_id is unique,
+// - wiki_articlerevision-(article_id, revision_number) is unique,
+// - wiki_urlpath-(site_id, parent_id, slug) is unique.
+// The zero value is not usable, use New.
+type Store struct {
+	mu *sync.Mutex
+	t  *tables
+	// inTx is 'true' for the store passed to the function of InTx. It already holds
+	// the lock.
+	inTx bool
+}
+
+var (
+	_ store.ArticleStore = (*Store)(nil)
+	_ store.UserStore    = (*Store)(nil)
+)
+
+// article is a record of wiki_article.
+type article struct {
+	ID       int
+	Created  time.Time
+	Modified time.Time
+	// CurrentRevisionID is 0 if the column is null.
+	CurrentRevisionID int
+	// OwnerID and GroupID are 0 if the columns are null.
+	OwnerID    int
+	GroupID    int
+	GroupRead  bool
+	GroupWrite bool
+	OtherRead  bool
+	OtherWrite bool
+}
+
+// revision is a record of wiki_articlerevision.
+type revision struct {
+	ID             int
+	ArticleID      int
+	RevisionNumber int
+	// PreviousRevisionID is 0 if the column is null.
+	PreviousRevisionID int
+	Title              string
+	Content            string
+	Created            time.Time
+	Modified           time.Time
+	Deleted            bool
+	Locked             bool
+	// UserID is 0 and IPAddress is "" if the columns are null.
+	UserID       int
+	IPAddress    string
+	UserMessage  string
+	AutomaticLog string
+}
+
+// urlPath is a record of wiki_urlpath.
+type urlPath struct {
+	ID int
+	// Slug is "" for the root node where the column is null.
+	Slug      string
+	Lft       int
+	Rght      int
+	Level     int
+	TreeID    int
+	ArticleID int
+	SiteID    int
+	// ParentID is 0 for the root node where the column is null.
+	ParentID int
+	// MovedToID is 0 unless the node redirects to another one.
+	MovedToID int
+}
+
+// tables holds all records and the sequences of their IDs.
+type tables struct {
+	articles  map[int]*article
+	revisions map[int]*revision
+	paths     map[int]*urlPath
+	users     map[int]*models.AuthUser
+	groups    map[int]string
+	// memberships are the records of auth_user_groups.
+	memberships []membership
+	// linkIndex maps wiki_article-id to the indexed revision, see wapi_linkindex.
+	linkIndex map[int]int
+	// links are the records of wapi_articlelink by wiki_article-id of the source.
+	links map[int][]models.ArticleLink
+
+	articleSeq  int
+	revisionSeq int
+	pathSeq     int
+	userSeq     int
+	groupSeq    int
+}
+
+// New returns an empty store.
+func New() *Store {
+	return &Store{
+		mu: &sync.Mutex{},
+		t: &tables{
+			articles:  map[int]*article{},
+			revisions: map[int]*revision{},
+			paths:     map[int]*urlPath{},
+			users:     map[int]*models.AuthUser{},
+			groups:    map[int]string{},
+			linkIndex: map[int]int{},
+			links:     map[int][]models.ArticleLink{},
+		},
+	}
+}
+
+// clone returns a deep copy of all tables.
+func (t *tables) clone() *tables {
+	c := *t
+	c.articles = make(map[int]*article, len(t.articles))
+	for id, r := range t.articles {
+		cp := *r
+		c.articles[id] = &cp
+	}
+	c.revisions = make(map[int]*revision, len(t.revisions))
+	for id, r := range t.revisions {
+		cp := *r
+		c.revisions[id] = &cp
+	}
+	c.paths = make(map[int]*urlPath, len(t.paths))
+	for id, r := range t.paths {
+		cp := *r
+		c.paths[id] = &cp
+	}
+	c.users = make(map[int]*models.AuthUser, len(t.users))
+	for id, r := range t.users {
+		cp := *r
+		c.users[id] = &cp
+	}
+	c.groups = make(map[int]string, len(t.groups))
+	for id, name := range t.groups {
+		c.groups[id] = name
+	}
+	c.memberships = append([]membership{}, t.memberships...)
+	c.linkIndex = make(map[int]int, len(t.linkIndex))
+	for id, revID := range t.linkIndex {
+		c.linkIndex[id] = revID
+	}
+	c.links = make(map[int][]models.ArticleLink, len(t.links))
+	for id, links := range t.links {
+		c.links[id] = append([]models.ArticleLink{}, links...)
+	}
+	return &c
+}
+
+// lock acquires the store's lock unless the store is used within InTx. It returns the
+// function to release the lock.
+func (s *Store) lock() func() {
+	if s.inTx {
+		return func() {}
+	}
+	s.mu.Lock()
+	return s.mu.Unlock
+}
+
+// InTx runs f while holding the lock of the store. If f fails, all tables are reset to
+// their state before f was called.
+func (s *Store) InTx(ctx context.Context, f func(tx store.ArticleStore) error) error {
+	defer s.lock()()
+	snapshot := s.t.clone()
+	if err := f(&Store{mu: s.mu, t: s.t, inTx: true}); err != nil {
+		*s.t = *snapshot
+		return err
+	}
+	return nil
+}
+
+// pathByArticle returns the wiki_urlpath record of an article.
+func (t *tables) pathByArticle(hdrID int) *urlPath {
+	for _, p := range t.paths {
+		if p.ArticleID == hdrID {
+			return p
+		}
+	}
+	return nil
+}
+
+// roots returns all wiki_urlpath records on level 0.
+func (t *tables) roots() []*urlPath {
+	var roots []*urlPath
+	for _, p := range t.paths {
+		if p.Level == 0 {
+			roots = append(roots, p)
+		}
+	}
+	return roots
+}
+
+// selectArticle mirrors db.SelectArticleByID: The article must have a current
+// revision and a wiki_urlpath record.
+func (t *tables) selectArticle(id int) (*models.Article, error) {
+	hdr, ok := t.articles[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: wiki_article %v", store.ErrNotFound, id)
+	}
+	rev, ok := t.revisions[hdr.CurrentRevisionID]
+	if !ok {
+		return nil, fmt.Errorf("%w: current revision of wiki_article %v", store.ErrNotFound, id)
+	}
+	path := t.pathByArticle(id)
+	if path == nil {
+		return nil, fmt.Errorf("%w: wiki_urlpath of wiki_article %v", store.ErrNotFound, id)
+	}
+	a := &models.Article{
+		ArticleBase: models.ArticleBase{
+			ID:          hdr.ID,
+			Title:       rev.Title,
+			Content:     rev.Content,
+			RevisionID:  rev.ID,
+			Deleted:     rev.Deleted,
+			Locked:      rev.Locked,
+			ParentArtID: -1,
+			PathID:      path.ID,
+			Left:        path.Lft,
+			Right:       path.Rght,
+			Permissions: hdr.permissions(),
+		},
+		Slug:  path.Slug,
+		Level: path.Level,
+	}
+	if parent, ok := t.paths[path.ParentID]; ok {
+		a.ParentArtID = parent.ArticleID
+	}
+	return a, nil
+}
+
+// SelectRootArticle selects the root article with its current revision.
+func (s *Store) SelectRootArticle(ctx context.Context) (*models.RootArticle, error) {
+	defer s.lock()()
+	roots := s.t.roots()
+	if len(roots) != 1 {
+		return nil, fmt.Errorf("%w: expected 1 root article, found %v", store.ErrNotFound, len(roots))
+	}
+	a, err := s.t.selectArticle(roots[0].ArticleID)
+	if err != nil {
+		return nil, err
+	}
+	// Mirror the columns selected by db.SelectRootArticle.
+	return &models.RootArticle{ArticleBase: models.ArticleBase{
+		ID:          a.ID,
+		Title:       a.Title,
+		Content:     a.Content,
+		RevisionID:  a.RevisionID,
+		Deleted:     a.Deleted,
+		Locked:      a.Locked,
+		Left:        a.Left,
+		Right:       a.Right,
+		Permissions: a.Permissions,
+	}}, nil
+}
+
+// SelectArticleByID selects an article with its current revision by wiki_article-id.
+func (s *Store) SelectArticleByID(ctx context.Context, id int) (*models.Article, error) {
+	defer s.lock()()
+	return s.t.selectArticle(id)
+}
+
+// SelectArticleByPath selects an article with its current revision by its URL path.
+func (s *Store) SelectArticleByPath(ctx context.Context, path string) (*models.Article, error) {
+	defer s.lock()()
+	id, err := s.t.articleIDByPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return s.t.selectArticle(id)
+}
+
+// SelectArticleHeader selects an article without its content.
+func (s *Store) SelectArticleHeader(ctx context.Context, id int) (*models.Article, error) {
+	defer s.lock()()
+	a, err := s.t.selectArticle(id)
+	if err == nil {
+		a.Content = ""
+	}
+	return a, err
+}
+
+// SelectArticleIDByPath returns the ID of the article with the URL path.
+func (s *Store) SelectArticleIDByPath(ctx context.Context, path string) (int, error) {
+	defer s.lock()()
+	return s.t.articleIDByPath(path)
+}
+
+// articleIDByPath returns the ID of the article with the URL path.
+func (t *tables) articleIDByPath(path string) (int, error) {
+	roots := t.roots()
+	if len(roots) != 1 {
+		return -1, fmt.Errorf("%w: expected 1 root article, found %v", store.ErrNotFound, len(roots))
+	}
+	node := roots[0]
+	for _, slug := range store.SplitPath(path) {
+		var next *urlPath
+		for _, p := range t.paths {
+			if p.ParentID == node.ID && p.Slug == slug {
+				next = p
+				break
+			}
+		}
+		if next == nil {
+			return -1, fmt.Errorf("%w: slug '%v' in wiki_urlpath", store.ErrNotFound, slug)
+		}
+		node = next
+	}
+	return node.ArticleID, nil
+}
+
+// SelectRevisions returns the revisions of an article ordered by revision number.
+func (s *Store) SelectRevisions(ctx context.Context, hdrID int) ([]models.Revision, error) {
+	defer s.lock()()
+	revs := []models.Revision{}
+	for _, r := range s.t.revisions {
+		if r.ArticleID == hdrID {
+			revs = append(revs, models.Revision{ID: r.ID, RevisionNumber: r.RevisionNumber, Title: r.Title,
+				Created: r.Created, UserID: r.UserID, UserMessage: r.UserMessage, AutomaticLog: r.AutomaticLog,
+				Deleted: r.Deleted, Locked: r.Locked})
+		}
+	}
+	sort.Slice(revs, func(i, j int) bool { return revs[i].RevisionNumber < revs[j].RevisionNumber })
+	return revs, nil
+}
+
+// permissions returns owner, group and permission flags of the article.
+func (a *article) permissions() *models.Permissions {
+	return &models.Permissions{
+		OwnerID:    a.OwnerID,
+		GroupID:    a.GroupID,
+		GroupRead:  a.GroupRead,
+		GroupWrite: a.GroupWrite,
+		OtherRead:  a.OtherRead,
+		OtherWrite: a.OtherWrite,
+	}
+}
+
+// setPermissions sets owner, group and permission flags of the article after checking
+// the foreign keys.
+func (t *tables) setPermissions(a *article, perms models.Permissions) error {
+	if _, ok := t.users[perms.OwnerID]; perms.OwnerID != 0 && !ok {
+		return fmt.Errorf("%w: wiki_article-owner_id %v does not exist", store.ErrConstraint, perms.OwnerID)
+	}
+	if _, ok := t.groups[perms.GroupID]; perms.GroupID != 0 && !ok {
+		return fmt.Errorf("%w: wiki_article-group_id %v does not exist", store.ErrConstraint, perms.GroupID)
+	}
+	a.OwnerID = perms.OwnerID
+	a.GroupID = perms.GroupID
+	a.GroupRead = perms.GroupRead
+	a.GroupWrite = perms.GroupWrite
+	a.OtherRead = perms.OtherRead
+	a.OtherWrite = perms.OtherWrite
+	return nil
+}
+
+// InsertWikiArticle inserts a record into wiki_article and returns its ID.
+func (s *Store) InsertWikiArticle(ctx context.Context, perms models.Permissions) (int, error) {
+	defer s.lock()()
+	now := time.Now()
+	a := &article{
+		ID:       s.t.articleSeq + 1,
+		Created:  now,
+		Modified: now,
+	}
+	if err := s.t.setPermissions(a, perms); err != nil {
+		return -1, err
+	}
+	s.t.articleSeq++
+	s.t.articles[a.ID] = a
+	return a.ID, nil
+}
+
+// UpdateWikiArticlePermissions sets owner, group and permission flags of an article.
+func (s *Store) UpdateWikiArticlePermissions(ctx context.Context, hdrID int, perms models.Permissions) error {
+	defer s.lock()()
+	a, ok := s.t.articles[hdrID]
+	if !ok {
+		return fmt.Errorf("%w: wiki_article %v", store.ErrNotFound, hdrID)
+	}
+	if err := s.t.setPermissions(a, perms); err != nil {
+		return err
+	}
+	a.Modified = time.Now()
+	return nil
+}
+
+// InsertWikiArticleRevision inserts the first revision of an article.
+func (s *Store) InsertWikiArticleRevision(ctx context.Context, hdrID int, title string, content string, meta models.RevisionMeta) (int, error) {
+	defer s.lock()()
+	if _, ok := s.t.articles[hdrID]; !ok {
+		return -1, fmt.Errorf("%w: wiki_articlerevision-article_id %v does not exist", store.ErrConstraint, hdrID)
+	}
+	for _, r := range s.t.revisions {
+		if r.ArticleID == hdrID && r.RevisionNumber == 1 {
+			return -1, fmt.Errorf("%w: revision 1 of wiki_article %v already exists", store.ErrConstraint, hdrID)
+		}
+	}
+	s.t.revisionSeq++
+	now := time.Now()
+	s.t.revisions[s.t.revisionSeq] = &revision{
+		ID:             s.t.revisionSeq,
+		ArticleID:      hdrID,
+		RevisionNumber: 1,
+		Title:          title,
+		Content:        content,
+		Created:        now,
+		Modified:       now,
+	}
+	if err := s.t.setRevisionMeta(s.t.revisions[s.t.revisionSeq], meta); err != nil {
+		delete(s.t.revisions, s.t.revisionSeq)
+		return -1, err
+	}
+	return s.t.revisionSeq, nil
+}
+
+// AddWikiArticleRevision adds a revision and makes it the current one.
+func (s *Store) AddWikiArticleRevision(ctx context.Context, hdrID int, title string, content string, meta models.RevisionMeta) (int, error) {
+	defer s.lock()()
+	hdr, ok := s.t.articles[hdrID]
+	if !ok {
+		return -1, fmt.Errorf("%w: wiki_article %v", store.ErrNotFound, hdrID)
+	}
+	cur, ok := s.t.revisions[hdr.CurrentRevisionID]
+	if !ok {
+		return -1, fmt.Errorf("%w: current revision of wiki_article %v", store.ErrNotFound, hdrID)
+	}
+	now := time.Now()
+	rev := &revision{
+		ID:                 s.t.revisionSeq + 1,
+		ArticleID:          hdrID,
+		RevisionNumber:     cur.RevisionNumber + 1,
+		PreviousRevisionID: cur.ID,
+		Title:              title,
+		Content:            content,
+		Created:            now,
+		Modified:           now,
+	}
+	if err := s.t.setRevisionMeta(rev, meta); err != nil {
+		return -1, err
+	}
+	s.t.revisionSeq++
+	s.t.revisions[rev.ID] = rev
+	hdr.CurrentRevisionID = rev.ID
+	return rev.ID, nil
+}
+
+// RevisionMetas returns the metadata of all revisions of an article ordered by
+// revision number. The API does not read the history, this is meant for tests.
+func (s *Store) RevisionMetas(hdrID int) []models.RevisionMeta {
+	defer s.lock()()
+	var revs []*revision
+	for _, r := range s.t.revisions {
+		if r.ArticleID == hdrID {
+			revs = append(revs, r)
+		}
+	}
+	sort.Slice(revs, func(i, j int) bool { return revs[i].RevisionNumber < revs[j].RevisionNumber })
+	metas := make([]models.RevisionMeta, len(revs))
+	for i, r := range revs {
+		metas[i] = models.RevisionMeta{UserID: r.UserID, IPAddress: r.IPAddress,
+			UserMessage: r.UserMessage, AutomaticLog: r.AutomaticLog, Deleted: r.Deleted, Locked: r.Locked}
+	}
+	return metas
+}
+
+// setRevisionMeta sets the columns of meta after checking the foreign key and the type
+// of ip_address.
+func (t *tables) setRevisionMeta(rev *revision, meta models.RevisionMeta) error {
+	if _, ok := t.users[meta.UserID]; meta.UserID != 0 && !ok {
+		return fmt.Errorf("%w: wiki_articlerevision-user_id %v does not exist", store.ErrConstraint, meta.UserID)
+	}
+	if meta.IPAddress != "" && net.ParseIP(meta.IPAddress) == nil {
+		return fmt.Errorf("invalid input syntax for type inet: '%v'", meta.IPAddress)
+	}
+	rev.UserID = meta.UserID
+	rev.IPAddress = meta.IPAddress
+	rev.UserMessage = meta.UserMessage
+	rev.AutomaticLog = meta.AutomaticLog
+	rev.Deleted = meta.Deleted
+	rev.Locked = meta.Locked
+	return nil
+}
+
+// SetWikiArticleRevision sets wiki_article-current_revision_id.
+func (s *Store) SetWikiArticleRevision(ctx context.Context, hdrID int, revID int) error {
+	defer s.lock()()
+	hdr, ok := s.t.articles[hdrID]
+	if !ok {
+		return fmt.Errorf("Failed to update 'current_revision_id' in wiki_article")
+	}
+	if _, ok := s.t.revisions[revID]; !ok {
+		return fmt.Errorf("%w: wiki_article-current_revision_id %v does not exist", store.ErrConstraint, revID)
+	}
+	for _, a := range s.t.articles {
+		if a.ID != hdrID && a.CurrentRevisionID == revID {
+			return fmt.Errorf("%w: wiki_article-current_revision_id %v is not unique", store.ErrConstraint, revID)
+		}
+	}
+	hdr.CurrentRevisionID = revID
+	return nil
+}
+
+// InsertWikiURLPathRoot inserts the wiki_urlpath record of the root article.
+func (s *Store) InsertWikiURLPathRoot(ctx context.Context, hdrID int) error {
+	defer s.lock()()
+	if _, ok := s.t.articles[hdrID]; !ok {
+		return fmt.Errorf("%w: wiki_urlpath-article_id %v does not exist", store.ErrConstraint, hdrID)
+	}
+	s.t.pathSeq++
+	s.t.paths[s.t.pathSeq] = &urlPath{
+		ID:        s.t.pathSeq,
+		Lft:       1,
+		Rght:      2,
+		Level:     0,
+		TreeID:    1,
+		ArticleID: hdrID,
+		SiteID:    1,
+	}
+	return nil
+}
+
+// InsertWikiURLPathChild inserts the wiki_urlpath record of a child article.
+func (s *Store) InsertWikiURLPathChild(ctx context.Context, slug string, hdrID int, lvl int, left int, right int, parentPathID int) (int, error) {
+	defer s.lock()()
+	if _, ok := s.t.articles[hdrID]; !ok {
+		return -1, fmt.Errorf("%w: wiki_urlpath-article_id %v does not exist", store.ErrConstraint, hdrID)
+	}
+	if _, ok := s.t.paths[parentPathID]; !ok {
+		return -1, fmt.Errorf("%w: wiki_urlpath-parent_id %v does not exist", store.ErrConstraint, parentPathID)
+	}
+	for _, p := range s.t.paths {
+		if p.ParentID == parentPathID && p.Slug == slug {
+			return -1, fmt.Errorf("%w: slug '%v' already exists under wiki_urlpath %v", store.ErrConstraint, slug, parentPathID)
+		}
+	}
+	s.t.pathSeq++
+	s.t.paths[s.t.pathSeq] = &urlPath{
+		ID:        s.t.pathSeq,
+		Slug:      slug,
+		Lft:       left,
+		Rght:      right,
+		Level:     lvl,
+		TreeID:    1,
+		ArticleID: hdrID,
+		SiteID:    1,
+		ParentID:  parentPathID,
+	}
+	return s.t.pathSeq, nil
+}
+
+// MPTTUpdWikiURLPathForInsert shifts 'lft' and 'rght' of all other nodes, see
+// db.MPTTUpdWikiURLPathForInsert.
+func (s *Store) MPTTUpdWikiURLPathForInsert(ctx context.Context, newArtPathID int, nLft int) error {
+	defer s.lock()()
+	for _, p := range s.t.paths {
+		if p.ID == newArtPathID {
+			continue
+		}
+		if p.Lft >= nLft {
+			p.Lft += 2
+		}
+		if p.Rght >= nLft {
+			p.Rght += 2
+		}
+	}
+	return nil
+}
+
+// children returns the wiki_urlpath records below parentPathID ordered by 'lft'.
+func (t *tables) children(parentPathID int) []*urlPath {
+	var children []*urlPath
+	for _, p := range t.paths {
+		if p.ParentID == parentPathID {
+			children = append(children, p)
+		}
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].Lft < children[j].Lft })
+	return children
+}
+
+// SelectURLPath returns the URL path of an article, see db.SelectURLPath.
+func (s *Store) SelectURLPath(ctx context.Context, hdrID int) (string, error) {
+	defer s.lock()()
+	p := s.t.pathByArticle(hdrID)
+	if p == nil {
+		return "", fmt.Errorf("%w: wiki_urlpath of wiki_article %v", store.ErrNotFound, hdrID)
+	}
+	return s.t.urlPathOf(p), nil
+}
+
+// MoveWikiURLPath moves a subtree of wiki_urlpath, see db.MoveWikiURLPath.
+func (s *Store) MoveWikiURLPath(ctx context.Context, pathID int, newParentPathID int, pos int) error {
+	defer s.lock()()
+	node, ok := s.t.paths[pathID]
+	if !ok {
+		return fmt.Errorf("%w: wiki_urlpath %v", store.ErrNotFound, pathID)
+	}
+	parent, ok := s.t.paths[newParentPathID]
+	if !ok {
+		return fmt.Errorf("%w: wiki_urlpath %v", store.ErrNotFound, newParentPathID)
+	}
+	if parent.Lft >= node.Lft && parent.Rght <= node.Rght {
+		return fmt.Errorf("%w: wiki_urlpath %v cannot be moved below itself or its descendant %v",
+			store.ErrConstraint, pathID, newParentPathID)
+	}
+	for _, p := range s.t.paths {
+		if p.ID != pathID && p.ParentID == newParentPathID && p.Slug == node.Slug {
+			return fmt.Errorf("%w: slug '%v' already exists under wiki_urlpath %v", store.ErrConstraint, node.Slug, newParentPathID)
+		}
+	}
+
+	target := parent.Rght
+	var siblings []*urlPath
+	for _, p := range s.t.children(newParentPathID) {
+		if p.ID != pathID {
+			siblings = append(siblings, p)
+		}
+	}
+	if pos >= 0 && pos < len(siblings) {
+		target = siblings[pos].Lft
+	}
+
+	left, right := node.Lft, node.Rght
+	delta, lo, hi, shift := store.MPTTCalcForMove(left, right, target)
+	dLvl := parent.Level + 1 - node.Level
+	move := func(v int) int {
+		switch {
+		case v >= left && v <= right:
+			return v + delta
+		case v >= lo && v <= hi:
+			return v + shift
+		}
+		return v
+	}
+	for _, p := range s.t.paths {
+		if p.TreeID != node.TreeID {
+			continue
+		}
+		if p.Lft >= left && p.Lft <= right {
+			p.Level += dLvl
+		}
+		p.Lft, p.Rght = move(p.Lft), move(p.Rght)
+	}
+	node.ParentID = newParentPathID
+	return nil
+}
+
+// UpdateWikiURLPathSlug renames a subtree of wiki_urlpath, see
+// db.UpdateWikiURLPathSlug.
+func (s *Store) UpdateWikiURLPathSlug(ctx context.Context, pathID int, slug string) error {
+	defer s.lock()()
+	node, ok := s.t.paths[pathID]
+	if !ok || node.ParentID == 0 {
+		return fmt.Errorf("%w: wiki_urlpath %v", store.ErrNotFound, pathID)
+	}
+	for _, p := range s.t.paths {
+		if p.ID != pathID && p.ParentID == node.ParentID && p.Slug == slug {
+			return fmt.Errorf("%w: slug '%v' already exists under wiki_urlpath %v", store.ErrConstraint, slug, node.ParentID)
+		}
+	}
+	node.Slug = slug
+	return nil
+}
+
+// DeleteWikiURLPath deletes a subtree of wiki_urlpath and its articles, see
+// db.DeleteWikiURLPath.
+func (s *Store) DeleteWikiURLPath(ctx context.Context, pathID int) error {
+	defer s.lock()()
+	node, ok := s.t.paths[pathID]
+	if !ok {
+		return fmt.Errorf("%w: wiki_urlpath %v", store.ErrNotFound, pathID)
+	}
+	left, right, treeID := node.Lft, node.Rght, node.TreeID
+	width := store.MPTTCalcForDelete(left, right)
+	for id, p := range s.t.paths {
+		if p.TreeID != treeID || p.Lft < left || p.Lft > right {
+			continue
+		}
+		delete(s.t.paths, id)
+		delete(s.t.articles, p.ArticleID)
+		delete(s.t.linkIndex, p.ArticleID)
+		delete(s.t.links, p.ArticleID)
+		for revID, r := range s.t.revisions {
+			if r.ArticleID == p.ArticleID {
+				delete(s.t.revisions, revID)
+			}
+		}
+	}
+	for _, p := range s.t.paths {
+		// Django sets 'moved_to' to null if the target is deleted.
+		if _, ok := s.t.paths[p.MovedToID]; !ok {
+			p.MovedToID = 0
+		}
+		if p.TreeID != treeID {
+			continue
+		}
+		if p.Lft > right {
+			p.Lft -= width
+		}
+		if p.Rght > right {
+			p.Rght -= width
+		}
+	}
+	return nil
+}
+
+// treeOf returns wiki_urlpath-tree_id of the node pathID, 0 if it does not exist.
+func (t *tables) treeOf(pathID int) int {
+	if p, ok := t.paths[pathID]; ok {
+		return p.TreeID
+	}
+	return 0
+}
+
+// SelectArticles lists article summaries, see db.SelectArticles.
+func (s *Store) SelectArticles(ctx context.Context, q models.ArticleQuery) ([]models.ArticleSummary, error) {
+	defer s.lock()()
+	before := func(a, b models.ArticleKey) bool {
+		if q.Desc {
+			a, b = b, a
+		}
+		if q.SortBy == "modified" && !a.Modified.Equal(b.Modified) {
+			return a.Modified.Before(b.Modified)
+		}
+		return a.ID < b.ID
+	}
+	var summaries []models.ArticleSummary
+	for _, p := range s.t.paths {
+		a := s.t.articles[p.ArticleID]
+		rev, ok := s.t.revisions[a.CurrentRevisionID]
+		if !ok || !q.Reader.Allows(*a.permissions()) {
+			continue
+		}
+		hasChildren := p.Rght-p.Lft > 1
+		switch {
+		case q.TitlePrefix != "" && !strings.HasPrefix(strings.ToLower(rev.Title), strings.ToLower(q.TitlePrefix)),
+			q.Slug != "" && p.Slug != q.Slug,
+			q.Level != nil && p.Level != *q.Level,
+			rev.Modified.Before(q.ModifiedSince),
+			a.Created.Before(q.CreatedSince),
+			q.Left != 0 && (p.Lft <= q.Left || p.Lft >= q.Right),
+			q.ContainsLeft != 0 && (p.Lft >= q.ContainsLeft || p.Rght <= q.ContainsRight),
+			(q.Left != 0 || q.ContainsLeft != 0) && p.TreeID != s.t.treeOf(q.PathID),
+			q.HasChildren != nil && hasChildren != *q.HasChildren,
+			q.Deleted != nil && rev.Deleted != *q.Deleted,
+			q.IDs != nil && !containsInt(q.IDs, a.ID):
+			continue
+		}
+		sum := models.ArticleSummary{ID: a.ID, RevisionID: rev.ID, ParentArtID: -1, Slug: p.Slug,
+			Title: rev.Title, Level: p.Level, Left: p.Lft, Right: p.Rght, Created: a.Created, Modified: rev.Modified,
+			Deleted: rev.Deleted, Locked: rev.Locked, HasChildren: hasChildren}
+		if q.After != nil && !before(*q.After, sum.Key()) {
+			continue
+		}
+		if parent, ok := s.t.paths[p.ParentID]; ok {
+			sum.ParentArtID = parent.ArticleID
+		}
+		for n := p; n != nil && n.ParentID != 0; n = s.t.paths[n.ParentID] {
+			sum.Path = n.Slug + "/" + sum.Path
+		}
+		if q.WithContent {
+			content := rev.Content
+			sum.Content = &content
+		}
+		summaries = append(summaries, sum)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return before(summaries[i].Key(), summaries[j].Key()) })
+	if len(summaries) > q.Limit {
+		summaries = summaries[:q.Limit]
+	}
+	return summaries, nil
+}
+
+// ImportWikiArticles inserts the trees nodes below an article, see
+// db.ImportWikiArticles. All constraints are checked before any record is written.
+func (s *Store) ImportWikiArticles(ctx context.Context, parentHdrID int, nodes []models.ArticleNode, perms models.Permissions, meta models.RevisionMeta) ([]int, error) {
+	defer s.lock()()
+	parent := s.t.pathByArticle(parentHdrID)
+	if parent == nil {
+		return nil, fmt.Errorf("%w: wiki_urlpath of wiki_article %v", store.ErrNotFound, parentHdrID)
+	}
+	if err := s.t.setPermissions(&article{}, perms); err != nil {
+		return nil, err
+	}
+	if err := s.t.setRevisionMeta(&revision{}, meta); err != nil {
+		return nil, err
+	}
+	placements := store.MPTTCalcForImport(nodes, parent.Level, parent.Rght)
+	slugs := map[[2]interface{}]bool{}
+	for _, p := range s.t.children(parent.ID) {
+		slugs[[2]interface{}{-1, p.Slug}] = true
+	}
+	for _, p := range placements {
+		key := [2]interface{}{p.Parent, p.Node.Slug}
+		if slugs[key] {
+			return nil, fmt.Errorf("%w: slug '%v' is not unique among its siblings", store.ErrConstraint, p.Node.Slug)
+		}
+		slugs[key] = true
+	}
+
+	n, prtRight := len(placements), parent.Rght
+	for _, p := range s.t.paths {
+		if p.TreeID != parent.TreeID || p.Rght < prtRight {
+			continue
+		}
+		if p.Lft >= prtRight {
+			p.Lft += 2 * n
+		}
+		p.Rght += 2 * n
+	}
+	now := time.Now()
+	hdrIDs := make([]int, n)
+	pathIDs := make([]int, n)
+	for i, pl := range placements {
+		s.t.articleSeq++
+		s.t.revisionSeq++
+		s.t.pathSeq++
+		a := &article{ID: s.t.articleSeq, Created: now, Modified: now, CurrentRevisionID: s.t.revisionSeq}
+		s.t.setPermissions(a, perms)
+		s.t.articles[a.ID] = a
+		rev := &revision{ID: s.t.revisionSeq, ArticleID: a.ID, RevisionNumber: 1,
+			Title: pl.Node.Title, Content: pl.Node.Content, Created: now, Modified: now}
+		s.t.setRevisionMeta(rev, meta)
+		s.t.revisions[rev.ID] = rev
+		parentID := parent.ID
+		if pl.Parent >= 0 {
+			parentID = pathIDs[pl.Parent]
+		}
+		s.t.paths[s.t.pathSeq] = &urlPath{
+			ID:        s.t.pathSeq,
+			Slug:      pl.Node.Slug,
+			Lft:       pl.Left,
+			Rght:      pl.Right,
+			Level:     pl.Level,
+			TreeID:    parent.TreeID,
+			ArticleID: a.ID,
+			SiteID:    1,
+			ParentID:  parentID,
+		}
+		hdrIDs[i], pathIDs[i] = a.ID, s.t.pathSeq
+	}
+	return hdrIDs, nil
+}
+
+// CopyWikiArticles copies a subtree, see db.CopyWikiArticles.
+func (s *Store) CopyWikiArticles(ctx context.Context, hdrID int, parentHdrID int, opts models.CopyOptions) ([]int, error) {
+	defer s.lock()()
+	parent := s.t.pathByArticle(parentHdrID)
+	if parent == nil {
+		return nil, fmt.Errorf("%w: wiki_urlpath of wiki_article %v", store.ErrNotFound, parentHdrID)
+	}
+	src := s.t.pathByArticle(hdrID)
+	if src == nil {
+		return nil, fmt.Errorf("%w: wiki_urlpath of wiki_article %v", store.ErrNotFound, hdrID)
+	}
+	if src.TreeID == parent.TreeID && parent.Lft >= src.Lft && parent.Rght <= src.Rght {
+		return nil, fmt.Errorf("%w: wiki_article %v cannot be copied below itself or its descendant %v",
+			store.ErrConstraint, hdrID, parentHdrID)
+	}
+	if _, ok := s.t.users[opts.OwnerID]; opts.OwnerID != 0 && !ok {
+		return nil, fmt.Errorf("%w: wiki_article-owner_id %v does not exist", store.ErrConstraint, opts.OwnerID)
+	}
+	if err := s.t.setRevisionMeta(&revision{}, opts.Meta); err != nil {
+		return nil, err
+	}
+	slug := src.Slug
+	if opts.Slug != "" {
+		slug = opts.Slug
+	}
+	for _, p := range s.t.children(parent.ID) {
+		if p.Slug == slug {
+			return nil, fmt.Errorf("%w: slug '%v' already exists under wiki_urlpath %v", store.ErrConstraint, slug, parent.ID)
+		}
+	}
+
+	// The nodes are copied before the nested set is shifted.
+	var nodes []urlPath
+	for _, p := range s.t.paths {
+		if p.TreeID == src.TreeID && p.Lft >= src.Lft && p.Lft <= src.Rght {
+			nodes = append(nodes, *p)
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Lft < nodes[j].Lft })
+	var revs []*revision
+	for _, node := range nodes {
+		for _, rev := range s.t.revisions {
+			if rev.ArticleID == node.ArticleID && (opts.History || rev.ID == s.t.articles[node.ArticleID].CurrentRevisionID) {
+				revs = append(revs, rev)
+			}
+		}
+	}
+	sort.Slice(revs, func(i, j int) bool { return revs[i].ID < revs[j].ID })
+
+	n, prtRight, srcLeft, dLvl := len(nodes), parent.Rght, src.Lft, parent.Level+1-src.Level
+	for _, p := range s.t.paths {
+		if p.TreeID != parent.TreeID || p.Rght < prtRight {
+			continue
+		}
+		if p.Lft >= prtRight {
+			p.Lft += 2 * n
+		}
+		p.Rght += 2 * n
+	}
+	now := time.Now()
+	newArtIDs := map[int]int{}
+	newPathIDs := map[int]int{}
+	hdrIDs := make([]int, n)
+	for i, node := range nodes {
+		s.t.articleSeq++
+		s.t.pathSeq++
+		newArtIDs[node.ArticleID], newPathIDs[node.ID] = s.t.articleSeq, s.t.pathSeq
+		hdrIDs[i] = s.t.articleSeq
+	}
+	newRevIDs := map[int]int{}
+	for _, rev := range revs {
+		s.t.revisionSeq++
+		newRevIDs[rev.ID] = s.t.revisionSeq
+	}
+
+	for _, rev := range revs {
+		cp := *rev
+		cp.ID, cp.ArticleID = newRevIDs[rev.ID], newArtIDs[rev.ArticleID]
+		cp.PreviousRevisionID = newRevIDs[rev.PreviousRevisionID]
+		if !opts.History {
+			cp.RevisionNumber, cp.Created, cp.Modified = 1, now, now
+			deleted, locked := rev.Deleted, rev.Locked
+			s.t.setRevisionMeta(&cp, opts.Meta)
+			cp.Deleted, cp.Locked = deleted, locked
+		}
+		s.t.revisions[cp.ID] = &cp
+	}
+	for i, node := range nodes {
+		a := *s.t.articles[node.ArticleID]
+		a.ID, a.Created, a.Modified = newArtIDs[node.ArticleID], now, now
+		a.CurrentRevisionID, a.OwnerID = newRevIDs[a.CurrentRevisionID], opts.OwnerID
+		s.t.articles[a.ID] = &a
+		p := node
+		p.ID, p.ArticleID = newPathIDs[node.ID], a.ID
+		p.Lft, p.Rght = node.Lft-srcLeft+prtRight, node.Rght-srcLeft+prtRight
+		p.Level, p.TreeID, p.ParentID = node.Level+dLvl, parent.TreeID, newPathIDs[node.ParentID]
+		if i == 0 {
+			p.Slug, p.ParentID = slug, parent.ID
+		}
+		// Redirects within the subtree point to the copies, others to the same node.
+		if id, ok := newPathIDs[node.MovedToID]; ok {
+			p.MovedToID = id
+		}
+		s.t.paths[p.ID] = &p
+	}
+	return hdrIDs, nil
+}
+
+// Ping always succeeds.
+func (s *Store) Ping(ctx context.Context) error {
+	return nil
+}
+
+// MissingTables returns the subset of tables that is not modelled by the store.
+func (s *Store) MissingTables(ctx context.Context, tables []string) ([]string, error) {
+	known := map[string]bool{
+		"wiki_article":         true,
+		"wiki_articlerevision": true,
+		"wiki_urlpath":         true,
+		"auth_user":            true,
+		"auth_group":           true,
+		"auth_user_groups":     true,
+	}
+	var missing []string
+	for _, t := range tables {
+		if !known[t] {
+			missing = append(missing, t)
+		}
+	}
+	return missing, nil
+}
+
+// CountRootArticles returns the number of articles on level 0.
+func (s *Store) CountRootArticles(ctx context.Context) (int, error) {
+	defer s.lock()()
+	return len(s.t.roots()), nil
+}
+
+// MPTTViolations checks the same rules as db.MPTTViolations.
+func (s *Store) MPTTViolations(ctx context.Context) ([]string, error) {
+	defer s.lock()()
+	var lftGeRght, dupBounds, badRoots, outside int
+	seen := map[int]bool{}
+	for _, p := range s.t.paths {
+		if p.Lft >= p.Rght {
+			lftGeRght++
+		}
+		for _, v := range []int{p.Lft, p.Rght} {
+			if seen[v] {
+				dupBounds++
+			}
+			seen[v] = true
+		}
+		if p.Level == 0 && (p.Lft != 1 || p.Rght != 2*len(s.t.paths)) {
+			badRoots++
+		}
+		if parent, ok := s.t.paths[p.ParentID]; ok {
+			if p.Lft <= parent.Lft || p.Rght >= parent.Rght || p.Level != parent.Level+1 {
+				outside++
+			}
+		}
+	}
+	var violations []string
+	for _, v := range []struct {
+		descr string
+		n     int
+	}{
+		{"nodes with lft >= rght", lftGeRght},
+		{"duplicate lft/rght values", dupBounds},
+		{"roots not spanning the tree", badRoots},
+		{"children outside of their parent", outside},
+	} {
+		if v.n > 0 {
+			violations = append(violations, fmt.Sprintf("%v: %v", v.descr, v.n))
+		}
+	}
+	return violations, nil
+}
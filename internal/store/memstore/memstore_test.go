@@ -0,0 +1,60 @@
+package memstore
+
+import (
+	"context"
+	"testing"
+
+	"coco-life.de/wapi/internal/models"
+	"coco-life.de/wapi/internal/store"
+	"coco-life.de/wapi/internal/store/storetest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.ArticleStore { return New() })
+}
+
+func TestMPTT(t *testing.T) {
+	storetest.RunMPTT(t, func(t *testing.T) (store.ArticleStore, func()) { return New(), func() {} },
+		storetest.MPTTConfig{Sequences: 50, Steps: 200})
+}
+
+// TestOtherTree checks that a second tree, whose lft and rght overlap those of the
+// first, never shows up in subtree queries. The store itself only builds tree 1.
+func TestOtherTree(t *testing.T) {
+	ctx := context.Background()
+	s := New()
+	rootID := storetest.InsertRoot(t, s, "Root")
+	aID, err := storetest.InsertChild(s, rootID, "a")
+	require.Nil(t, err)
+	otherID := storetest.InsertRoot(t, s, "Install")
+	other, err := s.SelectArticleByID(ctx, otherID)
+	require.Nil(t, err)
+	p := s.t.paths[other.PathID]
+	p.TreeID, p.Lft, p.Rght, p.Level = 2, 2, 3, 1
+
+	root, err := s.SelectArticleByID(ctx, rootID)
+	require.Nil(t, err)
+	a, err := s.SelectArticleByID(ctx, aID)
+	require.Nil(t, err)
+	all := models.ReadFilter{All: true}
+	ids := func(q models.ArticleQuery) []int {
+		q.Reader, q.SortBy, q.Limit = all, "id", 10
+		summaries, err := s.SelectArticles(ctx, q)
+		require.Nil(t, err)
+		ids := []int{}
+		for _, sum := range summaries {
+			ids = append(ids, sum.ID)
+		}
+		return ids
+	}
+	assert.Equal(t, []int{aID}, ids(models.ArticleQuery{Left: root.Left, Right: root.Right, PathID: root.PathID}))
+	assert.Equal(t, []int{rootID}, ids(models.ArticleQuery{ContainsLeft: a.Left, ContainsRight: a.Right, PathID: a.PathID}))
+
+	hits, total, err := s.SearchArticles(ctx, models.SearchQuery{Query: "install", Config: "simple",
+		Left: root.Left, Right: root.Right, PathID: root.PathID, Reader: all, Limit: 10})
+	require.Nil(t, err)
+	assert.Empty(t, hits)
+	assert.Equal(t, 0, total)
+}
@@ -0,0 +1,36 @@
+package memstore
+
+import (
+	"context"
+	"fmt"
+
+	"coco-life.de/wapi/internal/store"
+)
+
+// SetWikiURLPathMovedTo makes a node of wiki_urlpath a redirect, see
+// db.SetWikiURLPathMovedTo.
+func (s *Store) SetWikiURLPathMovedTo(ctx context.Context, pathID int, movedToPathID int) error {
+	defer s.lock()()
+	p, ok := s.t.paths[pathID]
+	if !ok {
+		return fmt.Errorf("%w: wiki_urlpath %v", store.ErrNotFound, pathID)
+	}
+	if _, ok := s.t.paths[movedToPathID]; !ok {
+		return fmt.Errorf("%w: wiki_urlpath-moved_to_id %v does not exist", store.ErrConstraint, movedToPathID)
+	}
+	p.MovedToID = movedToPathID
+	return nil
+}
+
+// SelectMovedTo returns the target of a redirect, see db.SelectMovedTo.
+func (s *Store) SelectMovedTo(ctx context.Context, hdrID int) (int, error) {
+	defer s.lock()()
+	p := s.t.pathByArticle(hdrID)
+	if p == nil {
+		return 0, fmt.Errorf("%w: wiki_urlpath of wiki_article %v", store.ErrNotFound, hdrID)
+	}
+	if dst, ok := s.t.paths[p.MovedToID]; ok {
+		return dst.ArticleID, nil
+	}
+	return 0, nil
+}
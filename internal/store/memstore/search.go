@@ -0,0 +1,205 @@
+package memstore
+
+import (
+	"context"
+	"html"
+	"sort"
+	"strings"
+	"unicode"
+
+	"coco-life.de/wapi/internal/models"
+)
+
+// snippetWords is the maximum number of words of a snippet.
+const snippetWords = 20
+
+// searchTerm is a word or a quoted phrase of a query. A negated term must not occur.
+type searchTerm struct {
+	tokens  []string
+	negated bool
+}
+
+// parseQuery splits a query in the syntax of websearch_to_tsquery into alternatives
+// separated by "or". A document matches an alternative if it contains all of its
+// terms that are not negated and none of the negated ones.
+func parseQuery(query string) [][]searchTerm {
+	var alternatives [][]searchTerm
+	var cur []searchTerm
+	for len(query) > 0 {
+		query = strings.TrimLeftFunc(query, unicode.IsSpace)
+		if query == "" {
+			break
+		}
+		negated := query[0] == '-'
+		if negated {
+			query = query[1:]
+		}
+		var text string
+		if strings.HasPrefix(query, `"`) {
+			end := strings.Index(query[1:], `"`)
+			if end < 0 {
+				text, query = query[1:], ""
+			} else {
+				text, query = query[1:end+1], query[end+2:]
+			}
+		} else {
+			end := strings.IndexFunc(query, unicode.IsSpace)
+			if end < 0 {
+				end = len(query)
+			}
+			text, query = query[:end], query[end:]
+			if !negated && strings.EqualFold(text, "or") {
+				if len(cur) > 0 {
+					alternatives = append(alternatives, cur)
+				}
+				cur = nil
+				continue
+			}
+		}
+		if tokens := tokenize(text); len(tokens) > 0 {
+			cur = append(cur, searchTerm{tokens: tokens, negated: negated})
+		}
+	}
+	if len(cur) > 0 {
+		alternatives = append(alternatives, cur)
+	}
+	return alternatives
+}
+
+// tokenize splits text into lower case words like the text search configuration
+// "simple".
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// count returns how often the tokens of t occur in a row in doc.
+func (t searchTerm) count(doc []string) int {
+	n := 0
+	for i := 0; i+len(t.tokens) <= len(doc); i++ {
+		match := true
+		for j, tok := range t.tokens {
+			if doc[i+j] != tok {
+				match = false
+				break
+			}
+		}
+		if match {
+			n++
+		}
+	}
+	return n
+}
+
+// rank returns the rank of a document with the given title and content, 0 if it does
+// not match. Like ts_rank with the weights of db.SearchArticles, a match in the title
+// counts more than a match in the content.
+func rank(alternatives [][]searchTerm, title []string, content []string) float64 {
+	best := 0.0
+	for _, terms := range alternatives {
+		r, matches := 0.0, true
+		for _, t := range terms {
+			n := t.count(title) + t.count(content)
+			if t.negated != (n == 0) {
+				matches = false
+				break
+			}
+			r += 1.0*float64(t.count(title)) + 0.4*float64(t.count(content))
+		}
+		if matches && r > best {
+			best = r
+		}
+	}
+	return best
+}
+
+// snippet returns up to snippetWords words of content starting shortly before the
+// first match. The words are HTML-escaped and matching words are enclosed in <mark>
+// and </mark>.
+func snippet(alternatives [][]searchTerm, content string) string {
+	positive := map[string]bool{}
+	for _, terms := range alternatives {
+		for _, t := range terms {
+			if !t.negated {
+				for _, tok := range t.tokens {
+					positive[tok] = true
+				}
+			}
+		}
+	}
+	words := strings.Fields(content)
+	isMatch := func(w string) bool {
+		for _, tok := range tokenize(w) {
+			if positive[tok] {
+				return true
+			}
+		}
+		return false
+	}
+	start := 0
+	for i, w := range words {
+		if isMatch(w) {
+			if start = i - 5; start < 0 {
+				start = 0
+			}
+			break
+		}
+	}
+	end := start + snippetWords
+	if end > len(words) {
+		end = len(words)
+	}
+	out := make([]string, 0, end-start)
+	for _, w := range words[start:end] {
+		if isMatch(w) {
+			out = append(out, "<mark>"+html.EscapeString(w)+"</mark>")
+		} else {
+			out = append(out, html.EscapeString(w))
+		}
+	}
+	return strings.Join(out, " ")
+}
+
+// SearchArticles approximates the full-text search of db.SearchArticles with the text
+// search configuration "simple": Words are compared in lower case without stemming.
+func (s *Store) SearchArticles(ctx context.Context, q models.SearchQuery) ([]models.SearchHit, int, error) {
+	defer s.lock()()
+	alternatives := parseQuery(q.Query)
+	var hits []models.SearchHit
+	for _, p := range s.t.paths {
+		if q.Left != 0 && (p.Lft < q.Left || p.Lft > q.Right || p.TreeID != s.t.treeOf(q.PathID)) {
+			continue
+		}
+		a := s.t.articles[p.ArticleID]
+		rev, ok := s.t.revisions[a.CurrentRevisionID]
+		if !ok || rev.Deleted || !q.Reader.Allows(*a.permissions()) {
+			continue
+		}
+		r := rank(alternatives, tokenize(rev.Title), tokenize(rev.Content))
+		if r == 0 {
+			continue
+		}
+		path := ""
+		for n := p; n != nil && n.ParentID != 0; n = s.t.paths[n.ParentID] {
+			path = n.Slug + "/" + path
+		}
+		hits = append(hits, models.SearchHit{ID: a.ID, Path: path, Title: rev.Title,
+			Snippet: snippet(alternatives, rev.Content), Rank: r})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Rank != hits[j].Rank {
+			return hits[i].Rank > hits[j].Rank
+		}
+		return hits[i].ID < hits[j].ID
+	})
+	total := len(hits)
+	if q.Offset >= total {
+		return []models.SearchHit{}, total, nil
+	}
+	hits = hits[q.Offset:]
+	if q.Limit < len(hits) {
+		hits = hits[:q.Limit]
+	}
+	return hits, total, nil
+}
@@ -0,0 +1,99 @@
+package memstore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"coco-life.de/wapi/internal/models"
+	"coco-life.de/wapi/internal/store"
+)
+
+// membership is a record of auth_user_groups.
+type membership struct {
+	UserID  int
+	GroupID int
+}
+
+// InsertAuthUser adds a Django user and returns its ID. The API never creates users,
+// this is meant for tests. auth_user-username is unique.
+func (s *Store) InsertAuthUser(ctx context.Context, u *models.AuthUser) (int, error) {
+	defer s.lock()()
+	for _, o := range s.t.users {
+		if o.Username == u.Username {
+			return -1, fmt.Errorf("%w: auth_user '%v' already exists", store.ErrConstraint, u.Username)
+		}
+	}
+	s.t.userSeq++
+	cp := *u
+	cp.ID = s.t.userSeq
+	s.t.users[cp.ID] = &cp
+	return cp.ID, nil
+}
+
+// SelectAuthUserByName selects a Django user by username.
+func (s *Store) SelectAuthUserByName(ctx context.Context, username string) (*models.AuthUser, error) {
+	defer s.lock()()
+	for _, u := range s.t.users {
+		if u.Username == username {
+			cp := *u
+			return &cp, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: auth_user '%v'", store.ErrNotFound, username)
+}
+
+// SelectAuthUserByID selects a Django user by ID.
+func (s *Store) SelectAuthUserByID(ctx context.Context, id int) (*models.AuthUser, error) {
+	defer s.lock()()
+	u, ok := s.t.users[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: auth_user %v", store.ErrNotFound, id)
+	}
+	cp := *u
+	return &cp, nil
+}
+
+// InsertAuthGroup adds a Django group and returns its ID. This is meant for tests.
+func (s *Store) InsertAuthGroup(ctx context.Context, name string) (int, error) {
+	defer s.lock()()
+	for _, n := range s.t.groups {
+		if n == name {
+			return -1, fmt.Errorf("%w: auth_group '%v' already exists", store.ErrConstraint, name)
+		}
+	}
+	s.t.groupSeq++
+	s.t.groups[s.t.groupSeq] = name
+	return s.t.groupSeq, nil
+}
+
+// AddAuthUserToGroup inserts a record into auth_user_groups. This is meant for tests.
+func (s *Store) AddAuthUserToGroup(ctx context.Context, userID int, groupID int) error {
+	defer s.lock()()
+	if _, ok := s.t.users[userID]; !ok {
+		return fmt.Errorf("%w: auth_user_groups-user_id %v does not exist", store.ErrConstraint, userID)
+	}
+	if _, ok := s.t.groups[groupID]; !ok {
+		return fmt.Errorf("%w: auth_user_groups-group_id %v does not exist", store.ErrConstraint, groupID)
+	}
+	for _, m := range s.t.memberships {
+		if m.UserID == userID && m.GroupID == groupID {
+			return fmt.Errorf("%w: auth_user_groups (%v, %v) already exists", store.ErrConstraint, userID, groupID)
+		}
+	}
+	s.t.memberships = append(s.t.memberships, membership{userID, groupID})
+	return nil
+}
+
+// SelectAuthUserGroupIDs returns the groups of a Django user.
+func (s *Store) SelectAuthUserGroupIDs(ctx context.Context, userID int) ([]int, error) {
+	defer s.lock()()
+	var ids []int
+	for _, m := range s.t.memberships {
+		if m.UserID == userID {
+			ids = append(ids, m.GroupID)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
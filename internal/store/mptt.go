@@ -0,0 +1,86 @@
+package store
+
+import "coco-life.de/wapi/internal/models"
+
+// The MPTTCalc functions compute the nested set values of the django-mptt tree in
+// wiki_urlpath. Both implementations of ArticleStore share them.
+
+// MPTTCalcForIns calculates the 'level', 'left' and 'right' for a node under a parent.
+// prtRgh is the 'right' value of the parent. The 'right' value is the anchor for being
+// able to add new child nodes as right siblings to other already existing children.
+func MPTTCalcForIns(prtLvl int, prtRght int) (lvl int, left int, right int) {
+	// Insert a new article `n` as child to parent `p`:
+	// Set `lft` and `rght` of `n` based on `p`.
+	// - `n.lft = p.rght`
+	// - `n.rght = p.rght + 1`
+	chLft := prtRght
+	chRght := prtRght + 1
+	chLvl := prtLvl + 1
+	return chLvl, chLft, chRght
+}
+
+// MPTTCalcForMove calculates how to move the subtree spanning [left, right] such that
+// it starts at target. target is a 'lft' or 'rght' value of the current numbering:
+// The 'lft' of the sibling the subtree is to be placed in front of or the 'rght' of the
+// new parent to append the subtree as its last child. target must not lie within
+// (left, right].
+//
+// After the move
+//   - all values `v` with `left <= v <= right` are shifted by delta,
+//   - all values `v` with `lo <= v <= hi` are shifted by shift, that is, the nodes
+//     between the old and the new position make room for or close the gap of the
+//     subtree,
+//   - all other values remain unchanged.
+func MPTTCalcForMove(left, right, target int) (delta, lo, hi, shift int) {
+	width := right - left + 1
+	if target > right {
+		// Moving right: The subtree ends right before target.
+		return target - right - 1, right + 1, target - 1, -width
+	}
+	// Moving left: The subtree starts at target.
+	return target - left, target, left - 1, width
+}
+
+// MPTTCalcForDelete returns the width of the gap a deleted subtree [left, right]
+// leaves. All 'lft' and 'rght' values greater than right need to be decremented by
+// it.
+func MPTTCalcForDelete(left, right int) (width int) {
+	return right - left + 1
+}
+
+// MPTTPlacement is the position of an imported node in the nested set, see
+// MPTTCalcForImport.
+type MPTTPlacement struct {
+	Node  *models.ArticleNode
+	Left  int
+	Right int
+	Level int
+	// Parent is the index of the parent placement, -1 for the nodes directly below the
+	// existing parent.
+	Parent int
+}
+
+// MPTTCalcForImport lays out the trees nodes as the last children of an existing
+// parent on level prtLvl whose 'rght' is prtRight. It returns the placements in
+// pre-order, that is, every parent precedes its descendants. Before inserting them,
+// all 'lft' and 'rght' values of the tree that are not less than prtRight need to be
+// shifted by 2*len(placements).
+func MPTTCalcForImport(nodes []models.ArticleNode, prtLvl int, prtRight int) []MPTTPlacement {
+	var placements []MPTTPlacement
+	next := prtRight
+	var visit func(n *models.ArticleNode, lvl int, parent int)
+	visit = func(n *models.ArticleNode, lvl int, parent int) {
+		idx := len(placements)
+		placements = append(placements, MPTTPlacement{Node: n, Left: next, Level: lvl, Parent: parent})
+		next++
+		for i := range n.Children {
+			visit(&n.Children[i], lvl+1, idx)
+		}
+		placements[idx].Right = next
+		next++
+	}
+	for i := range nodes {
+		visit(&nodes[i], prtLvl+1, -1)
+	}
+	return placements
+}
@@ -0,0 +1,153 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"coco-life.de/wapi/internal/models"
+)
+
+var (
+	// ErrNotFound is returned if a requested record does not exist.
+	ErrNotFound = errors.New("record not found")
+	// ErrConstraint is returned if a write would violate a constraint of the django-wiki
+	// schema, e.g. a missing foreign key or a duplicate slug under the same parent.
+	ErrConstraint = errors.New("constraint violation")
+)
+
+// ArticleStore gives access to the django-wiki tables wiki_article,
+// wiki_articlerevision and wiki_urlpath. The methods map one to one to the statements
+// the API executes, see package db for the Postgres implementation and package
+// memstore for the in-memory implementation.
+type ArticleStore interface {
+	// InTx runs f within a transaction. The transaction is committed if f returns nil
+	// and rolled back otherwise. Calling InTx on the store passed to f nests the
+	// transaction.
+	InTx(ctx context.Context, f func(tx ArticleStore) error) error
+
+	// SelectRootArticle selects the root article with its current revision.
+	SelectRootArticle(ctx context.Context) (*models.RootArticle, error)
+	// SelectArticleByID selects an article with its current revision by
+	// wiki_article-id.
+	SelectArticleByID(ctx context.Context, id int) (*models.Article, error)
+	// SelectArticleByPath selects an article with its current revision by its URL
+	// path, e.g. "foo/bar". An empty path selects the root article.
+	SelectArticleByPath(ctx context.Context, path string) (*models.Article, error)
+	// SelectArticleHeader selects an article like SelectArticleByID but without its
+	// content.
+	SelectArticleHeader(ctx context.Context, id int) (*models.Article, error)
+	// SelectArticleIDByPath returns wiki_article-id of the article with the URL path,
+	// see SelectArticleByPath.
+	SelectArticleIDByPath(ctx context.Context, path string) (int, error)
+	// SelectRevisions returns all revisions of an article ordered by revision number.
+	SelectRevisions(ctx context.Context, hdrID int) ([]models.Revision, error)
+
+	// InsertWikiArticle inserts a record into wiki_article with the given permissions
+	// and returns its ID.
+	InsertWikiArticle(ctx context.Context, perms models.Permissions) (int, error)
+	// UpdateWikiArticlePermissions sets owner, group and the permission flags of
+	// wiki_article hdrID.
+	UpdateWikiArticlePermissions(ctx context.Context, hdrID int, perms models.Permissions) error
+	// InsertWikiArticleRevision inserts the first revision of an article and returns
+	// wiki_articlerevision-id.
+	InsertWikiArticleRevision(ctx context.Context, hdrID int, title string, content string, meta models.RevisionMeta) (int, error)
+	// AddWikiArticleRevision inserts the revision following the current revision of
+	// an article, makes it the current revision and returns wiki_articlerevision-id.
+	AddWikiArticleRevision(ctx context.Context, hdrID int, title string, content string, meta models.RevisionMeta) (int, error)
+	// SetWikiArticleRevision sets wiki_article-current_revision_id.
+	SetWikiArticleRevision(ctx context.Context, hdrID int, revID int) error
+	// InsertWikiURLPathRoot inserts the wiki_urlpath record of the root article.
+	InsertWikiURLPathRoot(ctx context.Context, hdrID int) error
+	// InsertWikiURLPathChild inserts the wiki_urlpath record of a child article and
+	// returns wiki_urlpath-id. parentPathID is wiki_urlpath-id of the parent.
+	InsertWikiURLPathChild(ctx context.Context, slug string, hdrID int, lvl int, left int, right int, parentPathID int) (int, error)
+	// MPTTUpdWikiURLPathForInsert shifts 'lft' and 'rght' of all other nodes after
+	// the node newArtPathID has been inserted at nLft.
+	MPTTUpdWikiURLPathForInsert(ctx context.Context, newArtPathID int, nLft int) error
+	// SelectURLPath returns the URL path of an article like Django Wiki shows it, e.g.
+	// "a/b/", and "" for the root article.
+	SelectURLPath(ctx context.Context, hdrID int) (string, error)
+	// MoveWikiURLPath moves the subtree of wiki_urlpath pathID below newParentPathID
+	// as its pos-th child (starting at 0). A negative pos or a pos beyond the last
+	// child appends the subtree. Moving within the same parent reorders the children.
+	MoveWikiURLPath(ctx context.Context, pathID int, newParentPathID int, pos int) error
+	// UpdateWikiURLPathSlug sets the slug of wiki_urlpath pathID, which renames the
+	// subtree. A sibling with the same slug violates a constraint.
+	UpdateWikiURLPathSlug(ctx context.Context, pathID int, slug string) error
+	// SetWikiURLPathMovedTo sets wiki_urlpath-moved_to_id of pathID, which makes the
+	// node a redirect to the node movedToPathID like django-wiki's move with redirect.
+	SetWikiURLPathMovedTo(ctx context.Context, pathID int, movedToPathID int) error
+	// SelectMovedTo returns wiki_article-id of the article the URL path of article
+	// hdrID redirects to, see SetWikiURLPathMovedTo, and 0 if it is no redirect.
+	SelectMovedTo(ctx context.Context, hdrID int) (int, error)
+	// DeleteWikiURLPath deletes the subtree of wiki_urlpath pathID including the
+	// articles and revisions of all its nodes.
+	DeleteWikiURLPath(ctx context.Context, pathID int) error
+	// ImportWikiArticles inserts the trees nodes as the last children of the article
+	// parentHdrID with perms and a first revision with meta. It returns wiki_article-id
+	// of the new articles with every parent preceding its descendants.
+	ImportWikiArticles(ctx context.Context, parentHdrID int, nodes []models.ArticleNode, perms models.Permissions, meta models.RevisionMeta) ([]int, error)
+	// CopyWikiArticles copies the article hdrID with all its descendants as the last
+	// child of the article parentHdrID, see models.CopyOptions. It returns
+	// wiki_article-id of the copies with every parent preceding its descendants.
+	CopyWikiArticles(ctx context.Context, hdrID int, parentHdrID int, opts models.CopyOptions) ([]int, error)
+	// SelectArticles returns up to q.Limit summaries of the articles matching q, see
+	// models.ArticleQuery.
+	SelectArticles(ctx context.Context, q models.ArticleQuery) ([]models.ArticleSummary, error)
+	// SearchArticles returns the page of q.Limit hits starting at q.Offset and the
+	// total number of hits of a full-text search, see models.SearchQuery.
+	SearchArticles(ctx context.Context, q models.SearchQuery) ([]models.SearchHit, int, error)
+
+	// SelectUnindexedArticles returns up to limit articles whose links have not been
+	// indexed for their current revision, see ReplaceArticleLinks.
+	SelectUnindexedArticles(ctx context.Context, limit int) ([]models.LinkSource, error)
+	// ReplaceArticleLinks replaces the indexed links of article hdrID by links and
+	// records that they are the links of revision revID.
+	ReplaceArticleLinks(ctx context.Context, hdrID int, revID int, links []models.ArticleLink) error
+	// DeleteArticleLinks removes the indexed links of the article hdrID and its
+	// descendants, so that SelectUnindexedArticles returns them again. Relative links
+	// depend on the URL path, which changes without a revision of the descendants if
+	// an article is moved or renamed.
+	DeleteArticleLinks(ctx context.Context, hdrID int) error
+	// SelectArticleLinks returns the indexed links of an article ordered by target.
+	SelectArticleLinks(ctx context.Context, hdrID int) ([]models.ArticleLink, error)
+	// SelectBacklinks returns the indexed links to an article by its ID or its URL
+	// path ordered by source. With subtree, the links to its descendants are included.
+	SelectBacklinks(ctx context.Context, hdrID int, subtree bool) ([]models.ArticleLink, error)
+	// SelectBrokenLinks returns the indexed links to articles that do not exist ordered
+	// by source.
+	SelectBrokenLinks(ctx context.Context) ([]models.ArticleLink, error)
+
+	// Ping checks that the store is reachable.
+	Ping(ctx context.Context) error
+	// MissingTables returns the subset of tables that does not exist.
+	MissingTables(ctx context.Context, tables []string) ([]string, error)
+	// CountRootArticles returns the number of articles on level 0.
+	CountRootArticles(ctx context.Context) (int, error)
+	// MPTTViolations returns a description of every violated nested set rule.
+	MPTTViolations(ctx context.Context) ([]string, error)
+}
+
+// UserStore gives read access to the Django table auth_user. The API never changes
+// users, they are maintained in Django.
+type UserStore interface {
+	// SelectAuthUserByName selects a user by auth_user-username.
+	SelectAuthUserByName(ctx context.Context, username string) (*models.AuthUser, error)
+	// SelectAuthUserByID selects a user by auth_user-id.
+	SelectAuthUserByID(ctx context.Context, id int) (*models.AuthUser, error)
+	// SelectAuthUserGroupIDs returns auth_group-id of all groups of a user.
+	SelectAuthUserGroupIDs(ctx context.Context, userID int) ([]int, error)
+}
+
+// SplitPath splits a URL path like "/foo/bar/" into its slugs. Empty segments are
+// dropped, that is, "" and "/" result in no slugs and denote the root article.
+func SplitPath(path string) []string {
+	var slugs []string
+	for _, s := range strings.Split(path, "/") {
+		if s != "" {
+			slugs = append(slugs, s)
+		}
+	}
+	return slugs
+}
@@ -0,0 +1,389 @@
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"coco-life.de/wapi/internal/models"
+	"coco-life.de/wapi/internal/store"
+	"github.com/stretchr/testify/require"
+)
+
+// MPTTConfig configures RunMPTT.
+type MPTTConfig struct {
+	// Sequences is the number of random operation sequences.
+	Sequences int
+	// Steps is the number of operations per sequence.
+	Steps int
+}
+
+// NewMPTTStore returns an empty store and a function that releases it. RunMPTT
+// creates a store for every sequence it runs, shrinking a failure runs hundreds, so
+// each store is released as soon as its sequence is done.
+type NewMPTTStore func(t *testing.T) (store.ArticleStore, func())
+
+// opKind is the kind of a tree operation.
+type opKind int
+
+const (
+	opInsert opKind = iota
+	opMove
+	opDelete
+	opReorder
+	opCopy
+	numOpKinds
+)
+
+// maxCopyNodes keeps opCopy from growing the tree exponentially: Subtrees are not
+// copied if the tree would exceed it.
+const maxCopyNodes = 200
+
+// op is a tree operation. A, B and C select the nodes and positions the operation
+// works on relative to the tree it is applied to, see refTree.apply. Thereby every
+// subsequence of a valid sequence is valid as well, which allows shrinking.
+type op struct {
+	Kind    opKind
+	A, B, C int
+}
+
+func (o op) String() string {
+	name := [...]string{"insert", "move", "delete", "reorder", "copy"}[o.Kind]
+	return fmt.Sprintf("%v(%v,%v,%v)", name, o.A, o.B, o.C)
+}
+
+// refNode is a node of the reference model.
+type refNode struct {
+	hdrID    int
+	slug     string
+	parent   *refNode
+	children []*refNode
+}
+
+// refTree is a naive reference model of the article tree: Every node knows its
+// ordered children and the nested set is derived by a depth-first traversal.
+type refTree struct {
+	root    *refNode
+	slugSeq int
+}
+
+// nodes returns all nodes in depth-first order.
+func (r *refTree) nodes() []*refNode {
+	var all []*refNode
+	var walk func(n *refNode)
+	walk = func(n *refNode) {
+		all = append(all, n)
+		for _, c := range n.children {
+			walk(c)
+		}
+	}
+	walk(r.root)
+	return all
+}
+
+// isBelow returns 'true' if n equals a or is one of its descendants.
+func isBelow(n, a *refNode) bool {
+	for ; n != nil; n = n.parent {
+		if n == a {
+			return true
+		}
+	}
+	return false
+}
+
+func removeChild(parent, child *refNode) {
+	for i, c := range parent.children {
+		if c == child {
+			parent.children = append(parent.children[:i], parent.children[i+1:]...)
+			return
+		}
+	}
+}
+
+func insertChild(parent, child *refNode, pos int) {
+	if pos < 0 || pos >= len(parent.children) {
+		parent.children = append(parent.children, child)
+	} else {
+		parent.children = append(parent.children[:pos], append([]*refNode{child}, parent.children[pos:]...)...)
+	}
+	child.parent = parent
+}
+
+// cloneNode returns a copy of the subtree of n whose nodes take hdrIDs in depth-first
+// order.
+func cloneNode(n *refNode, hdrIDs *[]int) *refNode {
+	cp := &refNode{hdrID: (*hdrIDs)[0], slug: n.slug}
+	*hdrIDs = (*hdrIDs)[1:]
+	for _, c := range n.children {
+		child := cloneNode(c, hdrIDs)
+		child.parent = cp
+		cp.children = append(cp.children, child)
+	}
+	return cp
+}
+
+// expected is the nested set of a node according to the reference model.
+type expected struct {
+	left, right, level, parentHdrID int
+}
+
+// nestedSet numbers the reference model like django-mptt does.
+func (r *refTree) nestedSet() map[int]expected {
+	res := map[int]expected{}
+	counter := 0
+	var walk func(n *refNode, lvl int)
+	walk = func(n *refNode, lvl int) {
+		counter++
+		left := counter
+		for _, c := range n.children {
+			walk(c, lvl+1)
+		}
+		counter++
+		parentHdrID := -1
+		if n.parent != nil {
+			parentHdrID = n.parent.hdrID
+		}
+		res[n.hdrID] = expected{left, counter, lvl, parentHdrID}
+	}
+	walk(r.root, 0)
+	return res
+}
+
+// apply applies o to the reference model and the store. Operations that are not
+// applicable to the current tree, e.g. deleting if there is nothing but the root, are
+// skipped.
+func (r *refTree) apply(s store.ArticleStore, o op) error {
+	ctx := context.Background()
+	nodes := r.nodes()
+	nonRoot := nodes[1:]
+	switch o.Kind {
+	case opInsert:
+		parent := nodes[o.A%len(nodes)]
+		r.slugSeq++
+		slug := "n" + strconv.Itoa(r.slugSeq)
+		hdrID, err := InsertChild(s, parent.hdrID, slug)
+		if err != nil {
+			return fmt.Errorf("insert below %v: %w", parent.hdrID, err)
+		}
+		insertChild(parent, &refNode{hdrID: hdrID, slug: slug}, -1)
+
+	case opMove:
+		if len(nonRoot) == 0 {
+			return nil
+		}
+		n := nonRoot[o.A%len(nonRoot)]
+		var targets []*refNode
+		for _, t := range nodes {
+			if !isBelow(t, n) {
+				targets = append(targets, t)
+			}
+		}
+		parent := targets[o.B%len(targets)]
+		// Copies keep the slugs of the descendants, which may not be moved next to each
+		// other.
+		for _, c := range parent.children {
+			if c != n && c.slug == n.slug {
+				return nil
+			}
+		}
+		pos := o.C % (len(parent.children) + 1)
+		if err := move(ctx, s, n.hdrID, parent.hdrID, pos); err != nil {
+			return fmt.Errorf("move %v below %v at %v: %w", n.hdrID, parent.hdrID, pos, err)
+		}
+		removeChild(n.parent, n)
+		insertChild(parent, n, pos)
+
+	case opReorder:
+		if len(nonRoot) == 0 {
+			return nil
+		}
+		n := nonRoot[o.A%len(nonRoot)]
+		pos := o.B % len(n.parent.children)
+		if err := move(ctx, s, n.hdrID, n.parent.hdrID, pos); err != nil {
+			return fmt.Errorf("reorder %v to %v: %w", n.hdrID, pos, err)
+		}
+		parent := n.parent
+		removeChild(parent, n)
+		insertChild(parent, n, pos)
+
+	case opDelete:
+		if len(nonRoot) == 0 {
+			return nil
+		}
+		n := nonRoot[o.A%len(nonRoot)]
+		a, err := s.SelectArticleByID(ctx, n.hdrID)
+		if err != nil {
+			return fmt.Errorf("delete %v: %w", n.hdrID, err)
+		}
+		if err := s.DeleteWikiURLPath(ctx, a.PathID); err != nil {
+			return fmt.Errorf("delete %v: %w", n.hdrID, err)
+		}
+		removeChild(n.parent, n)
+
+	case opCopy:
+		if len(nonRoot) == 0 {
+			return nil
+		}
+		n := nonRoot[o.A%len(nonRoot)]
+		size := len((&refTree{root: n}).nodes())
+		if len(nodes)+size > maxCopyNodes {
+			return nil
+		}
+		var targets []*refNode
+		for _, t := range nodes {
+			if !isBelow(t, n) {
+				targets = append(targets, t)
+			}
+		}
+		parent := targets[o.B%len(targets)]
+		r.slugSeq++
+		slug := "n" + strconv.Itoa(r.slugSeq)
+		hdrIDs, err := s.CopyWikiArticles(ctx, n.hdrID, parent.hdrID, models.CopyOptions{Slug: slug, History: o.C%2 == 0})
+		if err != nil {
+			return fmt.Errorf("copy %v below %v: %w", n.hdrID, parent.hdrID, err)
+		}
+		if len(hdrIDs) != size {
+			return fmt.Errorf("copy %v below %v: expected %v copies, got %v", n.hdrID, parent.hdrID, size, len(hdrIDs))
+		}
+		cp := cloneNode(n, &hdrIDs)
+		cp.slug = slug
+		insertChild(parent, cp, -1)
+	}
+	return nil
+}
+
+// move moves the article hdrID below the article parentHdrID.
+func move(ctx context.Context, s store.ArticleStore, hdrID, parentHdrID, pos int) error {
+	return s.InTx(ctx, func(tx store.ArticleStore) error {
+		a, err := tx.SelectArticleByID(ctx, hdrID)
+		if err != nil {
+			return err
+		}
+		p, err := tx.SelectArticleByID(ctx, parentHdrID)
+		if err != nil {
+			return err
+		}
+		return tx.MoveWikiURLPath(ctx, a.PathID, p.PathID, pos)
+	})
+}
+
+// verify compares the store with the reference model.
+func (r *refTree) verify(s store.ArticleStore, deleted []int) error {
+	ctx := context.Background()
+	for hdrID, exp := range r.nestedSet() {
+		a, err := s.SelectArticleByID(ctx, hdrID)
+		if err != nil {
+			return fmt.Errorf("article %v: %w", hdrID, err)
+		}
+		act := expected{a.Left, a.Right, a.Level, a.ParentArtID}
+		if act != exp {
+			return fmt.Errorf("article %v: expected (lft %v, rght %v, level %v, parent %v), got (lft %v, rght %v, level %v, parent %v)",
+				hdrID, exp.left, exp.right, exp.level, exp.parentHdrID, act.left, act.right, act.level, act.parentHdrID)
+		}
+	}
+	for _, hdrID := range deleted {
+		if _, err := s.SelectArticleByID(ctx, hdrID); !errors.Is(err, store.ErrNotFound) {
+			return fmt.Errorf("deleted article %v still exists: %v", hdrID, err)
+		}
+	}
+	violations, err := s.MPTTViolations(ctx)
+	if err != nil {
+		return err
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("nested set violated: %v", strings.Join(violations, "; "))
+	}
+	return nil
+}
+
+// runOps applies ops to a fresh store and returns the index of the first failing
+// operation and the failure, or -1 and nil if all operations succeed.
+func runOps(t *testing.T, newStore NewMPTTStore, ops []op) (int, error) {
+	s, closeStore := newStore(t)
+	defer closeStore()
+	r := &refTree{root: &refNode{hdrID: InsertRoot(t, s, "Root")}}
+	for i, o := range ops {
+		before := map[int]bool{}
+		for _, n := range r.nodes() {
+			before[n.hdrID] = true
+		}
+		if err := r.apply(s, o); err != nil {
+			return i, err
+		}
+		for _, n := range r.nodes() {
+			delete(before, n.hdrID)
+		}
+		var deleted []int
+		for hdrID := range before {
+			deleted = append(deleted, hdrID)
+		}
+		if err := r.verify(s, deleted); err != nil {
+			return i, err
+		}
+	}
+	return -1, nil
+}
+
+// shrink removes operations from a failing sequence as long as it keeps failing.
+// It first tries to drop large chunks and then single operations.
+func shrink(t *testing.T, newStore NewMPTTStore, ops []op) []op {
+	fails := func(cand []op) bool {
+		i, _ := runOps(t, newStore, cand)
+		return i >= 0
+	}
+	for chunk := len(ops) / 2; chunk >= 1; chunk /= 2 {
+		for start := 0; start+chunk <= len(ops); {
+			cand := append(append([]op{}, ops[:start]...), ops[start+chunk:]...)
+			if fails(cand) {
+				ops = cand
+			} else {
+				start += chunk
+			}
+		}
+	}
+	return ops
+}
+
+// RunMPTT applies random sequences of insert, move, delete, reorder and copy
+// operations to the store and to a reference model and checks after every step that
+// 'lft', 'rght', 'level' and the parent of every article agree. A failing sequence is
+// shrunk to a minimal reproduction.
+//
+// The random seed is logged and can be fixed with the environment variable
+// WAPI_MPTT_SEED.
+func RunMPTT(t *testing.T, newStore NewMPTTStore, cfg MPTTConfig) {
+	seed := time.Now().UnixNano()
+	if v := os.Getenv("WAPI_MPTT_SEED"); v != "" {
+		var err error
+		seed, err = strconv.ParseInt(v, 10, 64)
+		require.Nil(t, err, "WAPI_MPTT_SEED")
+	}
+	t.Logf("WAPI_MPTT_SEED=%v", seed)
+	rnd := rand.New(rand.NewSource(seed))
+
+	for seq := 0; seq < cfg.Sequences; seq++ {
+		ops := make([]op, cfg.Steps)
+		for i := range ops {
+			// Favour inserts to grow trees deep enough for interesting moves.
+			kind := opKind(rnd.Intn(int(numOpKinds) + 2))
+			if kind >= numOpKinds {
+				kind = opInsert
+			}
+			ops[i] = op{kind, rnd.Int(), rnd.Int(), rnd.Int()}
+		}
+		i, err := runOps(t, newStore, ops)
+		if err == nil {
+			continue
+		}
+		minimal := shrink(t, newStore, ops[:i+1])
+		_, minErr := runOps(t, newStore, minimal)
+		t.Fatalf("sequence %v failed at step %v: %v\nminimal reproduction (%v ops): %v\nfailure: %v",
+			seq, i, err, len(minimal), minimal, minErr)
+	}
+}
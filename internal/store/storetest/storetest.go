@@ -0,0 +1,788 @@
+// Package storetest contains a test suite every implementation of
+// store.ArticleStore has to pass.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"coco-life.de/wapi/internal/models"
+	"coco-life.de/wapi/internal/store"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Run runs the suite. newStore has to return an empty store for every call.
+func Run(t *testing.T, newStore func(t *testing.T) store.ArticleStore) {
+	t.Run("InsertAndSelect", func(t *testing.T) { testInsertAndSelect(t, newStore(t)) })
+	t.Run("Rollback", func(t *testing.T) { testRollback(t, newStore(t)) })
+	t.Run("Constraints", func(t *testing.T) { testConstraints(t, newStore(t)) })
+	t.Run("PermissionsAndRevisions", func(t *testing.T) { testPermissionsAndRevisions(t, newStore(t)) })
+	t.Run("Import", func(t *testing.T) { testImport(t, newStore(t)) })
+	t.Run("Search", func(t *testing.T) { testSearch(t, newStore(t)) })
+	t.Run("List", func(t *testing.T) { testList(t, newStore(t)) })
+	t.Run("HeaderAndRevisions", func(t *testing.T) { testHeaderAndRevisions(t, newStore(t)) })
+	t.Run("Links", func(t *testing.T) { testLinks(t, newStore(t)) })
+	t.Run("LinksOfMovedSubtree", func(t *testing.T) { testLinksOfMovedSubtree(t, newStore(t)) })
+	t.Run("Redirects", func(t *testing.T) { testRedirects(t, newStore(t)) })
+	t.Run("Copy", func(t *testing.T) { testCopy(t, newStore(t)) })
+}
+
+// InsertRoot creates the root article the same way the handlers do.
+func InsertRoot(t testing.TB, s store.ArticleStore, title string) int {
+	ctx := context.Background()
+	var hdrID int
+	err := s.InTx(ctx, func(tx store.ArticleStore) error {
+		var err error
+		hdrID, err = tx.InsertWikiArticle(ctx, models.DefaultPermissions)
+		if err != nil {
+			return err
+		}
+		revID, err := tx.InsertWikiArticleRevision(ctx, hdrID, title, "# "+title, models.RevisionMeta{})
+		if err != nil {
+			return err
+		}
+		if err := tx.InsertWikiURLPathRoot(ctx, hdrID); err != nil {
+			return err
+		}
+		return tx.SetWikiArticleRevision(ctx, hdrID, revID)
+	})
+	require.Nil(t, err)
+	return hdrID
+}
+
+// InsertChild creates a child article the same way the handlers do.
+func InsertChild(s store.ArticleStore, parentArtID int, slug string) (int, error) {
+	ctx := context.Background()
+	var hdrID int
+	err := s.InTx(ctx, func(tx store.ArticleStore) error {
+		parent, err := tx.SelectArticleByID(ctx, parentArtID)
+		if err != nil {
+			return err
+		}
+		hdrID, err = tx.InsertWikiArticle(ctx, models.DefaultPermissions)
+		if err != nil {
+			return err
+		}
+		revID, err := tx.InsertWikiArticleRevision(ctx, hdrID, slug, "# "+slug, models.RevisionMeta{})
+		if err != nil {
+			return err
+		}
+		if err := tx.SetWikiArticleRevision(ctx, hdrID, revID); err != nil {
+			return err
+		}
+		lvl, left, right := store.MPTTCalcForIns(parent.Level, parent.Right)
+		pathID, err := tx.InsertWikiURLPathChild(ctx, slug, hdrID, lvl, left, right, parent.PathID)
+		if err != nil {
+			return err
+		}
+		return tx.MPTTUpdWikiURLPathForInsert(ctx, pathID, left)
+	})
+	return hdrID, err
+}
+
+// assertSound fails the test if the nested set is broken.
+func assertSound(t *testing.T, s store.ArticleStore) {
+	violations, err := s.MPTTViolations(context.Background())
+	require.Nil(t, err)
+	assert.Empty(t, violations)
+}
+
+// / -> /a -> /a/b and /c
+func testInsertAndSelect(t *testing.T, s store.ArticleStore) {
+	ctx := context.Background()
+	rootID := InsertRoot(t, s, "Root")
+	aID, err := InsertChild(s, rootID, "a")
+	require.Nil(t, err)
+	bID, err := InsertChild(s, aID, "b")
+	require.Nil(t, err)
+	cID, err := InsertChild(s, rootID, "c")
+	require.Nil(t, err)
+	assertSound(t, s)
+
+	root, err := s.SelectRootArticle(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, rootID, root.ID)
+	assert.Equal(t, 1, root.Left)
+	assert.Equal(t, 8, root.Right)
+
+	b, err := s.SelectArticleByPath(ctx, "/a/b/")
+	require.Nil(t, err)
+	assert.Equal(t, bID, b.ID)
+	assert.Equal(t, aID, b.ParentArtID)
+	assert.Equal(t, 2, b.Level)
+	assert.Equal(t, 3, b.Left)
+	assert.Equal(t, 4, b.Right)
+
+	c, err := s.SelectArticleByID(ctx, cID)
+	require.Nil(t, err)
+	assert.Equal(t, "c", c.Slug)
+	assert.Equal(t, "# c", c.Content)
+	assert.Equal(t, 6, c.Left)
+	assert.Equal(t, 7, c.Right)
+
+	r, err := s.SelectArticleByPath(ctx, "")
+	require.Nil(t, err)
+	assert.Equal(t, rootID, r.ID)
+	assert.Equal(t, -1, r.ParentArtID)
+
+	missing, err := s.MissingTables(ctx, []string{"wiki_article", "wiki_urlpath", "no_such_table"})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"no_such_table"}, missing)
+
+	n, err := s.CountRootArticles(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	_, err = s.SelectArticleByPath(ctx, "a/x")
+	assert.True(t, errors.Is(err, store.ErrNotFound), "unexpected error %v", err)
+	_, err = s.SelectArticleByID(ctx, cID+1000)
+	assert.True(t, errors.Is(err, store.ErrNotFound), "unexpected error %v", err)
+}
+
+// A failing transaction must not leave any trace.
+func testRollback(t *testing.T, s store.ArticleStore) {
+	ctx := context.Background()
+	rootID := InsertRoot(t, s, "Root")
+	failure := errors.New("failure")
+	err := s.InTx(ctx, func(tx store.ArticleStore) error {
+		if _, err := InsertChild(tx, rootID, "a"); err != nil {
+			return err
+		}
+		return failure
+	})
+	assert.Equal(t, failure, err)
+
+	_, err = s.SelectArticleByPath(ctx, "a")
+	assert.True(t, errors.Is(err, store.ErrNotFound), "unexpected error %v", err)
+	root, err := s.SelectRootArticle(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, 2, root.Right)
+	assertSound(t, s)
+}
+
+func testConstraints(t *testing.T, s store.ArticleStore) {
+	ctx := context.Background()
+	rootID := InsertRoot(t, s, "Root")
+	_, err := InsertChild(s, rootID, "a")
+	require.Nil(t, err)
+
+	// Duplicate slug under the same parent.
+	_, err = InsertChild(s, rootID, "a")
+	assert.True(t, errors.Is(err, store.ErrConstraint), "unexpected error %v", err)
+	assertSound(t, s)
+
+	// Renaming to the slug of a sibling.
+	bID, err := InsertChild(s, rootID, "b")
+	require.Nil(t, err)
+	b, err := s.SelectArticleHeader(ctx, bID)
+	require.Nil(t, err)
+	err = s.UpdateWikiURLPathSlug(ctx, b.PathID, "a")
+	assert.True(t, errors.Is(err, store.ErrConstraint), "unexpected error %v", err)
+	require.Nil(t, s.UpdateWikiURLPathSlug(ctx, b.PathID, "c"))
+	path, err := s.SelectURLPath(ctx, bID)
+	require.Nil(t, err)
+	assert.Equal(t, "c/", path)
+	assertSound(t, s)
+
+	// Revision of an article that does not exist.
+	_, err = s.InsertWikiArticleRevision(ctx, rootID+1000, "Title", "Content", models.RevisionMeta{})
+	assert.True(t, errors.Is(err, store.ErrConstraint), "unexpected error %v", err)
+
+	// Two articles sharing the same current revision.
+	root, err := s.SelectRootArticle(ctx)
+	require.Nil(t, err)
+	err = s.InTx(ctx, func(tx store.ArticleStore) error {
+		hdrID, err := tx.InsertWikiArticle(ctx, models.DefaultPermissions)
+		require.Nil(t, err)
+		return tx.SetWikiArticleRevision(ctx, hdrID, root.RevisionID)
+	})
+	assert.True(t, errors.Is(err, store.ErrConstraint), "unexpected error %v", err)
+}
+
+// Permissions and revisions of an existing article are updated in place.
+func testPermissionsAndRevisions(t *testing.T, s store.ArticleStore) {
+	ctx := context.Background()
+	rootID := InsertRoot(t, s, "Root")
+	aID, err := InsertChild(s, rootID, "a")
+	require.Nil(t, err)
+
+	a, err := s.SelectArticleByID(ctx, aID)
+	require.Nil(t, err)
+	require.NotNil(t, a.Permissions)
+	assert.Equal(t, models.DefaultPermissions, *a.Permissions)
+
+	restricted := models.Permissions{GroupRead: true}
+	require.Nil(t, s.UpdateWikiArticlePermissions(ctx, aID, restricted))
+	a, err = s.SelectArticleByPath(ctx, "a")
+	require.Nil(t, err)
+	assert.Equal(t, restricted, *a.Permissions)
+	root, err := s.SelectRootArticle(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, models.DefaultPermissions, *root.Permissions)
+
+	err = s.UpdateWikiArticlePermissions(ctx, aID, models.Permissions{OwnerID: 4711})
+	assert.True(t, errors.Is(err, store.ErrConstraint), "unexpected error %v", err)
+	err = s.UpdateWikiArticlePermissions(ctx, aID+1000, restricted)
+	assert.True(t, errors.Is(err, store.ErrNotFound), "unexpected error %v", err)
+
+	revID, err := s.AddWikiArticleRevision(ctx, aID, "A", "# A, second revision",
+		models.RevisionMeta{IPAddress: "192.0.2.1", UserMessage: "Typo", AutomaticLog: "Updated", Deleted: true, Locked: true})
+	require.Nil(t, err)
+	a2, err := s.SelectArticleByID(ctx, aID)
+	require.Nil(t, err)
+	assert.Equal(t, revID, a2.RevisionID)
+	assert.NotEqual(t, a.RevisionID, a2.RevisionID)
+	assert.Equal(t, "A", a2.Title)
+	assert.Equal(t, "# A, second revision", a2.Content)
+	assert.Equal(t, a.Left, a2.Left)
+	assert.True(t, a2.Deleted)
+	assert.True(t, a2.Locked)
+	assert.False(t, a.Locked)
+
+	_, err = s.AddWikiArticleRevision(ctx, aID+1000, "X", "X", models.RevisionMeta{})
+	assert.True(t, errors.Is(err, store.ErrNotFound), "unexpected error %v", err)
+	_, err = s.AddWikiArticleRevision(ctx, aID, "X", "X", models.RevisionMeta{UserID: 4711})
+	assert.True(t, errors.Is(err, store.ErrConstraint), "unexpected error %v", err)
+	_, err = s.AddWikiArticleRevision(ctx, aID, "X", "X", models.RevisionMeta{IPAddress: "no ip"})
+	assert.NotNil(t, err)
+
+	bID, err := InsertChild(s, aID, "b")
+	require.Nil(t, err)
+	for hdrID, exp := range map[int]string{rootID: "", aID: "a/", bID: "a/b/"} {
+		path, err := s.SelectURLPath(ctx, hdrID)
+		require.Nil(t, err)
+		assert.Equal(t, exp, path)
+	}
+	_, err = s.SelectURLPath(ctx, bID+1000)
+	assert.True(t, errors.Is(err, store.ErrNotFound), "unexpected error %v", err)
+}
+
+// / -> /a and /c, importing /a/x -> /a/x/y and /a/z and then /c/w
+func testImport(t *testing.T, s store.ArticleStore) {
+	ctx := context.Background()
+	rootID := InsertRoot(t, s, "Root")
+	aID, err := InsertChild(s, rootID, "a")
+	require.Nil(t, err)
+	cID, err := InsertChild(s, rootID, "c")
+	require.Nil(t, err)
+
+	perms := models.Permissions{GroupRead: true, OtherRead: true}
+	meta := models.RevisionMeta{IPAddress: "192.0.2.1", AutomaticLog: "Imported"}
+	nodes := []models.ArticleNode{
+		{Slug: "x", Title: "X", Content: "# X", Children: []models.ArticleNode{{Slug: "y", Title: "Y"}}},
+		{Slug: "z", Title: "Z"},
+	}
+	ids, err := s.ImportWikiArticles(ctx, aID, nodes, perms, meta)
+	require.Nil(t, err)
+	require.Len(t, ids, 3)
+	assertSound(t, s)
+	for i, path := range []string{"a/x/", "a/x/y/", "a/z/"} {
+		p, err := s.SelectURLPath(ctx, ids[i])
+		require.Nil(t, err)
+		assert.Equal(t, path, p)
+	}
+	x, err := s.SelectArticleByPath(ctx, "a/x")
+	require.Nil(t, err)
+	assert.Equal(t, ids[0], x.ID)
+	assert.Equal(t, "X", x.Title)
+	assert.Equal(t, "# X", x.Content)
+	assert.Equal(t, 2, x.Level)
+	assert.Equal(t, perms, *x.Permissions)
+	c, err := s.SelectArticleByID(ctx, cID)
+	require.Nil(t, err)
+	assert.Equal(t, c.Left+1, c.Right)
+
+	_, err = s.ImportWikiArticles(ctx, cID, []models.ArticleNode{{Slug: "w"}}, perms, meta)
+	require.Nil(t, err)
+	assertSound(t, s)
+
+	// A slug that exists below the parent or twice among the new siblings fails the
+	// whole import.
+	for _, nodes := range [][]models.ArticleNode{
+		{{Slug: "v"}, {Slug: "x"}},
+		{{Slug: "v", Children: []models.ArticleNode{{Slug: "u"}, {Slug: "u"}}}},
+	} {
+		_, err = s.ImportWikiArticles(ctx, aID, nodes, perms, meta)
+		assert.True(t, errors.Is(err, store.ErrConstraint), "unexpected error %v", err)
+		_, err = s.SelectArticleByPath(ctx, "a/v")
+		assert.True(t, errors.Is(err, store.ErrNotFound), "unexpected error %v", err)
+	}
+	_, err = s.ImportWikiArticles(ctx, aID, []models.ArticleNode{{Slug: "v"}}, models.Permissions{OwnerID: 4711}, meta)
+	assert.True(t, errors.Is(err, store.ErrConstraint), "unexpected error %v", err)
+	_, err = s.ImportWikiArticles(ctx, cID+1000, nodes, perms, meta)
+	assert.True(t, errors.Is(err, store.ErrNotFound), "unexpected error %v", err)
+	assertSound(t, s)
+}
+
+// / -> /guide -> /guide/install and /faq, /private, /old
+func testSearch(t *testing.T, s store.ArticleStore) {
+	ctx := context.Background()
+	rootID := InsertRoot(t, s, "Root")
+	ids := map[string]int{}
+	contents := []struct{ parent, slug, title, content string }{
+		{"", "guide", "Guide", "Read this before you install the wiki."},
+		{"guide", "install", "Install the wiki", "Download the release and run the installer."},
+		{"", "faq", "FAQ", "How do I install plugins? See the guide."},
+		{"", "private", "Private", "Install secrets."},
+		{"", "old", "Old", "Install the old wiki."},
+		{"", "xss", "XSS", `Escape <script>alert("xss")</script> & more.`},
+	}
+	for _, c := range contents {
+		parentID := rootID
+		if c.parent != "" {
+			parentID = ids[c.parent]
+		}
+		id, err := InsertChild(s, parentID, c.slug)
+		require.Nil(t, err)
+		ids[c.slug] = id
+		_, err = s.AddWikiArticleRevision(ctx, id, c.title, c.content, models.RevisionMeta{Deleted: c.slug == "old"})
+		require.Nil(t, err)
+	}
+	require.Nil(t, s.UpdateWikiArticlePermissions(ctx, ids["private"], models.Permissions{}))
+
+	search := func(query string, subtree *models.Article, reader models.ReadFilter, limit int, offset int) ([]string, int) {
+		q := models.SearchQuery{Query: query, Config: "simple", Reader: reader, Limit: limit, Offset: offset}
+		if subtree != nil {
+			q.Left, q.Right, q.PathID = subtree.Left, subtree.Right, subtree.PathID
+		}
+		hits, total, err := s.SearchArticles(ctx, q)
+		require.Nil(t, err)
+		paths := []string{}
+		for _, h := range hits {
+			paths = append(paths, h.Path)
+		}
+		return paths, total
+	}
+	anyone := models.ReadFilter{}
+
+	// The match in the title ranks first, deleted and unreadable articles are skipped.
+	all, total := search("install", nil, anyone, 10, 0)
+	require.Len(t, all, 3)
+	assert.Equal(t, "guide/install/", all[0])
+	assert.ElementsMatch(t, []string{"guide/install/", "faq/", "guide/"}, all)
+	assert.Equal(t, 3, total)
+	paths, _ := search("install", nil, models.ReadFilter{All: true}, 10, 0)
+	assert.Contains(t, paths, "private/")
+	paths, _ = search("install -plugins", nil, anyone, 10, 0)
+	assert.ElementsMatch(t, []string{"guide/install/", "guide/"}, paths)
+	paths, _ = search(`"run the installer" or plugins`, nil, anyone, 10, 0)
+	assert.ElementsMatch(t, []string{"guide/install/", "faq/"}, paths)
+	paths, _ = search("nothing", nil, anyone, 10, 0)
+	assert.Empty(t, paths)
+
+	// Subtree and pagination.
+	guide, err := s.SelectArticleByID(ctx, ids["guide"])
+	require.Nil(t, err)
+	paths, total = search("install", guide, anyone, 10, 0)
+	assert.ElementsMatch(t, []string{"guide/install/", "guide/"}, paths)
+	assert.Equal(t, 2, total)
+	paths, total = search("install", nil, anyone, 1, 1)
+	assert.Equal(t, all[1:2], paths)
+	assert.Equal(t, 3, total)
+	paths, total = search("install", nil, anyone, 10, 5)
+	assert.Empty(t, paths)
+	assert.Equal(t, 3, total)
+
+	hits, _, err := s.SearchArticles(ctx, models.SearchQuery{Query: "plugins", Config: "simple", Limit: 10})
+	require.Nil(t, err)
+	require.Len(t, hits, 1)
+	assert.Equal(t, ids["faq"], hits[0].ID)
+	assert.Equal(t, "FAQ", hits[0].Title)
+	assert.Contains(t, hits[0].Snippet, "<mark>plugins")
+	assert.Greater(t, hits[0].Rank, 0.0)
+
+	// The <mark> tags are the only markup of a snippet.
+	hits, _, err = s.SearchArticles(ctx, models.SearchQuery{Query: "xss", Config: "simple", Limit: 10})
+	require.Nil(t, err)
+	require.Len(t, hits, 1)
+	assert.Contains(t, hits[0].Snippet, "<mark>")
+	assert.Contains(t, hits[0].Snippet, "&lt;script&gt;")
+	assert.Contains(t, hits[0].Snippet, "&amp;")
+	assert.NotContains(t, hits[0].Snippet, "<script")
+}
+
+// / -> /a -> /a/b and /a/c and /d (deleted), listed with filters and in pages
+func testList(t *testing.T, s store.ArticleStore) {
+	ctx := context.Background()
+	rootID := InsertRoot(t, s, "Root")
+	aID, err := InsertChild(s, rootID, "a")
+	require.Nil(t, err)
+	bID, err := InsertChild(s, aID, "b")
+	require.Nil(t, err)
+	cID, err := InsertChild(s, aID, "c")
+	require.Nil(t, err)
+	dID, err := InsertChild(s, rootID, "d")
+	require.Nil(t, err)
+	_, err = s.AddWikiArticleRevision(ctx, dID, "D", "# D", models.RevisionMeta{Deleted: true})
+	require.Nil(t, err)
+	_, err = s.AddWikiArticleRevision(ctx, bID, "Bee", "# Bee", models.RevisionMeta{})
+	require.Nil(t, err)
+
+	all := models.ReadFilter{All: true}
+	list := func(q models.ArticleQuery) []int {
+		if q.Limit == 0 {
+			q.Limit = 100
+		}
+		if q.SortBy == "" {
+			q.SortBy = "id"
+		}
+		summaries, err := s.SelectArticles(ctx, q)
+		require.Nil(t, err)
+		ids := []int{}
+		for _, a := range summaries {
+			ids = append(ids, a.ID)
+		}
+		return ids
+	}
+	yes, no, one := true, false, 1
+
+	assert.Equal(t, []int{rootID, aID, bID, cID, dID}, list(models.ArticleQuery{Reader: all}))
+	assert.Equal(t, []int{dID, cID, bID, aID, rootID}, list(models.ArticleQuery{Reader: all, Desc: true}))
+	assert.Equal(t, []int{bID}, list(models.ArticleQuery{Reader: all, TitlePrefix: "be"}))
+	assert.Equal(t, []int{cID}, list(models.ArticleQuery{Reader: all, Slug: "c"}))
+	assert.Equal(t, []int{aID, dID}, list(models.ArticleQuery{Reader: all, Level: &one}))
+	assert.Equal(t, []int{rootID, aID}, list(models.ArticleQuery{Reader: all, HasChildren: &yes}))
+	assert.Equal(t, []int{dID}, list(models.ArticleQuery{Reader: all, Deleted: &yes}))
+	assert.Equal(t, []int{rootID, aID, bID, cID}, list(models.ArticleQuery{Reader: all, Deleted: &no}))
+	a, err := s.SelectArticleByID(ctx, aID)
+	require.Nil(t, err)
+	assert.Equal(t, []int{bID, cID}, list(models.ArticleQuery{Reader: all, Left: a.Left, Right: a.Right, PathID: a.PathID}))
+	assert.Empty(t, list(models.ArticleQuery{Reader: all, CreatedSince: time.Now().Add(time.Hour)}))
+	assert.Len(t, list(models.ArticleQuery{Reader: all, ModifiedSince: time.Now().Add(-time.Hour)}), 5)
+
+	require.Nil(t, s.UpdateWikiArticlePermissions(ctx, cID, models.Permissions{}))
+	assert.Equal(t, []int{rootID, aID, bID, dID}, list(models.ArticleQuery{}))
+
+	// Paging through the articles sorted by modification returns every article once.
+	for _, desc := range []bool{false, true} {
+		q := models.ArticleQuery{Reader: all, SortBy: "modified", Desc: desc, Limit: 2}
+		var seen []int
+		var last *models.ArticleSummary
+		for page := 0; page < 5; page++ {
+			summaries, err := s.SelectArticles(ctx, q)
+			require.Nil(t, err)
+			for i := range summaries {
+				if last != nil {
+					assert.True(t, desc != summaries[i].Modified.After(last.Modified) || summaries[i].Modified.Equal(last.Modified))
+				}
+				last = &summaries[i]
+				seen = append(seen, summaries[i].ID)
+				assert.Nil(t, summaries[i].Content)
+			}
+			if len(summaries) < q.Limit {
+				break
+			}
+			key := last.Key()
+			q.After = &key
+		}
+		assert.ElementsMatch(t, []int{rootID, aID, bID, cID, dID}, seen)
+	}
+
+	summaries, err := s.SelectArticles(ctx, models.ArticleQuery{Reader: all, SortBy: "id", Slug: "b", Limit: 10, WithContent: true})
+	require.Nil(t, err)
+	require.Len(t, summaries, 1)
+	b := summaries[0]
+	assert.Equal(t, "a/b/", b.Path)
+	assert.Equal(t, aID, b.ParentArtID)
+	assert.Equal(t, "Bee", b.Title)
+	assert.Equal(t, 2, b.Level)
+	assert.False(t, b.HasChildren)
+	require.NotNil(t, b.Content)
+	assert.Equal(t, "# Bee", *b.Content)
+}
+
+// / -> /a -> /a/b
+func testHeaderAndRevisions(t *testing.T, s store.ArticleStore) {
+	ctx := context.Background()
+	rootID := InsertRoot(t, s, "Root")
+	aID, err := InsertChild(s, rootID, "a")
+	require.Nil(t, err)
+	bID, err := InsertChild(s, aID, "b")
+	require.Nil(t, err)
+	_, err = s.AddWikiArticleRevision(ctx, bID, "B", "# B", models.RevisionMeta{UserMessage: "Typo", Locked: true})
+	require.Nil(t, err)
+
+	b, err := s.SelectArticleByID(ctx, bID)
+	require.Nil(t, err)
+	hdr, err := s.SelectArticleHeader(ctx, bID)
+	require.Nil(t, err)
+	assert.Empty(t, hdr.Content)
+	hdr.Content = b.Content
+	assert.Equal(t, b, hdr)
+	_, err = s.SelectArticleHeader(ctx, bID+1000)
+	assert.True(t, errors.Is(err, store.ErrNotFound), "unexpected error %v", err)
+
+	for path, exp := range map[string]int{"": rootID, "a": aID, "/a/b/": bID} {
+		id, err := s.SelectArticleIDByPath(ctx, path)
+		require.Nil(t, err)
+		assert.Equal(t, exp, id)
+	}
+	_, err = s.SelectArticleIDByPath(ctx, "a/x")
+	assert.True(t, errors.Is(err, store.ErrNotFound), "unexpected error %v", err)
+
+	revs, err := s.SelectRevisions(ctx, bID)
+	require.Nil(t, err)
+	require.Len(t, revs, 2)
+	assert.Equal(t, []int{1, 2}, []int{revs[0].RevisionNumber, revs[1].RevisionNumber})
+	assert.Equal(t, b.RevisionID, revs[1].ID)
+	assert.Equal(t, "b", revs[0].Title)
+	assert.Equal(t, "Typo", revs[1].UserMessage)
+	assert.True(t, revs[1].Locked)
+	assert.False(t, revs[0].Locked)
+
+	summaries, err := s.SelectArticles(ctx, models.ArticleQuery{Reader: models.ReadFilter{All: true},
+		SortBy: "id", Limit: 10, ContainsLeft: b.Left, ContainsRight: b.Right, PathID: b.PathID})
+	require.Nil(t, err)
+	require.Len(t, summaries, 2)
+	assert.Equal(t, []int{rootID, aID}, []int{summaries[0].ID, summaries[1].ID})
+}
+
+// testLinks indexes links and selects them by source and target.
+func testLinks(t *testing.T, s store.ArticleStore) {
+	ctx := context.Background()
+	rootID := InsertRoot(t, s, "Root")
+	aID, err := InsertChild(s, rootID, "a")
+	require.Nil(t, err)
+	bID, err := InsertChild(s, aID, "b")
+	require.Nil(t, err)
+	cID, err := InsertChild(s, rootID, "c")
+	require.Nil(t, err)
+
+	sources, err := s.SelectUnindexedArticles(ctx, 10)
+	require.Nil(t, err)
+	require.Len(t, sources, 4)
+	assert.Equal(t, bID, sources[2].ArticleID)
+	assert.Equal(t, "a/b/", sources[2].Path)
+	a, err := s.SelectArticleByID(ctx, aID)
+	require.Nil(t, err)
+	assert.Equal(t, a.RevisionID, sources[1].RevisionID)
+	assert.Equal(t, a.Content, sources[1].Content)
+	sources, err = s.SelectUnindexedArticles(ctx, 2)
+	require.Nil(t, err)
+	assert.Len(t, sources, 2)
+
+	aLinks := []models.ArticleLink{{ToID: bID}, {ToPath: "/c/"}, {ToPath: "/missing/"}, {ToID: 9999}, {ToID: bID}}
+	require.Nil(t, s.ReplaceArticleLinks(ctx, aID, a.RevisionID, aLinks))
+	c, err := s.SelectArticleByID(ctx, cID)
+	require.Nil(t, err)
+	require.Nil(t, s.ReplaceArticleLinks(ctx, cID, c.RevisionID, []models.ArticleLink{{ToPath: "/a/"}, {ToPath: "/"}}))
+	err = s.ReplaceArticleLinks(ctx, 9999, 1, nil)
+	assert.True(t, errors.Is(err, store.ErrConstraint), "unexpected error %v", err)
+
+	sources, err = s.SelectUnindexedArticles(ctx, 10)
+	require.Nil(t, err)
+	require.Len(t, sources, 2)
+	assert.Equal(t, []int{rootID, bID}, []int{sources[0].ArticleID, sources[1].ArticleID})
+
+	links, err := s.SelectArticleLinks(ctx, aID)
+	require.Nil(t, err)
+	assert.Equal(t, []models.ArticleLink{{FromID: aID, ToPath: "/c/"}, {FromID: aID, ToPath: "/missing/"},
+		{FromID: aID, ToID: bID}, {FromID: aID, ToID: 9999}}, links)
+	links, err = s.SelectBacklinks(ctx, bID, false)
+	require.Nil(t, err)
+	assert.Equal(t, []models.ArticleLink{{FromID: aID, ToID: bID}}, links)
+	links, err = s.SelectBacklinks(ctx, aID, false)
+	require.Nil(t, err)
+	assert.Equal(t, []models.ArticleLink{{FromID: cID, ToPath: "/a/"}}, links)
+	links, err = s.SelectBacklinks(ctx, aID, true)
+	require.Nil(t, err)
+	assert.Equal(t, []models.ArticleLink{{FromID: aID, ToID: bID}, {FromID: cID, ToPath: "/a/"}}, links)
+	links, err = s.SelectBacklinks(ctx, rootID, false)
+	require.Nil(t, err)
+	assert.Equal(t, []models.ArticleLink{{FromID: cID, ToPath: "/"}}, links)
+	links, err = s.SelectBrokenLinks(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, []models.ArticleLink{{FromID: aID, ToPath: "/missing/"}, {FromID: aID, ToID: 9999}}, links)
+
+	// A new revision has to be indexed again, deleted articles lose their links.
+	_, err = s.AddWikiArticleRevision(ctx, cID, "C", "", models.RevisionMeta{})
+	require.Nil(t, err)
+	sources, err = s.SelectUnindexedArticles(ctx, 10)
+	require.Nil(t, err)
+	assert.Len(t, sources, 3)
+	require.Nil(t, s.DeleteWikiURLPath(ctx, a.PathID))
+	links, err = s.SelectBrokenLinks(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, []models.ArticleLink{{FromID: cID, ToPath: "/a/"}}, links)
+}
+
+// testLinksOfMovedSubtree moves the parent of an article with a relative link, which
+// changes the target of the link without a revision of the article.
+func testLinksOfMovedSubtree(t *testing.T, s store.ArticleStore) {
+	ctx := context.Background()
+	rootID := InsertRoot(t, s, "Root")
+	pID, err := InsertChild(s, rootID, "p")
+	require.Nil(t, err)
+	xID, err := InsertChild(s, pID, "x")
+	require.Nil(t, err)
+	yID, err := InsertChild(s, xID, "y")
+	require.Nil(t, err)
+	qID, err := InsertChild(s, rootID, "q")
+	require.Nil(t, err)
+	// x links to its child y relatively, which resolves to "/p/x/y/".
+	x, err := s.SelectArticleByID(ctx, xID)
+	require.Nil(t, err)
+	require.Nil(t, s.ReplaceArticleLinks(ctx, xID, x.RevisionID, []models.ArticleLink{{ToPath: "/p/x/y/"}}))
+	for _, id := range []int{rootID, pID, yID, qID} {
+		a, err := s.SelectArticleHeader(ctx, id)
+		require.Nil(t, err)
+		require.Nil(t, s.ReplaceArticleLinks(ctx, id, a.RevisionID, nil))
+	}
+	links, err := s.SelectBacklinks(ctx, yID, false)
+	require.Nil(t, err)
+	assert.Equal(t, []models.ArticleLink{{FromID: xID, ToPath: "/p/x/y/"}}, links)
+
+	p, err := s.SelectArticleByID(ctx, pID)
+	require.Nil(t, err)
+	q, err := s.SelectArticleByID(ctx, qID)
+	require.Nil(t, err)
+	require.Nil(t, s.MoveWikiURLPath(ctx, p.PathID, q.PathID, -1))
+	require.Nil(t, s.DeleteArticleLinks(ctx, pID))
+	links, err = s.SelectBacklinks(ctx, yID, false)
+	require.Nil(t, err)
+	assert.Empty(t, links)
+	links, err = s.SelectArticleLinks(ctx, xID)
+	require.Nil(t, err)
+	assert.Empty(t, links)
+	sources, err := s.SelectUnindexedArticles(ctx, 10)
+	require.Nil(t, err)
+	paths := map[int]string{}
+	for _, src := range sources {
+		paths[src.ArticleID] = src.Path
+	}
+	assert.Equal(t, map[int]string{pID: "q/p/", xID: "q/p/x/", yID: "q/p/x/y/"}, paths, "only the subtree is unindexed")
+
+	require.Nil(t, s.ReplaceArticleLinks(ctx, xID, x.RevisionID, []models.ArticleLink{{ToPath: "/q/p/x/y/"}}))
+	links, err = s.SelectBacklinks(ctx, yID, false)
+	require.Nil(t, err)
+	assert.Equal(t, []models.ArticleLink{{FromID: xID, ToPath: "/q/p/x/y/"}}, links)
+}
+
+// testRedirects makes a node a redirect and deletes its target.
+func testRedirects(t *testing.T, s store.ArticleStore) {
+	ctx := context.Background()
+	rootID := InsertRoot(t, s, "Root")
+	aID, err := InsertChild(s, rootID, "a")
+	require.Nil(t, err)
+	bID, err := InsertChild(s, rootID, "b")
+	require.Nil(t, err)
+	a, err := s.SelectArticleHeader(ctx, aID)
+	require.Nil(t, err)
+	b, err := s.SelectArticleHeader(ctx, bID)
+	require.Nil(t, err)
+
+	require.Nil(t, s.SetWikiURLPathMovedTo(ctx, a.PathID, b.PathID))
+	movedTo, err := s.SelectMovedTo(ctx, aID)
+	require.Nil(t, err)
+	assert.Equal(t, bID, movedTo)
+	movedTo, err = s.SelectMovedTo(ctx, bID)
+	require.Nil(t, err)
+	assert.Equal(t, 0, movedTo)
+	_, err = s.SelectMovedTo(ctx, bID+1000)
+	assert.True(t, errors.Is(err, store.ErrNotFound), "unexpected error %v", err)
+
+	require.Nil(t, s.DeleteWikiURLPath(ctx, b.PathID))
+	movedTo, err = s.SelectMovedTo(ctx, aID)
+	require.Nil(t, err)
+	assert.Equal(t, 0, movedTo)
+	assertSound(t, s)
+}
+
+// / -> /a -> /a/b and /c
+func testCopy(t *testing.T, s store.ArticleStore) {
+	ctx := context.Background()
+	rootID := InsertRoot(t, s, "Root")
+	aID, err := InsertChild(s, rootID, "a")
+	require.Nil(t, err)
+	bID, err := InsertChild(s, aID, "b")
+	require.Nil(t, err)
+	cID, err := InsertChild(s, rootID, "c")
+	require.Nil(t, err)
+	perms := models.Permissions{GroupRead: true, OtherRead: true}
+	require.Nil(t, s.UpdateWikiArticlePermissions(ctx, aID, perms))
+	_, err = s.AddWikiArticleRevision(ctx, bID, "B", "# B, second revision",
+		models.RevisionMeta{IPAddress: "192.0.2.1", AutomaticLog: "Edited", Locked: true})
+	require.Nil(t, err)
+	a, err := s.SelectArticleHeader(ctx, aID)
+	require.Nil(t, err)
+	b, err := s.SelectArticleHeader(ctx, bID)
+	require.Nil(t, err)
+	require.Nil(t, s.SetWikiURLPathMovedTo(ctx, b.PathID, a.PathID))
+
+	// The current revision becomes the first one.
+	meta := models.RevisionMeta{IPAddress: "192.0.2.2", AutomaticLog: "Copied"}
+	ids, err := s.CopyWikiArticles(ctx, aID, cID, models.CopyOptions{Slug: "t", Meta: meta})
+	require.Nil(t, err)
+	require.Len(t, ids, 2)
+	assertSound(t, s)
+	for i, path := range []string{"c/t/", "c/t/b/"} {
+		p, err := s.SelectURLPath(ctx, ids[i])
+		require.Nil(t, err)
+		assert.Equal(t, path, p)
+	}
+	t1, err := s.SelectArticleByID(ctx, ids[0])
+	require.Nil(t, err)
+	assert.Equal(t, perms, *t1.Permissions)
+	assert.Equal(t, 2, t1.Level)
+	tb, err := s.SelectArticleByID(ctx, ids[1])
+	require.Nil(t, err)
+	assert.Equal(t, "# B, second revision", tb.Content)
+	assert.Equal(t, models.DefaultPermissions, *tb.Permissions)
+	revs, err := s.SelectRevisions(ctx, ids[1])
+	require.Nil(t, err)
+	require.Len(t, revs, 1)
+	assert.Equal(t, 1, revs[0].RevisionNumber)
+	assert.Equal(t, "Copied", revs[0].AutomaticLog)
+	assert.True(t, revs[0].Locked)
+	movedTo, err := s.SelectMovedTo(ctx, ids[1])
+	require.Nil(t, err)
+	assert.Equal(t, ids[0], movedTo, "redirect within the copy")
+	orig, err := s.SelectArticleByID(ctx, bID)
+	require.Nil(t, err)
+	assert.Equal(t, b.Left, orig.Left)
+
+	// With history, the revisions are copied verbatim.
+	ids, err = s.CopyWikiArticles(ctx, bID, rootID, models.CopyOptions{History: true, Meta: meta})
+	require.Nil(t, err)
+	require.Len(t, ids, 1)
+	assertSound(t, s)
+	hb, err := s.SelectArticleByPath(ctx, "b")
+	require.Nil(t, err)
+	assert.Equal(t, ids[0], hb.ID)
+	revs, err = s.SelectRevisions(ctx, ids[0])
+	require.Nil(t, err)
+	origRevs, err := s.SelectRevisions(ctx, bID)
+	require.Nil(t, err)
+	require.Len(t, revs, 2)
+	for i := range revs {
+		assert.NotEqual(t, origRevs[i].ID, revs[i].ID)
+		origRevs[i].ID = revs[i].ID
+		assert.Equal(t, origRevs[i], revs[i])
+	}
+	assert.Equal(t, revs[1].ID, hb.RevisionID)
+	movedTo, err = s.SelectMovedTo(ctx, ids[0])
+	require.Nil(t, err)
+	assert.Equal(t, aID, movedTo, "redirect out of the copy")
+
+	for _, tc := range []struct {
+		hdrID, parentHdrID int
+		opts               models.CopyOptions
+		expErr             error
+	}{
+		{aID, bID, models.CopyOptions{Slug: "x"}, store.ErrConstraint},
+		{aID, aID, models.CopyOptions{Slug: "x"}, store.ErrConstraint},
+		{aID, cID, models.CopyOptions{Slug: "t"}, store.ErrConstraint},
+		{aID, cID, models.CopyOptions{Slug: "x", OwnerID: 4711}, store.ErrConstraint},
+		{aID, cID + 1000, models.CopyOptions{Slug: "x"}, store.ErrNotFound},
+		{aID + 1000, cID, models.CopyOptions{Slug: "x"}, store.ErrNotFound},
+	} {
+		_, err = s.CopyWikiArticles(ctx, tc.hdrID, tc.parentHdrID, tc.opts)
+		assert.True(t, errors.Is(err, tc.expErr), "copy %v below %v: unexpected error %v", tc.hdrID, tc.parentHdrID, err)
+	}
+	_, err = s.SelectArticleByPath(ctx, "c/x")
+	assert.True(t, errors.Is(err, store.ErrNotFound), "unexpected error %v", err)
+	assertSound(t, s)
+}
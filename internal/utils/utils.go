@@ -1,21 +1,75 @@
 package utils
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 
+	"coco-life.de/wapi/internal/store"
 	"github.com/gin-gonic/gin"
 )
 
-// HandleErr returns 'true' if an error has been handled.
+// HandleErr returns 'true' if an error has been handled. The HTTP status code is
+// derived from the error, see StatusOf. Errors in the chain implementing
+// ErrorDetails add their fields to the response.
 func HandleErr(c *gin.Context, e *error, m string) bool {
 	if *e == nil {
 		return false
 	}
 	msg := fmt.Sprintf(m, *e)
 	fmt.Fprint(os.Stderr, msg)
-	c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+	body := gin.H{"error": msg}
+	for err := *e; err != nil; err = errors.Unwrap(err) {
+		if d, ok := err.(ErrorDetails); ok {
+			for name, value := range d.ErrorDetails() {
+				if _, exists := body[name]; !exists {
+					body[name] = value
+				}
+			}
+		}
+	}
+	c.JSON(StatusOf(*e), body)
 	return true
 }
 
+// ErrorDetails is implemented by errors that add fields to the error response, e.g.
+// the index of a failed operation of a batch.
+type ErrorDetails interface {
+	ErrorDetails() gin.H
+}
+
+// StatusError attaches an HTTP status code to an error.
+type StatusError struct {
+	Code int
+	Err  error
+}
+
+// NewStatusError returns an error with the given HTTP status code and message.
+func NewStatusError(code int, msg string) *StatusError {
+	return &StatusError{Code: code, Err: errors.New(msg)}
+}
+
+func (e *StatusError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the wrapped error.
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+// StatusOf returns the HTTP status code of a StatusError and maps the errors of
+// package store to HTTP status codes. Any other error is considered a bad request.
+func StatusOf(err error) int {
+	var se *StatusError
+	switch {
+	case errors.As(err, &se):
+		return se.Code
+	case errors.Is(err, store.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, store.ErrConstraint):
+		return http.StatusConflict
+	}
+	return http.StatusBadRequest
+}